@@ -23,6 +23,6 @@ var (
 
 func main() {
 	commands.Load()
-	exitCode := 0
+	exitCode := commands.Execute(bus, os.Args[1:])
 	exitFunc(exitCode)
 }
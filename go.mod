@@ -6,17 +6,17 @@ toolchain go1.24.0
 
 require (
 	github.com/lestrrat-go/strftime v1.1.0 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/spf13/afero v1.12.0 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
 	github.com/utahta/go-cronowriter v1.2.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
-	golang.org/x/text v0.22.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
 	github.com/majohn-r/cmd-toolkit v0.24.1
 	github.com/majohn-r/output v0.9.0
+	github.com/mattn/go-isatty v0.0.20
+	github.com/spf13/afero v1.12.0
+	github.com/spf13/pflag v1.0.6
 	gitlab.com/gomidi/midi/v2 v2.2.19
+	golang.org/x/sys v0.30.0
+	golang.org/x/text v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,1044 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// abcTicksPerQuarter is the resolution of files imported from ABC
+const abcTicksPerQuarter = 480
+
+var (
+	// the position of each note letter's major key on the circle of fifths
+	abcLetterFifths = map[byte]int{'F': -1, 'C': 0, 'G': 1, 'D': 2, 'A': 3, 'E': 4, 'B': 5}
+	// the change on the circle of fifths from a tonic's major key to its mode
+	abcModeFifths = map[string]int{
+		"maj": 0, "ion": 0, "lyd": 1, "mix": -1, "dor": -2, "min": -3, "aeo": -3, "m": -3, "phr": -4, "loc": -5,
+	}
+	abcSharpOrder = "FCGDAEB"
+)
+
+// abcElementKind distinguishes the elements of an ABC voice
+type abcElementKind int
+
+const (
+	abcNote        abcElementKind = iota // a note, chord, or rest
+	abcBar                               // a plain barline
+	abcStartRepeat                       // |:
+	abcEndRepeat                         // :|
+	abcSectionEnd                        // || or |]
+	abcEnding                            // [1, [2, ...
+	abcMeta                              // a key, meter, or tempo change
+)
+
+type abcElement struct {
+	kind    abcElementKind
+	pitches []uint8 // empty for a rest
+	ties    []bool  // parallel to pitches
+	length  *big.Rat
+	endings []int
+	message smf.Message
+}
+
+// abcKey is a parsed K: field
+type abcKey struct {
+	accidentals map[byte]int // alteration of each (upper case) letter
+	message     smf.Message
+}
+
+// abcPitchKey identifies a note letter in an octave, to which an accidental
+// applies for the rest of the bar
+type abcPitchKey struct {
+	letter byte
+	octave int
+}
+
+type abcVoice struct {
+	id             string
+	name           string
+	elements       []abcElement
+	key            abcKey
+	unit           *big.Rat // the L: unit note length
+	meter          *big.Rat // the length of a bar
+	barAccidentals map[abcPitchKey]int
+	tupletRatio    *big.Rat
+	tupletNotes    int
+	broken         *big.Rat // applied to the next note's length
+}
+
+// abcParser parses one tune of an ABC file
+type abcParser struct {
+	title     string
+	line      int
+	inBody    bool
+	defaults  abcVoice
+	voices    []*abcVoice
+	current   *abcVoice
+	conductor []smf.Message // the header's meter, key, and tempo
+}
+
+// readABC converts an ABC tune into a file with a conductor track and a track
+// for each voice; the tune is the first in the content, or the one numbered by
+// the --tune flag
+func (i *importFiles) readABC(content []byte) (*smf.SMF, error) {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	start := -1
+	for k, line := range lines {
+		if !strings.HasPrefix(line, "X:") {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(line[2:]))
+		if i.tune == 0 || (err == nil && number == i.tune) {
+			start = k
+			break
+		}
+	}
+	switch {
+	case start < 0 && i.tune > 0:
+		return nil, fmt.Errorf("there is no tune X:%d", i.tune)
+	case start < 0:
+		start = 0
+	}
+	p := &abcParser{}
+	p.defaults.meter = big.NewRat(1, 1)
+	p.defaults.key = abcKey{accidentals: map[byte]int{}, message: smf.MetaKey(0, true, 0, false)}
+	for k := start; k < len(lines); k++ {
+		line := strings.TrimRight(lines[k], " \t")
+		if k > start && (strings.HasPrefix(line, "X:") || (line == "" && p.inBody)) {
+			break
+		}
+		p.line = k + 1
+		if err := p.parseLine(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+	}
+	if !p.inBody {
+		return nil, fmt.Errorf("the tune has no K: field")
+	}
+	return p.build(), nil
+}
+
+func (p *abcParser) parseLine(line string) error {
+	if k := strings.Index(line, "%"); k >= 0 && !strings.HasPrefix(line, "%%") {
+		line = line[:k]
+	}
+	if strings.HasPrefix(line, "%%") || strings.TrimSpace(line) == "" {
+		return nil
+	}
+	if len(line) >= 2 && isLetter(line[0]) && line[1] == ':' && !strings.HasPrefix(line[2:], "|") {
+		return p.parseField(line[0], strings.TrimSpace(line[2:]))
+	}
+	if !p.inBody {
+		return fmt.Errorf("music %q appears before the K: field", line)
+	}
+	return p.parseMusic(line)
+}
+
+func (p *abcParser) parseField(field byte, value string) error {
+	target := &p.defaults
+	if p.inBody {
+		target = p.voice()
+	}
+	switch field {
+	case 'T':
+		if p.title == "" {
+			p.title = value
+		}
+	case 'M':
+		meter, message, err := parseABCMeter(value)
+		if err != nil {
+			return err
+		}
+		target.meter = meter
+		if message != nil {
+			p.addMeta(message)
+		}
+	case 'L':
+		unit, err := parseABCFraction(value)
+		if err != nil {
+			return fmt.Errorf("the unit note length %q is not valid", value)
+		}
+		target.unit = unit
+	case 'Q':
+		bpm, err := p.parseTempo(value, target)
+		if err != nil {
+			return err
+		}
+		p.addMeta(smf.MetaTempo(bpm))
+	case 'K':
+		key, err := parseABCKey(value)
+		if err != nil {
+			return err
+		}
+		target.key = key
+		if !p.inBody {
+			p.conductor = append(p.conductor, key.message)
+			if p.defaults.unit == nil {
+				p.defaults.unit = big.NewRat(1, 8)
+				if p.defaults.meter.Cmp(big.NewRat(3, 4)) < 0 {
+					p.defaults.unit = big.NewRat(1, 16)
+				}
+			}
+			for _, v := range p.voices {
+				v.key, v.unit, v.meter = p.defaults.key, p.defaults.unit, p.defaults.meter
+			}
+			p.inBody = true
+			return nil
+		}
+		p.addMeta(key.message)
+	case 'V':
+		id, name := parseABCVoice(value)
+		v := p.findVoice(id)
+		if name != "" {
+			v.name = name
+		}
+		if p.inBody {
+			p.current = v
+		}
+	}
+	return nil
+}
+
+// addMeta records a meta message in the header, or at the current position
+// of the current voice
+func (p *abcParser) addMeta(message smf.Message) {
+	if !p.inBody {
+		p.conductor = append(p.conductor, message)
+		return
+	}
+	v := p.voice()
+	v.elements = append(v.elements, abcElement{kind: abcMeta, message: message})
+}
+
+// voice returns the current voice, creating a default voice if the music has
+// not named one
+func (p *abcParser) voice() *abcVoice {
+	if p.current == nil {
+		if len(p.voices) > 0 {
+			p.current = p.voices[0]
+		} else {
+			p.current = p.findVoice("1")
+		}
+	}
+	return p.current
+}
+
+// findVoice returns the voice with the ID, creating it if necessary
+func (p *abcParser) findVoice(id string) *abcVoice {
+	for _, v := range p.voices {
+		if v.id == id {
+			return v
+		}
+	}
+	v := &abcVoice{id: id, barAccidentals: map[abcPitchKey]int{}}
+	v.key, v.unit, v.meter = p.defaults.key, p.defaults.unit, p.defaults.meter
+	p.voices = append(p.voices, v)
+	return v
+}
+
+func (p *abcParser) parseMusic(line string) error {
+	v := p.voice()
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '`' || c == 'y' || c == '\\' || c == ')' || strings.IndexByte(".~HLMOPSTuv", c) >= 0:
+			i++
+		case c == '"' || c == '!' || c == '+' || c == '{':
+			closing := map[byte]byte{'"': '"', '!': '!', '+': '+', '{': '}'}[c]
+			end := strings.IndexByte(line[i+1:], closing)
+			if end < 0 {
+				return fmt.Errorf("%q is not closed", string(c))
+			}
+			i += end + 2
+		case c == '(' && i+1 < len(line) && isDigit(line[i+1]):
+			i = p.parseTuplet(v, line, i+1)
+		case c == '(':
+			i++
+		case c == '-':
+			if k := lastNote(v); k >= 0 {
+				for j := range v.elements[k].ties {
+					v.elements[k].ties[j] = true
+				}
+			}
+			i++
+		case c == '>' || c == '<':
+			n := 1
+			for i+n < len(line) && line[i+n] == c {
+				n++
+			}
+			p.applyBroken(v, c, n)
+			i += n
+		case c == '[' && i+1 < len(line) && isDigit(line[i+1]):
+			var err error
+			if i, err = p.parseEnding(v, line, i+1); err != nil {
+				return err
+			}
+		case c == '[' && i+2 < len(line) && isLetter(line[i+1]) && line[i+2] == ':':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				return fmt.Errorf("the inline field %q is not closed", line[i:])
+			}
+			if err := p.parseField(line[i+1], strings.TrimSpace(line[i+3:i+end])); err != nil {
+				return err
+			}
+			v = p.voice()
+			i += end + 1
+		case c == '|' || c == ':' || (c == '[' && i+1 < len(line) && line[i+1] == '|'):
+			var err error
+			if i, err = p.parseBar(v, line, i); err != nil {
+				return err
+			}
+		case c == '[':
+			var err error
+			if i, err = p.parseChord(v, line, i+1); err != nil {
+				return err
+			}
+		case c == 'z' || c == 'x':
+			length, next := parseABCLength(line, i+1)
+			p.addNote(v, nil, new(big.Rat).Mul(v.unit, length))
+			i = next
+		case c == 'Z' || c == 'X':
+			bars, next := 1, i+1
+			for next < len(line) && isDigit(line[next]) {
+				next++
+			}
+			if next > i+1 {
+				bars, _ = strconv.Atoi(line[i+1 : next])
+			}
+			p.addNote(v, nil, new(big.Rat).Mul(v.meter, big.NewRat(int64(bars), 1)))
+			i = next
+		case strings.IndexByte("^_=", c) >= 0 || isNoteLetter(c):
+			pitch, length, next, err := p.parseNote(v, line, i)
+			if err != nil {
+				return err
+			}
+			p.addNote(v, []uint8{pitch}, new(big.Rat).Mul(v.unit, length))
+			i = next
+		default:
+			return fmt.Errorf("the character %q is not expected", string(c))
+		}
+	}
+	return nil
+}
+
+// parseNote parses accidentals, a note letter, octave marks, and a length
+func (p *abcParser) parseNote(v *abcVoice, line string, i int) (pitch uint8, length *big.Rat, next int, err error) {
+	alter, explicit := 0, false
+	for ; i < len(line) && strings.IndexByte("^_=", line[i]) >= 0; i++ {
+		explicit = true
+		switch line[i] {
+		case '^':
+			alter++
+		case '_':
+			alter--
+		}
+	}
+	if i >= len(line) || !isNoteLetter(line[i]) {
+		return 0, nil, i, fmt.Errorf("an accidental is not followed by a note")
+	}
+	letter := line[i]
+	octave := 4
+	if letter >= 'a' {
+		letter -= 'a' - 'A'
+		octave = 5
+	}
+	for i++; i < len(line) && (line[i] == '\'' || line[i] == ','); i++ {
+		if line[i] == '\'' {
+			octave++
+		} else {
+			octave--
+		}
+	}
+	k := abcPitchKey{letter: letter, octave: octave}
+	switch accidental, found := v.barAccidentals[k]; {
+	case explicit:
+		v.barAccidentals[k] = alter
+	case found:
+		alter = accidental
+	default:
+		alter = v.key.accidentals[letter]
+	}
+	value := (octave+1)*12 + pitchClasses[letter] + alter
+	if value < 0 || value > 127 {
+		return 0, nil, i, fmt.Errorf("the note %q is out of range", line[:i])
+	}
+	length, next = parseABCLength(line, i)
+	return uint8(value), length, next, nil
+}
+
+// parseChord parses the notes of a chord up to its closing bracket, and the
+// chord's length; the chord lasts as long as its first note
+func (p *abcParser) parseChord(v *abcVoice, line string, i int) (int, error) {
+	var pitches []uint8
+	var ties []bool
+	var length *big.Rat
+	for i < len(line) && line[i] != ']' {
+		switch c := line[i]; {
+		case c == '-':
+			if len(ties) > 0 {
+				ties[len(ties)-1] = true
+			}
+			i++
+		case c == ' ' || strings.IndexByte(".~HLMOPSTuv", c) >= 0:
+			i++
+		default:
+			pitch, noteLength, next, err := p.parseNote(v, line, i)
+			if err != nil {
+				return next, err
+			}
+			pitches = append(pitches, pitch)
+			ties = append(ties, false)
+			if length == nil {
+				length = noteLength
+			}
+			i = next
+		}
+	}
+	if i >= len(line) {
+		return i, fmt.Errorf("a chord is not closed")
+	}
+	if len(pitches) == 0 {
+		return i, fmt.Errorf("a chord has no notes")
+	}
+	multiplier, next := parseABCLength(line, i+1)
+	p.addNote(v, pitches, new(big.Rat).Mul(v.unit, new(big.Rat).Mul(length, multiplier)))
+	v.elements[len(v.elements)-1].ties = ties
+	return next, nil
+}
+
+// parseTuplet parses (p, (p:q, or (p:q:r, which puts p notes into the time of
+// q for the next r notes
+func (p *abcParser) parseTuplet(v *abcVoice, line string, i int) int {
+	var numbers []int
+	for len(numbers) < 3 {
+		start := i
+		for i < len(line) && isDigit(line[i]) {
+			i++
+		}
+		n, _ := strconv.Atoi(line[start:i])
+		numbers = append(numbers, n)
+		if i >= len(line) || line[i] != ':' {
+			break
+		}
+		i++
+	}
+	notes := numbers[0]
+	into := map[int]int{2: 3, 3: 2, 4: 3, 6: 2, 8: 3}[notes]
+	if into == 0 {
+		into = 2
+		if compound := new(big.Rat).Mul(v.meter, big.NewRat(8, 1)); compound.IsInt() && compound.Num().Int64()%3 == 0 && compound.Num().Int64() > 3 {
+			into = 3
+		}
+	}
+	if len(numbers) > 1 && numbers[1] > 0 {
+		into = numbers[1]
+	}
+	count := notes
+	if len(numbers) > 2 && numbers[2] > 0 {
+		count = numbers[2]
+	}
+	if notes > 0 {
+		v.tupletRatio = big.NewRat(int64(into), int64(notes))
+		v.tupletNotes = count
+	}
+	return i
+}
+
+// applyBroken lengthens the previous note and shortens the next for >, and
+// the reverse for <; doubled and tripled marks take more
+func (p *abcParser) applyBroken(v *abcVoice, mark byte, n int) {
+	k := lastNote(v)
+	if k < 0 {
+		return
+	}
+	short := big.NewRat(1, int64(1)<<n)
+	long := new(big.Rat).Sub(big.NewRat(2, 1), short)
+	if mark == '<' {
+		long, short = short, long
+	}
+	v.elements[k].length.Mul(v.elements[k].length, long)
+	v.broken = short
+}
+
+func (p *abcParser) parseEnding(v *abcVoice, line string, i int) (int, error) {
+	start := i
+	for i < len(line) && (isDigit(line[i]) || line[i] == ',' || line[i] == '-') {
+		i++
+	}
+	var endings []int
+	for _, part := range strings.Split(line[start:i], ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(from)
+		if err != nil {
+			return i, fmt.Errorf("the ending %q is not valid", line[start:i])
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(to); err != nil || last < first {
+				return i, fmt.Errorf("the ending %q is not valid", line[start:i])
+			}
+		}
+		for n := first; n <= last; n++ {
+			endings = append(endings, n)
+		}
+	}
+	v.elements = append(v.elements, abcElement{kind: abcEnding, endings: endings})
+	return i, nil
+}
+
+// parseBar parses a barline, with any repeat marks and a following ending
+// number
+func (p *abcParser) parseBar(v *abcVoice, line string, i int) (int, error) {
+	start := i
+	for i < len(line) && (line[i] == '|' || line[i] == ':' || (line[i] == '[' && i+1 < len(line) && line[i+1] == '|') ||
+		(line[i] == ']' && i > start && line[i-1] == '|')) {
+		i++
+	}
+	bar := line[start:i]
+	v.barAccidentals = map[abcPitchKey]int{}
+	switch {
+	case strings.HasPrefix(bar, ":") && strings.HasSuffix(bar, ":"):
+		v.elements = append(v.elements, abcElement{kind: abcEndRepeat}, abcElement{kind: abcStartRepeat})
+	case strings.HasPrefix(bar, ":"):
+		v.elements = append(v.elements, abcElement{kind: abcEndRepeat})
+	case strings.HasSuffix(bar, ":"):
+		v.elements = append(v.elements, abcElement{kind: abcStartRepeat})
+	case bar == "||" || bar == "|]" || bar == "[|":
+		v.elements = append(v.elements, abcElement{kind: abcSectionEnd})
+	default:
+		v.elements = append(v.elements, abcElement{kind: abcBar})
+	}
+	if i < len(line) && isDigit(line[i]) {
+		return p.parseEnding(v, line, i)
+	}
+	return i, nil
+}
+
+// addNote appends a note, chord, or rest, applying any pending tuplet and
+// broken rhythm
+func (p *abcParser) addNote(v *abcVoice, pitches []uint8, length *big.Rat) {
+	if v.tupletNotes > 0 {
+		length.Mul(length, v.tupletRatio)
+		v.tupletNotes--
+	}
+	if v.broken != nil {
+		length.Mul(length, v.broken)
+		v.broken = nil
+	}
+	v.elements = append(v.elements, abcElement{kind: abcNote, pitches: pitches, ties: make([]bool, len(pitches)), length: length})
+}
+
+func lastNote(v *abcVoice) int {
+	for k := len(v.elements) - 1; k >= 0; k-- {
+		switch v.elements[k].kind {
+		case abcNote:
+			return k
+		case abcMeta:
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// unroll plays the voice's repeats: the music between |: (or the start, the
+// end of the last repeat, or a section end) and :| is played twice, with [1
+// endings on the first time through and [2 endings on the second; a [2 ending
+// right after the :| closes the repeat
+func (v *abcVoice) unroll() []abcElement {
+	var played []abcElement
+	repeatStart, pass, skipping := 0, 1, false
+	afterRepeat := false // whether a repeat just ended, so that an ending belongs to it
+	for i := 0; i < len(v.elements); i++ {
+		e := v.elements[i]
+		switch e.kind {
+		case abcStartRepeat, abcSectionEnd:
+			repeatStart, pass, skipping, afterRepeat = i+1, 1, false, false
+		case abcEnding:
+			if afterRepeat {
+				skipping, afterRepeat = !containsEnding(e.endings, 2), false
+				continue
+			}
+			skipping = !containsEnding(e.endings, pass)
+		case abcEndRepeat:
+			if !skipping && pass == 1 {
+				pass = 2
+				i = repeatStart - 1
+				continue
+			}
+			repeatStart, pass, skipping, afterRepeat = i+1, 1, false, true
+		case abcNote, abcMeta:
+			if e.kind == abcNote {
+				afterRepeat = false
+			}
+			if !skipping {
+				played = append(played, e)
+			}
+		}
+	}
+	return played
+}
+
+func containsEnding(endings []int, pass int) bool {
+	for _, n := range endings {
+		if n == pass {
+			return true
+		}
+	}
+	return false
+}
+
+// build renders the tune as a format 1 file
+func (p *abcParser) build() *smf.SMF {
+	data := smf.NewSMF1()
+	data.TimeFormat = smf.MetricTicks(abcTicksPerQuarter)
+	var conductor []timedMessage
+	if p.title != "" {
+		conductor = append(conductor, timedMessage{message: smf.MetaTrackSequenceName(p.title)})
+	}
+	for _, message := range p.conductor {
+		conductor = append(conductor, timedMessage{message: message})
+	}
+	whole := big.NewRat(4*abcTicksPerQuarter, 1)
+	toTick := func(position *big.Rat) int64 {
+		f, _ := new(big.Rat).Mul(position, whole).Float64()
+		return int64(math.Round(f))
+	}
+	var tracks []smf.Track
+	seenMeta := map[string]bool{}
+	for k, v := range p.voices {
+		channel := uint8(min(k, 14))
+		if channel >= 9 {
+			channel++
+		}
+		var messages []timedMessage
+		name := v.name
+		if name == "" && len(p.voices) > 1 {
+			name = "Voice " + v.id
+		}
+		if name != "" {
+			messages = append(messages, timedMessage{message: smf.MetaTrackSequenceName(name)})
+		}
+		tied := map[uint8]int64{}
+		position := new(big.Rat)
+		var offs, ons []timedMessage
+		for _, e := range v.unroll() {
+			tick := toTick(position)
+			if e.kind == abcMeta {
+				key := fmt.Sprintf("%d %x", tick, e.message.Bytes())
+				if !seenMeta[key] {
+					seenMeta[key] = true
+					conductor = append(conductor, timedMessage{tick: tick, message: e.message})
+				}
+				continue
+			}
+			position.Add(position, e.length)
+			end := toTick(position)
+			stillTied := map[uint8]int64{}
+			for j, pitch := range e.pitches {
+				start, continuing := tied[pitch]
+				if !continuing {
+					start = tick
+				}
+				if e.ties[j] {
+					stillTied[pitch] = start
+					continue
+				}
+				ons = append(ons, timedMessage{tick: start, message: smf.Message(midi.NoteOn(channel, pitch, 80))})
+				offs = append(offs, timedMessage{tick: end, message: smf.Message(midi.NoteOff(channel, pitch))})
+			}
+			// in order of pitch, so that the file's bytes are the same every time
+			for _, pitch := range slices.Sorted(maps.Keys(tied)) {
+				if _, found := stillTied[pitch]; !found && !containsPitch(e.pitches, pitch) {
+					start := tied[pitch]
+					ons = append(ons, timedMessage{tick: start, message: smf.Message(midi.NoteOn(channel, pitch, 80))})
+					offs = append(offs, timedMessage{tick: tick, message: smf.Message(midi.NoteOff(channel, pitch))})
+				}
+			}
+			tied = stillTied
+		}
+		end := toTick(position)
+		for _, pitch := range slices.Sorted(maps.Keys(tied)) {
+			ons = append(ons, timedMessage{tick: tied[pitch], message: smf.Message(midi.NoteOn(channel, pitch, 80))})
+			offs = append(offs, timedMessage{tick: end, message: smf.Message(midi.NoteOff(channel, pitch))})
+		}
+		// note ends come before note starts at the same tick
+		messages = append(messages, offs...)
+		messages = append(messages, ons...)
+		tracks = append(tracks, buildTrack(messages))
+	}
+	_ = data.Add(buildTrack(conductor))
+	for _, track := range tracks {
+		_ = data.Add(track)
+	}
+	return data
+}
+
+func containsPitch(pitches []uint8, pitch uint8) bool {
+	for _, p := range pitches {
+		if p == pitch {
+			return true
+		}
+	}
+	return false
+}
+
+// parseABCMeter parses an M: field, returning the length of a bar as a
+// fraction of a whole note, and the time signature message (nil for none)
+func parseABCMeter(value string) (*big.Rat, smf.Message, error) {
+	switch value {
+	case "C":
+		return big.NewRat(1, 1), smf.MetaMeter(4, 4), nil
+	case "C|":
+		return big.NewRat(1, 1), smf.MetaMeter(2, 2), nil
+	case "none", "":
+		return big.NewRat(1, 1), nil, nil
+	}
+	numerator, denominator, found := strings.Cut(value, "/")
+	n, err1 := strconv.Atoi(strings.TrimSpace(numerator))
+	d, err2 := strconv.Atoi(strings.TrimSpace(denominator))
+	if !found || err1 != nil || err2 != nil || n < 1 || n > 255 || d < 1 || d > 128 || d&(d-1) != 0 {
+		return nil, nil, fmt.Errorf("the meter %q is not valid", value)
+	}
+	return big.NewRat(int64(n), int64(d)), smf.MetaMeter(uint8(n), uint8(d)), nil
+}
+
+// parseABCFraction parses a fraction such as 1/8
+func parseABCFraction(value string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("%q is not a positive fraction", value)
+	}
+	return r, nil
+}
+
+// parseTempo parses a Q: field, e.g., 1/4=120, "Allegro" 3/8=40, or (in the
+// old style) 120 unit notes per minute, and returns quarter notes per minute
+func (p *abcParser) parseTempo(value string, v *abcVoice) (float64, error) {
+	for {
+		start := strings.IndexByte(value, '"')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			return 0, fmt.Errorf("the tempo %q is not valid", value)
+		}
+		value = value[:start] + value[start+end+2:]
+	}
+	beats, rate, found := strings.Cut(value, "=")
+	if !found {
+		beats, rate = "", beats
+	}
+	bpm, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil || bpm <= 0 {
+		return 0, fmt.Errorf("the tempo %q is not valid", value)
+	}
+	beat := new(big.Rat)
+	if fields := strings.Fields(beats); len(fields) > 0 {
+		for _, field := range fields {
+			f, err := parseABCFraction(field)
+			if err != nil {
+				return 0, fmt.Errorf("the tempo %q is not valid", value)
+			}
+			beat.Add(beat, f)
+		}
+	} else if v.unit != nil {
+		beat.Set(v.unit)
+	} else {
+		beat.SetFrac64(1, 8)
+	}
+	quarters, _ := new(big.Rat).Mul(beat, big.NewRat(4, 1)).Float64()
+	return bpm * quarters, nil
+}
+
+// parseABCKey parses a K: field: a tonic and a mode, e.g., G, F#m, Bbmin, or
+// D dorian; anything after the mode, such as a clef, is ignored
+func parseABCKey(value string) (abcKey, error) {
+	key := abcKey{accidentals: map[byte]int{}}
+	fields := strings.Fields(value)
+	if len(fields) == 0 || strings.EqualFold(fields[0], "none") || strings.HasPrefix(fields[0], "H") ||
+		strings.Contains(fields[0], "=") {
+		key.message = smf.MetaKey(0, true, 0, false)
+		return key, nil
+	}
+	tonic := fields[0]
+	letter := tonic[0]
+	letterFifths, found := abcLetterFifths[letter]
+	if !found {
+		return key, fmt.Errorf("the key %q is not valid", value)
+	}
+	rest := tonic[1:]
+	alter := 0
+	switch {
+	case strings.HasPrefix(rest, "#"):
+		alter, rest = 1, rest[1:]
+	case strings.HasPrefix(rest, "b"):
+		alter, rest = -1, rest[1:]
+	}
+	if rest == "" && len(fields) > 1 && !strings.Contains(fields[1], "=") {
+		rest = fields[1]
+	}
+	mode := strings.ToLower(rest)
+	if len(mode) > 3 {
+		mode = mode[:3]
+	}
+	modeFifths, found := abcModeFifths[mode]
+	if mode == "" {
+		found = true
+	}
+	if !found {
+		return key, fmt.Errorf("the mode of the key %q is not valid", value)
+	}
+	fifths := letterFifths + 7*alter + modeFifths
+	if fifths < -7 || fifths > 7 {
+		return key, fmt.Errorf("the key %q has too many accidentals", value)
+	}
+	for k := 0; k < fifths; k++ {
+		key.accidentals[abcSharpOrder[k]] = 1
+	}
+	for k := 0; k < -fifths; k++ {
+		key.accidentals[abcSharpOrder[6-k]] = -1
+	}
+	count := uint8(fifths)
+	if fifths < 0 {
+		count = uint8(-fifths)
+	}
+	tonicClass := uint8((pitchClasses[letter] + alter + 12) % 12)
+	switch modeFifths {
+	case 0:
+		key.message = smf.MetaKey(tonicClass, true, count, fifths < 0)
+	case -3:
+		key.message = smf.MetaKey(tonicClass, false, count, fifths < 0)
+	default:
+		// other modes are written as their relative major key
+		key.message = smf.MetaKey(uint8((7*fifths%12+12)%12), true, count, fifths < 0)
+	}
+	return key, nil
+}
+
+// parseABCVoice parses a V: field into the voice's ID and name
+func parseABCVoice(value string) (id, name string) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "1", ""
+	}
+	id = fields[0]
+	for _, attribute := range []string{"name=", "nm="} {
+		if k := strings.Index(value, attribute); k >= 0 {
+			name = value[k+len(attribute):]
+			if strings.HasPrefix(name, `"`) {
+				name = name[1:]
+				if end := strings.IndexByte(name, '"'); end >= 0 {
+					name = name[:end]
+				}
+			} else if end := strings.IndexByte(name, ' '); end >= 0 {
+				name = name[:end]
+			}
+			break
+		}
+	}
+	return id, name
+}
+
+// parseABCLength parses a note length multiplier, e.g., 2, 3/2, /2, or //,
+// starting at i, and returns it with the index following it
+func parseABCLength(line string, i int) (*big.Rat, int) {
+	start := i
+	for i < len(line) && isDigit(line[i]) {
+		i++
+	}
+	numerator := int64(1)
+	if i > start {
+		numerator, _ = strconv.ParseInt(line[start:i], 10, 64)
+	}
+	denominator := int64(1)
+	for i < len(line) && line[i] == '/' {
+		i++
+		start = i
+		for i < len(line) && isDigit(line[i]) {
+			i++
+		}
+		if i > start {
+			d, _ := strconv.ParseInt(line[start:i], 10, 64)
+			denominator *= max(d, 1)
+		} else {
+			denominator *= 2
+		}
+	}
+	return big.NewRat(max(numerator, 1), denominator), i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isNoteLetter(c byte) bool {
+	return (c >= 'A' && c <= 'G') || (c >= 'a' && c <= 'g')
+}
+
+// writeABC writes the melody, the first voice of the first non-percussion
+// part, as an ABC tune with a unit note length of an eighth; the title is the
+// melody's track name, or else the first track's
+func (e *export) writeABC(w io.Writer, data *smf.SMF) error {
+	s := newScore(data, e.divisions())
+	melody := -1
+	for k, p := range s.parts {
+		if !p.percussion {
+			melody = k
+			break
+		}
+	}
+	if melody < 0 {
+		return fmt.Errorf("there is no melody to export")
+	}
+	p := s.parts[melody]
+	title := p.name
+	for _, event := range data.Tracks[0] {
+		if title == "" {
+			_ = event.Message.GetMetaTrackName(&title)
+		}
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+	var b strings.Builder
+	first := s.measures[0]
+	fmt.Fprintf(&b, "X:1\nT:%s\nM:%d/%d\nL:1/8\n", title, first.numerator, first.denominator)
+	if len(first.tempos) > 0 {
+		fmt.Fprintf(&b, "Q:1/4=%d\n", int(math.Round(first.tempos[0].bpm)))
+	}
+	fmt.Fprintf(&b, "K:%s\n", abcKeyName(first.key))
+	var line []string
+	for k, measure := range s.measures {
+		var items []string
+		if k > 0 && measure.key != s.measures[k-1].key {
+			items = append(items, "[K:"+abcKeyName(measure.key)+"]")
+		}
+		if k > 0 && (measure.numerator != s.measures[k-1].numerator || measure.denominator != s.measures[k-1].denominator) {
+			items = append(items, fmt.Sprintf("[M:%d/%d]", measure.numerator, measure.denominator))
+		}
+		tempos := measure.tempos
+		if k == 0 && len(tempos) > 0 {
+			tempos = tempos[1:]
+		}
+		accidentals := map[abcPitchKey]int{}
+		keyAccidentals := map[byte]int{}
+		if key, err := parseABCKey(abcKeyName(measure.key)); err == nil {
+			keyAccidentals = key.accidentals
+		}
+		beat := s.divisions * 4 / int64(measure.denominator)
+		if measure.numerator%3 == 0 && measure.numerator > 3 {
+			beat *= 3
+		}
+		music := ""
+		for _, voice := range p.measures[k] {
+			if voice.number != 1 {
+				continue
+			}
+			for _, event := range voice.events {
+				for len(tempos) > 0 && tempos[0].offset <= event.offset {
+					music += fmt.Sprintf(" [Q:1/4=%d] ", int(math.Round(tempos[0].bpm)))
+					tempos = tempos[1:]
+				}
+				// notes within a beat are beamed together
+				if event.offset%beat == 0 && music != "" && !strings.HasSuffix(music, " ") {
+					music += " "
+				}
+				music += s.abcEvent(measure, event, keyAccidentals, accidentals)
+			}
+		}
+		items = append(items, strings.TrimSpace(music))
+		line = append(line, strings.Join(items, " "))
+		if len(line) == 4 || k == len(s.measures)-1 {
+			bar := " |"
+			if k == len(s.measures)-1 {
+				bar = " |]"
+			}
+			b.WriteString(strings.Join(line, " | ") + bar + "\n")
+			line = nil
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// abcEvent writes the event, with the accidentals needed given the key
+// signature and the accidentals already written in the bar
+func (s *score) abcEvent(measure scoreMeasure, event scoreEvent, keyAccidentals map[byte]int, accidentals map[abcPitchKey]int) string {
+	duration := event.duration
+	if event.wholeMeasure {
+		duration = measure.length
+	}
+	// the unit note, an eighth, is half a division of a quarter note
+	length := abcLength(big.NewRat(duration*2, s.divisions))
+	if len(event.pitches) == 0 {
+		return "z" + length
+	}
+	var names []string
+	for _, pitch := range event.pitches {
+		step, alter, octave := spellPitch(measure.key, pitch)
+		k := abcPitchKey{letter: step[0], octave: octave}
+		current, found := accidentals[k]
+		if !found {
+			current = keyAccidentals[step[0]]
+		}
+		name := ""
+		if alter != current {
+			name = map[int]string{-1: "_", 0: "=", 1: "^"}[alter]
+			accidentals[k] = alter
+		}
+		switch {
+		case octave >= 5:
+			name += strings.ToLower(step) + strings.Repeat("'", octave-5)
+		default:
+			name += step + strings.Repeat(",", 4-octave)
+		}
+		names = append(names, name)
+	}
+	written := names[0]
+	if len(names) > 1 {
+		written = "[" + strings.Join(names, "") + "]"
+	}
+	written += length
+	if event.tieStart {
+		written += "-"
+	}
+	return written
+}
+
+// abcLength writes a multiple of the unit note length
+func abcLength(r *big.Rat) string {
+	switch {
+	case r.Cmp(big.NewRat(1, 1)) == 0:
+		return ""
+	case r.IsInt():
+		return r.Num().String()
+	case r.Num().Int64() == 1:
+		return "/" + r.Denom().String()
+	default:
+		return r.Num().String() + "/" + r.Denom().String()
+	}
+}
+
+// abcKeyName names the key as a K: field value, e.g., Bb or F#m
+func abcKeyName(k smf.Key) string {
+	name := strings.NewReplacer("♯", "#", "♭", "b").Replace(spellPitchClass(k, k.Key))
+	if !k.IsMajor {
+		name += "m"
+	}
+	return name
+}
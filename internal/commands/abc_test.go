@@ -0,0 +1,248 @@
+package commands
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_parseABCKey(t *testing.T) {
+	tests := map[string]struct {
+		value           string
+		wantAccidentals map[byte]int
+		wantKey         smf.Key
+		wantErr         bool
+	}{
+		"C":        {value: "C", wantAccidentals: map[byte]int{}, wantKey: smf.Key{Key: 0, IsMajor: true}},
+		"G":        {value: "G", wantAccidentals: map[byte]int{'F': 1}, wantKey: smf.Key{Key: 7, IsMajor: true, Num: 1}},
+		"F#m":      {value: "F#m", wantAccidentals: map[byte]int{'F': 1, 'C': 1, 'G': 1}, wantKey: smf.Key{Key: 6, Num: 3}},
+		"Bb":       {value: "Bb", wantAccidentals: map[byte]int{'B': -1, 'E': -1}, wantKey: smf.Key{Key: 10, IsMajor: true, Num: 2, IsFlat: true}},
+		"D dorian": {value: "D dorian", wantAccidentals: map[byte]int{}, wantKey: smf.Key{Key: 0, IsMajor: true}},
+		"A mix":    {value: "Amix clef=treble", wantAccidentals: map[byte]int{'F': 1, 'C': 1}, wantKey: smf.Key{Key: 2, IsMajor: true, Num: 2}},
+		"Ebmin":    {value: "Ebmin", wantAccidentals: map[byte]int{'B': -1, 'E': -1, 'A': -1, 'D': -1, 'G': -1, 'C': -1}, wantKey: smf.Key{Key: 3, Num: 6, IsFlat: true}},
+		"none":     {value: "none", wantAccidentals: map[byte]int{}, wantKey: smf.Key{Key: 0, IsMajor: true}},
+		"bad mode": {value: "G wobbly", wantErr: true},
+		"bad note": {value: "H#", wantAccidentals: map[byte]int{}, wantKey: smf.Key{Key: 0, IsMajor: true}},
+		"too many": {value: "G#", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseABCKey(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseABCKey() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.accidentals, tt.wantAccidentals) {
+				t.Errorf("parseABCKey() accidentals = %v, want %v", got.accidentals, tt.wantAccidentals)
+			}
+			var key smf.Key
+			if !got.message.GetMetaKey(&key) || key != tt.wantKey {
+				t.Errorf("parseABCKey() key = %+v, want %+v", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+// notePitches returns the pitches of the file's notes and the ticks at which
+// they start, in order
+func notePitches(data *smf.SMF) (pitches []uint8, starts []int64) {
+	for _, n := range collectNotes(data) {
+		pitches = append(pitches, n.pitch)
+		starts = append(starts, n.start)
+	}
+	return
+}
+
+// eighthStarts returns the starts of that many eighth notes in a row
+func eighthStarts(count int) []int64 {
+	starts := make([]int64, count)
+	for k := range starts {
+		starts[k] = int64(k) * 240
+	}
+	return starts
+}
+
+func Test_importFiles_readABC(t *testing.T) {
+	tests := map[string]struct {
+		tune        string
+		number      int
+		wantPitches []uint8
+		wantStarts  []int64
+		wantErr     string
+	}{
+		"repeats and endings": {
+			tune:        "X:1\nT:Test\nM:2/4\nL:1/8\nK:G\n|:GA|1 Bc:|2 de|]\n",
+			wantPitches: []uint8{67, 69, 71, 72, 67, 69, 74, 76},
+			wantStarts:  []int64{0, 240, 480, 720, 960, 1200, 1440, 1680},
+		},
+		"repeats one after another": {
+			tune:        "X:1\nK:C\nCDEF:|GABc:|\n",
+			wantPitches: []uint8{60, 62, 64, 65, 60, 62, 64, 65, 67, 69, 71, 72, 67, 69, 71, 72},
+			wantStarts:  eighthStarts(16),
+		},
+		"repeat after a section end": {
+			tune:        "X:1\nK:C\n|:CDEF:|GABc||defg:|\n",
+			wantPitches: []uint8{60, 62, 64, 65, 60, 62, 64, 65, 67, 69, 71, 72, 74, 76, 77, 79, 74, 76, 77, 79},
+			wantStarts:  eighthStarts(20),
+		},
+		"repeat with endings after a repeat": {
+			tune:        "X:1\nK:C\nCD:|EF|1G:|2A|]\n",
+			wantPitches: []uint8{60, 62, 60, 62, 64, 65, 67, 64, 65, 69},
+			wantStarts:  eighthStarts(10),
+		},
+		"accidentals last for the bar": {
+			tune:        "X:1\nK:F\nB ^F F =B | F B2\n",
+			wantPitches: []uint8{70, 66, 66, 71, 65, 70},
+			wantStarts:  []int64{0, 240, 480, 720, 960, 1200},
+		},
+		"tuplets broken rhythm and ties": {
+			tune:        "X:1\nL:1/4\nK:C\n(3cde c>d c2-|c\n",
+			wantPitches: []uint8{72, 74, 76, 72, 74, 72},
+			wantStarts:  []int64{0, 320, 640, 960, 1680, 1920},
+		},
+		"chords and octaves": {
+			tune:        "X:1\nK:C\n[C,Ec'] z/ d'/\n",
+			wantPitches: []uint8{48, 64, 84, 86},
+			wantStarts:  []int64{0, 0, 0, 360},
+		},
+		"voices": {
+			tune:        "X:1\nL:1/4\nV:1 name=\"Upper\"\nV:2\nK:C\nV:1\ncd\nV:2\nCD\n",
+			wantPitches: []uint8{72, 60, 74, 62},
+			wantStarts:  []int64{0, 0, 480, 480},
+		},
+		"second tune": {
+			tune:        "X:1\nK:C\nC\n\nX:2\nK:C\nD\n",
+			number:      2,
+			wantPitches: []uint8{62},
+			wantStarts:  []int64{0},
+		},
+		"missing tune": {tune: "X:1\nK:C\nC\n", number: 3, wantErr: "there is no tune X:3"},
+		"no key":       {tune: "X:1\nT:Nothing\n", wantErr: "the tune has no K: field"},
+		"bad meter":    {tune: "X:1\nM:3/5\nK:C\n", wantErr: "line 2: the meter \"3/5\" is not valid"},
+		"bad music":    {tune: "X:1\nK:C\nCDE\nC$D\n", wantErr: "line 4: the character \"$\" is not expected"},
+		"open chord":   {tune: "X:1\nK:C\n[CEG\n", wantErr: "line 3: a chord is not closed"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := (&importFiles{tune: tt.number}).readABC([]byte(tt.tune))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("importFiles.readABC() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("importFiles.readABC() error = %v", err)
+			}
+			pitches, starts := notePitches(data)
+			if !reflect.DeepEqual(pitches, tt.wantPitches) || !reflect.DeepEqual(starts, tt.wantStarts) {
+				t.Errorf("importFiles.readABC() notes = %v at %v, want %v at %v", pitches, starts, tt.wantPitches, tt.wantStarts)
+			}
+		})
+	}
+}
+
+func Test_importFiles_readABC_tiedChords(t *testing.T) {
+	// tied notes that end together start and stop in order of pitch
+	data, err := (&importFiles{}).readABC([]byte("X:1\nL:1/4\nK:C\n[GEc]- D [cAF]-\n"))
+	if err != nil {
+		t.Fatalf("importFiles.readABC() error = %v", err)
+	}
+	got := describeTracks(&smf.SMF{Tracks: data.Tracks[1:]})
+	want := "track 0\n" +
+		"0 NoteOn channel: 0 key: 64 velocity: 80\n" +
+		"0 NoteOn channel: 0 key: 67 velocity: 80\n" +
+		"0 NoteOn channel: 0 key: 72 velocity: 80\n" +
+		"480 NoteOff channel: 0 key: 64\n" +
+		"480 NoteOff channel: 0 key: 67\n" +
+		"480 NoteOff channel: 0 key: 72\n" +
+		"480 NoteOn channel: 0 key: 62 velocity: 80\n" +
+		"960 NoteOff channel: 0 key: 62\n" +
+		"960 NoteOn channel: 0 key: 65 velocity: 80\n" +
+		"960 NoteOn channel: 0 key: 69 velocity: 80\n" +
+		"960 NoteOn channel: 0 key: 72 velocity: 80\n" +
+		"1440 NoteOff channel: 0 key: 65\n" +
+		"1440 NoteOff channel: 0 key: 69\n" +
+		"1440 NoteOff channel: 0 key: 72\n" +
+		"1440 MetaEndOfTrack\n"
+	if got != want {
+		t.Errorf("importFiles.readABC() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_importFiles_readABC_header(t *testing.T) {
+	tune := "X:1\nT:Reel\nM:C|\nQ:1/2=60\nK:Dm\nV:1 nm=Fiddle\nDE\n"
+	data, err := (&importFiles{}).readABC([]byte(tune))
+	if err != nil {
+		t.Fatalf("importFiles.readABC() error = %v", err)
+	}
+	if len(data.Tracks) != 2 {
+		t.Fatalf("importFiles.readABC() wrote %d tracks, want 2", len(data.Tracks))
+	}
+	var title, voice string
+	var numerator, denominator uint8
+	var bpm float64
+	var key smf.Key
+	for _, event := range data.Tracks[0] {
+		_ = event.Message.GetMetaTrackName(&title)
+		_ = event.Message.GetMetaMeter(&numerator, &denominator)
+		_ = event.Message.GetMetaTempo(&bpm)
+		_ = event.Message.GetMetaKey(&key)
+	}
+	_ = data.Tracks[1][0].Message.GetMetaTrackName(&voice)
+	if title != "Reel" || numerator != 2 || denominator != 2 || round3(bpm) != 120 || keyName(key) != "DMinor" || voice != "Fiddle" {
+		t.Errorf("importFiles.readABC() header = %q %d/%d %g %s %q", title, numerator, denominator, bpm, keyName(key), voice)
+	}
+}
+
+func Test_export_writeABC(t *testing.T) {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaTrackSequenceName("Jig"))
+	conductor.Add(0, smf.MetaMeter(6, 8))
+	conductor.Add(0, smf.MetaKey(2, true, 2, false))
+	conductor.Add(0, smf.MetaTempo(90))
+	var melody smf.Track
+	for _, pitch := range []uint8{62, 66, 69, 72, 71, 69} {
+		melody.Add(0, midi.NoteOn(0, pitch, 80))
+		melody.Add(240, midi.NoteOff(0, pitch))
+	}
+	melody.Add(0, midi.NoteOn(0, 50, 80))
+	melody.Add(0, midi.NoteOn(0, 57, 80))
+	melody.Add(2160, midi.NoteOff(0, 50))
+	melody.Add(0, midi.NoteOff(0, 57))
+	var b bytes.Buffer
+	if err := (&export{quantize: 16}).writeABC(&b, makeTestSMF(conductor, melody)); err != nil {
+		t.Fatalf("export.writeABC() error = %v", err)
+	}
+	want := "X:1\nT:Jig\nM:6/8\nL:1/8\nQ:1/4=90\nK:D\n" +
+		"DFA =cBA | [D,A,]6- | [D,A,]3 z3 |]\n"
+	if got := b.String(); got != want {
+		t.Errorf("export.writeABC() = %q, want %q", got, want)
+	}
+	data, err := (&importFiles{}).readABC(b.Bytes())
+	if err != nil {
+		t.Fatalf("importFiles.readABC() of the export error = %v", err)
+	}
+	pitches, starts := notePitches(data)
+	if want := []uint8{62, 66, 69, 72, 71, 69, 50, 57}; !reflect.DeepEqual(pitches, want) {
+		t.Errorf("round trip pitches = %v, want %v", pitches, want)
+	}
+	if want := []int64{0, 240, 480, 720, 960, 1200, 1440, 1440}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("round trip starts = %v, want %v", starts, want)
+	}
+}
+
+func Test_export_writeABC_noMelody(t *testing.T) {
+	var drums smf.Track
+	drums.Add(0, midi.NoteOn(9, 36, 80))
+	drums.Add(240, midi.NoteOff(9, 36))
+	var b bytes.Buffer
+	if err := (&export{quantize: 16}).writeABC(&b, makeTestSMF(drums)); err == nil {
+		t.Errorf("export.writeABC() of drums alone did not fail")
+	}
+}
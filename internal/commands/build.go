@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+	"gopkg.in/yaml.v3"
+)
+
+// build converts dumped files, in YAML or JSON, back into standard MIDI files
+type build struct {
+	outFile string
+}
+
+func newBuild() command {
+	return &build{}
+}
+
+func (b *build) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&b.outFile, "output", "o", "", "file to write (default: the input file with the .mid extension)")
+}
+
+func (b *build) run(o output.Bus, args []string) int {
+	if b.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return exitUserError
+	}
+	return processFilesOfType(o, args, []string{".yaml", ".yml", ".json"}, b.processFile)
+}
+
+func (b *build) processFile(o output.Bus, path string) bool {
+	content, err := readFile(path)
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be read: %v.\n", path, err)
+		o.Log(output.Error, "cannot read file", map[string]any{"file": path, "error": err})
+		return false
+	}
+	data, err := buildSMF(content)
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be built: %v.\n", path, err)
+		o.Log(output.Error, "cannot build file", map[string]any{"file": path, "error": err})
+		return false
+	}
+	destination := b.outFile
+	if destination == "" {
+		destination = strings.TrimSuffix(path, filepath.Ext(path)) + ".mid"
+	}
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: built %s\n", path, destination)
+	return true
+}
+
+// buildSMF converts a dumped document (JSON being a subset of YAML) into a
+// file; errors name the line of the offending event
+func buildSMF(content []byte) (*smf.SMF, error) {
+	var doc dumpDocument
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	// a second pass finds the line of each event
+	var lines struct {
+		Tracks []struct {
+			Events []yaml.Node `yaml:"events"`
+		} `yaml:"tracks"`
+	}
+	if err := yaml.Unmarshal(content, &lines); err != nil {
+		return nil, err
+	}
+	var data *smf.SMF
+	switch doc.Format {
+	case 0:
+		if len(doc.Tracks) != 1 {
+			return nil, fmt.Errorf("a format 0 file holds 1 track, not %d", len(doc.Tracks))
+		}
+		data = smf.New()
+	case 1:
+		data = smf.NewSMF1()
+	case 2:
+		data = smf.NewSMF2()
+	default:
+		return nil, fmt.Errorf("the format %d is not valid: it must be 0, 1, or 2", doc.Format)
+	}
+	tf := doc.TimeFormat
+	switch {
+	case tf.TicksPerQuarter > 0 && tf.FramesPerSecond == 0 && tf.TicksPerQuarter <= 0x7FFF:
+		data.TimeFormat = smf.MetricTicks(tf.TicksPerQuarter)
+	case tf.TicksPerQuarter == 0 && (tf.FramesPerSecond == 24 || tf.FramesPerSecond == 25 ||
+		tf.FramesPerSecond == 29 || tf.FramesPerSecond == 30):
+		data.TimeFormat = smf.TimeCode{FramesPerSecond: tf.FramesPerSecond, SubFrames: tf.Subframes}
+	default:
+		return nil, errors.New("the time format needs either ticksPerQuarter (1 to 32767) or framesPerSecond " +
+			"(24, 25, 29, or 30)")
+	}
+	for t, dumped := range doc.Tracks {
+		var track smf.Track
+		var tick int64
+		for k, e := range dumped.Events {
+			line := lines.Tracks[t].Events[k].Line
+			if e.Tick < tick {
+				return nil, fmt.Errorf("line %d: the tick %d precedes the previous event's tick %d", line, e.Tick, tick)
+			}
+			if track.IsClosed() {
+				return nil, fmt.Errorf("line %d: the event follows the end of the track", line)
+			}
+			message, err := e.message()
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			track.Add(uint32(e.Tick-tick), message)
+			tick = e.Tick
+		}
+		track.Close(0)
+		_ = data.Add(track)
+	}
+	return data, nil
+}
+
+// message encodes the event; the fields its type needs must all be present
+func (e dumpEvent) message() (smf.Message, error) {
+	missing := func(fields string) error {
+		return fmt.Errorf("a %s event needs %s", e.Type, fields)
+	}
+	inRange := func(name string, v, high int) error {
+		if v > high {
+			return fmt.Errorf("the %s %d is not valid: it must be from 0 to %d", name, v, high)
+		}
+		return nil
+	}
+	data, err := parseHexBytes(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	if name, found := dumpTypeBytes()[e.Type]; found {
+		switch {
+		case e.Text != nil:
+			return smf.MetaUndefined(name, []byte(*e.Text)), nil
+		case e.Data != "":
+			return smf.MetaUndefined(name, data), nil
+		}
+		return smf.MetaUndefined(name, nil), nil
+	}
+	switch e.Type {
+	case "note_off", "note_on":
+		if e.Channel == nil || e.Key == nil || e.Velocity == nil {
+			return nil, missing("channel, key, and velocity")
+		}
+		return e.channelMessage(map[string]byte{"note_off": 0x80, "note_on": 0x90}[e.Type], *e.Key, *e.Velocity)
+	case "poly_aftertouch":
+		if e.Channel == nil || e.Key == nil || e.Pressure == nil {
+			return nil, missing("channel, key, and pressure")
+		}
+		return e.channelMessage(0xA0, *e.Key, *e.Pressure)
+	case "control_change":
+		if e.Channel == nil || e.Controller == nil || e.Value == nil {
+			return nil, missing("channel, controller, and value")
+		}
+		return e.channelMessage(0xB0, *e.Controller, *e.Value)
+	case "program_change":
+		if e.Channel == nil || e.Program == nil {
+			return nil, missing("channel and program")
+		}
+		return e.channelMessage(0xC0, *e.Program)
+	case "aftertouch":
+		if e.Channel == nil || e.Pressure == nil {
+			return nil, missing("channel and pressure")
+		}
+		return e.channelMessage(0xD0, *e.Pressure)
+	case "pitch_bend":
+		if e.Channel == nil || e.Bend == nil {
+			return nil, missing("channel and bend")
+		}
+		if *e.Bend < -0x2000 || *e.Bend > 0x1FFF {
+			return nil, fmt.Errorf("the bend %d is not valid: it must be from -8192 to 8191", *e.Bend)
+		}
+		v := *e.Bend + 0x2000
+		return e.channelMessage(0xE0, byte(v&0x7F), byte(v>>7))
+	case "sysex":
+		return smf.Message(append([]byte{0xF0}, data...)), nil
+	case "sysex_escape":
+		return smf.Message(append([]byte{0xF7}, data...)), nil
+	case "sequence_number":
+		if e.Number == nil {
+			return nil, missing("number")
+		}
+		return smf.MetaUndefined(0x00, []byte{byte(*e.Number >> 8), byte(*e.Number)}), nil
+	case "channel_prefix":
+		if e.Channel == nil {
+			return nil, missing("channel")
+		}
+		if err := inRange("channel", int(*e.Channel), 15); err != nil {
+			return nil, err
+		}
+		return smf.MetaUndefined(0x20, []byte{*e.Channel}), nil
+	case "port":
+		if e.Port == nil {
+			return nil, missing("port")
+		}
+		return smf.MetaUndefined(0x21, []byte{*e.Port}), nil
+	case "end_of_track":
+		return smf.MetaUndefined(0x2F, nil), nil
+	case "tempo":
+		if e.Microseconds == nil {
+			return nil, missing("microseconds")
+		}
+		v := *e.Microseconds
+		if v == 0 || v > 0xFFFFFF {
+			return nil, fmt.Errorf("the microseconds %d are not valid: they must be from 1 to %d", v, 0xFFFFFF)
+		}
+		return smf.MetaUndefined(0x51, []byte{byte(v >> 16), byte(v >> 8), byte(v)}), nil
+	case "smpte_offset":
+		if e.Hour == nil || e.Minute == nil || e.Second == nil || e.Frame == nil || e.Subframe == nil {
+			return nil, missing("hour, minute, second, frame, and subframe")
+		}
+		return smf.MetaUndefined(0x54, []byte{*e.Hour, *e.Minute, *e.Second, *e.Frame, *e.Subframe}), nil
+	case "time_signature":
+		if e.Numerator == nil || e.Denominator == nil || e.Clocks == nil || e.ThirtySeconds == nil {
+			return nil, missing("numerator, denominator, clocks, and thirtySeconds")
+		}
+		power := byte(0)
+		for 1<<power < int(*e.Denominator) {
+			power++
+		}
+		if 1<<power != int(*e.Denominator) {
+			return nil, fmt.Errorf("the denominator %d is not valid: it must be a power of 2", *e.Denominator)
+		}
+		return smf.MetaUndefined(0x58, []byte{*e.Numerator, power, *e.Clocks, *e.ThirtySeconds}), nil
+	case "key_signature":
+		if e.Accidentals == nil || e.Mode == "" {
+			return nil, missing("accidentals and mode")
+		}
+		if *e.Accidentals < -7 || *e.Accidentals > 7 {
+			return nil, fmt.Errorf("the accidentals %d are not valid: they must be from -7 to 7", *e.Accidentals)
+		}
+		modes := map[string]byte{"major": 0, "minor": 1}
+		mode, found := modes[e.Mode]
+		if !found {
+			return nil, fmt.Errorf("the mode %q is not valid: it must be major or minor", e.Mode)
+		}
+		return smf.MetaUndefined(0x59, []byte{byte(*e.Accidentals), mode}), nil
+	case "sequencer_specific":
+		return smf.MetaUndefined(0x7F, data), nil
+	case "meta":
+		if e.Meta == nil {
+			return nil, missing("meta")
+		}
+		if err := inRange("meta", int(*e.Meta), 0x7F); err != nil {
+			return nil, err
+		}
+		return smf.MetaUndefined(*e.Meta, data), nil
+	case "raw":
+		if len(data) == 0 {
+			return nil, missing("data")
+		}
+		return smf.Message(data), nil
+	}
+	return nil, fmt.Errorf("the type %q is not known", e.Type)
+}
+
+// channelMessage encodes a channel message with the status and data bytes
+func (e dumpEvent) channelMessage(status byte, values ...byte) (smf.Message, error) {
+	if *e.Channel > 15 {
+		return nil, fmt.Errorf("the channel %d is not valid: it must be from 0 to 15", *e.Channel)
+	}
+	for _, v := range values {
+		if v > 0x7F {
+			return nil, fmt.Errorf("the value %d is not valid: it must be from 0 to 127", v)
+		}
+	}
+	return smf.Message(append([]byte{status | *e.Channel}, values...)), nil
+}
+
+// dumpTypeBytes maps the text meta event names to their type bytes
+func dumpTypeBytes() map[string]byte {
+	types := map[string]byte{}
+	for typ, name := range dumpTextTypes {
+		types[name] = typ
+	}
+	return types
+}
+
+// parseHexBytes reads space separated hex bytes
+func parseHexBytes(s string) ([]byte, error) {
+	var data []byte
+	for _, field := range strings.Fields(s) {
+		b, err := hex.DecodeString(field)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("the data %q is not valid: it must be hex bytes separated by spaces", s)
+		}
+		data = append(data, b[0])
+	}
+	return data, nil
+}
@@ -0,0 +1,296 @@
+package commands
+
+import (
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// chasedMetaTypes are the meta events whose effect lasts, so that a part of a
+// file starting later must repeat them: the copyright, the names, the channel
+// prefix and port, the tempo, and the time and key signatures
+var chasedMetaTypes = map[byte]bool{
+	0x02: true, 0x03: true, 0x04: true, 0x08: true, 0x09: true,
+	0x20: true, 0x21: true, 0x51: true, 0x58: true, 0x59: true,
+}
+
+// parameterNumber names a registered (RPN) or non-registered (NRPN)
+// parameter
+type parameterNumber struct {
+	registered bool
+	number     uint16
+}
+
+// controllers that select and set parameters
+const (
+	dataEntryMSB  = 6
+	dataEntryLSB  = 38
+	nrpnLSB       = 98
+	nrpnMSB       = 99
+	rpnLSB        = 100
+	rpnMSB        = 101
+	parameterNull = 0x3FFF
+)
+
+// chasedChannel is what a channel's messages have set up to some point; -1
+// marks a value never set
+type chasedChannel struct {
+	program     int
+	programBank [2]int // the bank select MSB and LSB when the program was chosen
+	controllers [128]int
+	// parameters holds the data entry MSB and LSB of each parameter set
+	parameters map[parameterNumber][2]int
+	registered bool // whether an RPN, rather than an NRPN, was selected last
+	pitchBend  int  // from -8192 to 8191
+	pressure   int
+	notes      map[uint8]uint8 // the sounding notes and their velocities
+	noteOrder  []uint8         // the sounding notes, in the order they began
+}
+
+func newChasedChannel() *chasedChannel {
+	ch := &chasedChannel{
+		program:     -1,
+		programBank: [2]int{-1, -1},
+		parameters:  map[parameterNumber][2]int{},
+		pitchBend:   -1 << 15,
+		pressure:    -1,
+		notes:       map[uint8]uint8{},
+	}
+	for k := range ch.controllers {
+		ch.controllers[k] = -1
+	}
+	return ch
+}
+
+// hasPitchBend reports whether the pitch bend was set
+func (ch *chasedChannel) hasPitchBend() bool {
+	return ch.pitchBend != -1<<15
+}
+
+// selected returns the parameter that data entry sets, if one is selected
+func (ch *chasedChannel) selected() (parameterNumber, bool) {
+	msb, lsb := nrpnMSB, nrpnLSB
+	if ch.registered {
+		msb, lsb = rpnMSB, rpnLSB
+	}
+	if ch.controllers[msb] < 0 || ch.controllers[lsb] < 0 {
+		return parameterNumber{}, false
+	}
+	number := uint16(ch.controllers[msb])<<7 | uint16(ch.controllers[lsb])
+	return parameterNumber{registered: ch.registered, number: number}, number != parameterNull
+}
+
+func (ch *chasedChannel) control(controller, value uint8) {
+	switch {
+	case controller == dataEntryMSB || controller == dataEntryLSB:
+		if p, found := ch.selected(); found {
+			entry, set := ch.parameters[p]
+			if !set {
+				entry = [2]int{0, -1}
+			}
+			if controller == dataEntryMSB {
+				// a new MSB starts a new value
+				entry = [2]int{int(value), -1}
+			} else {
+				entry[1] = int(value)
+			}
+			ch.parameters[p] = entry
+		}
+		return
+	case controller == nrpnLSB || controller == nrpnMSB:
+		ch.registered = false
+	case controller == rpnLSB || controller == rpnMSB:
+		ch.registered = true
+	case controller == 121:
+		// reset all controllers, as recommended practice RP-015 has it, but
+		// the bank, volume, pan, and effects depths
+		for k := range ch.controllers {
+			if k != 0 && k != 7 && k != 10 && k != 32 && (k < 91 || k > 95) {
+				ch.controllers[k] = -1
+			}
+		}
+		ch.pitchBend, ch.pressure = -1<<15, -1
+		return
+	case controller == 120 || controller >= 123:
+		// all sound off, all notes off, and the mode changes that imply it
+		clear(ch.notes)
+		ch.noteOrder = nil
+		return
+	case controller >= 120:
+		return
+	}
+	ch.controllers[controller] = int(value)
+}
+
+func (ch *chasedChannel) noteOn(key, velocity uint8) {
+	if _, sounding := ch.notes[key]; !sounding {
+		ch.noteOrder = append(ch.noteOrder, key)
+	}
+	ch.notes[key] = velocity
+}
+
+func (ch *chasedChannel) noteOff(key uint8) {
+	delete(ch.notes, key)
+	ch.noteOrder = slices.DeleteFunc(ch.noteOrder, func(k uint8) bool { return k == key })
+}
+
+// parameterNumbers returns the parameters set, the registered ones first, in
+// order
+func (ch *chasedChannel) parameterNumbers() []parameterNumber {
+	parameters := make([]parameterNumber, 0, len(ch.parameters))
+	for p := range ch.parameters {
+		parameters = append(parameters, p)
+	}
+	slices.SortFunc(parameters, func(a, b parameterNumber) int {
+		if a.registered != b.registered {
+			if a.registered {
+				return -1
+			}
+			return 1
+		}
+		return int(a.number) - int(b.number)
+	})
+	return parameters
+}
+
+// messages returns the messages that set the channel up again; with notes,
+// the sounding notes begin again, too
+func (ch *chasedChannel) messages(channel uint8, notes bool) []smf.Message {
+	var messages []smf.Message
+	cc := func(controller uint8, value int) {
+		if value >= 0 {
+			messages = append(messages, smf.Message(midi.ControlChange(channel, controller, uint8(value))))
+		}
+	}
+	if ch.program >= 0 {
+		cc(0, ch.programBank[0])
+		cc(32, ch.programBank[1])
+		messages = append(messages, smf.Message(midi.ProgramChange(channel, uint8(ch.program))))
+	}
+	for controller, value := range ch.controllers {
+		switch controller {
+		case 0, 32, dataEntryMSB, dataEntryLSB, nrpnLSB, nrpnMSB, rpnLSB, rpnMSB, 96, 97:
+			continue
+		}
+		cc(uint8(controller), value)
+	}
+	if ch.controllers[0] != ch.programBank[0] || ch.controllers[32] != ch.programBank[1] {
+		// the bank was selected for a program change yet to come
+		cc(0, ch.controllers[0])
+		cc(32, ch.controllers[32])
+	}
+	selectParameter := func(registered bool, msb, lsb int) {
+		if registered {
+			cc(rpnMSB, msb)
+			cc(rpnLSB, lsb)
+		} else {
+			cc(nrpnMSB, msb)
+			cc(nrpnLSB, lsb)
+		}
+	}
+	for _, p := range ch.parameterNumbers() {
+		selectParameter(p.registered, int(p.number>>7), int(p.number&0x7F))
+		entry := ch.parameters[p]
+		cc(dataEntryMSB, entry[0])
+		cc(dataEntryLSB, entry[1])
+	}
+	if ch.registered {
+		selectParameter(true, ch.controllers[rpnMSB], ch.controllers[rpnLSB])
+	} else {
+		selectParameter(false, ch.controllers[nrpnMSB], ch.controllers[nrpnLSB])
+	}
+	if ch.hasPitchBend() {
+		messages = append(messages, smf.Message(midi.Pitchbend(channel, int16(ch.pitchBend))))
+	}
+	if ch.pressure >= 0 {
+		messages = append(messages, smf.Message(midi.AfterTouch(channel, uint8(ch.pressure))))
+	}
+	if notes {
+		for _, key := range ch.noteOrder {
+			messages = append(messages, smf.Message(midi.NoteOn(channel, key, ch.notes[key])))
+		}
+	}
+	return messages
+}
+
+// chaser follows messages up to some point, tracking the state they set up:
+// the lasting meta events, the system exclusive messages, and each channel's
+// program, controllers, parameters, pitch bend, pressure, and sounding notes
+type chaser struct {
+	metas    map[byte]smf.Message
+	sysEx    []smf.Message
+	channels [16]*chasedChannel
+}
+
+func newChaser() *chaser {
+	return &chaser{metas: map[byte]smf.Message{}}
+}
+
+// channel returns the state of the channel, creating it on first use
+func (c *chaser) channel(channel uint8) *chasedChannel {
+	if c.channels[channel] == nil {
+		c.channels[channel] = newChasedChannel()
+	}
+	return c.channels[channel]
+}
+
+func (c *chaser) apply(message smf.Message) {
+	var channel, key, velocity, controller, value, program, pressure uint8
+	var absolute uint16
+	switch {
+	case message.IsMeta():
+		if typ, _ := metaData(message); chasedMetaTypes[typ] {
+			c.metas[typ] = message
+		}
+	case message.Is(midi.SysExMsg):
+		c.sysEx = append(c.sysEx, message)
+	case message.GetNoteOn(&channel, &key, &velocity) && velocity > 0:
+		c.channel(channel).noteOn(key, velocity)
+	case message.GetNoteOn(&channel, &key, nil), message.GetNoteOff(&channel, &key, nil):
+		c.channel(channel).noteOff(key)
+	case message.GetControlChange(&channel, &controller, &value):
+		c.channel(channel).control(controller, value)
+	case message.GetProgramChange(&channel, &program):
+		ch := c.channel(channel)
+		ch.program, ch.programBank = int(program), [2]int{ch.controllers[0], ch.controllers[32]}
+	case message.GetPitchBend(&channel, nil, &absolute):
+		c.channel(channel).pitchBend = int(absolute) - 8192
+	case message.GetAfterTouch(&channel, &pressure):
+		c.channel(channel).pressure = int(pressure)
+	}
+}
+
+// messages returns the messages that set the state up again: the meta events
+// in the order of their types, the system exclusive messages, and then each
+// channel's messages; with notes, the sounding notes begin again, too
+func (c *chaser) messages(notes bool) []smf.Message {
+	var messages []smf.Message
+	types := make([]int, 0, len(c.metas))
+	for typ := range c.metas {
+		types = append(types, int(typ))
+	}
+	slices.Sort(types)
+	for _, typ := range types {
+		messages = append(messages, c.metas[byte(typ)])
+	}
+	messages = append(messages, c.sysEx...)
+	for channel, ch := range c.channels {
+		if ch != nil {
+			messages = append(messages, ch.messages(uint8(channel), notes)...)
+		}
+	}
+	return messages
+}
+
+// lastingMessage reports whether the message's effect lasts, as the chaser
+// tracks it: it is a lasting meta event, a system exclusive message, or a
+// channel message other than a note
+func lastingMessage(message smf.Message) bool {
+	if message.IsMeta() {
+		typ, _ := metaData(message)
+		return chasedMetaTypes[typ]
+	}
+	return message.Is(midi.SysExMsg) || message.Is(midi.ControlChangeMsg) || message.Is(midi.ProgramChangeMsg) ||
+		message.Is(midi.PitchBendMsg) || message.Is(midi.AfterTouchMsg)
+}
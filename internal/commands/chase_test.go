@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_chaser_messages(t *testing.T) {
+	tests := map[string]struct {
+		messages []smf.Message
+		notes    bool
+		want     []string
+	}{
+		"bank chosen after the program": {
+			messages: []smf.Message{
+				smf.Message(midi.ControlChange(1, 0, 1)),
+				smf.Message(midi.ProgramChange(1, 5)),
+				smf.Message(midi.ControlChange(1, 0, 2)),
+			},
+			want: []string{
+				"ControlChange channel: 1 controller: 0 value: 1",
+				"ProgramChange channel: 1 program: 5",
+				"ControlChange channel: 1 controller: 0 value: 2",
+			},
+		},
+		"the last of each lasting meta event": {
+			messages: []smf.Message{
+				smf.DMaj(), smf.MetaTempo(90), smf.MetaMarker("A"), smf.MetaTempo(100),
+			},
+			want: []string{"MetaTempo bpm: 100.00", "MetaKeySig key: DMaj"},
+		},
+		"reset all controllers": {
+			messages: []smf.Message{
+				smf.Message(midi.ControlChange(0, 7, 90)),
+				smf.Message(midi.ControlChange(0, 64, 127)),
+				smf.Message(midi.Pitchbend(0, 100)),
+				smf.Message(midi.ControlChange(0, 121, 0)),
+			},
+			want: []string{"ControlChange channel: 0 controller: 7 value: 90"},
+		},
+		"parameters": {
+			messages: []smf.Message{
+				smf.Message(midi.ControlChange(0, 99, 1)),
+				smf.Message(midi.ControlChange(0, 98, 8)),
+				smf.Message(midi.ControlChange(0, 6, 64)),
+				smf.Message(midi.ControlChange(0, 101, 0)),
+				smf.Message(midi.ControlChange(0, 100, 0)),
+				smf.Message(midi.ControlChange(0, 6, 2)),
+				smf.Message(midi.ControlChange(0, 38, 50)),
+			},
+			want: []string{
+				"ControlChange channel: 0 controller: 101 value: 0",
+				"ControlChange channel: 0 controller: 100 value: 0",
+				"ControlChange channel: 0 controller: 6 value: 2",
+				"ControlChange channel: 0 controller: 38 value: 50",
+				"ControlChange channel: 0 controller: 99 value: 1",
+				"ControlChange channel: 0 controller: 98 value: 8",
+				"ControlChange channel: 0 controller: 6 value: 64",
+				"ControlChange channel: 0 controller: 101 value: 0",
+				"ControlChange channel: 0 controller: 100 value: 0",
+			},
+		},
+		"sounding notes": {
+			messages: []smf.Message{
+				smf.Message(midi.NoteOn(2, 64, 90)),
+				smf.Message(midi.NoteOn(2, 60, 80)),
+				smf.Message(midi.NoteOn(2, 62, 70)),
+				smf.Message(midi.NoteOff(2, 60)),
+				smf.Message(midi.NoteOn(2, 67, 60)),
+				smf.Message(midi.ControlChange(3, 123, 0)),
+			},
+			notes: true,
+			want: []string{
+				"NoteOn channel: 2 key: 64 velocity: 90",
+				"NoteOn channel: 2 key: 62 velocity: 70",
+				"NoteOn channel: 2 key: 67 velocity: 60",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newChaser()
+			for _, message := range tt.messages {
+				c.apply(message)
+			}
+			var got []string
+			for _, message := range c.messages(tt.notes) {
+				got = append(got, message.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("chaser.messages() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// chordTemplate describes a chord quality by its intervals above the root
+type chordTemplate struct {
+	intervals []uint8
+	symbol    string // appended to the root in chord symbols
+	minor     bool   // rendered with a lower case Roman numeral
+	numeral   string // appended to the Roman numeral, before any inversion figures
+	seventh   bool   // uses seventh chord inversion figures
+}
+
+// chordTemplates are listed from simplest to most complex; ties in matching go
+// to the simpler template
+var chordTemplates = []chordTemplate{
+	{intervals: []uint8{0, 4, 7}, symbol: ""},
+	{intervals: []uint8{0, 3, 7}, symbol: "m", minor: true},
+	{intervals: []uint8{0, 3, 6}, symbol: "dim", minor: true, numeral: "°"},
+	{intervals: []uint8{0, 4, 8}, symbol: "aug", numeral: "+"},
+	{intervals: []uint8{0, 2, 7}, symbol: "sus2", numeral: "sus2"},
+	{intervals: []uint8{0, 5, 7}, symbol: "sus4", numeral: "sus4"},
+	{intervals: []uint8{0, 7}, symbol: "5", numeral: "5"},
+	{intervals: []uint8{0, 4, 7, 10}, symbol: "7", seventh: true},
+	{intervals: []uint8{0, 4, 7, 11}, symbol: "maj7", numeral: "M", seventh: true},
+	{intervals: []uint8{0, 3, 7, 10}, symbol: "m7", minor: true, seventh: true},
+	{intervals: []uint8{0, 3, 6, 10}, symbol: "m7♭5", minor: true, numeral: "ø", seventh: true},
+	{intervals: []uint8{0, 3, 6, 9}, symbol: "dim7", minor: true, numeral: "°", seventh: true},
+	{intervals: []uint8{0, 3, 7, 11}, symbol: "m(maj7)", minor: true, numeral: "M", seventh: true},
+	{intervals: []uint8{0, 4, 8, 11}, symbol: "aug(maj7)", numeral: "+M", seventh: true},
+	{intervals: []uint8{0, 5, 7, 10}, symbol: "7sus4", numeral: "sus4", seventh: true},
+	{intervals: []uint8{0, 4, 7, 9}, symbol: "6", numeral: "add6"},
+	{intervals: []uint8{0, 3, 7, 9}, symbol: "m6", minor: true, numeral: "add6"},
+	{intervals: []uint8{0, 4, 7, 2}, symbol: "add9", numeral: "add9"},
+	{intervals: []uint8{0, 3, 7, 2}, symbol: "m(add9)", minor: true, numeral: "add9"},
+	{intervals: []uint8{0, 4, 7, 10, 2}, symbol: "9", numeral: "9"},
+	{intervals: []uint8{0, 4, 7, 11, 2}, symbol: "maj9", numeral: "M9"},
+	{intervals: []uint8{0, 3, 7, 10, 2}, symbol: "m9", minor: true, numeral: "9"},
+	{intervals: []uint8{0, 4, 7, 10, 2, 5}, symbol: "11", numeral: "11"},
+	{intervals: []uint8{0, 3, 7, 10, 2, 5}, symbol: "m11", minor: true, numeral: "11"},
+	{intervals: []uint8{0, 4, 7, 10, 2, 9}, symbol: "13", numeral: "13"},
+	{intervals: []uint8{0, 4, 7, 11, 2, 9}, symbol: "maj13", numeral: "M13"},
+}
+
+var (
+	// Roman numerals for the scale degrees, indexed by semitones above the tonic
+	majorDegrees = [12]string{"I", "♭II", "II", "♭III", "III", "IV", "♯IV", "V", "♭VI", "VI", "♭VII", "VII"}
+	minorDegrees = [12]string{"I", "♭II", "II", "III", "♯III", "IV", "♯IV", "V", "VI", "♯VI", "VII", "♯VII"}
+)
+
+// chord is an identified chord: a root and bass pitch class, and a quality
+type chord struct {
+	root     uint8
+	bass     uint8
+	template *chordTemplate
+}
+
+// chordSegment is a span of time and the chord sounding during it; empty
+// segments have no identifiable chord
+type chordSegment struct {
+	span  tickRange
+	chord chord
+	empty bool
+}
+
+// analyze performs musical analyses of files; for now, the only analysis is
+// chord recognition ("analyze chords")
+type analyze struct {
+	segmentBy string
+}
+
+func newAnalyze() command {
+	return &analyze{}
+}
+
+func (a *analyze) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&a.segmentBy, "by", "beat", "segment the music by beat or by onset")
+}
+
+func (a *analyze) run(o output.Bus, args []string) int {
+	if len(args) == 0 || args[0] != "chords" {
+		o.ErrorPrintln("The analysis must be specified; the only analysis supported is \"chords\".")
+		return exitUserError
+	}
+	if a.segmentBy != "beat" && a.segmentBy != "onset" {
+		o.ErrorPrintf("The --by value %q is not valid: it must be beat or onset.\n", a.segmentBy)
+		return exitUserError
+	}
+	return processFiles(o, args[1:], a.processFile)
+}
+
+func (a *analyze) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	o.ConsolePrintf("%s:\n", path)
+	a.chart(o, data)
+	return true
+}
+
+// chart prints the chords, one line per bar, with a Roman numeral analysis
+// relative to the key in effect; repeated chords are shown once
+func (a *analyze) chart(o output.Bus, data *smf.SMF) {
+	m := newMeter(data)
+	notes := collectNotes(data)
+	keys := keyTimeline(data, notes)
+	var segments []chordSegment
+	if a.segmentBy == "onset" {
+		segments = segmentByOnset(notes)
+	} else {
+		segments = segmentByBeat(notes, m, lastTick(data))
+	}
+	currentBar := 0
+	var previous *chordSegment
+	var currentKey smf.Key
+	keyReported := false
+	var line []string
+	flush := func() {
+		if currentBar > 0 {
+			o.ConsolePrintf("  bar %d: %s\n", currentBar, strings.Join(line, " | "))
+		}
+		line = nil
+	}
+	for k := range segments {
+		s := &segments[k]
+		key, _ := keyAt(keys, s.span.from)
+		if !keyReported || key != currentKey {
+			flush()
+			currentKey = key
+			keyReported = true
+			currentBar = 0
+			o.ConsolePrintf("  key: %s\n", keyName(key))
+		}
+		bar, _, _ := m.locate(s.span.from)
+		if bar != currentBar {
+			flush()
+			currentBar = bar
+			previous = nil
+		}
+		if previous != nil && previous.empty == s.empty && previous.chord == s.chord {
+			continue
+		}
+		previous = s
+		if s.empty {
+			line = append(line, "N.C.")
+			continue
+		}
+		line = append(line, fmt.Sprintf("%s (%s)", s.chord.symbol(key), s.chord.romanNumeral(key)))
+	}
+	flush()
+}
+
+// segmentByBeat identifies the chord in each beat of the music
+func segmentByBeat(notes []note, m *meter, end int64) []chordSegment {
+	var segments []chordSegment
+	for tick := int64(0); tick < end; {
+		c := m.changeAtTick(tick)
+		next := tick + m.beatTicks(c)
+		if nextChange := m.changeAtTick(next); nextChange.tick > tick && nextChange.tick < next {
+			next = nextChange.tick
+		}
+		segments = append(segments, identifySegment(notes, tickRange{from: tick, to: next}))
+		tick = next
+	}
+	return segments
+}
+
+// segmentByOnset identifies the chord sounding between successive note onsets
+func segmentByOnset(notes []note) []chordSegment {
+	var onsets []int64
+	for _, n := range notes {
+		if n.channel != 9 && (len(onsets) == 0 || onsets[len(onsets)-1] != n.start) {
+			onsets = append(onsets, n.start)
+		}
+	}
+	var segments []chordSegment
+	for k, onset := range onsets {
+		span := tickRange{from: onset, to: -1}
+		if k+1 < len(onsets) {
+			span.to = onsets[k+1]
+		} else {
+			for _, n := range notes {
+				span.to = max(span.to, n.end)
+			}
+		}
+		segments = append(segments, identifySegment(notes, span))
+	}
+	return segments
+}
+
+// identifySegment identifies the chord formed by the non-percussion notes that
+// sound for at least a quarter of the span
+func identifySegment(notes []note, span tickRange) chordSegment {
+	var weights [12]int64
+	bass := -1
+	threshold := max((span.to-span.from)/4, 1)
+	for _, n := range notes {
+		if n.channel == 9 || n.start >= span.to || n.end <= span.from {
+			continue
+		}
+		overlap := min(n.end, span.to) - max(n.start, span.from)
+		if overlap < threshold {
+			continue
+		}
+		weights[n.pitch%12] += overlap
+		if bass < 0 || int(n.pitch) < bass {
+			bass = int(n.pitch)
+		}
+	}
+	if bass < 0 {
+		return chordSegment{span: span, empty: true}
+	}
+	c, found := identifyChord(weights, uint8(bass%12))
+	return chordSegment{span: span, chord: c, empty: !found}
+}
+
+// identifyChord finds the template and root that best explain the pitch
+// classes with non-zero weights
+func identifyChord(weights [12]int64, bass uint8) (best chord, found bool) {
+	var present [12]bool
+	count := 0
+	for pc, w := range weights {
+		if w > 0 {
+			present[pc] = true
+			count++
+		}
+	}
+	if count < 2 {
+		return
+	}
+	bestScore := -1000.0
+	for t := range chordTemplates {
+		template := &chordTemplates[t]
+		for root := uint8(0); root < 12; root++ {
+			if !present[root] {
+				continue
+			}
+			var inTemplate [12]bool
+			matched := 0
+			for _, interval := range template.intervals {
+				pc := (root + interval) % 12
+				inTemplate[pc] = true
+				if present[pc] {
+					matched++
+				}
+			}
+			missing := len(template.intervals) - matched
+			extra := count - matched
+			score := 2*float64(matched) - 1.5*float64(missing) - float64(extra)
+			if root == bass {
+				score += 0.25
+			}
+			if score > bestScore {
+				bestScore = score
+				best = chord{root: root, bass: bass, template: template}
+				found = true
+			}
+		}
+	}
+	return
+}
+
+// symbol renders the chord as a lead sheet symbol, e.g., "Am7/G"
+func (c chord) symbol(k smf.Key) string {
+	s := spellPitchClass(k, c.root) + c.template.symbol
+	if c.bass != c.root {
+		s += "/" + spellPitchClass(k, c.bass)
+	}
+	return s
+}
+
+// romanNumeral renders the chord as a Roman numeral relative to the key, with
+// figured bass inversion symbols, e.g., "V65"
+func (c chord) romanNumeral(k smf.Key) string {
+	degrees := majorDegrees
+	if !k.IsMajor {
+		degrees = minorDegrees
+	}
+	numeral := degrees[(c.root+12-k.Key%12)%12]
+	if c.template.minor {
+		numeral = strings.ToLower(numeral)
+	}
+	numeral += c.template.numeral
+	switch inversion := c.inversion(); {
+	case inversion < 0:
+		numeral += "/" + spellPitchClass(k, c.bass)
+	case c.template.seventh:
+		numeral += [4]string{"7", "65", "43", "42"}[inversion]
+	case inversion == 1:
+		numeral += "6"
+	case inversion == 2:
+		numeral += "64"
+	}
+	return numeral
+}
+
+// inversion returns 0 for root position, 1 when the third (or the suspended
+// tone) is in the bass, 2 when the fifth is, 3 when the seventh of a seventh
+// chord is, and -1 when the bass is not one of those chord tones
+func (c chord) inversion() int {
+	interval := (c.bass + 12 - c.root) % 12
+	intervals := c.template.intervals
+	switch {
+	case interval == 0:
+		return 0
+	case len(intervals) > 2 && interval == intervals[1]:
+		return 1
+	case len(intervals) > 2 && interval == intervals[2], len(intervals) == 2 && interval == intervals[1]:
+		return 2
+	case c.template.seventh && interval == intervals[3]:
+		return 3
+	}
+	return -1
+}
@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// makeChordTrack plays each chord as a block lasting a whole 4/4 bar on
+// channel 0
+func makeChordTrack(chords ...[]uint8) smf.Track {
+	var track smf.Track
+	for _, pitches := range chords {
+		for _, pitch := range pitches {
+			track.Add(0, midi.NoteOn(0, pitch, 80))
+		}
+		for k, pitch := range pitches {
+			delta := uint32(0)
+			if k == 0 {
+				delta = 1920
+			}
+			track.Add(delta, midi.NoteOff(0, pitch))
+		}
+	}
+	return track
+}
+
+func Test_identifyChord(t *testing.T) {
+	cMajor := smf.Key{IsMajor: true}
+	tests := map[string]struct {
+		pitches    []uint8
+		key        smf.Key
+		wantSymbol string
+		wantRoman  string
+	}{
+		"C major":            {pitches: []uint8{60, 64, 67}, key: cMajor, wantSymbol: "C", wantRoman: "I"},
+		"A minor":            {pitches: []uint8{57, 60, 64}, key: cMajor, wantSymbol: "Am", wantRoman: "vi"},
+		"A minor over C":     {pitches: []uint8{48, 57, 64}, key: cMajor, wantSymbol: "Am/C", wantRoman: "vi6"},
+		"G over D":           {pitches: []uint8{50, 59, 67}, key: cMajor, wantSymbol: "G/D", wantRoman: "V64"},
+		"G7":                 {pitches: []uint8{55, 59, 62, 65}, key: cMajor, wantSymbol: "G7", wantRoman: "V7"},
+		"G7 over B":          {pitches: []uint8{47, 55, 62, 65}, key: cMajor, wantSymbol: "G7/B", wantRoman: "V65"},
+		"G7 over F":          {pitches: []uint8{53, 55, 59, 62}, key: cMajor, wantSymbol: "G7/F", wantRoman: "V42"},
+		"B diminished":       {pitches: []uint8{59, 62, 65}, key: cMajor, wantSymbol: "Bdim", wantRoman: "vii°"},
+		"B half diminished":  {pitches: []uint8{59, 62, 65, 69}, key: cMajor, wantSymbol: "Bm7♭5", wantRoman: "viiø7"},
+		"F major seventh":    {pitches: []uint8{53, 57, 60, 64}, key: cMajor, wantSymbol: "Fmaj7", wantRoman: "IVM7"},
+		"D suspended fourth": {pitches: []uint8{62, 67, 69}, key: cMajor, wantSymbol: "Dsus4", wantRoman: "IIsus4"},
+		"E♭ in C major":      {pitches: []uint8{63, 67, 70}, key: smf.Key{IsMajor: true, IsFlat: true}, wantSymbol: "E♭", wantRoman: "♭III"},
+		"missing fifth":      {pitches: []uint8{55, 59, 65}, key: cMajor, wantSymbol: "G7", wantRoman: "V7"},
+		"minor key dominant": {pitches: []uint8{64, 68, 71}, key: smf.Key{Key: 9, Num: 0}, wantSymbol: "E", wantRoman: "V"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var weights [12]int64
+			bass := tt.pitches[0]
+			for _, p := range tt.pitches {
+				weights[p%12]++
+				bass = min(bass, p)
+			}
+			c, found := identifyChord(weights, bass%12)
+			if !found {
+				t.Fatalf("identifyChord() found no chord")
+			}
+			if got := c.symbol(tt.key); got != tt.wantSymbol {
+				t.Errorf("chord.symbol() = %q, want %q", got, tt.wantSymbol)
+			}
+			if got := c.romanNumeral(tt.key); got != tt.wantRoman {
+				t.Errorf("chord.romanNumeral() = %q, want %q", got, tt.wantRoman)
+			}
+		})
+	}
+}
+
+func Test_identifyChord_tooFewNotes(t *testing.T) {
+	var weights [12]int64
+	weights[0] = 10
+	if _, found := identifyChord(weights, 0); found {
+		t.Errorf("identifyChord() found a chord in a single pitch class")
+	}
+}
+
+func Test_analyze_chart(t *testing.T) {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaKey(0, true, 0, false))
+	chords := makeChordTrack(
+		[]uint8{48, 64, 67},
+		[]uint8{48, 57, 64},
+		[]uint8{53, 57, 60},
+		[]uint8{55, 59, 62, 65},
+	)
+	// a final bar in which the chord changes halfway through
+	chords.Add(0, midi.NoteOn(0, 48, 80))
+	chords.Add(0, midi.NoteOn(0, 64, 80))
+	chords.Add(960, midi.NoteOff(0, 64))
+	chords.Add(0, midi.NoteOn(0, 65, 80))
+	chords.Add(0, midi.NoteOn(0, 57, 80))
+	chords.Add(960, midi.NoteOff(0, 48))
+	chords.Add(0, midi.NoteOff(0, 65))
+	chords.Add(0, midi.NoteOff(0, 57))
+	data := makeTestSMF(conductor, chords)
+	tests := map[string]struct {
+		by   string
+		want string
+	}{
+		"by beat": {
+			by: "beat",
+			want: "  key: CMajor\n" +
+				"  bar 1: C (I)\n" +
+				"  bar 2: Am/C (vi6)\n" +
+				"  bar 3: F (IV)\n" +
+				"  bar 4: G7 (V7)\n" +
+				"  bar 5: C (I) | F/C (IV64)\n",
+		},
+		"by onset": {
+			by: "onset",
+			want: "  key: CMajor\n" +
+				"  bar 1: C (I)\n" +
+				"  bar 2: Am/C (vi6)\n" +
+				"  bar 3: F (IV)\n" +
+				"  bar 4: G7 (V7)\n" +
+				"  bar 5: C (I) | F/C (IV64)\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			(&analyze{segmentBy: tt.by}).chart(o, data)
+			if got := o.ConsoleOutput(); got != tt.want {
+				t.Errorf("analyze.chart() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_analyze_run(t *testing.T) {
+	tests := map[string]struct {
+		a    *analyze
+		args []string
+		want int
+	}{
+		"no analysis":      {a: &analyze{segmentBy: "beat"}, want: exitUserError},
+		"unknown analysis": {a: &analyze{segmentBy: "beat"}, args: []string{"melody"}, want: exitUserError},
+		"bad segmentation": {a: &analyze{segmentBy: "bar"}, args: []string{"chords", "x.mid"}, want: exitUserError},
+		"no files":         {a: &analyze{segmentBy: "beat"}, args: []string{"chords"}, want: exitUserError},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.a.run(output.NewRecorder(), tt.args); got != tt.want {
+				t.Errorf("analyze.run() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
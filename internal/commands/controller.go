@@ -1,6 +1,113 @@
 package commands
 
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+)
+
+// exit codes; 1 is left for the commands that, like git diff, report a
+// difference or a conflict with it
+const (
+	exitSuccess     = 0
+	exitUserError   = 2
+	exitSystemError = 3
+)
+
+// command is implemented by each smf-tool subcommand
+type command interface {
+	// defineFlags binds the command's flags to the provided flag set
+	defineFlags(flags *pflag.FlagSet)
+	// run executes the command with the arguments left over after flag
+	// parsing, and returns the process exit code
+	run(o output.Bus, args []string) int
+}
+
+type commandDescription struct {
+	summary string
+	create  func() command
+}
+
+var commandTable = map[string]commandDescription{}
+
 // Load is meant to be called by main(), to load the commands package
 func Load() {
-	// does nothing
+	commandTable = map[string]commandDescription{
+		"analyze":   {summary: "analyze the music; \"analyze chords\" charts the chords", create: newAnalyze},
+		"build":     {summary: "build files from their YAML or JSON dumps", create: newBuild},
+		"cut":       {summary: "write a range of files, such as a marker's section, as files of their own", create: newCut},
+		"delete":    {summary: "delete a range of files, closing the gap", create: newRangeDeleter},
+		"diff":      {summary: "compare files event by event, in musical terms", create: newDiff},
+		"dump":      {summary: "write files as editable YAML or JSON", create: newDump},
+		"export":    {summary: "convert files to other formats", create: newExport},
+		"import":    {summary: "convert files from other formats", create: newImport},
+		"index":     {summary: "index a library of files, and search it by key, tempo, instrument, and more", create: newIndex},
+		"insert":    {summary: "insert empty bars into files", create: newBarInserter},
+		"key":       {summary: "estimate the key from the notes", create: newKeyEstimator},
+		"meta":      {summary: "get, set, and remove text meta events such as track names and copyright", create: newMeta},
+		"merge3":    {summary: "merge two sides' changes to a base file, as a git merge driver", create: newMerge3},
+		"pianoroll": {summary: "draw the notes as a piano roll in SVG or PNG", create: newPianoRoll},
+		"read":      {summary: "print the events of files, optionally filtered", create: newRead},
+		"render":    {summary: "render files to WAV audio with a built-in synthesizer", create: newRender},
+		"repeat":    {summary: "repeat a range of files a number of times", create: newRangeRepeater},
+		"search":    {summary: "find a melody in files, in any key", create: newSearch},
+		"sections":  {summary: "list the sections of files marked out by markers and cue points", create: newSections},
+		"state":     {summary: "report the tempo, signatures, and each channel's state at a position", create: newState},
+		"stats":     {summary: "report musical statistics per track and channel", create: newStats},
+		"tokens":    {summary: "check the measures of token-language scores against their time signatures", create: newTokens},
+		"transcode": {summary: "rewrite the text of files, such as track names and lyrics, in UTF-8", create: newTranscode},
+		"velocity":  {summary: "edit note velocities", create: newVelocity},
+		"view":      {summary: "show the tracks and notes as text in the terminal", create: newView},
+	}
+}
+
+// Execute runs the command named by the first argument, passing it the remaining
+// arguments, and returns the process exit code
+func Execute(o output.Bus, args []string) int {
+	if len(args) == 0 {
+		o.ErrorPrintln("No command was specified.")
+		listCommands(o)
+		return exitUserError
+	}
+	name := args[0]
+	description, found := commandTable[name]
+	if !found {
+		o.ErrorPrintf("The command %q is not recognized.\n", name)
+		listCommands(o)
+		return exitUserError
+	}
+	cmd := description.create()
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.SetOutput(o.ErrorWriter())
+	cmd.defineFlags(flags)
+	flags.IntVar(&jobs, "jobs", runtime.NumCPU(), "number of files to process at once")
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return exitSuccess
+		}
+		o.ErrorPrintf("The arguments for command %q are not valid: %v.\n", name, err)
+		return exitUserError
+	}
+	if jobs < 1 {
+		o.ErrorPrintf("The --jobs value %d is not valid: it must be at least 1.\n", jobs)
+		return exitUserError
+	}
+	o.Log(output.Info, "executing command", map[string]any{"command": name, "args": flags.Args()})
+	return cmd.run(o, flags.Args())
+}
+
+func listCommands(o output.Bus) {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	o.ErrorPrintln("Available commands:")
+	for _, name := range names {
+		o.ErrorPrintln(fmt.Sprintf("  %-10s %s", name, commandTable[name].summary))
+	}
 }
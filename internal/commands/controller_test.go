@@ -2,6 +2,8 @@ package commands
 
 import (
 	"testing"
+
+	"github.com/majohn-r/output"
 )
 
 func TestLoad(t *testing.T) {
@@ -10,6 +12,54 @@ func TestLoad(t *testing.T) {
 	for name := range tests {
 		t.Run(name, func(t *testing.T) {
 			Load()
+			if _, found := commandTable["velocity"]; !found {
+				t.Errorf("Load() did not register the velocity command")
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	Load()
+	tests := map[string]struct {
+		args         []string
+		wantExitCode int
+		output.WantedRecording
+	}{
+		"no command": {
+			args:         nil,
+			wantExitCode: exitUserError,
+		},
+		"unknown command": {
+			args:         []string{"frobnicate"},
+			wantExitCode: exitUserError,
+		},
+		"bad flag": {
+			args:         []string{"velocity", "--no-such-flag"},
+			wantExitCode: exitUserError,
+		},
+		"no files": {
+			args:         []string{"velocity"},
+			wantExitCode: exitUserError,
+		},
+		"bad jobs": {
+			args:         []string{"velocity", "--jobs", "0", "a.mid"},
+			wantExitCode: exitUserError,
+		},
+		"missing file": {
+			args:         []string{"velocity", "no such file.mid"},
+			wantExitCode: exitSystemError,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := Execute(o, tt.args); got != tt.wantExitCode {
+				t.Errorf("Execute() = %d, want %d", got, tt.wantExitCode)
+			}
+			if tt.wantExitCode != exitSuccess && o.ErrorOutput() == "" {
+				t.Errorf("Execute() wrote no error output")
+			}
 		})
 	}
 }
@@ -0,0 +1,645 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// writeCSV writes the file in the midicsv format: one row per event, each
+// starting with the track number (counting from 1) and the absolute time in
+// ticks, between Header and End_of_file rows
+func (e *export) writeCSV(w io.Writer, data *smf.SMF) error {
+	ticks, ok := data.TimeFormat.(smf.MetricTicks)
+	if !ok {
+		return fmt.Errorf("the time format %s cannot be written as CSV", data.TimeFormat)
+	}
+	o := output.NewCustomBus(w, io.Discard, output.NilLogger{})
+	o.ConsolePrintf("0, 0, Header, %d, %d, %d\n", data.Format(), len(data.Tracks), ticks.Ticks4th())
+	for k, track := range data.Tracks {
+		rows := &csvRows{track: k + 1}
+		o.ConsolePrintf("%d, 0, Start_track\n", rows.track)
+		interpretTrack(o, rows, track)
+		if rows.err != nil {
+			return rows.err
+		}
+		if !track.IsClosed() {
+			o.ConsolePrintf("%d, %d, End_track\n", rows.track, rows.tick)
+		}
+	}
+	o.ConsolePrintln("0, 0, End_of_file")
+	return nil
+}
+
+// csvRows writes the events of one track as midicsv rows
+type csvRows struct {
+	track int
+	tick  int64
+	err   error
+}
+
+func (c *csvRows) beginEvent(o output.Bus, _ int, event smf.Event) {
+	c.tick += int64(event.Delta)
+	o.ConsolePrintf("%d, %d, ", c.track, c.tick)
+}
+
+func (c *csvRows) interpretAfterTouchMsg(o output.Bus, message smf.Message) {
+	var channel, pressure uint8
+	_ = message.GetAfterTouch(&channel, &pressure)
+	o.ConsolePrintf("Channel_aftertouch_c, %d, %d\n", channel, pressure)
+}
+
+func (c *csvRows) interpretControlChangeMsg(o output.Bus, message smf.Message) {
+	var channel, controller, value uint8
+	_ = message.GetControlChange(&channel, &controller, &value)
+	o.ConsolePrintf("Control_c, %d, %d, %d\n", channel, controller, value)
+}
+
+func (c *csvRows) interpretMetaChannelMsg(o output.Bus, message smf.Message) {
+	var channel uint8
+	_ = message.GetMetaChannel(&channel)
+	o.ConsolePrintf("Channel_prefix, %d\n", channel)
+}
+
+func (c *csvRows) interpretMetaCopyrightMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaCopyright(&text)
+	o.ConsolePrintf("Copyright_t, %s\n", csvString(text))
+}
+
+func (c *csvRows) interpretMetaCuepointMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaCuepoint(&text)
+	o.ConsolePrintf("Cue_point_t, %s\n", csvString(text))
+}
+
+// interpretMetaDeviceMsg writes the device name as an unknown meta event, as
+// midicsv has no row type for it
+func (c *csvRows) interpretMetaDeviceMsg(o output.Bus, message smf.Message) {
+	c.interpretUnrecognizedMsg(o, message)
+}
+
+func (c *csvRows) interpretMetaInstrumentMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaInstrument(&text)
+	o.ConsolePrintf("Instrument_name_t, %s\n", csvString(text))
+}
+
+func (c *csvRows) interpretMetaKeySigMsg(o output.Bus, message smf.Message) {
+	var num uint8
+	var isMajor, isFlat bool
+	_ = message.GetMetaKeySig(nil, &num, &isMajor, &isFlat)
+	accidentals := int(num)
+	if isFlat {
+		accidentals = -accidentals
+	}
+	mode := "minor"
+	if isMajor {
+		mode = "major"
+	}
+	o.ConsolePrintf("Key_signature, %d, %s\n", accidentals, csvString(mode))
+}
+
+func (c *csvRows) interpretMetaLyricMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaLyric(&text)
+	o.ConsolePrintf("Lyric_t, %s\n", csvString(text))
+}
+
+func (c *csvRows) interpretMetaMarkerMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaMarker(&text)
+	o.ConsolePrintf("Marker_t, %s\n", csvString(text))
+}
+
+func (c *csvRows) interpretMetaPortMsg(o output.Bus, message smf.Message) {
+	var port uint8
+	_ = message.GetMetaPort(&port)
+	o.ConsolePrintf("MIDI_port, %d\n", port)
+}
+
+// interpretMetaProgramNameMsg writes the program name as an unknown meta
+// event, as midicsv has no row type for it
+func (c *csvRows) interpretMetaProgramNameMsg(o output.Bus, message smf.Message) {
+	c.interpretUnrecognizedMsg(o, message)
+}
+
+func (c *csvRows) interpretMetaSMPTEOffsetMsg(o output.Bus, message smf.Message) {
+	var hour, minute, second, frame, fractFrame uint8
+	_ = message.GetMetaSMPTEOffsetMsg(&hour, &minute, &second, &frame, &fractFrame)
+	o.ConsolePrintf("SMPTE_offset, %d, %d, %d, %d, %d\n", hour, minute, second, frame, fractFrame)
+}
+
+func (c *csvRows) interpretMetaSeqDataMsg(o output.Bus, message smf.Message) {
+	var bt []byte
+	_ = message.GetMetaSeqData(&bt)
+	o.ConsolePrintf("Sequencer_specific, %d%s\n", len(bt), csvBytes(bt))
+}
+
+func (c *csvRows) interpretMetaSeqNumberMsg(o output.Bus, message smf.Message) {
+	var sequenceNumber uint16
+	_ = message.GetMetaSeqNumber(&sequenceNumber)
+	o.ConsolePrintf("Sequence_number, %d\n", sequenceNumber)
+}
+
+// interpretMetaTempoMsg writes the tempo as midicsv does, in microseconds per
+// quarter note, straight from the message so that it survives a round trip
+func (c *csvRows) interpretMetaTempoMsg(o output.Bus, message smf.Message) {
+	_, data := metaData(message)
+	var microseconds int
+	for _, b := range data {
+		microseconds = microseconds<<8 | int(b)
+	}
+	o.ConsolePrintf("Tempo, %d\n", microseconds)
+}
+
+func (c *csvRows) interpretMetaTextMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaText(&text)
+	o.ConsolePrintf("Text_t, %s\n", csvString(text))
+}
+
+func (c *csvRows) interpretMetaTimeSigMsg(o output.Bus, message smf.Message) {
+	var numerator, denominator, clocksPerClick, demiSemiQuaverPerQuarter uint8
+	_ = message.GetMetaTimeSig(&numerator, &denominator, &clocksPerClick, &demiSemiQuaverPerQuarter)
+	o.ConsolePrintf("Time_signature, %d, %d, %d, %d\n",
+		numerator, bits.TrailingZeros8(denominator), clocksPerClick, demiSemiQuaverPerQuarter)
+}
+
+func (c *csvRows) interpretMetaTrackNameMsg(o output.Bus, message smf.Message) {
+	var text string
+	_ = message.GetMetaTrackName(&text)
+	o.ConsolePrintf("Title_t, %s\n", csvString(text))
+}
+
+func (c *csvRows) interpretNoteOffMsg(o output.Bus, message smf.Message) {
+	var channel, key, velocity uint8
+	_ = message.GetNoteOff(&channel, &key, &velocity)
+	o.ConsolePrintf("Note_off_c, %d, %d, %d\n", channel, key, velocity)
+}
+
+func (c *csvRows) interpretNoteOnMsg(o output.Bus, message smf.Message) {
+	var channel, key, velocity uint8
+	_ = message.GetNoteOn(&channel, &key, &velocity)
+	o.ConsolePrintf("Note_on_c, %d, %d, %d\n", channel, key, velocity)
+}
+
+func (c *csvRows) interpretPitchBendMsg(o output.Bus, message smf.Message) {
+	var channel uint8
+	var relative int16
+	var absolute uint16
+	_ = message.GetPitchBend(&channel, &relative, &absolute)
+	o.ConsolePrintf("Pitch_bend_c, %d, %d\n", channel, absolute)
+}
+
+func (c *csvRows) interpretPolyAfterTouchMsg(o output.Bus, message smf.Message) {
+	var channel, key, pressure uint8
+	_ = message.GetPolyAfterTouch(&channel, &key, &pressure)
+	o.ConsolePrintf("Poly_aftertouch_c, %d, %d, %d\n", channel, key, pressure)
+}
+
+func (c *csvRows) interpretProgramChangeMsg(o output.Bus, message smf.Message) {
+	var channel, program uint8
+	_ = message.GetProgramChange(&channel, &program)
+	o.ConsolePrintf("Program_c, %d, %d\n", channel, program)
+}
+
+// interpretSysExMsg writes the data as midicsv does, with the closing 0xF7
+func (c *csvRows) interpretSysExMsg(o output.Bus, message smf.Message) {
+	bt := []byte(message)[1:]
+	o.ConsolePrintf("System_exclusive, %d%s\n", len(bt), csvBytes(bt))
+}
+
+// interpretUnrecognizedMsg ends the track at the end of track event, and
+// writes any other meta event as an unknown meta event; anything else is an
+// error
+func (c *csvRows) interpretUnrecognizedMsg(o output.Bus, message smf.Message) {
+	switch {
+	case message.Is(smf.MetaEndOfTrackMsg):
+		o.ConsolePrintln("End_track")
+	case len(message) > 1 && message[0] == 0xFF:
+		typ, data := metaData(message)
+		o.ConsolePrintf("Unknown_meta_event, %d, %d%s\n", typ, len(data), csvBytes(data))
+	default:
+		if c.err == nil {
+			c.err = fmt.Errorf("track %d has an event at tick %d (%v) that cannot be written as CSV", c.track, c.tick, message.Bytes())
+		}
+	}
+}
+
+// metaData splits a meta message into its type and data
+func metaData(message smf.Message) (byte, []byte) {
+	typ := message[1]
+	rest := message[2:]
+	for len(rest) > 0 {
+		b := rest[0]
+		rest = rest[1:]
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return typ, rest
+}
+
+// csvString quotes the text as midicsv does: embedded quotes are doubled, and
+// backslashes and control characters are written as octal escapes
+func csvString(text string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for k := 0; k < len(text); k++ {
+		switch c := text[k]; {
+		case c == '"':
+			b.WriteString(`""`)
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c < 0x20 || c == 0x7F:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func csvBytes(data []byte) string {
+	var b strings.Builder
+	for _, d := range data {
+		fmt.Fprintf(&b, ", %d", d)
+	}
+	return b.String()
+}
+
+// readCSV reads a file in the midicsv format; every row is checked, and
+// errors name the offending line
+func (i *importFiles) readCSV(content []byte) (*smf.SMF, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	b := &csvBuilder{}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+		if err := b.add(record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return b.finish()
+}
+
+// csvBuilder assembles a file from midicsv rows
+type csvBuilder struct {
+	data     *smf.SMF
+	tracks   int // as declared by the header
+	track    smf.Track
+	number   int // of the open track, or 0
+	tick     int64
+	finished bool
+}
+
+func (b *csvBuilder) add(record []string) error {
+	for k := range record {
+		record[k] = strings.TrimSpace(record[k])
+	}
+	if len(record) < 3 {
+		return errors.New("a row needs a track, a time, and a type")
+	}
+	track, err := strconv.Atoi(record[0])
+	if err != nil || track < 0 {
+		return fmt.Errorf("the track %q is not valid", record[0])
+	}
+	tick, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil || tick < 0 {
+		return fmt.Errorf("the time %q is not valid", record[1])
+	}
+	typ, fields := record[2], record[3:]
+	switch {
+	case b.finished:
+		return fmt.Errorf("the %s row follows End_of_file", typ)
+	case b.data == nil && typ != "Header":
+		return fmt.Errorf("the %s row precedes the Header", typ)
+	}
+	switch typ {
+	case "Header":
+		if b.data != nil {
+			return errors.New("there is more than one Header")
+		}
+		values, err := csvTypedNumbers(typ, fields, 2, 0xFFFF, 0x7FFF)
+		if err != nil {
+			return err
+		}
+		if values[2] == 0 {
+			return errors.New("the division must not be 0")
+		}
+		switch values[0] {
+		case 0:
+			b.data = smf.New()
+		case 1:
+			b.data = smf.NewSMF1()
+		default:
+			b.data = smf.NewSMF2()
+		}
+		b.data.TimeFormat = smf.MetricTicks(values[2])
+		b.tracks = values[1]
+	case "Start_track":
+		if b.number != 0 {
+			return fmt.Errorf("track %d is not ended", b.number)
+		}
+		if want := len(b.data.Tracks) + 1; track != want {
+			return fmt.Errorf("track %d starts out of order; the next track is %d", track, want)
+		}
+		b.number, b.tick, b.track = track, 0, nil
+	case "End_track":
+		if err := b.checkEvent(typ, track, tick); err != nil {
+			return err
+		}
+		b.track.Close(uint32(tick - b.tick))
+		_ = b.data.Add(b.track)
+		b.number = 0
+	case "End_of_file":
+		if b.number != 0 {
+			return fmt.Errorf("track %d is not ended", b.number)
+		}
+		b.finished = true
+	default:
+		if err := b.checkEvent(typ, track, tick); err != nil {
+			return err
+		}
+		message, err := csvMessage(typ, fields)
+		if err != nil {
+			return err
+		}
+		b.track.Add(uint32(tick-b.tick), message)
+		b.tick = tick
+	}
+	return nil
+}
+
+// checkEvent verifies that the row belongs to the open track, in time order
+func (b *csvBuilder) checkEvent(typ string, track int, tick int64) error {
+	switch {
+	case b.number == 0:
+		return fmt.Errorf("the %s row is outside a track", typ)
+	case track != b.number:
+		return fmt.Errorf("the %s row is for track %d, but track %d is open", typ, track, b.number)
+	case tick < b.tick:
+		return fmt.Errorf("the time %d precedes the previous row's time %d", tick, b.tick)
+	}
+	return nil
+}
+
+func (b *csvBuilder) finish() (*smf.SMF, error) {
+	switch {
+	case b.data == nil:
+		return nil, errors.New("there is no Header")
+	case b.number != 0:
+		return nil, fmt.Errorf("track %d is not ended", b.number)
+	case !b.finished:
+		return nil, errors.New("there is no End_of_file row")
+	case len(b.data.Tracks) != b.tracks:
+		return nil, fmt.Errorf("the Header declares %d tracks, but there are %d", b.tracks, len(b.data.Tracks))
+	case b.data.Format() == 0 && b.tracks != 1:
+		return nil, fmt.Errorf("a format 0 file holds 1 track, not %d", b.tracks)
+	}
+	return b.data, nil
+}
+
+// csvTextMessages holds the constructors of the text meta events
+var csvTextMessages = map[string]func(string) smf.Message{
+	"Copyright_t":       smf.MetaCopyright,
+	"Cue_point_t":       smf.MetaCuepoint,
+	"Instrument_name_t": smf.MetaInstrument,
+	"Lyric_t":           smf.MetaLyric,
+	"Marker_t":          smf.MetaMarker,
+	"Text_t":            smf.MetaText,
+	"Title_t":           smf.MetaTrackSequenceName,
+}
+
+// csvMessage builds the message of an event row from its type and fields
+func csvMessage(typ string, fields []string) (smf.Message, error) {
+	if build, found := csvTextMessages[typ]; found {
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("%s needs 1 field, not %d", typ, len(fields))
+		}
+		text, err := csvUnquote(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return build(text), nil
+	}
+	switch typ {
+	case "Note_on_c", "Note_off_c", "Poly_aftertouch_c", "Control_c":
+		v, err := csvTypedNumbers(typ, fields, 15, 127, 127)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "Note_on_c":
+			return smf.Message(midi.NoteOn(uint8(v[0]), uint8(v[1]), uint8(v[2]))), nil
+		case "Note_off_c":
+			return smf.Message(midi.NoteOffVelocity(uint8(v[0]), uint8(v[1]), uint8(v[2]))), nil
+		case "Poly_aftertouch_c":
+			return smf.Message(midi.PolyAfterTouch(uint8(v[0]), uint8(v[1]), uint8(v[2]))), nil
+		default:
+			return smf.Message(midi.ControlChange(uint8(v[0]), uint8(v[1]), uint8(v[2]))), nil
+		}
+	case "Program_c", "Channel_aftertouch_c":
+		v, err := csvTypedNumbers(typ, fields, 15, 127)
+		if err != nil {
+			return nil, err
+		}
+		if typ == "Program_c" {
+			return smf.Message(midi.ProgramChange(uint8(v[0]), uint8(v[1]))), nil
+		}
+		return smf.Message(midi.AfterTouch(uint8(v[0]), uint8(v[1]))), nil
+	case "Pitch_bend_c":
+		v, err := csvTypedNumbers(typ, fields, 15, 0x3FFF)
+		if err != nil {
+			return nil, err
+		}
+		return smf.Message(midi.Pitchbend(uint8(v[0]), int16(v[1]-0x2000))), nil
+	case "Sequence_number":
+		v, err := csvTypedNumbers(typ, fields, 0xFFFF)
+		if err != nil {
+			return nil, err
+		}
+		return smf.MetaSequenceNo(uint16(v[0])), nil
+	case "MIDI_port", "Channel_prefix":
+		v, err := csvTypedNumbers(typ, fields, 0xFF)
+		if err != nil {
+			return nil, err
+		}
+		if typ == "MIDI_port" {
+			return smf.MetaPort(uint8(v[0])), nil
+		}
+		return smf.MetaChannel(uint8(v[0])), nil
+	// tempos, time signatures, and key signatures are built from their bytes,
+	// which the exporter writes unconverted
+	case "Tempo":
+		v, err := csvTypedNumbers(typ, fields, 0xFFFFFF)
+		if err != nil {
+			return nil, err
+		}
+		return smf.MetaUndefined(0x51, []byte{byte(v[0] >> 16), byte(v[0] >> 8), byte(v[0])}), nil
+	case "SMPTE_offset":
+		v, err := csvTypedNumbers(typ, fields, 23, 59, 59, 30, 99)
+		if err != nil {
+			return nil, err
+		}
+		return smf.MetaSMPTE(uint8(v[0]), uint8(v[1]), uint8(v[2]), uint8(v[3]), uint8(v[4])), nil
+	case "Time_signature":
+		v, err := csvTypedNumbers(typ, fields, 0xFF, 7, 0xFF, 0xFF)
+		if err != nil {
+			return nil, err
+		}
+		return smf.MetaUndefined(0x58, []byte{byte(v[0]), byte(v[1]), byte(v[2]), byte(v[3])}), nil
+	case "Key_signature":
+		return csvKeySignature(fields)
+	case "Sequencer_specific":
+		data, err := csvData(typ, fields)
+		if err != nil {
+			return nil, err
+		}
+		return smf.MetaSequencerData(data), nil
+	case "System_exclusive":
+		data, err := csvData(typ, fields)
+		if err != nil {
+			return nil, err
+		}
+		return smf.Message(append([]byte{0xF0}, data...)), nil
+	case "Unknown_meta_event":
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%s needs a type", typ)
+		}
+		v, err := csvNumbers(fields[:1], 0x7F)
+		if err != nil {
+			return nil, err
+		}
+		data, err := csvData(typ, fields[1:])
+		if err != nil {
+			return nil, err
+		}
+		return smf.MetaUndefined(byte(v[0]), data), nil
+	}
+	return nil, fmt.Errorf("the type %q is not known", typ)
+}
+
+func csvKeySignature(fields []string) (smf.Message, error) {
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Key_signature needs 2 fields, not %d", len(fields))
+	}
+	accidentals, err := strconv.Atoi(fields[0])
+	if err != nil || accidentals < -7 || accidentals > 7 {
+		return nil, fmt.Errorf("the key %q must be a number from -7 to 7", fields[0])
+	}
+	mode, err := csvUnquote(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	var minor byte
+	switch strings.ToLower(mode) {
+	case "major":
+	case "minor":
+		minor = 1
+	default:
+		return nil, fmt.Errorf("the mode %q must be major or minor", mode)
+	}
+	return smf.MetaUndefined(0x59, []byte{byte(int8(accidentals)), minor}), nil
+}
+
+// csvData reads a length field followed by that many bytes
+func csvData(typ string, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s needs a length", typ)
+	}
+	length, err := strconv.Atoi(fields[0])
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("the length %q is not valid", fields[0])
+	}
+	if length != len(fields)-1 {
+		return nil, fmt.Errorf("%s declares %d bytes, but has %d", typ, length, len(fields)-1)
+	}
+	limits := make([]int, length)
+	for k := range limits {
+		limits[k] = 0xFF
+	}
+	values, err := csvNumbers(fields[1:], limits...)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	for k, v := range values {
+		data[k] = byte(v)
+	}
+	return data, nil
+}
+
+func csvTypedNumbers(typ string, fields []string, limits ...int) ([]int, error) {
+	if len(fields) != len(limits) {
+		return nil, fmt.Errorf("%s needs %d fields, not %d", typ, len(limits), len(fields))
+	}
+	return csvNumbers(fields, limits...)
+}
+
+// csvNumbers parses the fields as numbers from 0 up to their limits
+func csvNumbers(fields []string, limits ...int) ([]int, error) {
+	if len(fields) != len(limits) {
+		return nil, fmt.Errorf("%d fields are needed, not %d", len(limits), len(fields))
+	}
+	values := make([]int, len(fields))
+	for k, field := range fields {
+		v, err := strconv.Atoi(field)
+		if err != nil || v < 0 || v > limits[k] {
+			return nil, fmt.Errorf("the value %q must be a number from 0 to %d", field, limits[k])
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+// csvUnquote undoes the octal and backslash escapes of a midicsv string; the
+// CSV reader has already removed the quotes and undoubled embedded ones
+func csvUnquote(field string) (string, error) {
+	if !strings.Contains(field, `\`) {
+		return field, nil
+	}
+	var b strings.Builder
+	for k := 0; k < len(field); k++ {
+		c := field[k]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if k+1 < len(field) && field[k+1] == '\\' {
+			b.WriteByte('\\')
+			k++
+			continue
+		}
+		if k+3 >= len(field) {
+			return "", fmt.Errorf("the escape in %q is not complete", field)
+		}
+		v, err := strconv.ParseUint(field[k+1:k+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("the escape %q is not valid", field[k:k+4])
+		}
+		b.WriteByte(byte(v))
+		k += 3
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,258 @@
+package commands
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// csvTestSMF returns a file holding every kind of event the CSV format
+// supports
+func csvTestSMF() *smf.SMF {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaTrackSequenceName(`Say "hi"\now`))
+	conductor.Add(0, smf.MetaCopyright("(c) 2026"))
+	conductor.Add(0, smf.MetaSequenceNo(3))
+	conductor.Add(0, smf.MetaTimeSig(6, 8, 24, 8))
+	conductor.Add(0, smf.MetaKey(0, false, 3, true))
+	conductor.Add(0, smf.MetaTempo(100))
+	conductor.Add(0, smf.MetaSMPTE(1, 2, 3, 4, 5))
+	conductor.Add(0, smf.MetaSequencerData([]byte{0, 0x41}))
+	conductor.Add(0, smf.MetaDevice("Synth"))
+	conductor.Add(960, smf.MetaMarker("Verse"))
+	conductor.Add(0, smf.MetaCuepoint("Lights"))
+	var melody smf.Track
+	melody.Add(0, smf.MetaInstrument("Lead"))
+	melody.Add(0, smf.MetaChannel(1))
+	melody.Add(0, smf.MetaPort(2))
+	melody.Add(0, smf.MetaProgram("Patch\t1"))
+	melody.Add(0, midi.ProgramChange(1, 73))
+	melody.Add(0, midi.ControlChange(1, 7, 100))
+	melody.Add(0, midi.SysEx([]byte{0x7E, 0x7F, 0x09, 0x01}))
+	melody.Add(0, smf.MetaLyric("la"))
+	melody.Add(0, midi.NoteOn(1, 60, 90))
+	melody.Add(120, midi.Pitchbend(1, -8192))
+	melody.Add(0, midi.PolyAfterTouch(1, 60, 30))
+	melody.Add(0, midi.AfterTouch(1, 40))
+	melody.Add(360, midi.NoteOffVelocity(1, 60, 64))
+	melody.Add(0, midi.NoteOn(1, 62, 0))
+	melody.Add(0, smf.MetaText("end"))
+	melody.Add(0, smf.MetaUndefined(0x60, []byte{1, 2}))
+	return makeTestSMF(conductor, melody)
+}
+
+const csvTestRows = `0, 0, Header, 1, 2, 480
+1, 0, Start_track
+1, 0, Title_t, "Say ""hi""\\now"
+1, 0, Copyright_t, "(c) 2026"
+1, 0, Sequence_number, 3
+1, 0, Time_signature, 6, 3, 24, 8
+1, 0, Key_signature, -3, "minor"
+1, 0, Tempo, 600000
+1, 0, SMPTE_offset, 1, 2, 3, 4, 5
+1, 0, Sequencer_specific, 2, 0, 65
+1, 0, Unknown_meta_event, 9, 5, 83, 121, 110, 116, 104
+1, 960, Marker_t, "Verse"
+1, 960, Cue_point_t, "Lights"
+1, 960, End_track
+2, 0, Start_track
+2, 0, Instrument_name_t, "Lead"
+2, 0, Channel_prefix, 1
+2, 0, MIDI_port, 2
+2, 0, Unknown_meta_event, 8, 7, 80, 97, 116, 99, 104, 9, 49
+2, 0, Program_c, 1, 73
+2, 0, Control_c, 1, 7, 100
+2, 0, System_exclusive, 5, 126, 127, 9, 1, 247
+2, 0, Lyric_t, "la"
+2, 0, Note_on_c, 1, 60, 90
+2, 120, Pitch_bend_c, 1, 0
+2, 120, Poly_aftertouch_c, 1, 60, 30
+2, 120, Channel_aftertouch_c, 1, 40
+2, 480, Note_off_c, 1, 60, 64
+2, 480, Note_on_c, 1, 62, 0
+2, 480, Text_t, "end"
+2, 480, Unknown_meta_event, 96, 2, 1, 2
+2, 480, End_track
+0, 0, End_of_file
+`
+
+func Test_export_writeCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := (&export{}).writeCSV(&b, csvTestSMF()); err != nil {
+		t.Fatalf("export.writeCSV() error = %v", err)
+	}
+	if got := b.String(); got != csvTestRows {
+		t.Errorf("export.writeCSV() = %s, want %s", got, csvTestRows)
+	}
+	data := smf.New()
+	data.TimeFormat = smf.SMPTE30(40)
+	if err := (&export{}).writeCSV(&b, data); err == nil {
+		t.Errorf("export.writeCSV() of a SMPTE timed file did not fail")
+	}
+}
+
+func Test_importFiles_readCSV_roundTrip(t *testing.T) {
+	want := csvTestSMF()
+	got, err := (&importFiles{}).readCSV([]byte(csvTestRows))
+	if err != nil {
+		t.Fatalf("importFiles.readCSV() error = %v", err)
+	}
+	if got.Format() != want.Format() || got.TimeFormat != want.TimeFormat || len(got.Tracks) != len(want.Tracks) {
+		t.Fatalf("importFiles.readCSV() = format %d, %v, %d tracks; want format %d, %v, %d tracks",
+			got.Format(), got.TimeFormat, len(got.Tracks), want.Format(), want.TimeFormat, len(want.Tracks))
+	}
+	for k := range want.Tracks {
+		if !reflect.DeepEqual(trackBytes(got.Tracks[k]), trackBytes(want.Tracks[k])) {
+			t.Errorf("importFiles.readCSV() track %d = %v, want %v", k, trackBytes(got.Tracks[k]), trackBytes(want.Tracks[k]))
+		}
+	}
+}
+
+// trackBytes lists the deltas and bytes of the track's events
+func trackBytes(track smf.Track) []any {
+	var events []any
+	for _, event := range track {
+		events = append(events, event.Delta, event.Message.Bytes())
+	}
+	return events
+}
+
+func Test_importFiles_readCSV(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		wantErr string
+	}{
+		"comments and blank lines": {
+			content: "# made by hand\n0, 0, Header, 0, 1, 96\n\n1, 0, Start_track\n1, 0, Note_on_c, 0, 60, 100\n" +
+				"1, 96, Note_off_c, 0, 60, 0\n1, 96, End_track\n0, 0, End_of_file\n",
+		},
+		"no header": {
+			content: "1, 0, Start_track\n",
+			wantErr: "line 1: the Start_track row precedes the Header",
+		},
+		"two headers": {
+			content: "0, 0, Header, 1, 1, 96\n0, 0, Header, 1, 1, 96\n",
+			wantErr: "line 2: there is more than one Header",
+		},
+		"bad division": {
+			content: "0, 0, Header, 1, 1, 0\n",
+			wantErr: "line 1: the division must not be 0",
+		},
+		"short row": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0\n",
+			wantErr: "line 2: a row needs a track, a time, and a type",
+		},
+		"bad time": {
+			content: "0, 0, Header, 1, 1, 96\n1, -5, Start_track\n",
+			wantErr: "line 2: the time \"-5\" is not valid",
+		},
+		"track out of order": {
+			content: "0, 0, Header, 1, 2, 96\n2, 0, Start_track\n",
+			wantErr: "line 2: track 2 starts out of order; the next track is 1",
+		},
+		"event outside track": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Note_on_c, 0, 60, 100\n",
+			wantErr: "line 2: the Note_on_c row is outside a track",
+		},
+		"event for another track": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n2, 0, Note_on_c, 0, 60, 100\n",
+			wantErr: "line 3: the Note_on_c row is for track 2, but track 1 is open",
+		},
+		"time goes backwards": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 10, Text_t, \"a\"\n1, 5, Text_t, \"b\"\n",
+			wantErr: "line 4: the time 5 precedes the previous row's time 10",
+		},
+		"unknown type": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Note_c, 0, 60\n",
+			wantErr: "line 3: the type \"Note_c\" is not known",
+		},
+		"wrong field count": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Note_on_c, 0, 60\n",
+			wantErr: "line 3: Note_on_c needs 3 fields, not 2",
+		},
+		"value out of range": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Note_on_c, 16, 60, 100\n",
+			wantErr: "line 3: the value \"16\" must be a number from 0 to 15",
+		},
+		"bad key": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Key_signature, 8, \"major\"\n",
+			wantErr: "line 3: the key \"8\" must be a number from -7 to 7",
+		},
+		"bad mode": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Key_signature, 1, \"dorian\"\n",
+			wantErr: "line 3: the mode \"dorian\" must be major or minor",
+		},
+		"short data": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, System_exclusive, 3, 1, 247\n",
+			wantErr: "line 3: System_exclusive declares 3 bytes, but has 2",
+		},
+		"bad escape": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Text_t, \"a\\9\"\n",
+			wantErr: "line 3: the escape in \"a\\\\9\" is not complete",
+		},
+		"unended track": {
+			content: "0, 0, Header, 1, 2, 96\n1, 0, Start_track\n1, 0, Start_track\n",
+			wantErr: "line 3: track 1 is not ended",
+		},
+		"row after end": {
+			content: "0, 0, Header, 1, 0, 96\n0, 0, End_of_file\n1, 0, Start_track\n",
+			wantErr: "line 3: the Start_track row follows End_of_file",
+		},
+		"missing end of file": {
+			content: "0, 0, Header, 1, 0, 96\n",
+			wantErr: "there is no End_of_file row",
+		},
+		"track count": {
+			content: "0, 0, Header, 1, 2, 96\n1, 0, Start_track\n1, 0, End_track\n0, 0, End_of_file\n",
+			wantErr: "the Header declares 2 tracks, but there are 1",
+		},
+		"format 0": {
+			content: "0, 0, Header, 0, 0, 96\n0, 0, End_of_file\n",
+			wantErr: "a format 0 file holds 1 track, not 0",
+		},
+		"bad quoting": {
+			content: "0, 0, Header, 1, 1, 96\n1, 0, Start_track\n1, 0, Text_t, \"a\n",
+			wantErr: "parse error on line 3, column 18: extraneous or missing \" in quoted-field",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := (&importFiles{}).readCSV([]byte(tt.content))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("importFiles.readCSV() error = %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("importFiles.readCSV() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_csvUnquote(t *testing.T) {
+	tests := map[string]struct {
+		field   string
+		want    string
+		wantErr bool
+	}{
+		"plain":     {field: "hello", want: "hello"},
+		"backslash": {field: `a\\b`, want: `a\b`},
+		"octal":     {field: `tab\011end`, want: "tab\tend"},
+		"utf-8":     {field: "café", want: "café"},
+		"bad octal": {field: `\999`, wantErr: true},
+		"cut short": {field: `\01`, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := csvUnquote(tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("csvUnquote() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("csvUnquote() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// cut writes a range of files, such as the section starting with a marker or
+// cue point, as files of their own, optionally deleting it from the files
+type cut struct {
+	span    rangeFlags
+	delete  bool
+	outFile string
+}
+
+func newCut() command {
+	return &cut{}
+}
+
+func (c *cut) defineFlags(flags *pflag.FlagSet) {
+	c.span.define(flags)
+	flags.BoolVar(&c.delete, "delete", false, "also delete the range from the input file, closing the gap")
+	flags.StringVarP(&c.outFile, "output", "o", "",
+		"file to write the range to (default: the input file's name, followed by the section's or the range's)")
+}
+
+func (c *cut) run(o output.Bus, args []string) int {
+	if valid := c.span.validate(o); !validOutput(o, c.outFile, args) || !valid {
+		return exitUserError
+	}
+	return processFiles(o, args, c.processFile)
+}
+
+func (c *cut) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	s, err := c.span.resolve(data)
+	if err != nil {
+		o.ErrorPrintf("The range of the file %q is not valid: %v.\n", path, err)
+		return false
+	}
+	destination := c.outFile
+	if destination == "" {
+		name := s.name
+		if name == "" {
+			name = c.span.from + " " + c.span.to
+		}
+		destination = strings.TrimSuffix(path, filepath.Ext(path)) + "-" + fileNamePart(name) + ".mid"
+	}
+	if !writeSMF(o, extractRange(data, s.from, s.to), destination) {
+		return false
+	}
+	m := newMeter(data)
+	o.ConsolePrintf("%s: wrote %s, to %s\n", path, describeRange(m, s), destination)
+	if !c.delete {
+		return true
+	}
+	deleteRange(data, s.from, s.to)
+	if !writeSMF(o, data, path) {
+		return false
+	}
+	o.ConsolePrintf("%s: deleted %s\n", path, describeRange(m, s))
+	return true
+}
+
+// fileNamePart makes the name safe for use in a file name, keeping letters
+// and digits and replacing runs of anything else with a hyphen
+func fileNamePart(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	if part := strings.TrimSuffix(b.String(), "-"); part != "" {
+		return part
+	}
+	return "section"
+}
+
+// emptyLike returns a file without tracks, of the file's format and time
+// format
+func emptyLike(data *smf.SMF) *smf.SMF {
+	var empty *smf.SMF
+	switch data.Format() {
+	case 0:
+		empty = smf.New()
+	case 2:
+		empty = smf.NewSMF2()
+	default:
+		empty = smf.NewSMF1()
+	}
+	empty.TimeFormat = data.TimeFormat
+	return empty
+}
+
+// extractRange returns a file of the events from the tick up to, but not
+// including, the tick to, moved to start at 0. Each track starts by setting
+// up again what its earlier events set up (the tempo, the time and key
+// signatures, the programs, and the controllers), and by starting again the
+// notes sounding there; the notes still sounding at the end stop there.
+func extractRange(data *smf.SMF, from, to int64) *smf.SMF {
+	extracted := emptyLike(data)
+	for _, track := range data.Tracks {
+		c, messages := rangeMessages(track, from, to, true)
+		var setup []timedMessage
+		for _, message := range c.messages(true) {
+			setup = append(setup, timedMessage{message: message})
+		}
+		_ = extracted.Add(closeAt(buildTrack(append(setup, messages...)), to-from))
+	}
+	return extracted
+}
+
+// closeAt moves the end of the track to the tick, if it ends sooner
+func closeAt(track smf.Track, tick int64) smf.Track {
+	if ticks := absoluteTicks(track); len(ticks) > 0 && ticks[len(ticks)-1] < tick {
+		track[len(track)-1].Delta += uint32(tick - ticks[len(ticks)-1])
+	}
+	return track
+}
@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/afero"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// describeTracks lists each track's events, one per line, with their ticks
+func describeTracks(data *smf.SMF) string {
+	var b strings.Builder
+	for index, track := range data.Tracks {
+		fmt.Fprintf(&b, "track %d\n", index)
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			fmt.Fprintf(&b, "%d %s\n", ticks[k], event.Message)
+		}
+	}
+	return b.String()
+}
+
+func Test_extractRange(t *testing.T) {
+	got := describeTracks(extractRange(sectionsTestSMF(), 3840, 7680))
+	// the note sounding at the start begins again, and the one sounding at the
+	// end stops there
+	want := "track 0\n" +
+		"0 MetaTempo bpm: 60.00\n" +
+		"0 MetaTimeSig meter: 4/4\n" +
+		"0 MetaMarker text: \"Chorus\"\n" +
+		"3840 MetaEndOfTrack\n" +
+		"track 1\n" +
+		"0 MetaTrackName text: \"Violin\"\n" +
+		"0 ControlChange channel: 0 controller: 0 value: 1\n" +
+		"0 ProgramChange channel: 0 program: 40\n" +
+		"0 ControlChange channel: 0 controller: 7 value: 100\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 6 value: 12\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 127\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 127\n" +
+		"0 NoteOn channel: 0 key: 60 velocity: 80\n" +
+		"480 NoteOff channel: 0 key: 60\n" +
+		"480 NoteOn channel: 0 key: 62 velocity: 80\n" +
+		"1160 NoteOff channel: 0 key: 62\n" +
+		"3160 NoteOn channel: 0 key: 64 velocity: 80\n" +
+		"3840 NoteOff channel: 0 key: 64\n" +
+		"3840 MetaEndOfTrack\n"
+	if got != want {
+		t.Errorf("extractRange() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_cut_run(t *testing.T) {
+	tests := map[string]struct {
+		c    *cut
+		args []string
+		want int
+		output.WantedRecording
+		// written is the file the cut should write
+		written string
+	}{
+		"section": {
+			c:    &cut{span: rangeFlags{section: "chorus"}},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid: wrote \"Chorus\", from bar 3 beat 1 to bar 5 beat 1, to /songs/song-Chorus.mid\n",
+			},
+			written: "/songs/song-Chorus.mid",
+		},
+		"section to a file": {
+			c:    &cut{span: rangeFlags{section: "Outro"}, outFile: "/outro.mid"},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid: wrote \"Outro\", from bar 5 beat 1 to bar 6 beat 1, to /outro.mid\n",
+			},
+			written: "/outro.mid",
+		},
+		"no such section": {
+			c:    &cut{span: rangeFlags{section: "Bridge"}},
+			args: []string{"/songs/song.mid"},
+			want: exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Error: "The range of the file \"/songs/song.mid\" is not valid: it has no section \"Bridge\"; " +
+					"its sections are \"Intro\", \"Chorus\", \"Outro\".\n",
+			},
+		},
+		"bars": {
+			c:    &cut{span: rangeFlags{from: "2", to: "3"}},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid: wrote from bar 2 beat 1 to bar 3 beat 1, to /songs/song-2-3.mid\n",
+			},
+			written: "/songs/song-2-3.mid",
+		},
+		"delete": {
+			c:    &cut{span: rangeFlags{section: "Intro"}, delete: true},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid: wrote \"Intro\", from bar 1 beat 1 to bar 3 beat 1, to /songs/song-Intro.mid\n" +
+					"/songs/song.mid: deleted \"Intro\", from bar 1 beat 1 to bar 3 beat 1\n",
+			},
+			written: "/songs/song-Intro.mid",
+		},
+		"no section": {
+			c:    &cut{outFile: "/x.mid"},
+			args: []string{"/songs"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "Either --from or --section must be specified, but not both.\n" +
+					"The --output flag may only be used with a single input file.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			memory := useMemoryFileSystem(t, map[string][]byte{"/songs/song.mid": smfBytes(t, sectionsTestSMF())})
+			o := output.NewRecorder()
+			if got := tt.c.run(o, tt.args); got != tt.want {
+				t.Errorf("cut.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("cut.run() %s", issue)
+				}
+			}
+			if tt.written != "" {
+				if found, _ := afero.Exists(memory, tt.written); !found {
+					t.Errorf("cut.run() did not write %s", tt.written)
+				}
+			}
+		})
+	}
+}
+
+func Test_fileNamePart(t *testing.T) {
+	for name, want := range map[string]string{
+		"Chorus":        "Chorus",
+		"Verse 2 (alt)": "Verse-2-alt",
+		"サビ":            "サビ",
+		"--":            "section",
+	} {
+		if got := fileNamePart(name); got != want {
+			t.Errorf("fileNamePart(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
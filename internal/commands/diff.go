@@ -0,0 +1,477 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// exitDifferent is returned with --exit-code when the files differ, as git
+// diff does
+const exitDifferent = 1
+
+// diffItem is an event reduced to what it means to a listener: a note is one
+// item from its start to its end, and how the file encodes it (running
+// status, a note on with velocity 0 for a note off) is gone
+type diffItem struct {
+	tick       int64  // in the first file's resolution
+	at         string // the position, in the item's own file
+	match      string // identifies the item within its tick, e.g. the channel and key
+	name       string // describes what the item is, e.g. "channel 0 note E4"
+	attributes []diffAttribute
+}
+
+// diffAttribute is what may change about an item without it becoming
+// another item, such as a note's velocity
+type diffAttribute struct {
+	name  string
+	value string
+}
+
+func (a diffAttribute) String() string {
+	if a.name == "" {
+		return a.value
+	}
+	return a.name + " " + a.value
+}
+
+func (i diffItem) String() string {
+	parts := make([]string, len(i.attributes))
+	for k, a := range i.attributes {
+		parts[k] = a.String()
+	}
+	if len(parts) == 0 {
+		return i.name
+	}
+	return i.name + " " + strings.Join(parts, ", ")
+}
+
+func (i diffItem) sameAs(other diffItem) bool {
+	if len(i.attributes) != len(other.attributes) {
+		return false
+	}
+	for k, a := range i.attributes {
+		if other.attributes[k] != a {
+			return false
+		}
+	}
+	return true
+}
+
+// changeTo describes how the attributes changed, e.g. "velocity 80→96"
+func (i diffItem) changeTo(other diffItem) string {
+	var parts []string
+	for k, a := range i.attributes {
+		if b := other.attributes[k]; b != a {
+			if a.name == "" {
+				parts = append(parts, a.value+"→"+b.value)
+			} else {
+				parts = append(parts, a.name+" "+a.value+"→"+b.value)
+			}
+		}
+	}
+	return i.name + " " + strings.Join(parts, ", ")
+}
+
+// diffChange is one difference between a pair of tracks
+type diffChange struct {
+	kind   byte // '-' deleted, '+' inserted, or '~' changed
+	before diffItem
+	after  diffItem
+}
+
+func (c diffChange) String() string {
+	switch c.kind {
+	case '-':
+		return fmt.Sprintf("- %s: %s", c.before.at, c.before)
+	case '+':
+		return fmt.Sprintf("+ %s: %s", c.after.at, c.after)
+	default:
+		return fmt.Sprintf("~ %s: %s", c.before.at, c.before.changeTo(c.after))
+	}
+}
+
+// diffFile is a file prepared for comparison
+type diffFile struct {
+	path   string
+	data   *smf.SMF
+	names  []string
+	tracks [][]diffItem
+}
+
+// diff compares files by their music rather than their bytes
+type diff struct {
+	exitCode bool
+	textconv bool
+}
+
+func newDiff() command {
+	return &diff{}
+}
+
+func (d *diff) defineFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&d.exitCode, "exit-code", false, fmt.Sprintf("exit with %d when the files differ", exitDifferent))
+	flags.BoolVar(&d.textconv, "textconv", false, "list a single file's events in the form diff compares them")
+}
+
+func (d *diff) run(o output.Bus, args []string) int {
+	if d.textconv {
+		if len(args) != 1 {
+			o.ErrorPrintln("The --textconv flag requires exactly one file.")
+			return exitUserError
+		}
+		data, ok := readSMF(o, args[0])
+		if !ok {
+			return exitSystemError
+		}
+		d.list(o, newDiffFile(args[0], data, nil))
+		return exitSuccess
+	}
+	var pathA, pathB string
+	switch len(args) {
+	case 2:
+		pathA, pathB = args[0], args[1]
+	case 7:
+		// git runs an external diff with the path, then the old file, hash,
+		// and mode, then the new file, hash, and mode
+		pathA, pathB = args[1], args[4]
+	default:
+		o.ErrorPrintln("The diff command requires two files.")
+		return exitUserError
+	}
+	dataA, okA := readSMF(o, pathA)
+	dataB, okB := readSMF(o, pathB)
+	if !okA || !okB {
+		return exitSystemError
+	}
+	a := newDiffFile(pathA, dataA, nil)
+	b := newDiffFile(pathB, dataB, dataA)
+	if len(args) == 7 {
+		a.path, b.path = "a/"+args[0], "b/"+args[0]
+	}
+	if d.compare(o, a, b) && d.exitCode {
+		return exitDifferent
+	}
+	return exitSuccess
+}
+
+// newDiffFile reduces the file's tracks to items; given a reference file with
+// a different resolution, the ticks are scaled to the reference's
+func newDiffFile(path string, data, reference *smf.SMF) *diffFile {
+	f := &diffFile{path: path, data: data}
+	scale := func(tick int64) int64 { return tick }
+	if reference != nil {
+		from, fromOK := data.TimeFormat.(smf.MetricTicks)
+		to, toOK := reference.TimeFormat.(smf.MetricTicks)
+		if fromOK && toOK && from != to && from > 0 {
+			scale = func(tick int64) int64 {
+				return (tick*int64(to) + int64(from)/2) / int64(from)
+			}
+		}
+	}
+	m := newMeter(data)
+	key := firstKey(data)
+	speller := &read{key: &key}
+	for index, track := range data.Tracks {
+		var name string
+		for _, event := range track {
+			if event.Message.GetMetaTrackName(&name) {
+				break
+			}
+		}
+		f.names = append(f.names, name)
+		notes := collectTrackNotes(index, track)
+		ticks := absoluteTicks(track)
+		var items []diffItem
+		for k, event := range track {
+			item := diffItem{tick: scale(ticks[k]), at: m.describe(ticks[k])}
+			var channel, pitch, velocity uint8
+			if event.Message.GetNoteStart(&channel, &pitch, &velocity) {
+				n := notes[0]
+				notes = notes[1:]
+				item.match = fmt.Sprintf("note %d %d", n.channel, n.pitch)
+				item.name = fmt.Sprintf("channel %d note %s", n.channel, speller.asNote(n.channel, n.pitch))
+				item.attributes = []diffAttribute{
+					{name: "velocity", value: fmt.Sprintf("%d", n.velocity)},
+					{name: "length", value: fmt.Sprintf("%d ticks", scale(n.end)-scale(n.start))},
+				}
+				items = append(items, item)
+				continue
+			}
+			if describeDiffItem(&item, newDumpEvent(ticks[k], event.Message), speller) {
+				items = append(items, item)
+			}
+		}
+		f.tracks = append(f.tracks, items)
+	}
+	return f
+}
+
+// describeDiffItem fills in what the event is; note ends and the end of the
+// track are left out, as notes are compared whole and track lengths follow
+// from the events
+func describeDiffItem(item *diffItem, e dumpEvent, speller *read) bool {
+	e.annotate(speller)
+	value := func(v string) []diffAttribute { return []diffAttribute{{value: v}} }
+	switch e.Type {
+	case "note_on", "note_off", "end_of_track":
+		return false
+	case "poly_aftertouch":
+		item.match = fmt.Sprintf("%s %d %d", e.Type, *e.Channel, *e.Key)
+		item.name = fmt.Sprintf("channel %d aftertouch on %s", *e.Channel, e.Note)
+		item.attributes = []diffAttribute{{name: "pressure", value: fmt.Sprintf("%d", *e.Pressure)}}
+	case "aftertouch":
+		item.match = fmt.Sprintf("%s %d", e.Type, *e.Channel)
+		item.name = fmt.Sprintf("channel %d aftertouch", *e.Channel)
+		item.attributes = []diffAttribute{{name: "pressure", value: fmt.Sprintf("%d", *e.Pressure)}}
+	case "control_change":
+		item.match = fmt.Sprintf("%s %d %d", e.Type, *e.Channel, *e.Controller)
+		item.name = fmt.Sprintf("channel %d controller %d", *e.Channel, *e.Controller)
+		item.attributes = []diffAttribute{{name: "value", value: fmt.Sprintf("%d", *e.Value)}}
+	case "program_change":
+		item.match = fmt.Sprintf("%s %d", e.Type, *e.Channel)
+		item.name = fmt.Sprintf("channel %d program", *e.Channel)
+		item.attributes = value(fmt.Sprintf("%d (%s)", *e.Program, e.Instrument))
+	case "pitch_bend":
+		item.match = fmt.Sprintf("%s %d", e.Type, *e.Channel)
+		item.name = fmt.Sprintf("channel %d pitch bend", *e.Channel)
+		item.attributes = value(fmt.Sprintf("%+d", *e.Bend))
+	case "tempo":
+		item.match, item.name = e.Type, "tempo"
+		item.attributes = value(fmt.Sprintf("%g BPM", e.BPM))
+	case "time_signature":
+		item.match, item.name = e.Type, "time signature"
+		item.attributes = value(fmt.Sprintf("%d/%d", *e.Numerator, *e.Denominator))
+	case "key_signature":
+		item.match, item.name = e.Type, "key signature"
+		item.attributes = value(e.KeyName)
+	case "sequence_number":
+		item.match, item.name = e.Type, "sequence number"
+		item.attributes = value(fmt.Sprintf("%d", *e.Number))
+	case "channel_prefix":
+		item.match, item.name = e.Type, "channel prefix"
+		item.attributes = value(fmt.Sprintf("%d", *e.Channel))
+	case "port":
+		item.match, item.name = e.Type, "port"
+		item.attributes = value(fmt.Sprintf("%d", *e.Port))
+	case "smpte_offset":
+		item.match, item.name = e.Type, "SMPTE offset"
+		item.attributes = value(fmt.Sprintf("%02d:%02d:%02d frame %02d.%02d", *e.Hour, *e.Minute, *e.Second,
+			*e.Frame, *e.Subframe))
+	case "meta":
+		item.match = fmt.Sprintf("meta %d", *e.Meta)
+		item.name = fmt.Sprintf("meta event 0x%02X", *e.Meta)
+		item.attributes = value("[" + e.Data + "]")
+	default:
+		// text, sysex, and anything that could not be decoded
+		item.match, item.name = e.Type, strings.ReplaceAll(e.Type, "_", " ")
+		item.attributes = value("[" + e.Data + "]")
+		if e.Text != nil {
+			item.attributes = value(fmt.Sprintf("%q", *e.Text))
+		}
+	}
+	return true
+}
+
+// compare reports the differences between the files, and whether there are
+// any
+func (d *diff) compare(o output.Bus, a, b *diffFile) bool {
+	o.ConsolePrintf("--- %s\n+++ %s\n", a.path, b.path)
+	differs := false
+	if formatA, formatB := a.data.Format(), b.data.Format(); formatA != formatB {
+		o.ConsolePrintf("format %d→%d\n", formatA, formatB)
+		differs = true
+	}
+	if a.data.TimeFormat != b.data.TimeFormat {
+		o.ConsolePrintf("time format %s→%s\n", describeTimeFormat(a.data.TimeFormat),
+			describeTimeFormat(b.data.TimeFormat))
+		differs = true
+	}
+	var inserted, deleted, changed int
+	for _, pair := range pairTracks(a.names, b.names) {
+		var itemsA, itemsB []diffItem
+		var heading string
+		switch {
+		case pair[1] < 0:
+			itemsA = a.tracks[pair[0]]
+			heading = fmt.Sprintf("track %d%s (only in %s):", pair[0], quotedName(a.names[pair[0]]), a.path)
+		case pair[0] < 0:
+			itemsB = b.tracks[pair[1]]
+			heading = fmt.Sprintf("track %d%s (only in %s):", pair[1], quotedName(b.names[pair[1]]), b.path)
+		case pair[0] == pair[1]:
+			itemsA, itemsB = a.tracks[pair[0]], b.tracks[pair[1]]
+			heading = fmt.Sprintf("track %d%s:", pair[0], quotedName(a.names[pair[0]]))
+		default:
+			itemsA, itemsB = a.tracks[pair[0]], b.tracks[pair[1]]
+			heading = fmt.Sprintf("track %d→%d%s:", pair[0], pair[1], quotedName(a.names[pair[0]]))
+		}
+		changes := diffTracks(itemsA, itemsB)
+		if len(changes) == 0 && pair[0] >= 0 && pair[1] >= 0 {
+			continue
+		}
+		differs = true
+		o.ConsolePrintln(heading)
+		for _, c := range changes {
+			o.ConsolePrintf("  %s\n", c)
+			switch c.kind {
+			case '+':
+				inserted++
+			case '-':
+				deleted++
+			default:
+				changed++
+			}
+		}
+	}
+	if differs {
+		o.ConsolePrintf("%d inserted, %d deleted, %d changed\n", inserted, deleted, changed)
+	} else {
+		o.ConsolePrintln("no differences")
+	}
+	return differs
+}
+
+// list writes the file's items, one per line, so that a line by line diff of
+// two listings shows what diff would
+func (d *diff) list(o output.Bus, f *diffFile) {
+	o.ConsolePrintf("format %d, time format %s\n", f.data.Format(), describeTimeFormat(f.data.TimeFormat))
+	for index, items := range f.tracks {
+		o.ConsolePrintf("track %d%s:\n", index, quotedName(f.names[index]))
+		for _, item := range items {
+			o.ConsolePrintf("  %s: %s\n", item.at, item)
+		}
+	}
+}
+
+func describeTimeFormat(tf smf.TimeFormat) string {
+	if mt, ok := tf.(smf.MetricTicks); ok {
+		return fmt.Sprintf("%d ticks per quarter", mt.Ticks4th())
+	}
+	return tf.String()
+}
+
+func quotedName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" %q", name)
+}
+
+// pairTracks matches the tracks of two files: first those with the same name,
+// where the name is unique in both files, then the rest in order. Each pair
+// holds a track number from each file, or -1 for a track in only one file;
+// the pairs are ordered by the first file's tracks, followed by the tracks
+// only in the second file.
+func pairTracks(namesA, namesB []string) [][2]int {
+	countA, countB := map[string]int{}, map[string]int{}
+	for _, name := range namesA {
+		countA[name]++
+	}
+	for _, name := range namesB {
+		countB[name]++
+	}
+	partnerA := make([]int, len(namesA))
+	pairedB := make([]bool, len(namesB))
+	for k := range partnerA {
+		partnerA[k] = -1
+	}
+	for k, name := range namesA {
+		if name == "" || countA[name] != 1 || countB[name] != 1 {
+			continue
+		}
+		for j, other := range namesB {
+			if other == name {
+				partnerA[k], pairedB[j] = j, true
+			}
+		}
+	}
+	next := 0
+	for k := range partnerA {
+		if partnerA[k] >= 0 {
+			continue
+		}
+		for next < len(namesB) && pairedB[next] {
+			next++
+		}
+		if next < len(namesB) {
+			partnerA[k], pairedB[next] = next, true
+		}
+	}
+	var pairs [][2]int
+	for k, j := range partnerA {
+		pairs = append(pairs, [2]int{k, j})
+	}
+	for j, paired := range pairedB {
+		if !paired {
+			pairs = append(pairs, [2]int{-1, j})
+		}
+	}
+	return pairs
+}
+
+// diffTracks aligns the items of two tracks by tick and by what they are:
+// identical items cancel out, items of the same kind at the same tick that
+// are left over are changes, and the rest are deletions and insertions. The
+// changes are ordered by tick, with deletions first.
+func diffTracks(a, b []diffItem) []diffChange {
+	type slot struct {
+		tick  int64
+		match string
+	}
+	pendingB := map[slot][]int{}
+	for j, item := range b {
+		s := slot{tick: item.tick, match: item.match}
+		pendingB[s] = append(pendingB[s], j)
+	}
+	usedB := make([]bool, len(b))
+	var unmatchedA []int
+	for k, item := range a {
+		s := slot{tick: item.tick, match: item.match}
+		found := false
+		for n, j := range pendingB[s] {
+			if item.sameAs(b[j]) {
+				usedB[j] = true
+				pendingB[s] = append(pendingB[s][:n:n], pendingB[s][n+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatchedA = append(unmatchedA, k)
+		}
+	}
+	var changes []diffChange
+	for _, k := range unmatchedA {
+		s := slot{tick: a[k].tick, match: a[k].match}
+		if pending := pendingB[s]; len(pending) > 0 {
+			usedB[pending[0]] = true
+			pendingB[s] = pending[1:]
+			changes = append(changes, diffChange{kind: '~', before: a[k], after: b[pending[0]]})
+			continue
+		}
+		changes = append(changes, diffChange{kind: '-', before: a[k]})
+	}
+	for j, used := range usedB {
+		if !used {
+			changes = append(changes, diffChange{kind: '+', after: b[j]})
+		}
+	}
+	tick := func(c diffChange) int64 {
+		if c.kind == '+' {
+			return c.after.tick
+		}
+		return c.before.tick
+	}
+	order := map[byte]int{'-': 0, '~': 1, '+': 2}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if ti, tj := tick(changes[i]), tick(changes[j]); ti != tj {
+			return ti < tj
+		}
+		return order[changes[i].kind] < order[changes[j].kind]
+	})
+	return changes
+}
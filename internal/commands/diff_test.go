@@ -0,0 +1,282 @@
+package commands
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// diffTestOptions vary the file built by diffTestSMF in the ways the tests
+// compare
+type diffTestOptions struct {
+	resolution  int
+	zeroNoteOff bool // end notes with note on, velocity 0
+	velocity    uint8
+	extraNote   bool
+	noVolume    bool
+	tempo       float64
+	extraTrack  bool
+	swapTracks  bool
+	bassKey     uint8 // 36 if 0
+	noBass      bool
+}
+
+// diffTestSMF builds a conductor track, a piano track, and a bass track, in
+// 4/4 at the resolution
+func diffTestSMF(opts diffTestOptions) *smf.SMF {
+	data := smf.NewSMF1()
+	data.TimeFormat = smf.MetricTicks(opts.resolution)
+	q := uint32(opts.resolution)
+	noteOff := func(key uint8) midi.Message {
+		if opts.zeroNoteOff {
+			return midi.NoteOn(0, key, 0)
+		}
+		return midi.NoteOff(0, key)
+	}
+	var conductor, piano, bass smf.Track
+	conductor.Add(0, smf.MetaMeter(4, 4))
+	conductor.Add(0, smf.MetaTempo(opts.tempo))
+	conductor.Close(0)
+	piano.Add(0, smf.MetaTrackSequenceName("Piano"))
+	piano.Add(0, midi.ProgramChange(0, 0))
+	if !opts.noVolume {
+		piano.Add(0, midi.ControlChange(0, 7, 100))
+	}
+	piano.Add(0, midi.NoteOn(0, 60, 80))
+	piano.Add(q, noteOff(60))
+	piano.Add(q, midi.NoteOn(0, 64, opts.velocity))
+	piano.Add(q, noteOff(64))
+	if opts.extraNote {
+		piano.Add(4*q, midi.NoteOn(0, 67, 90))
+		piano.Add(2*q, noteOff(67))
+	}
+	piano.Close(0)
+	bass.Add(0, smf.MetaTrackSequenceName("Bass"))
+	bassKey := cmp.Or(opts.bassKey, 36)
+	bass.Add(0, midi.NoteOn(1, bassKey, 100))
+	bass.Add(4*q, midi.NoteOff(1, bassKey))
+	bass.Close(0)
+	_ = data.Add(conductor)
+	switch {
+	case opts.noBass:
+		_ = data.Add(piano)
+	case opts.swapTracks:
+		_ = data.Add(bass)
+		_ = data.Add(piano)
+	default:
+		_ = data.Add(piano)
+		_ = data.Add(bass)
+	}
+	if opts.extraTrack {
+		var pad smf.Track
+		pad.Add(0, smf.MetaTrackSequenceName("Strings"))
+		pad.Add(0, smf.MetaMarker("Intro"))
+		pad.Close(0)
+		_ = data.Add(pad)
+	}
+	return data
+}
+
+func writeDiffTestFile(t *testing.T, dir, name string, opts diffTestOptions) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, smfBytes(t, diffTestSMF(opts)), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	return path
+}
+
+func Test_diff_run(t *testing.T) {
+	dir := t.TempDir()
+	base := diffTestOptions{resolution: 480, velocity: 80, tempo: 120}
+	original := writeDiffTestFile(t, dir, "a.mid", base)
+	encoded := base
+	encoded.zeroNoteOff = true
+	reencoded := writeDiffTestFile(t, dir, "encoded.mid", encoded)
+	finer := base
+	finer.resolution = 960
+	rescaled := writeDiffTestFile(t, dir, "finer.mid", finer)
+	edited := base
+	edited.velocity, edited.extraNote, edited.noVolume, edited.tempo = 96, true, true, 90
+	changed := writeDiffTestFile(t, dir, "b.mid", edited)
+	reordered := base
+	reordered.swapTracks, reordered.extraTrack = true, true
+	moved := writeDiffTestFile(t, dir, "moved.mid", reordered)
+	tests := map[string]struct {
+		d    *diff
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"no files": {
+			d:               &diff{},
+			want:            exitUserError,
+			WantedRecording: output.WantedRecording{Error: "The diff command requires two files.\n"},
+		},
+		"no files, with --exit-code": {
+			// a bad command line is told apart from files that differ
+			d:               &diff{exitCode: true},
+			want:            2,
+			WantedRecording: output.WantedRecording{Error: "The diff command requires two files.\n"},
+		},
+		"same music, different encoding": {
+			d:    &diff{exitCode: true},
+			args: []string{original, reencoded},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "--- " + original + "\n+++ " + reencoded + "\nno differences\n",
+			},
+		},
+		"different resolution": {
+			d:    &diff{exitCode: true},
+			args: []string{original, rescaled},
+			want: exitDifferent,
+			WantedRecording: output.WantedRecording{
+				Console: "--- " + original + "\n+++ " + rescaled + "\n" +
+					"time format 480 ticks per quarter→960 ticks per quarter\n" +
+					"0 inserted, 0 deleted, 0 changed\n",
+			},
+		},
+		"edits": {
+			d:    &diff{exitCode: true},
+			args: []string{original, changed},
+			want: exitDifferent,
+			WantedRecording: output.WantedRecording{
+				Console: "--- " + original + "\n+++ " + changed + "\n" +
+					"track 0:\n" +
+					"  ~ bar 1 beat 1: tempo 120 BPM→90 BPM\n" +
+					"track 1 \"Piano\":\n" +
+					"  - bar 1 beat 1: channel 0 controller 7 value 100\n" +
+					"  ~ bar 1 beat 3: channel 0 note E5 velocity 80→96\n" +
+					"  + bar 2 beat 4: channel 0 note G5 velocity 90, length 960 ticks\n" +
+					"1 inserted, 1 deleted, 2 changed\n",
+			},
+		},
+		"without --exit-code": {
+			d:    &diff{},
+			args: []string{original, changed},
+			want: exitSuccess,
+		},
+		"tracks paired by name": {
+			d:    &diff{},
+			args: []string{original, moved},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "--- " + original + "\n+++ " + moved + "\n" +
+					"track 3 \"Strings\" (only in " + moved + "):\n" +
+					"  + bar 1 beat 1: track name \"Strings\"\n" +
+					"  + bar 1 beat 1: marker \"Intro\"\n" +
+					"2 inserted, 0 deleted, 0 changed\n",
+			},
+		},
+		"git external diff": {
+			d:    &diff{},
+			args: []string{"song.mid", original, "1234567", "100644", reencoded, "89abcdef", "100644"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "--- a/song.mid\n+++ b/song.mid\nno differences\n",
+			},
+		},
+		"missing file": {
+			d:    &diff{},
+			args: []string{original, filepath.Join(dir, "none.mid")},
+			want: exitSystemError,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.d.run(o, tt.args); got != tt.want {
+				t.Errorf("diff.run() = %d, want %d", got, tt.want)
+			}
+			if tt.WantedRecording == (output.WantedRecording{}) {
+				return
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("diff.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_diff_textconv(t *testing.T) {
+	dir := t.TempDir()
+	input := writeDiffTestFile(t, dir, "a.mid", diffTestOptions{resolution: 480, velocity: 80, tempo: 120})
+	o := output.NewRecorder()
+	if got := (&diff{textconv: true}).run(o, []string{input}); got != exitSuccess {
+		t.Fatalf("diff.run() = %d: %s", got, o.ErrorOutput())
+	}
+	want := strings.Join([]string{
+		"format 1, time format 480 ticks per quarter",
+		"track 0:",
+		"  bar 1 beat 1: time signature 4/4",
+		"  bar 1 beat 1: tempo 120 BPM",
+		"track 1 \"Piano\":",
+		"  bar 1 beat 1: track name \"Piano\"",
+		"  bar 1 beat 1: channel 0 program 0 (Acoustic grand piano)",
+		"  bar 1 beat 1: channel 0 controller 7 value 100",
+		"  bar 1 beat 1: channel 0 note C5 velocity 80, length 480 ticks",
+		"  bar 1 beat 3: channel 0 note E5 velocity 80, length 480 ticks",
+		"track 2 \"Bass\":",
+		"  bar 1 beat 1: track name \"Bass\"",
+		"  bar 1 beat 1: channel 1 note C3 velocity 100, length 1920 ticks",
+		"",
+	}, "\n")
+	if got := o.ConsoleOutput(); got != want {
+		t.Errorf("diff.run() = %q, want %q", got, want)
+	}
+	o = output.NewRecorder()
+	if got := (&diff{textconv: true}).run(o, []string{input, input}); got != exitUserError {
+		t.Errorf("diff.run() = %d, want %d", got, exitUserError)
+	}
+}
+
+func Test_pairTracks(t *testing.T) {
+	tests := map[string]struct {
+		namesA []string
+		namesB []string
+		want   [][2]int
+	}{
+		"same": {
+			namesA: []string{"", "Piano", "Bass"},
+			namesB: []string{"", "Piano", "Bass"},
+			want:   [][2]int{{0, 0}, {1, 1}, {2, 2}},
+		},
+		"moved": {
+			namesA: []string{"", "Piano", "Bass"},
+			namesB: []string{"", "Bass", "Piano"},
+			want:   [][2]int{{0, 0}, {1, 2}, {2, 1}},
+		},
+		"renamed and added": {
+			namesA: []string{"", "Piano", "Bass"},
+			namesB: []string{"", "Keys", "Bass", "Drums"},
+			want:   [][2]int{{0, 0}, {1, 1}, {2, 2}, {-1, 3}},
+		},
+		"duplicate names pair in order": {
+			namesA: []string{"Guitar", "Guitar"},
+			namesB: []string{"Guitar"},
+			want:   [][2]int{{0, 0}, {1, -1}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := pairTracks(tt.namesA, tt.namesB)
+			if len(got) != len(tt.want) {
+				t.Fatalf("pairTracks() = %v, want %v", got, tt.want)
+			}
+			for k := range got {
+				if got[k] != tt.want[k] {
+					t.Errorf("pairTracks() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	// glyphScale is the size, in pixels, of a font pixel in a PNG image
+	glyphScale = 2
+	// charWidth is the advance of a character, in pixels
+	charWidth = 4 * glyphScale
+	// lineHeight is the height of a line of text, in pixels
+	lineHeight = 6 * glyphScale
+)
+
+// drawing is a picture made of filled rectangles and single lines of text,
+// which can be written as SVG or rasterized as PNG
+type drawing struct {
+	width  int
+	height int
+	items  []drawingItem
+}
+
+// drawingItem is a rectangle, or, if it has text, a line of text whose top
+// left corner is at x, y
+type drawingItem struct {
+	x, y, w, h float64
+	color      color.RGBA
+	text       string
+	title      string // the rectangle's tooltip in SVG
+}
+
+func (d *drawing) rect(x, y, w, h float64, c color.RGBA, title string) {
+	d.items = append(d.items, drawingItem{x: x, y: y, w: w, h: h, color: c, title: title})
+}
+
+func (d *drawing) text(x, y float64, s string, c color.RGBA) {
+	d.items = append(d.items, drawingItem{x: x, y: y, color: c, text: s})
+}
+
+// textWidth returns the width of the text, in pixels
+func textWidth(s string) float64 {
+	return float64(len([]rune(s)) * charWidth)
+}
+
+func cssColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (d *drawing) writeSVG(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		d.width, d.height, d.width, d.height)
+	fmt.Fprintf(b, "<rect width=\"100%%\" height=\"100%%\" fill=\"#ffffff\"/>\n")
+	number := func(v float64) string {
+		return fmt.Sprintf("%g", math.Round(v*100)/100)
+	}
+	for _, item := range d.items {
+		switch {
+		case item.text != "":
+			fmt.Fprintf(b, "<text x=\"%s\" y=\"%s\" font-family=\"monospace\" font-size=\"%d\" fill=\"%s\">%s</text>\n",
+				number(item.x), number(item.y+lineHeight-2), lineHeight-2, cssColor(item.color), xmlText(item.text))
+		case item.title != "":
+			fmt.Fprintf(b, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"><title>%s</title></rect>\n",
+				number(item.x), number(item.y), number(item.w), number(item.h), cssColor(item.color), xmlText(item.title))
+		default:
+			fmt.Fprintf(b, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"/>\n",
+				number(item.x), number(item.y), number(item.w), number(item.h), cssColor(item.color))
+		}
+	}
+	fmt.Fprintf(b, "</svg>\n")
+	return b.Flush()
+}
+
+// xmlText escapes text for XML content
+func xmlText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;").Replace(s)
+}
+
+// image rasterizes the drawing; rectangles snap to whole pixels, but are
+// never less than a pixel wide or high
+func (d *drawing) image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}), image.Point{}, draw.Src)
+	for _, item := range d.items {
+		if item.text != "" {
+			drawText(img, int(math.Round(item.x)), int(math.Round(item.y)), item.text, item.color)
+			continue
+		}
+		x0, y0 := int(math.Round(item.x)), int(math.Round(item.y))
+		x1, y1 := max(int(math.Round(item.x+item.w)), x0+1), max(int(math.Round(item.y+item.h)), y0+1)
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(item.color), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func (d *drawing) writePNG(w io.Writer) error {
+	return png.Encode(w, d.image())
+}
+
+// drawText draws the text in the built-in font, from its top left corner
+func drawText(img *image.RGBA, x, y int, s string, c color.RGBA) {
+	for _, r := range strings.ToUpper(s) {
+		glyph, found := glyphs[r]
+		if !found {
+			glyph = glyphs['?']
+		}
+		for k, pixel := range glyph {
+			if pixel == '#' {
+				px, py := x+(k%3)*glyphScale, y+(k/3)*glyphScale
+				draw.Draw(img, image.Rect(px, py, px+glyphScale, py+glyphScale), image.NewUniform(c), image.Point{},
+					draw.Src)
+			}
+		}
+		x += charWidth
+	}
+}
+
+// glyphs is a font of 3 by 5 pixel characters, each written row by row; the
+// lower case letters are drawn as capitals
+var glyphs = map[rune]string{
+	'A': ".#.#.#####.##.#", 'B': "##.#.###.#.###.", 'C': ".###..#..#...##", 'D': "##.#.##.##.###.",
+	'E': "####..##.#..###", 'F': "####..##.#..#..", 'G': ".###..#.##.#.##", 'H': "#.##.#####.##.#",
+	'I': "###.#..#..#.###", 'J': "..#..#..##.#.#.", 'K': "#.##.###.#.##.#", 'L': "#..#..#..#..###",
+	'M': "#.########.##.#", 'N': "##.#.##.##.##.#", 'O': ".#.#.##.##.#.#.", 'P': "##.#.###.#..#..",
+	'Q': ".#.#.##.###..##", 'R': "##.#.###.#.##.#", 'S': ".###...#...###.", 'T': "###.#..#..#..#.",
+	'U': "#.##.##.##.####", 'V': "#.##.##.##.#.#.", 'W': "#.##.########.#", 'X': "#.##.#.#.#.##.#",
+	'Y': "#.##.#.#..#..#.", 'Z': "###..#.#.#..###", '0': "####.##.##.####", '1': ".#.##..#..#.###",
+	'2': "##...#.#.#..###", '3': "##...#.#...###.", '4': "#.##.####..#..#", '5': "####..##...###.",
+	'6': ".###..####.####", '7': "###..#.#..#..#.", '8': "####.#####.####", '9': "####.####..###.",
+	' ': "...............", '.': ".............#.", ',': "..........#.#..", ':': "....#.....#....",
+	'-': "......###......", '+': "....#.###.#....", '=': "...###...###...", '(': ".#.#..#..#...#.",
+	')': ".#...#..#..#.#.", '/': "..#..#.#.#..#..", '\'': ".#..#..........", '!': ".#..#..#.....#.",
+	'?': "##...#.#.....#.", '#': "#.#####.#####.#", '_': "............###", '&': ".#.#.#.#.#.#.##",
+	'*': "...#.#.#.#.#...", '%': "#....#.#.#....#", '"': "#.##.#.........", '[': "##.#..#..#..##.",
+	']': ".##..#..#..#.##", '<': "..#.#.#...#...#", '>': "#...#...#.#.#..",
+}
@@ -0,0 +1,363 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+	"gopkg.in/yaml.v3"
+)
+
+// dumpDocument is the textual form of a file: its header and its tracks, with
+// each event at its absolute tick
+type dumpDocument struct {
+	Format     uint16         `json:"format" yaml:"format"`
+	TimeFormat dumpTimeFormat `json:"timeFormat" yaml:"timeFormat"`
+	Tracks     []dumpTrack    `json:"tracks" yaml:"tracks"`
+}
+
+// dumpTimeFormat holds either the ticks per quarter note or the SMPTE frame
+// rate (29 meaning 30 drop frame) and subframes
+type dumpTimeFormat struct {
+	TicksPerQuarter uint16 `json:"ticksPerQuarter,omitempty" yaml:"ticksPerQuarter,omitempty"`
+	FramesPerSecond uint8  `json:"framesPerSecond,omitempty" yaml:"framesPerSecond,omitempty"`
+	Subframes       uint8  `json:"subframes,omitempty" yaml:"subframes,omitempty"`
+}
+
+type dumpTrack struct {
+	Events []dumpEvent `json:"events" yaml:"events"`
+}
+
+// dumpEvent is one event; which fields are set depends on the type. Data is
+// written as space separated hex bytes. Note, Instrument, BPM, and KeyName
+// annotate a readable dump, and build ignores them.
+type dumpEvent struct {
+	Tick          int64   `json:"tick" yaml:"tick"`
+	Type          string  `json:"type" yaml:"type"`
+	Channel       *uint8  `json:"channel,omitempty" yaml:"channel,omitempty"`
+	Key           *uint8  `json:"key,omitempty" yaml:"key,omitempty"`
+	Velocity      *uint8  `json:"velocity,omitempty" yaml:"velocity,omitempty"`
+	Pressure      *uint8  `json:"pressure,omitempty" yaml:"pressure,omitempty"`
+	Controller    *uint8  `json:"controller,omitempty" yaml:"controller,omitempty"`
+	Value         *uint8  `json:"value,omitempty" yaml:"value,omitempty"`
+	Program       *uint8  `json:"program,omitempty" yaml:"program,omitempty"`
+	Bend          *int    `json:"bend,omitempty" yaml:"bend,omitempty"`
+	Number        *uint16 `json:"number,omitempty" yaml:"number,omitempty"`
+	Port          *uint8  `json:"port,omitempty" yaml:"port,omitempty"`
+	Microseconds  *uint32 `json:"microseconds,omitempty" yaml:"microseconds,omitempty"`
+	Numerator     *uint8  `json:"numerator,omitempty" yaml:"numerator,omitempty"`
+	Denominator   *uint8  `json:"denominator,omitempty" yaml:"denominator,omitempty"`
+	Clocks        *uint8  `json:"clocks,omitempty" yaml:"clocks,omitempty"`
+	ThirtySeconds *uint8  `json:"thirtySeconds,omitempty" yaml:"thirtySeconds,omitempty"`
+	Accidentals   *int8   `json:"accidentals,omitempty" yaml:"accidentals,omitempty"`
+	Mode          string  `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Hour          *uint8  `json:"hour,omitempty" yaml:"hour,omitempty"`
+	Minute        *uint8  `json:"minute,omitempty" yaml:"minute,omitempty"`
+	Second        *uint8  `json:"second,omitempty" yaml:"second,omitempty"`
+	Frame         *uint8  `json:"frame,omitempty" yaml:"frame,omitempty"`
+	Subframe      *uint8  `json:"subframe,omitempty" yaml:"subframe,omitempty"`
+	Meta          *uint8  `json:"meta,omitempty" yaml:"meta,omitempty"`
+	Text          *string `json:"text,omitempty" yaml:"text,omitempty"`
+	Data          string  `json:"data,omitempty" yaml:"data,omitempty"`
+	Note          string  `json:"note,omitempty" yaml:"note,omitempty"`
+	Instrument    string  `json:"instrument,omitempty" yaml:"instrument,omitempty"`
+	BPM           float64 `json:"bpm,omitempty" yaml:"bpm,omitempty"`
+	KeyName       string  `json:"keyName,omitempty" yaml:"keyName,omitempty"`
+}
+
+// MarshalYAML writes each event on a line of its own
+func (e dumpEvent) MarshalYAML() (any, error) {
+	type plain dumpEvent
+	var n yaml.Node
+	if err := n.Encode(plain(e)); err != nil {
+		return nil, err
+	}
+	n.Style = yaml.FlowStyle
+	return &n, nil
+}
+
+// dumpTextTypes names the text meta events by their type bytes
+var dumpTextTypes = map[byte]string{
+	0x01: "text",
+	0x02: "copyright",
+	0x03: "track_name",
+	0x04: "instrument_name",
+	0x05: "lyric",
+	0x06: "marker",
+	0x07: "cue_point",
+	0x08: "program_name",
+	0x09: "device_name",
+}
+
+// dump writes files in a textual form that build turns back into files
+type dump struct {
+	format   string
+	outFile  string
+	lossless bool
+}
+
+func newDump() command {
+	return &dump{}
+}
+
+func (d *dump) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&d.format, "format", "yaml", "output format: yaml or json")
+	flags.StringVarP(&d.outFile, "output", "o", "", "file to write (default: the console)")
+	flags.BoolVar(&d.lossless, "lossless", false,
+		"write the canonical form only, with end of track events and without annotations")
+}
+
+func (d *dump) run(o output.Bus, args []string) int {
+	switch d.format {
+	case "yaml", "json":
+	default:
+		o.ErrorPrintf("The --format value %q is not valid: it must be yaml or json.\n", d.format)
+		return exitUserError
+	}
+	if d.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return exitUserError
+	}
+	return processFiles(o, args, d.processFile)
+}
+
+func (d *dump) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	var content bytes.Buffer
+	if err := d.write(&content, newDumpDocument(data, !d.lossless)); err != nil {
+		o.ErrorPrintf("The file %q cannot be dumped: %v.\n", path, err)
+		o.Log(output.Error, "cannot dump file", map[string]any{"file": path, "error": err})
+		return false
+	}
+	if d.outFile == "" {
+		_, _ = o.ConsoleWriter().Write(content.Bytes())
+		return true
+	}
+	if err := writeFile(d.outFile, content.Bytes()); err != nil {
+		o.ErrorPrintf("The file %q cannot be written: %v.\n", d.outFile, err)
+		o.Log(output.Error, "cannot write file", map[string]any{"file": d.outFile, "error": err})
+		return false
+	}
+	o.ConsolePrintf("%s: dumped to %s\n", path, d.outFile)
+	return true
+}
+
+func (d *dump) write(w io.Writer, doc *dumpDocument) error {
+	if d.format == "yaml" {
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+	return writeDumpJSON(w, doc)
+}
+
+// writeDumpJSON writes the document as indented JSON, but with each event on
+// a line of its own
+func writeDumpJSON(w io.Writer, doc *dumpDocument) error {
+	timeFormat, err := json.Marshal(doc.TimeFormat)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "{\n  \"format\": %d,\n  \"timeFormat\": %s,\n  \"tracks\": [", doc.Format, timeFormat)
+	for t, track := range doc.Tracks {
+		if t > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString("\n    {\"events\": [")
+		for k, event := range track.Events {
+			line, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if k > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "\n      %s", line)
+		}
+		if len(track.Events) > 0 {
+			b.WriteString("\n    ")
+		}
+		b.WriteString("]}")
+	}
+	if len(doc.Tracks) > 0 {
+		b.WriteString("\n  ")
+	}
+	b.WriteString("]\n}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// newDumpDocument converts the file; with annotations, end of track events
+// are left out, and notes, programs, tempos, and keys are described
+func newDumpDocument(data *smf.SMF, annotate bool) *dumpDocument {
+	doc := &dumpDocument{Format: data.Format(), Tracks: []dumpTrack{}}
+	switch tf := data.TimeFormat.(type) {
+	case smf.MetricTicks:
+		doc.TimeFormat.TicksPerQuarter = uint16(tf)
+	case smf.TimeCode:
+		doc.TimeFormat.FramesPerSecond = tf.FramesPerSecond
+		doc.TimeFormat.Subframes = tf.SubFrames
+	}
+	key := firstKey(data)
+	speller := &read{key: &key}
+	for _, track := range data.Tracks {
+		events := []dumpEvent{}
+		var tick int64
+		for _, event := range track {
+			tick += int64(event.Delta)
+			e := newDumpEvent(tick, event.Message)
+			if annotate {
+				if e.Type == "end_of_track" {
+					continue
+				}
+				e.annotate(speller)
+			}
+			events = append(events, e)
+		}
+		doc.Tracks = append(doc.Tracks, dumpTrack{Events: events})
+	}
+	return doc
+}
+
+// newDumpEvent decodes the message from its bytes; anything malformed is kept
+// as a meta event or as raw bytes
+func newDumpEvent(tick int64, message smf.Message) dumpEvent {
+	e := dumpEvent{Tick: tick, Type: "raw", Data: hexBytes(message)}
+	m := []byte(message)
+	if len(m) == 0 {
+		return e
+	}
+	status := m[0]
+	u8 := func(v byte) *uint8 { return &v }
+	switch {
+	case status >= 0x80 && status < 0xF0:
+		lengths := map[byte]int{0x8: 3, 0x9: 3, 0xA: 3, 0xB: 3, 0xC: 2, 0xD: 2, 0xE: 3}
+		if len(m) != lengths[status>>4] {
+			return e
+		}
+		e = dumpEvent{Tick: tick, Channel: u8(status & 0x0F)}
+		switch status >> 4 {
+		case 0x8, 0x9:
+			e.Type = map[byte]string{0x8: "note_off", 0x9: "note_on"}[status>>4]
+			e.Key, e.Velocity = u8(m[1]), u8(m[2])
+		case 0xA:
+			e.Type, e.Key, e.Pressure = "poly_aftertouch", u8(m[1]), u8(m[2])
+		case 0xB:
+			e.Type, e.Controller, e.Value = "control_change", u8(m[1]), u8(m[2])
+		case 0xC:
+			e.Type, e.Program = "program_change", u8(m[1])
+		case 0xD:
+			e.Type, e.Pressure = "aftertouch", u8(m[1])
+		default:
+			bend := (int(m[2])<<7 | int(m[1])) - 0x2000
+			e.Type, e.Bend = "pitch_bend", &bend
+		}
+	case status == 0xF0:
+		e = dumpEvent{Tick: tick, Type: "sysex", Data: hexBytes(m[1:])}
+	case status == 0xF7:
+		e = dumpEvent{Tick: tick, Type: "sysex_escape", Data: hexBytes(m[1:])}
+	case status == 0xFF && len(m) >= 3:
+		typ, data := metaData(message)
+		e = dumpEvent{Tick: tick, Type: "meta", Meta: u8(typ), Data: hexBytes(data)}
+		if !bytes.Equal(smf.MetaUndefined(typ, data), m) {
+			// the length is not written minimally
+			return dumpEvent{Tick: tick, Type: "raw", Data: hexBytes(m)}
+		}
+		e.decodeMeta(typ, data)
+	}
+	return e
+}
+
+// decodeMeta replaces the generic form of a meta event with a specific one,
+// if the data has the expected shape
+func (e *dumpEvent) decodeMeta(typ byte, data []byte) {
+	u8 := func(v byte) *uint8 { return &v }
+	specific := dumpEvent{Tick: e.Tick}
+	if name, found := dumpTextTypes[typ]; found {
+		if !utf8.Valid(data) {
+			return
+		}
+		text := string(data)
+		specific.Type, specific.Text = name, &text
+		*e = specific
+		return
+	}
+	switch {
+	case typ == 0x00 && len(data) == 2:
+		number := uint16(data[0])<<8 | uint16(data[1])
+		specific.Type, specific.Number = "sequence_number", &number
+	case typ == 0x20 && len(data) == 1:
+		specific.Type, specific.Channel = "channel_prefix", u8(data[0])
+	case typ == 0x21 && len(data) == 1:
+		specific.Type, specific.Port = "port", u8(data[0])
+	case typ == 0x2F && len(data) == 0:
+		specific.Type = "end_of_track"
+	case typ == 0x51 && len(data) == 3:
+		microseconds := uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+		specific.Type, specific.Microseconds = "tempo", &microseconds
+	case typ == 0x54 && len(data) == 5:
+		specific.Type = "smpte_offset"
+		specific.Hour, specific.Minute, specific.Second = u8(data[0]), u8(data[1]), u8(data[2])
+		specific.Frame, specific.Subframe = u8(data[3]), u8(data[4])
+	case typ == 0x58 && len(data) == 4 && data[1] < 8:
+		specific.Type = "time_signature"
+		specific.Numerator, specific.Denominator = u8(data[0]), u8(1<<data[1])
+		specific.Clocks, specific.ThirtySeconds = u8(data[2]), u8(data[3])
+	case typ == 0x59 && len(data) == 2 && int8(data[0]) >= -7 && int8(data[0]) <= 7 && data[1] < 2:
+		accidentals := int8(data[0])
+		specific.Type, specific.Accidentals = "key_signature", &accidentals
+		specific.Mode = map[byte]string{0: "major", 1: "minor"}[data[1]]
+	case typ == 0x7F:
+		specific.Type, specific.Data = "sequencer_specific", hexBytes(data)
+	default:
+		return
+	}
+	*e = specific
+}
+
+// annotate describes the event's note, program, tempo, or key
+func (e *dumpEvent) annotate(speller *read) {
+	switch e.Type {
+	case "note_on", "note_off", "poly_aftertouch":
+		e.Note = speller.asNote(*e.Channel, *e.Key)
+	case "program_change":
+		e.Instrument = speller.asInstrument(*e.Channel, *e.Program)
+	case "tempo":
+		if *e.Microseconds > 0 {
+			e.BPM = math.Round(60000000/float64(*e.Microseconds)*1000) / 1000
+		}
+	case "key_signature":
+		var k smf.Key
+		_ = smf.MetaKey(0, e.Mode == "major", uint8(max(*e.Accidentals, -*e.Accidentals)), *e.Accidentals < 0).GetMetaKey(&k)
+		e.KeyName = keyName(k)
+	}
+}
+
+func hexBytes(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for k, d := range data {
+		if k > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(hex.EncodeToString([]byte{d}))
+	}
+	return b.String()
+}
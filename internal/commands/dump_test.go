@@ -0,0 +1,328 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// assembleSMF builds a file's bytes from the header fields and the tracks'
+// event bytes
+func assembleSMF(format, division uint16, tracks ...[]byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("MThd")
+	_ = binary.Write(&b, binary.BigEndian, []uint32{6})
+	_ = binary.Write(&b, binary.BigEndian, []uint16{format, uint16(len(tracks)), division})
+	for _, track := range tracks {
+		b.WriteString("MTrk")
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(track)))
+		b.Write(track)
+	}
+	return b.Bytes()
+}
+
+func smfBytes(t *testing.T, data *smf.SMF) []byte {
+	var b bytes.Buffer
+	if _, err := data.WriteTo(&b); err != nil {
+		t.Fatalf("cannot write test file: %v", err)
+	}
+	return b.Bytes()
+}
+
+// roundTripCorpus returns the fixture files for the round trip tests, and
+// whether rebuilding each should reproduce its bytes exactly
+func roundTripCorpus(t *testing.T) map[string]struct {
+	file          []byte
+	byteIdentical bool
+} {
+	var single smf.Track
+	single.Add(0, smf.MetaTempo(140))
+	single.Add(0, midi.NoteOn(0, 64, 100))
+	single.Add(240, midi.NoteOn(0, 64, 0))
+	single.Add(0, midi.NoteOn(0, 67, 100))
+	single.Add(240, midi.NoteOff(0, 67))
+	single.Close(0)
+	format0 := smf.New()
+	format0.TimeFormat = smf.MetricTicks(96)
+	_ = format0.Add(single)
+
+	var first, second smf.Track
+	first.Add(10, midi.ControlChange(2, 10, 64))
+	first.Close(25)
+	second.Close(400)
+	format2 := smf.NewSMF2()
+	format2.TimeFormat = smf.MetricTicks(1000)
+	_ = format2.Add(first)
+	_ = format2.Add(second)
+
+	handmade := assembleSMF(1, 192, []byte{
+		0x00, 0x90, 0x3C, 0x40, // note on
+		0x60, 0x3E, 0x40, // running status
+		0x00, 0x80, 0x3C, 0x00,
+		0x00, 0xF7, 0x03, 0x43, 0x12, 0x00, // sysex escape
+		0x00, 0xFF, 0x01, 0x02, 0xC9, 0x74, // text that is not UTF-8
+		0x00, 0xFF, 0x51, 0x02, 0x07, 0xA1, // tempo of the wrong length
+		0x00, 0xFF, 0x59, 0x02, 0x09, 0x00, // key signature with 9 sharps
+		0x00, 0xFF, 0x58, 0x04, 0x04, 0x09, 0x18, 0x08, // time signature over 512
+		0x10, 0xE0, 0x00, 0x40, // pitch bend at the center
+		0x00, 0xFF, 0x2F, 0x00,
+	}, []byte{
+		0x00, 0xFF, 0x2F, 0x00,
+	})
+	return map[string]struct {
+		file          []byte
+		byteIdentical bool
+	}{
+		"every event":    {file: smfBytes(t, csvTestSMF()), byteIdentical: true},
+		"format 0":       {file: smfBytes(t, format0), byteIdentical: true},
+		"format 2":       {file: smfBytes(t, format2), byteIdentical: true},
+		"hand assembled": {file: handmade, byteIdentical: true},
+	}
+}
+
+func Test_dump_build_roundTrip(t *testing.T) {
+	for name, fixture := range roundTripCorpus(t) {
+		for _, format := range []string{"yaml", "json"} {
+			t.Run(name+" as "+format, func(t *testing.T) {
+				original, err := smf.ReadFrom(bytes.NewReader(fixture.file))
+				if err != nil {
+					t.Fatalf("cannot read fixture: %v", err)
+				}
+				var dumped bytes.Buffer
+				d := &dump{format: format, lossless: true}
+				if err := d.write(&dumped, newDumpDocument(original, false)); err != nil {
+					t.Fatalf("dump.write() error = %v", err)
+				}
+				rebuilt, err := buildSMF(dumped.Bytes())
+				if err != nil {
+					t.Fatalf("buildSMF() error = %v\n%s", err, dumped.String())
+				}
+				if rebuilt.Format() != original.Format() || rebuilt.TimeFormat != original.TimeFormat {
+					t.Errorf("buildSMF() header = %d %v, want %d %v",
+						rebuilt.Format(), rebuilt.TimeFormat, original.Format(), original.TimeFormat)
+				}
+				if len(rebuilt.Tracks) != len(original.Tracks) {
+					t.Fatalf("buildSMF() tracks = %d, want %d", len(rebuilt.Tracks), len(original.Tracks))
+				}
+				for k := range original.Tracks {
+					if got, want := trackBytes(rebuilt.Tracks[k]), trackBytes(original.Tracks[k]); !reflect.DeepEqual(got, want) {
+						t.Errorf("buildSMF() track %d = %v, want %v", k, got, want)
+					}
+				}
+				if fixture.byteIdentical && !bytes.Equal(smfBytes(t, rebuilt), fixture.file) {
+					t.Errorf("buildSMF() wrote % x, want % x", smfBytes(t, rebuilt), fixture.file)
+				}
+				var again bytes.Buffer
+				_ = d.write(&again, newDumpDocument(rebuilt, false))
+				if again.String() != dumped.String() {
+					t.Errorf("dumping the rebuilt file = %s, want %s", again.String(), dumped.String())
+				}
+			})
+		}
+	}
+}
+
+func Test_newDumpDocument(t *testing.T) {
+	data, err := smf.ReadFrom(bytes.NewReader(roundTripCorpus(t)["hand assembled"].file))
+	if err != nil {
+		t.Fatalf("cannot read fixture: %v", err)
+	}
+	var b bytes.Buffer
+	if err := (&dump{format: "yaml"}).write(&b, newDumpDocument(data, true)); err != nil {
+		t.Fatalf("dump.write() error = %v", err)
+	}
+	want := `format: 1
+timeFormat:
+  ticksPerQuarter: 192
+tracks:
+  - events:
+      - {tick: 0, type: note_on, channel: 0, key: 60, velocity: 64, note: C5}
+      - {tick: 96, type: note_on, channel: 0, key: 62, velocity: 64, note: D5}
+      - {tick: 96, type: note_off, channel: 0, key: 60, velocity: 0, note: C5}
+      - {tick: 96, type: sysex_escape, data: 43 12 00}
+      - {tick: 96, type: meta, meta: 1, data: c9 74}
+      - {tick: 96, type: meta, meta: 81, data: 07 a1}
+      - {tick: 96, type: meta, meta: 89, data: 09 00}
+      - {tick: 96, type: meta, meta: 88, data: 04 09 18 08}
+      - {tick: 112, type: pitch_bend, channel: 0, bend: 0}
+  - events: []
+`
+	if got := b.String(); got != want {
+		t.Errorf("dump.write() = %s, want %s", got, want)
+	}
+}
+
+func Test_buildSMF(t *testing.T) {
+	header := "format: 1\ntimeFormat: {ticksPerQuarter: 96}\ntracks:\n  - events:\n"
+	tests := map[string]struct {
+		content string
+		wantErr string
+	}{
+		"annotated": {
+			content: header + "      - {tick: 0, type: note_on, channel: 0, key: 60, velocity: 64, note: C5}\n" +
+				"      - {tick: 96, type: note_off, channel: 0, key: 60, velocity: 0}\n",
+		},
+		"json": {
+			content: `{"format": 0, "timeFormat": {"ticksPerQuarter": 96}, "tracks": [{"events": [` +
+				`{"tick": 0, "type": "tempo", "microseconds": 500000}]}]}`,
+		},
+		"smpte": {
+			content: "format: 1\ntimeFormat: {framesPerSecond: 29, subframes: 80}\ntracks: [{events: []}]\n",
+		},
+		"unknown field": {
+			content: header + "      - {tick: 0, type: note_on, chanel: 0}\n",
+			wantErr: "yaml: unmarshal errors:\n  line 5: field chanel not found in type commands.dumpEvent",
+		},
+		"missing field": {
+			content: header + "      - {tick: 0, type: note_on, channel: 0, key: 60}\n",
+			wantErr: "line 5: a note_on event needs channel, key, and velocity",
+		},
+		"bad channel": {
+			content: header + "      - {tick: 0, type: program_change, channel: 16, program: 1}\n",
+			wantErr: "line 5: the channel 16 is not valid: it must be from 0 to 15",
+		},
+		"bad value": {
+			content: header + "      - {tick: 0, type: control_change, channel: 0, controller: 7, value: 128}\n",
+			wantErr: "line 5: the value 128 is not valid: it must be from 0 to 127",
+		},
+		"bad bend": {
+			content: header + "      - {tick: 0, type: pitch_bend, channel: 0, bend: 8192}\n",
+			wantErr: "line 5: the bend 8192 is not valid: it must be from -8192 to 8191",
+		},
+		"bad denominator": {
+			content: header + "      - {tick: 0, type: time_signature, numerator: 3, denominator: 6, clocks: 24, thirtySeconds: 8}\n",
+			wantErr: "line 5: the denominator 6 is not valid: it must be a power of 2",
+		},
+		"bad mode": {
+			content: header + "      - {tick: 0, type: key_signature, accidentals: 2, mode: lydian}\n",
+			wantErr: "line 5: the mode \"lydian\" is not valid: it must be major or minor",
+		},
+		"bad data": {
+			content: header + "      - {tick: 0, type: sysex, data: 7e 7g}\n",
+			wantErr: "line 5: the data \"7e 7g\" is not valid: it must be hex bytes separated by spaces",
+		},
+		"unknown type": {
+			content: header + "      - {tick: 0, type: note}\n",
+			wantErr: "line 5: the type \"note\" is not known",
+		},
+		"ticks out of order": {
+			content: header + "      - {tick: 10, type: text, text: a}\n      - {tick: 5, type: text, text: b}\n",
+			wantErr: "line 6: the tick 5 precedes the previous event's tick 10",
+		},
+		"event after end": {
+			content: header + "      - {tick: 10, type: end_of_track}\n      - {tick: 10, type: text, text: b}\n",
+			wantErr: "line 6: the event follows the end of the track",
+		},
+		"bad format": {
+			content: "format: 3\ntimeFormat: {ticksPerQuarter: 96}\ntracks: []\n",
+			wantErr: "the format 3 is not valid: it must be 0, 1, or 2",
+		},
+		"format 0 tracks": {
+			content: "format: 0\ntimeFormat: {ticksPerQuarter: 96}\ntracks: []\n",
+			wantErr: "a format 0 file holds 1 track, not 0",
+		},
+		"bad time format": {
+			content: "format: 1\ntimeFormat: {framesPerSecond: 20}\ntracks: []\n",
+			wantErr: "the time format needs either ticksPerQuarter (1 to 32767) or framesPerSecond (24, 25, 29, or 30)",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := buildSMF([]byte(tt.content))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("buildSMF() error = %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("buildSMF() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_dump_run(t *testing.T) {
+	tests := map[string]struct {
+		d    *dump
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"bad format": {
+			d:    &dump{format: "xml"},
+			args: []string{"a.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --format value \"xml\" is not valid: it must be yaml or json.\n",
+			},
+		},
+		"output with several files": {
+			d:    &dump{format: "yaml", outFile: "x.yaml"},
+			args: []string{"a.mid", "b.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --output flag may only be used with a single input file.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.d.run(o, tt.args); got != tt.want {
+				t.Errorf("dump.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("dump.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_dump_build_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "song.mid")
+	original := smfBytes(t, csvTestSMF())
+	if err := os.WriteFile(input, original, 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	dumped := filepath.Join(dir, "song.yaml")
+	o := output.NewRecorder()
+	if !(&dump{format: "yaml", outFile: dumped, lossless: true}).processFile(o, input) {
+		t.Fatalf("dump.processFile() failed: %s", o.ErrorOutput())
+	}
+	if got, want := o.ConsoleOutput(), input+": dumped to "+dumped+"\n"; got != want {
+		t.Errorf("dump.processFile() console = %q, want %q", got, want)
+	}
+	if err := os.Remove(input); err != nil {
+		t.Fatalf("cannot remove test file: %v", err)
+	}
+	o = output.NewRecorder()
+	if !(&build{}).processFile(o, dumped) {
+		t.Fatalf("build.processFile() failed: %s", o.ErrorOutput())
+	}
+	if got, want := o.ConsoleOutput(), dumped+": built "+input+"\n"; got != want {
+		t.Errorf("build.processFile() console = %q, want %q", got, want)
+	}
+	rebuilt, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("cannot read rebuilt file: %v", err)
+	}
+	if !bytes.Equal(rebuilt, original) {
+		t.Errorf("build.processFile() wrote % x, want % x", rebuilt, original)
+	}
+	o = output.NewRecorder()
+	if !(&dump{format: "json"}).processFile(o, input) {
+		t.Fatalf("dump.processFile() to the console failed: %s", o.ErrorOutput())
+	}
+	if !strings.HasPrefix(o.ConsoleOutput(), "{\n  \"format\": 1,") {
+		t.Errorf("dump.processFile() to the console wrote %q", o.ConsoleOutput())
+	}
+}
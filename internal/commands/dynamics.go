@@ -0,0 +1,71 @@
+package commands
+
+import "fmt"
+
+// dynamic is a dynamic marking and the MIDI velocity it translates to
+type dynamic struct {
+	name         string
+	abbreviation string
+	symbol       string
+	velocity     uint8
+}
+
+// dynamics lists the dynamic markings from softest to loudest, per
+// https://professionalcomposers.com/music-dynamics-chart/
+var dynamics = []dynamic{
+	{name: "pianississimo", abbreviation: "ppp", symbol: "𝆏𝆏𝆏", velocity: 16},
+	{name: "pianissimo", abbreviation: "pp", symbol: "𝆏𝆏", velocity: 33},
+	{name: "piano", abbreviation: "p", symbol: "𝆏", velocity: 49},
+	{name: "mezzo-piano", abbreviation: "mp", symbol: "𝆐𝆏", velocity: 64},
+	{name: "mezzo-forte", abbreviation: "mf", symbol: "𝆐𝆑", velocity: 80},
+	{name: "forte", abbreviation: "f", symbol: "𝆑", velocity: 96},
+	{name: "fortissimo", abbreviation: "ff", symbol: "𝆑𝆑", velocity: 112},
+	{name: "fortississimo", abbreviation: "fff", symbol: "𝆑𝆑𝆑", velocity: 127},
+}
+
+func (d dynamic) String() string {
+	return fmt.Sprintf("%s (%s)", d.name, d.symbol)
+}
+
+// nearestDynamic returns the dynamic marking whose velocity is closest to the
+// specified velocity; ties go to the louder marking
+func nearestDynamic(velocity uint8) dynamic {
+	nearest := dynamics[0]
+	bestDistance := 256
+	for _, d := range dynamics {
+		distance := int(velocity) - int(d.velocity)
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance <= bestDistance {
+			nearest = d
+			bestDistance = distance
+		}
+	}
+	return nearest
+}
+
+// velocityBands returns the labels of the bands that velocityBand assigns
+// velocities to: one for each dynamic marking, and one for each gap below or
+// between them
+func velocityBands() []string {
+	labels := []string{"<" + dynamics[0].abbreviation, dynamics[0].abbreviation}
+	for k := 1; k < len(dynamics); k++ {
+		labels = append(labels, dynamics[k-1].abbreviation+"-"+dynamics[k].abbreviation, dynamics[k].abbreviation)
+	}
+	return labels
+}
+
+// velocityBand returns the index of the band, as described by asVolume, that
+// the velocity falls into
+func velocityBand(velocity uint8) int {
+	for k, d := range dynamics {
+		switch {
+		case velocity == d.velocity:
+			return 2*k + 1
+		case velocity < d.velocity:
+			return 2 * k
+		}
+	}
+	return 2*len(dynamics) - 1
+}
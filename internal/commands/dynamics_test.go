@@ -0,0 +1,44 @@
+package commands
+
+import "testing"
+
+func Test_nearestDynamic(t *testing.T) {
+	tests := map[string]struct {
+		velocity uint8
+		want     string
+	}{
+		"silent":      {velocity: 0, want: "pianississimo"},
+		"exact":       {velocity: 49, want: "piano"},
+		"closer down": {velocity: 70, want: "mezzo-piano"},
+		"tie":         {velocity: 88, want: "forte"},
+		"top":         {velocity: 127, want: "fortississimo"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := nearestDynamic(tt.velocity); got.name != tt.want {
+				t.Errorf("nearestDynamic() = %q, want %q", got.name, tt.want)
+			}
+		})
+	}
+}
+
+func Test_velocityBand(t *testing.T) {
+	bands := velocityBands()
+	tests := map[string]struct {
+		velocity uint8
+		want     string
+	}{
+		"quietest": {velocity: 1, want: "<ppp"},
+		"ppp":      {velocity: 16, want: "ppp"},
+		"between":  {velocity: 70, want: "mp-mf"},
+		"forte":    {velocity: 96, want: "f"},
+		"loudest":  {velocity: 127, want: "fff"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := bands[velocityBand(tt.velocity)]; got != tt.want {
+				t.Errorf("velocityBand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,511 @@
+package commands
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// timePosition is a position, or a time in seconds
+type timePosition struct {
+	position
+	seconds   float64
+	inSeconds bool
+}
+
+// parseTimePosition parses a position as parsePosition does, or a time in
+// seconds followed by an 's' (e.g., "12.5s")
+func parseTimePosition(s string) (timePosition, error) {
+	s = strings.TrimSpace(s)
+	if text, found := strings.CutSuffix(s, "s"); found {
+		seconds, err := strconv.ParseFloat(text, 64)
+		if err != nil || seconds < 0 {
+			return timePosition{}, fmt.Errorf("%q is not a valid time", s)
+		}
+		return timePosition{seconds: seconds, inSeconds: true}, nil
+	}
+	p, err := parsePosition(s)
+	return timePosition{position: p}, err
+}
+
+func (p timePosition) resolve(m *meter, tempos *tempoMap) int64 {
+	if p.inSeconds {
+		return tempos.tickAt(p.seconds)
+	}
+	return m.resolve(p.position)
+}
+
+// rangeFlags choose a range of a file: the section of a marker or cue point,
+// or the range between two positions
+type rangeFlags struct {
+	from    string
+	to      string
+	section string
+}
+
+func (r *rangeFlags) define(flags *pflag.FlagSet) {
+	flags.StringVar(&r.from, "from", "",
+		"start of the range: a bar (12), bar and beat (12:3), ticks (1920t), or seconds (12.5s)")
+	flags.StringVar(&r.to, "to", "", "end of the range, which is not included (default: the end of the file)")
+	flags.StringVar(&r.section, "section", "",
+		"name of the marker or cue point starting the section to use as the range")
+}
+
+func (r *rangeFlags) validate(o output.Bus) bool {
+	valid := true
+	if (r.from == "") == (r.section == "") {
+		o.ErrorPrintln("Either --from or --section must be specified, but not both.")
+		valid = false
+	}
+	if r.to != "" && r.section != "" {
+		o.ErrorPrintln("The --to flag may only be used with --from.")
+		valid = false
+	}
+	for _, flag := range []struct{ name, value string }{{"from", r.from}, {"to", r.to}} {
+		if flag.value == "" {
+			continue
+		}
+		if _, err := parseTimePosition(flag.value); err != nil {
+			o.ErrorPrintf("The --%s value %q is not valid: %v.\n", flag.name, flag.value, err)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// resolve returns the range in the file as a section, named if it is one
+func (r *rangeFlags) resolve(data *smf.SMF) (section, error) {
+	if r.section != "" {
+		found := findSections(data)
+		s, ok := findSection(found, r.section)
+		switch {
+		case ok:
+			return s, nil
+		case len(found) == 0:
+			return s, fmt.Errorf("it has no section %q; it has no markers or cue points", r.section)
+		default:
+			return s, fmt.Errorf("it has no section %q; its sections are %s", r.section, sectionNames(found))
+		}
+	}
+	m, tempos := newMeter(data), newTempoMap(data)
+	from, _ := parseTimePosition(r.from)
+	s := section{from: from.resolve(m, tempos), to: lastTick(data)}
+	if r.to != "" {
+		to, _ := parseTimePosition(r.to)
+		s.to = to.resolve(m, tempos)
+	}
+	if s.to <= s.from {
+		return s, fmt.Errorf("the range from %s to %s is empty", m.describe(s.from), m.describe(s.to))
+	}
+	return s, nil
+}
+
+// describeRange renders the range as "from bar N beat B to bar M beat C",
+// preceded by its name if it has one
+func describeRange(m *meter, s section) string {
+	if s.name != "" {
+		return fmt.Sprintf("%q, from %s to %s", s.name, m.describe(s.from), m.describe(s.to))
+	}
+	return fmt.Sprintf("from %s to %s", m.describe(s.from), m.describe(s.to))
+}
+
+// noteEvent reports whether the message starts or stops a note, and which
+func noteEvent(message smf.Message) (note [2]uint8, on, off bool) {
+	var velocity uint8
+	switch {
+	case message.GetNoteOn(&note[0], &note[1], &velocity) && velocity > 0:
+		on = true
+	case message.GetNoteOn(&note[0], &note[1], nil), message.GetNoteOff(&note[0], &note[1], nil):
+		off = true
+	}
+	return
+}
+
+// chaseTo returns the state the track's events before the tick set up
+func chaseTo(track smf.Track, tick int64) *chaser {
+	c := newChaser()
+	ticks := absoluteTicks(track)
+	for k := 0; k < len(track) && ticks[k] < tick; k++ {
+		c.apply(track[k].Message)
+	}
+	return c
+}
+
+// soundingNotes returns the notes the chaser found sounding, in order
+func soundingNotes(c *chaser) [][2]uint8 {
+	var notes [][2]uint8
+	for channel, ch := range c.channels {
+		if ch != nil {
+			for _, key := range ch.noteOrder {
+				notes = append(notes, [2]uint8{uint8(channel), key})
+			}
+		}
+	}
+	return notes
+}
+
+// trackEnd returns the tick of the track's end
+func trackEnd(track smf.Track) int64 {
+	if ticks := absoluteTicks(track); len(ticks) > 0 {
+		return ticks[len(ticks)-1]
+	}
+	return 0
+}
+
+// rangeMessages returns what the track's events before the tick from set up,
+// and the messages from there up to the tick to, moved to start at 0. With
+// held, the notes sounding at the start keep their ends; without, those ends
+// are left out. The notes still sounding at the end stop there.
+func rangeMessages(track smf.Track, from, to int64, held bool) (*chaser, []timedMessage) {
+	c := chaseTo(track, from)
+	started := map[[2]uint8]bool{}
+	if held {
+		for _, note := range soundingNotes(c) {
+			started[note] = true
+		}
+	}
+	ticks := absoluteTicks(track)
+	var messages []timedMessage
+	for k, event := range track {
+		message, tick := event.Message, ticks[k]
+		if message.Is(smf.MetaEndOfTrackMsg) || tick < from || tick >= to {
+			continue
+		}
+		switch note, on, off := noteEvent(message); {
+		case on:
+			started[note] = true
+		case off:
+			if !started[note] {
+				// the note began before the range
+				continue
+			}
+			delete(started, note)
+		}
+		messages = append(messages, timedMessage{tick: tick - from, message: message})
+	}
+	sounding := slices.SortedFunc(maps.Keys(started), func(a, b [2]uint8) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	for _, note := range sounding {
+		stop := smf.Message(midi.NoteOff(note[0], note[1]))
+		messages = append(messages, timedMessage{tick: to - from, message: stop})
+	}
+	return c, messages
+}
+
+// deleteRange removes the events from the tick up to, but not including, the
+// tick to, and moves the later events back to close the gap. The notes
+// sounding at the start that would end in the range stop there, and the notes
+// starting in the range are removed, but the lasting changes made in the range
+// (to the tempo, the programs, the controllers, and so on) are kept, at the
+// start.
+func deleteRange(data *smf.SMF, from, to int64) {
+	length := to - from
+	for index, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		var messages []timedMessage
+		sounding := map[[2]uint8]int{}
+		removed := map[[2]uint8]int{}
+		for k, event := range track {
+			message, tick := event.Message, ticks[k]
+			if message.Is(smf.MetaEndOfTrackMsg) {
+				continue
+			}
+			note, on, off := noteEvent(message)
+			switch {
+			case tick < from:
+				if on {
+					sounding[note]++
+				} else if off && sounding[note] > 0 {
+					sounding[note]--
+				}
+			case tick < to:
+				switch {
+				case on:
+					removed[note]++
+					continue
+				case off && removed[note] > 0:
+					removed[note]--
+					continue
+				case off && sounding[note] > 0:
+					sounding[note]--
+				case off, !lastingMessage(message):
+					continue
+				}
+				tick = from
+			default:
+				if off && removed[note] > 0 {
+					removed[note]--
+					continue
+				}
+				tick -= length
+			}
+			messages = append(messages, timedMessage{tick: tick, message: message})
+		}
+		end := trackEnd(track)
+		switch {
+		case end >= to:
+			end -= length
+		case end > from:
+			end = from
+		}
+		data.Tracks[index] = closeAt(buildTrack(messages), end)
+	}
+}
+
+// insertSpace moves the events at and after the tick later by the length,
+// but for the ends of notes at the tick, which end before the space
+func insertSpace(data *smf.SMF, at, length int64) {
+	for index, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		var messages []timedMessage
+		for k, event := range track {
+			message, tick := event.Message, ticks[k]
+			if message.Is(smf.MetaEndOfTrackMsg) {
+				continue
+			}
+			if _, _, off := noteEvent(message); tick > at || tick == at && !off {
+				tick += length
+			}
+			messages = append(messages, timedMessage{tick: tick, message: message})
+		}
+		end := trackEnd(track)
+		if end >= at {
+			end += length
+		}
+		data.Tracks[index] = closeAt(buildTrack(messages), end)
+	}
+}
+
+// repeatRange plays the events from the tick up to, but not including, the
+// tick to, the number of times, moving the later events to follow the last
+// time. Each repeat starts by setting up again what changed in the range;
+// notes sounding across the range's end stop there.
+func repeatRange(data *smf.SMF, from, to int64, times int) {
+	length := to - from
+	extra := length * int64(times-1)
+	for index, track := range data.Tracks {
+		atEnd := chaseTo(track, to)
+		stopped := map[[2]uint8]bool{}
+		for _, note := range soundingNotes(atEnd) {
+			stopped[note] = true
+		}
+		ticks := absoluteTicks(track)
+		var before, after []timedMessage
+		for k, event := range track {
+			message, tick := event.Message, ticks[k]
+			switch note, _, off := noteEvent(message); {
+			case message.Is(smf.MetaEndOfTrackMsg):
+			case tick < to:
+				before = append(before, timedMessage{tick: tick, message: message})
+			case off && stopped[note]:
+				delete(stopped, note)
+				before = append(before, timedMessage{tick: to, message: message})
+			default:
+				after = append(after, timedMessage{tick: tick + extra, message: message})
+			}
+		}
+		atStart, repeated := rangeMessages(track, from, to, false)
+		set := map[string]bool{}
+		for _, message := range atEnd.messages(false) {
+			set[string(message)] = true
+		}
+		var restore []smf.Message
+		for _, message := range atStart.messages(false) {
+			if !set[string(message)] {
+				restore = append(restore, message)
+			}
+		}
+		messages := before
+		for n := 1; n < times; n++ {
+			start := to + int64(n-1)*length
+			for _, message := range restore {
+				messages = append(messages, timedMessage{tick: start, message: message})
+			}
+			for _, m := range repeated {
+				messages = append(messages, timedMessage{tick: start + m.tick, message: m.message})
+			}
+		}
+		messages = append(messages, after...)
+		end := trackEnd(track)
+		if end >= to {
+			end += extra
+		}
+		data.Tracks[index] = closeAt(buildTrack(messages), end)
+	}
+}
+
+// rangeDeleter deletes a range of files, closing the gap
+type rangeDeleter struct {
+	span    rangeFlags
+	outFile string
+}
+
+func newRangeDeleter() command {
+	return &rangeDeleter{}
+}
+
+func (d *rangeDeleter) defineFlags(flags *pflag.FlagSet) {
+	d.span.define(flags)
+	flags.StringVarP(&d.outFile, "output", "o", "", "file to write (default: overwrite the input file)")
+}
+
+func (d *rangeDeleter) run(o output.Bus, args []string) int {
+	if valid := d.span.validate(o); !validOutput(o, d.outFile, args) || !valid {
+		return exitUserError
+	}
+	return processFiles(o, args, d.processFile)
+}
+
+func (d *rangeDeleter) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	s, err := d.span.resolve(data)
+	if err != nil {
+		o.ErrorPrintf("The range of the file %q is not valid: %v.\n", path, err)
+		return false
+	}
+	m, tempos := newMeter(data), newTempoMap(data)
+	seconds := round3(tempos.seconds(s.to) - tempos.seconds(s.from))
+	deleteRange(data, s.from, s.to)
+	destination := editDestination(path, d.outFile)
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: deleted %s, %g seconds\n", destination, describeRange(m, s), seconds)
+	return true
+}
+
+// barInserter inserts empty bars into files
+type barInserter struct {
+	at      string
+	bars    int
+	outFile string
+	// set by run
+	position timePosition
+}
+
+func newBarInserter() command {
+	return &barInserter{}
+}
+
+func (i *barInserter) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&i.at, "at", "", "where to insert the bars: a bar (12), bar and beat (12:3), ticks (1920t), "+
+		"or seconds (12.5s)")
+	flags.IntVar(&i.bars, "bars", 1, "number of bars to insert, each as long as a bar at that position")
+	flags.StringVarP(&i.outFile, "output", "o", "", "file to write (default: overwrite the input file)")
+}
+
+func (i *barInserter) run(o output.Bus, args []string) int {
+	valid := true
+	if i.at == "" {
+		o.ErrorPrintln("The --at flag must be specified.")
+		valid = false
+	} else if p, err := parseTimePosition(i.at); err != nil {
+		o.ErrorPrintf("The --at value %q is not valid: %v.\n", i.at, err)
+		valid = false
+	} else {
+		i.position = p
+	}
+	if i.bars < 1 {
+		o.ErrorPrintf("The --bars value %d is not valid: it must be at least 1.\n", i.bars)
+		valid = false
+	}
+	if !validOutput(o, i.outFile, args) || !valid {
+		return exitUserError
+	}
+	return processFiles(o, args, i.processFile)
+}
+
+func (i *barInserter) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	m := newMeter(data)
+	at := i.position.resolve(m, newTempoMap(data))
+	length := int64(i.bars) * m.barTicks(m.changeAtTick(at))
+	insertSpace(data, at, length)
+	destination := editDestination(path, i.outFile)
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: inserted %d bars at %s\n", destination, i.bars, m.describe(at))
+	return true
+}
+
+// rangeRepeater repeats a range of files
+type rangeRepeater struct {
+	span    rangeFlags
+	times   int
+	outFile string
+}
+
+func newRangeRepeater() command {
+	return &rangeRepeater{}
+}
+
+func (r *rangeRepeater) defineFlags(flags *pflag.FlagSet) {
+	r.span.define(flags)
+	flags.IntVar(&r.times, "times", 2, "number of times to play the range, counting the first")
+	flags.StringVarP(&r.outFile, "output", "o", "", "file to write (default: overwrite the input file)")
+}
+
+func (r *rangeRepeater) run(o output.Bus, args []string) int {
+	valid := r.span.validate(o)
+	if r.times < 2 {
+		o.ErrorPrintf("The --times value %d is not valid: it must be at least 2.\n", r.times)
+		valid = false
+	}
+	if !validOutput(o, r.outFile, args) || !valid {
+		return exitUserError
+	}
+	return processFiles(o, args, r.processFile)
+}
+
+func (r *rangeRepeater) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	s, err := r.span.resolve(data)
+	if err != nil {
+		o.ErrorPrintf("The range of the file %q is not valid: %v.\n", path, err)
+		return false
+	}
+	m := newMeter(data)
+	repeatRange(data, s.from, s.to, r.times)
+	destination := editDestination(path, r.outFile)
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: repeated %s, %d times\n", destination, describeRange(m, s), r.times)
+	return true
+}
+
+// validOutput checks that an --output file is only named for a single input
+// file
+func validOutput(o output.Bus, outFile string, args []string) bool {
+	if outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return false
+	}
+	return true
+}
+
+func editDestination(path, outFile string) string {
+	if outFile != "" {
+		return outFile
+	}
+	return path
+}
@@ -0,0 +1,258 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/afero"
+)
+
+func Test_parseTimePosition(t *testing.T) {
+	tests := map[string]struct {
+		s       string
+		want    timePosition
+		wantErr bool
+	}{
+		"bar":          {s: "12", want: timePosition{position: position{bar: 12, beat: 1}}},
+		"ticks":        {s: "1920t", want: timePosition{position: position{tick: 1920, isTick: true}}},
+		"seconds":      {s: "12.5s", want: timePosition{seconds: 12.5, inSeconds: true}},
+		"bad seconds":  {s: "xs", wantErr: true},
+		"negative":     {s: "-1s", wantErr: true},
+		"bad position": {s: "chorus", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseTimePosition(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimePosition() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseTimePosition() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rangeFlags_resolve(t *testing.T) {
+	data := sectionsTestSMF()
+	tests := map[string]struct {
+		r       rangeFlags
+		want    section
+		wantErr string
+	}{
+		"section":     {r: rangeFlags{section: "outro"}, want: section{name: "Outro", from: 7680, to: 9600}},
+		"to the end":  {r: rangeFlags{from: "5"}, want: section{from: 7680, to: 9600}},
+		"seconds":     {r: rangeFlags{from: "1s", to: "3s"}, want: section{from: 960, to: 2400}},
+		"empty":       {r: rangeFlags{from: "3", to: "2"}, wantErr: "the range from bar 3 beat 1 to bar 2 beat 1 is empty"},
+		"no such one": {r: rangeFlags{section: "Bridge"}, wantErr: "it has no section \"Bridge\"; its sections are \"Intro\", \"Chorus\", \"Outro\""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.r.resolve(data)
+			if err != nil {
+				if err.Error() != tt.wantErr {
+					t.Errorf("rangeFlags.resolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.wantErr != "" || got != tt.want {
+				t.Errorf("rangeFlags.resolve() = %+v, want %+v, error %q", got, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_deleteRange(t *testing.T) {
+	data := sectionsTestSMF()
+	deleteRange(data, 3840, 7680)
+	// the chorus's marker and its notes go, the note sounding at the start
+	// stops there, and the rest moves back
+	want := "track 0\n" +
+		"0 MetaTimeSig meter: 4/4\n" +
+		"0 MetaTempo bpm: 120.00\n" +
+		"0 MetaMarker text: \"Intro\"\n" +
+		"1920 MetaTempo bpm: 60.00\n" +
+		"3840 MetaCuepoint text: \"Outro\"\n" +
+		"3840 MetaEndOfTrack\n" +
+		"track 1\n" +
+		"0 MetaTrackName text: \"Violin\"\n" +
+		"0 ControlChange channel: 0 controller: 0 value: 1\n" +
+		"0 ProgramChange channel: 0 program: 40\n" +
+		"0 ControlChange channel: 0 controller: 7 value: 100\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 6 value: 12\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 127\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 127\n" +
+		"3000 NoteOn channel: 0 key: 60 velocity: 80\n" +
+		"3840 NoteOff channel: 0 key: 60\n" +
+		"4160 NoteOn channel: 0 key: 65 velocity: 80\n" +
+		"5760 NoteOff channel: 0 key: 65\n" +
+		"5760 MetaEndOfTrack\n"
+	if got := describeTracks(data); got != want {
+		t.Errorf("deleteRange() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_insertSpace(t *testing.T) {
+	data := sectionsTestSMF()
+	insertSpace(data, 4320, 1920)
+	// the note ending where the space goes in ends before it
+	want := "track 0\n" +
+		"0 MetaTimeSig meter: 4/4\n" +
+		"0 MetaTempo bpm: 120.00\n" +
+		"0 MetaMarker text: \"Intro\"\n" +
+		"1920 MetaTempo bpm: 60.00\n" +
+		"3840 MetaMarker text: \"Chorus\"\n" +
+		"9600 MetaCuepoint text: \"Outro\"\n" +
+		"9600 MetaEndOfTrack\n" +
+		"track 1\n" +
+		"0 MetaTrackName text: \"Violin\"\n" +
+		"0 ControlChange channel: 0 controller: 0 value: 1\n" +
+		"0 ProgramChange channel: 0 program: 40\n" +
+		"0 ControlChange channel: 0 controller: 7 value: 100\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 6 value: 12\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 127\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 127\n" +
+		"3000 NoteOn channel: 0 key: 60 velocity: 80\n" +
+		"4320 NoteOff channel: 0 key: 60\n" +
+		"6240 NoteOn channel: 0 key: 62 velocity: 80\n" +
+		"6920 NoteOff channel: 0 key: 62\n" +
+		"8920 NoteOn channel: 0 key: 64 velocity: 80\n" +
+		"9920 NoteOff channel: 0 key: 64\n" +
+		"9920 NoteOn channel: 0 key: 65 velocity: 80\n" +
+		"11520 NoteOff channel: 0 key: 65\n" +
+		"11520 MetaEndOfTrack\n"
+	if got := describeTracks(data); got != want {
+		t.Errorf("insertSpace() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_repeatRange(t *testing.T) {
+	data := sectionsTestSMF()
+	repeatRange(data, 3840, 7680, 3)
+	// the note sounding across the end stops there, each time
+	want := "track 0\n" +
+		"0 MetaTimeSig meter: 4/4\n" +
+		"0 MetaTempo bpm: 120.00\n" +
+		"0 MetaMarker text: \"Intro\"\n" +
+		"1920 MetaTempo bpm: 60.00\n" +
+		"3840 MetaMarker text: \"Chorus\"\n" +
+		"7680 MetaMarker text: \"Chorus\"\n" +
+		"11520 MetaMarker text: \"Chorus\"\n" +
+		"15360 MetaCuepoint text: \"Outro\"\n" +
+		"15360 MetaEndOfTrack\n" +
+		"track 1\n" +
+		"0 MetaTrackName text: \"Violin\"\n" +
+		"0 ControlChange channel: 0 controller: 0 value: 1\n" +
+		"0 ProgramChange channel: 0 program: 40\n" +
+		"0 ControlChange channel: 0 controller: 7 value: 100\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 0\n" +
+		"0 ControlChange channel: 0 controller: 6 value: 12\n" +
+		"0 ControlChange channel: 0 controller: 101 value: 127\n" +
+		"0 ControlChange channel: 0 controller: 100 value: 127\n" +
+		"3000 NoteOn channel: 0 key: 60 velocity: 80\n" +
+		"4320 NoteOff channel: 0 key: 60\n" +
+		"4320 NoteOn channel: 0 key: 62 velocity: 80\n" +
+		"5000 NoteOff channel: 0 key: 62\n" +
+		"7000 NoteOn channel: 0 key: 64 velocity: 80\n" +
+		"7680 NoteOff channel: 0 key: 64\n" +
+		"8160 NoteOn channel: 0 key: 62 velocity: 80\n" +
+		"8840 NoteOff channel: 0 key: 62\n" +
+		"10840 NoteOn channel: 0 key: 64 velocity: 80\n" +
+		"11520 NoteOff channel: 0 key: 64\n" +
+		"12000 NoteOn channel: 0 key: 62 velocity: 80\n" +
+		"12680 NoteOff channel: 0 key: 62\n" +
+		"14680 NoteOn channel: 0 key: 64 velocity: 80\n" +
+		"15360 NoteOff channel: 0 key: 64\n" +
+		"15680 NoteOn channel: 0 key: 65 velocity: 80\n" +
+		"17280 NoteOff channel: 0 key: 65\n" +
+		"17280 MetaEndOfTrack\n"
+	if got := describeTracks(data); got != want {
+		t.Errorf("repeatRange() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_edit_run(t *testing.T) {
+	tests := map[string]struct {
+		c    command
+		args []string
+		want int
+		output.WantedRecording
+		written string
+	}{
+		"delete": {
+			c:    &rangeDeleter{span: rangeFlags{section: "Chorus"}, outFile: "/short.mid"},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/short.mid: deleted \"Chorus\", from bar 3 beat 1 to bar 5 beat 1, 8 seconds\n",
+			},
+			written: "/short.mid",
+		},
+		"delete without a range": {
+			c:    &rangeDeleter{span: rangeFlags{section: "Chorus", to: "4"}},
+			args: []string{"/songs/song.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --to flag may only be used with --from.\n",
+			},
+		},
+		"insert": {
+			c:    &barInserter{at: "3", bars: 2},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid: inserted 2 bars at bar 3 beat 1\n",
+			},
+		},
+		"insert nowhere": {
+			c:    &barInserter{bars: 0},
+			args: []string{"/songs/song.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --at flag must be specified.\n" +
+					"The --bars value 0 is not valid: it must be at least 1.\n",
+			},
+		},
+		"repeat": {
+			c:    &rangeRepeater{span: rangeFlags{from: "3", to: "5"}, times: 2, outFile: "/long.mid"},
+			args: []string{"/songs/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/long.mid: repeated from bar 3 beat 1 to bar 5 beat 1, 2 times\n",
+			},
+			written: "/long.mid",
+		},
+		"repeat once": {
+			c:    &rangeRepeater{span: rangeFlags{from: "3s"}, times: 1},
+			args: []string{"/songs/song.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --times value 1 is not valid: it must be at least 2.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			memory := useMemoryFileSystem(t, map[string][]byte{"/songs/song.mid": smfBytes(t, sectionsTestSMF())})
+			o := output.NewRecorder()
+			if got := tt.c.run(o, tt.args); got != tt.want {
+				t.Errorf("run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("run() %s", issue)
+				}
+			}
+			if tt.written != "" {
+				if found, _ := afero.Exists(memory, tt.written); !found {
+					t.Errorf("run() did not write %s", tt.written)
+				}
+			}
+		})
+	}
+}
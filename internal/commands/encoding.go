@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// autoEncoding is the --encoding value that detects each file's encoding
+const autoEncoding = "auto"
+
+// textEncodings maps the names accepted by --encoding to the encodings of
+// text meta events; the standard does not name one, so legacy files are often
+// written in the author's local encoding
+var textEncodings = map[string]encoding.Encoding{
+	"utf-8":        encoding.Nop,
+	"latin-1":      charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+	"shift-jis":    japanese.ShiftJIS,
+}
+
+// encodingNames lists the --encoding values, for flag usage and errors
+func encodingNames() string {
+	return joinSorted(append(fieldNames(textEncodings), autoEncoding))
+}
+
+// checkEncodingName returns an error if the --encoding value is not known; an
+// empty name, like auto, detects the encoding
+func checkEncodingName(name string) error {
+	if _, found := textEncodings[name]; found || name == "" || name == autoEncoding {
+		return nil
+	}
+	return fmt.Errorf("the encodings are %s", encodingNames())
+}
+
+// textCodec returns the name and the encoding chosen by the --encoding value
+// for the file
+func textCodec(name string, data *smf.SMF) (string, encoding.Encoding) {
+	if name == "" || name == autoEncoding {
+		name = detectTextEncoding(fileTexts(data))
+	}
+	return name, textEncodings[name]
+}
+
+// fileTexts returns the data of every text meta event in the file
+func fileTexts(data *smf.SMF) [][]byte {
+	var texts [][]byte
+	for _, track := range data.Tracks {
+		for _, event := range track {
+			if !event.Message.IsMeta() {
+				continue
+			}
+			if typ, text := metaData(event.Message); dumpTextTypes[typ] != "" {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts
+}
+
+// detectTextEncoding guesses the encoding of the texts, which are taken to
+// share one: UTF-8 if they are valid UTF-8 (as plain ASCII is), else Shift-JIS
+// if they read as Japanese, else windows-1252, which covers Latin-1
+func detectTextEncoding(texts [][]byte) string {
+	joined := bytes.Join(texts, []byte{'\n'})
+	switch {
+	case utf8.Valid(joined):
+		return "utf-8"
+	case looksLikeShiftJIS(joined):
+		return "shift-jis"
+	default:
+		return "windows-1252"
+	}
+}
+
+// looksLikeShiftJIS reports whether the bytes are valid Shift-JIS whose
+// two-byte characters are mostly punctuation, full-width letters, or kana, or
+// have a trail byte above 0x7f; accented Latin letters followed by ASCII ones
+// also make valid Shift-JIS, but as kanji with ASCII trail bytes
+func looksLikeShiftJIS(b []byte) bool {
+	pairs, likely := 0, 0
+	for k := 0; k < len(b); k++ {
+		c := b[k]
+		switch {
+		case c < 0x80, c >= 0xa1 && c <= 0xdf:
+			// ASCII, or a half-width katakana
+		case (c >= 0x81 && c <= 0x9f) || (c >= 0xe0 && c <= 0xfc):
+			if k+1 == len(b) {
+				return false
+			}
+			k++
+			trail := b[k]
+			if trail < 0x40 || trail == 0x7f || trail > 0xfc {
+				return false
+			}
+			pairs++
+			if c <= 0x83 || trail > 0x7f {
+				likely++
+			}
+		default:
+			return false
+		}
+	}
+	return pairs > 0 && likely*2 > pairs
+}
+
+// metaText decodes the data of a text meta event; a nil codec leaves it alone
+func metaText(codec encoding.Encoding, data []byte) string {
+	if codec == nil {
+		return string(data)
+	}
+	if text, err := codec.NewDecoder().Bytes(data); err == nil {
+		return string(text)
+	}
+	return string(bytes.ToValidUTF8(data, []byte("�")))
+}
@@ -0,0 +1,27 @@
+package commands
+
+import "testing"
+
+func Test_detectTextEncoding(t *testing.T) {
+	tests := map[string]struct {
+		texts [][]byte
+		want  string
+	}{
+		"none":            {want: "utf-8"},
+		"ASCII":           {texts: [][]byte{[]byte("Piano"), []byte("Verse 1")}, want: "utf-8"},
+		"UTF-8":           {texts: [][]byte{[]byte("Crème brûlée"), []byte("さくら")}, want: "utf-8"},
+		"Shift-JIS":       {texts: [][]byte{[]byte("\x82\xb3\x82\xad\x82\xe7"), []byte("Piano")}, want: "shift-jis"},
+		"Shift-JIS kanji": {texts: [][]byte{[]byte("\x92\x98\x8d\xec\x8c\xa0")}, want: "shift-jis"},
+		"Latin-1":         {texts: [][]byte{[]byte("\xa9 Caf\xe9")}, want: "windows-1252"},
+		// è and û followed by ASCII letters are also valid Shift-JIS
+		"Latin-1 like Shift-JIS": {texts: [][]byte{[]byte("Cr\xe8me br\xfbl\xe9e")}, want: "windows-1252"},
+		"windows-1252 quotes":    {texts: [][]byte{[]byte("\x93Sakura\x94")}, want: "windows-1252"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := detectTextEncoding(tt.texts); got != tt.want {
+				t.Errorf("detectTextEncoding() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// exporter writes a file in some other format
+type exporter struct {
+	extension string
+	write     func(e *export, w io.Writer, data *smf.SMF) error
+}
+
+var exporters = map[string]exporter{
+	"abc":      {extension: ".abc", write: (*export).writeABC},
+	"csv":      {extension: ".csv", write: (*export).writeCSV},
+	"lilypond": {extension: ".ly", write: (*export).writeLilyPond},
+	"musicxml": {extension: ".musicxml", write: (*export).writeMusicXML},
+}
+
+// export converts files into other formats
+type export struct {
+	format   string
+	outFile  string
+	quantize int
+}
+
+func newExport() command {
+	return &export{}
+}
+
+func (e *export) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&e.format, "to", "", "format to export: "+strings.Join(exporterNames(), ", "))
+	flags.StringVarP(&e.outFile, "output", "o", "",
+		"file to write, or - for the console (default: the input file with the format's extension)")
+	flags.IntVar(&e.quantize, "quantize", 16, "shortest note value written by notation formats: 4, 8, 16, 32, or 64")
+}
+
+func (e *export) run(o output.Bus, args []string) int {
+	if _, found := exporters[e.format]; !found {
+		o.ErrorPrintf("The --to value %q is not valid: it must be one of %s.\n", e.format, strings.Join(exporterNames(), ", "))
+		return exitUserError
+	}
+	switch e.quantize {
+	case 4, 8, 16, 32, 64:
+	default:
+		o.ErrorPrintf("The --quantize value %d is not valid: it must be 4, 8, 16, 32, or 64.\n", e.quantize)
+		return exitUserError
+	}
+	if e.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return exitUserError
+	}
+	return processFiles(o, args, e.processFile)
+}
+
+func (e *export) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	x := exporters[e.format]
+	destination := e.outFile
+	if destination == "" {
+		destination = strings.TrimSuffix(path, filepath.Ext(path)) + x.extension
+	}
+	var content bytes.Buffer
+	if err := x.write(e, &content, data); err != nil {
+		o.ErrorPrintf("The file %q cannot be exported: %v.\n", path, err)
+		o.Log(output.Error, "cannot export file", map[string]any{"file": path, "format": e.format, "error": err})
+		return false
+	}
+	if destination == "-" {
+		_, _ = o.ConsoleWriter().Write(content.Bytes())
+		return true
+	}
+	if err := writeFile(destination, content.Bytes()); err != nil {
+		o.ErrorPrintf("The file %q cannot be written: %v.\n", destination, err)
+		o.Log(output.Error, "cannot write file", map[string]any{"file": destination, "error": err})
+		return false
+	}
+	o.ConsolePrintf("%s: exported to %s\n", path, destination)
+	return true
+}
+
+// divisions returns the number of divisions of a quarter note needed for the
+// shortest note value
+func (e *export) divisions() int64 {
+	return int64(max(e.quantize/4, 1))
+}
+
+func exporterNames() []string {
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_export_run(t *testing.T) {
+	tests := map[string]struct {
+		e    *export
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"unknown format": {
+			e:    &export{format: "pdf", quantize: 16},
+			args: []string{"a.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --to value \"pdf\" is not valid: it must be one of " + strings.Join(exporterNames(), ", ") + ".\n",
+			},
+		},
+		"bad quantize": {
+			e:    &export{format: "musicxml", quantize: 12},
+			args: []string{"a.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --quantize value 12 is not valid: it must be 4, 8, 16, 32, or 64.\n",
+			},
+		},
+		"output with several files": {
+			e:    &export{format: "musicxml", quantize: 16, outFile: "x"},
+			args: []string{"a.mid", "b.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --output flag may only be used with a single input file.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.e.run(o, tt.args); got != tt.want {
+				t.Errorf("export.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("export.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_export_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "song.mid")
+	var track smf.Track
+	track.Add(0, midi.NoteOn(0, 60, 70))
+	track.Add(480, midi.NoteOff(0, 60))
+	if err := makeTestSMF(track).WriteFile(input); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	o := output.NewRecorder()
+	e := &export{format: "musicxml", quantize: 16}
+	if !e.processFile(o, input) {
+		t.Fatalf("export.processFile() failed: %s", o.ErrorOutput())
+	}
+	destination := filepath.Join(dir, "song.musicxml")
+	if got, want := o.ConsoleOutput(), input+": exported to "+destination+"\n"; got != want {
+		t.Errorf("export.processFile() console = %q, want %q", got, want)
+	}
+	content, err := os.ReadFile(destination)
+	if err != nil {
+		t.Fatalf("cannot read exported file: %v", err)
+	}
+	if !strings.Contains(string(content), "<score-partwise") {
+		t.Errorf("export.processFile() did not write a MusicXML score")
+	}
+	o = output.NewRecorder()
+	e.outFile = "-"
+	if !e.processFile(o, input) {
+		t.Fatalf("export.processFile() to the console failed: %s", o.ErrorOutput())
+	}
+	if got := o.ConsoleOutput(); got != string(content) {
+		t.Errorf("export.processFile() to the console wrote %q, want %q", got, content)
+	}
+}
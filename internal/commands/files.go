@@ -0,0 +1,241 @@
+package commands
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/afero"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+var (
+	// fileSystem is where commands read and write files; tests may replace it
+	// with an in-memory file system
+	fileSystem = afero.NewOsFs()
+	// jobs is the number of files processed at once
+	jobs = runtime.NumCPU()
+	// midiExtensions are the extensions of the files found in directories by
+	// commands that read standard MIDI files
+	midiExtensions = []string{".mid", ".midi", ".kar", ".smf"}
+)
+
+func readSMF(o output.Bus, path string) (*smf.SMF, bool) {
+	f, err := fileSystem.Open(path)
+	if err == nil {
+		defer f.Close()
+		var data *smf.SMF
+		if data, err = smf.ReadFrom(f); err == nil {
+			return data, true
+		}
+	}
+	o.ErrorPrintf("The file %q cannot be read: %v.\n", path, err)
+	o.Log(output.Error, "cannot read file", map[string]any{"file": path, "error": err})
+	return nil, false
+}
+
+func writeSMF(o output.Bus, data *smf.SMF, path string) bool {
+	var content bytes.Buffer
+	_, err := data.WriteTo(&content)
+	if err == nil {
+		err = writeFile(path, content.Bytes())
+	}
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be written: %v.\n", path, err)
+		o.Log(output.Error, "cannot write file", map[string]any{"file": path, "error": err})
+		return false
+	}
+	return true
+}
+
+func readFile(path string) ([]byte, error) {
+	return afero.ReadFile(fileSystem, path)
+}
+
+func writeFile(path string, content []byte) error {
+	return afero.WriteFile(fileSystem, path, content, 0o644)
+}
+
+// namesSeveralFiles reports whether the arguments name more than one file, or
+// a directory or glob pattern that may yield more than one
+func namesSeveralFiles(paths []string) bool {
+	if len(paths) != 1 {
+		return len(paths) > 1
+	}
+	if isGlobPattern(paths[0]) {
+		return true
+	}
+	info, err := fileSystem.Stat(paths[0])
+	return err == nil && info.IsDir()
+}
+
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// expandPaths replaces each glob pattern among the paths with the paths it
+// matches, and each directory with the files within it, at any depth, having
+// one of the extensions; paths naming nothing are kept, for the caller to
+// report. It returns false if a pattern or a directory yields nothing.
+func expandPaths(o output.Bus, paths, extensions []string) ([]string, bool) {
+	var files []string
+	ok := true
+	for _, path := range paths {
+		candidates := []string{path}
+		if isGlobPattern(path) {
+			matches, err := afero.Glob(fileSystem, path)
+			if err != nil || len(matches) == 0 {
+				o.ErrorPrintf("The pattern %q matches no files.\n", path)
+				ok = false
+				continue
+			}
+			candidates = matches
+		}
+		for _, candidate := range candidates {
+			info, err := fileSystem.Stat(candidate)
+			if err != nil || !info.IsDir() {
+				files = append(files, candidate)
+				continue
+			}
+			found := 0
+			err = afero.Walk(fileSystem, candidate, func(p string, info fs.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && slices.Contains(extensions, strings.ToLower(filepath.Ext(p))) {
+					files = append(files, p)
+					found++
+				}
+				return nil
+			})
+			switch {
+			case err != nil:
+				o.ErrorPrintf("The directory %q cannot be read: %v.\n", candidate, err)
+				ok = false
+			case found == 0:
+				o.ErrorPrintf("The directory %q contains no %s files.\n", candidate, strings.Join(extensions, ", "))
+				ok = false
+			}
+		}
+	}
+	return files, ok
+}
+
+// processFiles applies fn to each named standard MIDI file, and returns
+// exitSystemError if any application fails
+func processFiles(o output.Bus, paths []string, fn func(output.Bus, string) bool) int {
+	return processFilesOfType(o, paths, midiExtensions, fn)
+}
+
+// processFilesOfType applies fn to each named file, finding the files with
+// one of the extensions in named directories, and returns exitSystemError if
+// any application fails
+func processFilesOfType(o output.Bus, paths, extensions []string, fn func(output.Bus, string) bool) int {
+	_, exitCode := mapFiles(o, paths, extensions, func(o output.Bus, path string) (struct{}, bool) {
+		return struct{}{}, fn(o, path)
+	})
+	return exitCode
+}
+
+// fileOutcome holds what processing one of several files wrote and returned
+type fileOutcome[T any] struct {
+	console bytes.Buffer
+	errors  bytes.Buffer
+	value   T
+	ok      bool
+	done    chan struct{}
+}
+
+// mapFiles applies fn to each named file, as many at once as jobs allows,
+// and returns the values for the files it succeeded on, in order; each file's
+// output is written in order too, and a summary follows the output for
+// several files. It returns exitSystemError if any application fails.
+func mapFiles[T any](o output.Bus, paths, extensions []string, fn func(output.Bus, string) (T, bool)) ([]T, int) {
+	if len(paths) == 0 {
+		o.ErrorPrintln("No files were specified.")
+		return nil, exitUserError
+	}
+	files, expanded := expandPaths(o, paths, extensions)
+	exitCode := exitSuccess
+	if !expanded {
+		exitCode = exitSystemError
+	}
+	var values []T
+	if len(files) == 1 {
+		if value, ok := fn(o, files[0]); ok {
+			values = append(values, value)
+		} else {
+			exitCode = exitSystemError
+		}
+		return values, exitCode
+	}
+	outcomes := make([]*fileOutcome[T], len(files))
+	for k := range outcomes {
+		outcomes[k] = &fileOutcome[T]{done: make(chan struct{})}
+	}
+	work := make(chan int)
+	go func() {
+		for k := range files {
+			work <- k
+		}
+		close(work)
+	}()
+	logger := &busLogger{bus: o}
+	for range min(max(jobs, 1), len(files)) {
+		go func() {
+			for k := range work {
+				outcome := outcomes[k]
+				fileBus := output.NewCustomBus(&outcome.console, &outcome.errors, logger)
+				outcome.value, outcome.ok = fn(fileBus, files[k])
+				close(outcome.done)
+			}
+		}()
+	}
+	failed, warned := 0, 0
+	for _, outcome := range outcomes {
+		<-outcome.done
+		o.ConsolePrintf("%s", outcome.console.String())
+		o.ErrorPrintf("%s", outcome.errors.String())
+		switch {
+		case !outcome.ok:
+			failed++
+			exitCode = exitSystemError
+		case outcome.errors.Len() > 0:
+			warned++
+			values = append(values, outcome.value)
+		default:
+			values = append(values, outcome.value)
+		}
+	}
+	if len(files) > 1 {
+		o.ErrorPrintf("%d files: %d ok, %d failed, %d with warnings.\n",
+			len(files), len(files)-failed-warned, failed, warned)
+	}
+	return values, exitCode
+}
+
+// busLogger hands the log entries of the buses used for several files at
+// once to the original bus, one at a time
+type busLogger struct {
+	lock sync.Mutex
+	bus  output.Bus
+}
+
+func (l *busLogger) log(level output.Level, msg string, fields map[string]any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.bus.Log(level, msg, fields)
+}
+
+func (l *busLogger) Trace(msg string, fields map[string]any)   { l.log(output.Trace, msg, fields) }
+func (l *busLogger) Debug(msg string, fields map[string]any)   { l.log(output.Debug, msg, fields) }
+func (l *busLogger) Info(msg string, fields map[string]any)    { l.log(output.Info, msg, fields) }
+func (l *busLogger) Warning(msg string, fields map[string]any) { l.log(output.Warning, msg, fields) }
+func (l *busLogger) Error(msg string, fields map[string]any)   { l.log(output.Error, msg, fields) }
+func (l *busLogger) Panic(msg string, fields map[string]any)   { l.log(output.Panic, msg, fields) }
+func (l *busLogger) Fatal(msg string, fields map[string]any)   { l.log(output.Fatal, msg, fields) }
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/afero"
+)
+
+// useMemoryFileSystem replaces the file system with an in-memory one holding
+// the files, for the duration of the test
+func useMemoryFileSystem(t *testing.T, files map[string][]byte) afero.Fs {
+	t.Helper()
+	memory := afero.NewMemMapFs()
+	for path, content := range files {
+		if err := afero.WriteFile(memory, path, content, 0o644); err != nil {
+			t.Fatalf("cannot create test file: %v", err)
+		}
+	}
+	saved := fileSystem
+	fileSystem = memory
+	t.Cleanup(func() { fileSystem = saved })
+	return memory
+}
+
+func Test_processFiles(t *testing.T) {
+	song := smfBytes(t, diffTestSMF(diffTestOptions{resolution: 480, velocity: 80, tempo: 120}))
+	useMemoryFileSystem(t, map[string][]byte{
+		"/library/a.mid":           song,
+		"/library/notes.txt":       []byte("not music"),
+		"/library/rock/b.MID":      song,
+		"/library/rock/c.midi":     song,
+		"/library/rock/broken.mid": []byte("MThd"),
+		"/library/empty/read.me":   []byte("nothing here"),
+		"/single.mid":              song,
+	})
+	// report prints each file's path, warning about b.MID
+	report := func(o output.Bus, path string) bool {
+		if !strings.HasSuffix(path, ".mid") && !strings.HasSuffix(path, ".MID") && !strings.HasSuffix(path, ".midi") {
+			o.ErrorPrintf("unexpected %s\n", path)
+			return false
+		}
+		if _, ok := readSMF(o, path); !ok {
+			return false
+		}
+		o.ConsolePrintf("%s\n", path)
+		if strings.HasSuffix(path, "b.MID") {
+			o.ErrorPrintf("%s: a warning\n", path)
+		}
+		return true
+	}
+	tests := map[string]struct {
+		paths []string
+		jobs  int
+		want  int
+		output.WantedRecording
+	}{
+		"no files": {
+			want:            exitUserError,
+			WantedRecording: output.WantedRecording{Error: "No files were specified.\n"},
+		},
+		"one file": {
+			paths:           []string{"/single.mid"},
+			jobs:            4,
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/single.mid\n"},
+		},
+		"directory, in order, with a summary": {
+			paths: []string{"/library"},
+			jobs:  3,
+			want:  exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Console: "/library/a.mid\n/library/rock/b.MID\n/library/rock/c.midi\n",
+				Error: "/library/rock/b.MID: a warning\n" +
+					"The file \"/library/rock/broken.mid\" cannot be read: EOF.\n" +
+					"4 files: 2 ok, 1 failed, 1 with warnings.\n",
+				Log: "level='error' error='EOF' file='/library/rock/broken.mid' msg='cannot read file'\n",
+			},
+		},
+		"one job at a time": {
+			paths: []string{"/single.mid", "/library/rock/c.midi"},
+			jobs:  1,
+			want:  exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/single.mid\n/library/rock/c.midi\n",
+				Error:   "2 files: 2 ok, 0 failed, 0 with warnings.\n",
+			},
+		},
+		"glob pattern": {
+			paths: []string{"/library/rock/?.*", "/library/*.mid"},
+			jobs:  2,
+			want:  exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/library/rock/b.MID\n/library/rock/c.midi\n/library/a.mid\n",
+				Error:   "/library/rock/b.MID: a warning\n3 files: 2 ok, 0 failed, 1 with warnings.\n",
+			},
+		},
+		"nothing found": {
+			paths: []string{"/library/empty", "/library/*.wav", "/single.mid"},
+			jobs:  2,
+			want:  exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Console: "/single.mid\n",
+				Error: "The directory \"/library/empty\" contains no .mid, .midi, .kar, .smf files.\n" +
+					"The pattern \"/library/*.wav\" matches no files.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			saved := jobs
+			jobs = tt.jobs
+			defer func() { jobs = saved }()
+			o := output.NewRecorder()
+			if got := processFiles(o, tt.paths, report); got != tt.want {
+				t.Errorf("processFiles() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("processFiles() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_namesSeveralFiles(t *testing.T) {
+	useMemoryFileSystem(t, map[string][]byte{"/songs/a.mid": nil})
+	tests := map[string]struct {
+		paths []string
+		want  bool
+	}{
+		"none":          {want: false},
+		"one file":      {paths: []string{"/songs/a.mid"}, want: false},
+		"missing file":  {paths: []string{"/songs/b.mid"}, want: false},
+		"directory":     {paths: []string{"/songs"}, want: true},
+		"glob pattern":  {paths: []string{"/songs/*.mid"}, want: true},
+		"several files": {paths: []string{"/songs/a.mid", "/songs/a.mid"}, want: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := namesSeveralFiles(tt.paths); got != tt.want {
+				t.Errorf("namesSeveralFiles() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mapFiles(t *testing.T) {
+	useMemoryFileSystem(t, map[string][]byte{
+		"/songs/1.mid": nil, "/songs/2.mid": nil, "/songs/3.mid": nil, "/songs/4.mid": nil, "/songs/5.mid": nil,
+	})
+	saved := jobs
+	jobs = 3
+	defer func() { jobs = saved }()
+	o := output.NewRecorder()
+	values, exitCode := mapFiles(o, []string{"/songs"}, midiExtensions, func(o output.Bus, path string) (string, bool) {
+		return strings.TrimPrefix(path, "/songs/"), path != "/songs/4.mid"
+	})
+	if exitCode != exitSystemError {
+		t.Errorf("mapFiles() exit code = %d, want %d", exitCode, exitSystemError)
+	}
+	if got, want := strings.Join(values, " "), "1.mid 2.mid 3.mid 5.mid"; got != want {
+		t.Errorf("mapFiles() = %q, want %q", got, want)
+	}
+	if got, want := o.ErrorOutput(), "5 files: 4 ok, 1 failed, 0 with warnings.\n"; got != want {
+		t.Errorf("mapFiles() error output = %q, want %q", got, want)
+	}
+}
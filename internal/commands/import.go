@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// importer converts the content of a file in some other format
+type importer func(i *importFiles, content []byte) (*smf.SMF, error)
+
+var importers = map[string]importer{
+	"abc": (*importFiles).readABC,
+	"csv": (*importFiles).readCSV,
+}
+
+// importFiles converts files in other formats into standard MIDI files
+type importFiles struct {
+	format  string
+	outFile string
+	tune    int
+}
+
+func newImport() command {
+	return &importFiles{}
+}
+
+func (i *importFiles) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&i.format, "from", "", "format to import: "+strings.Join(importerNames(), ", "))
+	flags.StringVarP(&i.outFile, "output", "o", "", "file to write (default: the input file with the .mid extension)")
+	flags.IntVar(&i.tune, "tune", 0, "reference number (X:) of the tune to import from ABC (default: the first)")
+}
+
+func (i *importFiles) run(o output.Bus, args []string) int {
+	if _, found := importers[i.format]; !found {
+		o.ErrorPrintf("The --from value %q is not valid: it must be one of %s.\n", i.format, strings.Join(importerNames(), ", "))
+		return exitUserError
+	}
+	if i.tune < 0 {
+		o.ErrorPrintf("The --tune value %d is not valid: it must not be negative.\n", i.tune)
+		return exitUserError
+	}
+	if i.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return exitUserError
+	}
+	return processFilesOfType(o, args, []string{"." + i.format}, i.processFile)
+}
+
+func (i *importFiles) processFile(o output.Bus, path string) bool {
+	content, err := readFile(path)
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be read: %v.\n", path, err)
+		o.Log(output.Error, "cannot read file", map[string]any{"file": path, "error": err})
+		return false
+	}
+	data, err := importers[i.format](i, content)
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be imported: %v.\n", path, err)
+		o.Log(output.Error, "cannot import file", map[string]any{"file": path, "format": i.format, "error": err})
+		return false
+	}
+	destination := i.outFile
+	if destination == "" {
+		destination = strings.TrimSuffix(path, filepath.Ext(path)) + ".mid"
+	}
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: imported to %s\n", path, destination)
+	return true
+}
+
+func importerNames() []string {
+	names := make([]string, 0, len(importers))
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
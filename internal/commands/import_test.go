@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_importFiles_run(t *testing.T) {
+	tests := map[string]struct {
+		i    *importFiles
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"unknown format": {
+			i:    &importFiles{format: "pdf"},
+			args: []string{"a.pdf"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --from value \"pdf\" is not valid: it must be one of " + strings.Join(importerNames(), ", ") + ".\n",
+			},
+		},
+		"negative tune": {
+			i:    &importFiles{format: "abc", tune: -1},
+			args: []string{"a.abc"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --tune value -1 is not valid: it must not be negative.\n",
+			},
+		},
+		"output with several files": {
+			i:    &importFiles{format: "abc", outFile: "x.mid"},
+			args: []string{"a.abc", "b.abc"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --output flag may only be used with a single input file.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.i.run(o, tt.args); got != tt.want {
+				t.Errorf("importFiles.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("importFiles.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_importFiles_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "tune.abc")
+	if err := os.WriteFile(input, []byte("X:1\nT:Scale\nK:C\nCDEF|\n"), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	o := output.NewRecorder()
+	i := &importFiles{format: "abc"}
+	if !i.processFile(o, input) {
+		t.Fatalf("importFiles.processFile() failed: %s", o.ErrorOutput())
+	}
+	destination := filepath.Join(dir, "tune.mid")
+	if got, want := o.ConsoleOutput(), input+": imported to "+destination+"\n"; got != want {
+		t.Errorf("importFiles.processFile() console = %q, want %q", got, want)
+	}
+	data, err := smf.ReadFile(destination)
+	if err != nil {
+		t.Fatalf("cannot read imported file: %v", err)
+	}
+	if got := len(collectNotes(data)); got != 4 {
+		t.Errorf("importFiles.processFile() wrote %d notes, want 4", got)
+	}
+	bad := filepath.Join(dir, "bad.abc")
+	if err := os.WriteFile(bad, []byte("X:1\nT:Nothing\n"), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	o = output.NewRecorder()
+	if i.processFile(o, bad) {
+		t.Fatalf("importFiles.processFile() of a tune without a key succeeded")
+	}
+	if got, want := o.ErrorOutput(), "The file \""+bad+"\" cannot be imported: the tune has no K: field.\n"; got != want {
+		t.Errorf("importFiles.processFile() error = %q, want %q", got, want)
+	}
+}
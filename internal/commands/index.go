@@ -0,0 +1,377 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// libraryIndexVersion changes whenever the index format does
+const libraryIndexVersion = 1
+
+// libraryIndex describes a library of files, so that they can be searched
+// without being read
+type libraryIndex struct {
+	Version int          `json:"version"`
+	Files   []indexEntry `json:"files"`
+}
+
+// indexEntry describes one file of the library
+type indexEntry struct {
+	File            string   `json:"file"`
+	Tracks          int      `json:"tracks"`
+	TrackNames      []string `json:"trackNames,omitempty"`
+	Instruments     []string `json:"instruments,omitempty"`
+	Keys            []string `json:"keys,omitempty"`
+	KeyEstimated    bool     `json:"keyEstimated,omitempty"`
+	Meters          []string `json:"meters"`
+	MinBPM          float64  `json:"minBPM"`
+	MaxBPM          float64  `json:"maxBPM"`
+	Bars            int      `json:"bars"`
+	DurationSeconds float64  `json:"durationSeconds"`
+	Notes           int      `json:"notes"`
+	Copyright       []string `json:"copyright,omitempty"`
+	Text            []string `json:"text,omitempty"`
+}
+
+// index builds a library index and searches it ("index build", "index
+// query")
+type index struct {
+	indexFile string
+}
+
+func newIndex() command {
+	return &index{}
+}
+
+func (ix *index) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&ix.indexFile, "index", "smf-index.json", "index file to build or query")
+}
+
+func (ix *index) run(o output.Bus, args []string) int {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "build":
+		return ix.build(o, args[1:])
+	case "query":
+		return ix.query(o, args[1:])
+	default:
+		o.ErrorPrintln("The index action must be specified; the actions supported are \"build\" and \"query\".")
+		return exitUserError
+	}
+}
+
+// build indexes the files, replacing the index file
+func (ix *index) build(o output.Bus, paths []string) int {
+	entries, exitCode := mapFiles(o, paths, midiExtensions, func(o output.Bus, path string) (indexEntry, bool) {
+		data, ok := readSMF(o, path)
+		if !ok {
+			return indexEntry{}, false
+		}
+		return newIndexEntry(path, data), true
+	})
+	if exitCode == exitUserError {
+		return exitCode
+	}
+	content, err := json.MarshalIndent(libraryIndex{Version: libraryIndexVersion, Files: entries}, "", "  ")
+	if err == nil {
+		err = writeFile(ix.indexFile, append(content, '\n'))
+	}
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be written: %v.\n", ix.indexFile, err)
+		o.Log(output.Error, "cannot write file", map[string]any{"file": ix.indexFile, "error": err})
+		return exitSystemError
+	}
+	o.ConsolePrintf("%s: indexed %d files\n", ix.indexFile, len(entries))
+	return exitCode
+}
+
+// query prints the path of each indexed file matching every term
+func (ix *index) query(o output.Bus, terms []string) int {
+	var conditions []indexCondition
+	for _, term := range splitIndexTerms(terms) {
+		c, err := parseIndexCondition(term)
+		if err != nil {
+			o.ErrorPrintf("The query term %q is not valid: %v.\n", term, err)
+			return exitUserError
+		}
+		conditions = append(conditions, c)
+	}
+	if len(conditions) == 0 {
+		o.ErrorPrintln("The query must have at least one term, such as \"key=DMaj\" or \"bpm<100\".")
+		return exitUserError
+	}
+	content, err := readFile(ix.indexFile)
+	if err != nil {
+		o.ErrorPrintf("The index %q cannot be read: %v; build it with \"smf-tool index build\".\n", ix.indexFile, err)
+		o.Log(output.Error, "cannot read file", map[string]any{"file": ix.indexFile, "error": err})
+		return exitSystemError
+	}
+	var library libraryIndex
+	if err = json.Unmarshal(content, &library); err != nil || library.Version != libraryIndexVersion {
+		if err == nil {
+			err = fmt.Errorf("it has version %d, not %d", library.Version, libraryIndexVersion)
+		}
+		o.ErrorPrintf("The index %q is not valid: %v; rebuild it with \"smf-tool index build\".\n", ix.indexFile, err)
+		return exitSystemError
+	}
+	found := 0
+	for _, entry := range library.Files {
+		if slices.IndexFunc(conditions, func(c indexCondition) bool { return !c.matches(entry) }) < 0 {
+			o.ConsolePrintln(entry.File)
+			found++
+		}
+	}
+	if found == 0 {
+		o.ErrorPrintf("No files of the %d indexed match.\n", len(library.Files))
+	}
+	return exitSuccess
+}
+
+// finiteOrZero returns the value, or 0 for the infinities and NaN, which the
+// index, in JSON, cannot hold
+func finiteOrZero(value float64) float64 {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0
+	}
+	return value
+}
+
+// newIndexEntry describes the file
+func newIndexEntry(path string, data *smf.SMF) indexEntry {
+	m := newMeter(data)
+	tempos := newTempoMap(data)
+	last := lastTick(data)
+	bars, _, _ := m.locate(last)
+	notes := collectNotes(data)
+	entry := indexEntry{
+		File:            path,
+		Tracks:          len(data.Tracks),
+		Bars:            bars,
+		DurationSeconds: finiteOrZero(round3(tempos.seconds(last))),
+		Notes:           len(notes),
+		MinBPM:          math.Inf(1),
+	}
+	for _, change := range tempos.changes {
+		// a tempo of 0 microseconds per quarter note is infinitely fast
+		if bpm := round3(change.bpm); finiteOrZero(bpm) == bpm {
+			entry.MinBPM = min(entry.MinBPM, bpm)
+			entry.MaxBPM = max(entry.MaxBPM, bpm)
+		}
+	}
+	entry.MinBPM = finiteOrZero(entry.MinBPM)
+	speller := &read{}
+	addOnce := func(list []string, s string) []string {
+		if s == "" || slices.Contains(list, s) {
+			return list
+		}
+		return append(list, s)
+	}
+	for _, track := range data.Tracks {
+		for _, event := range track {
+			message := event.Message
+			var text string
+			var channel, program, numerator, denominator uint8
+			switch {
+			case message.GetMetaTrackName(&text):
+				entry.TrackNames = addOnce(entry.TrackNames, text)
+			case message.GetProgramChange(&channel, &program):
+				entry.Instruments = addOnce(entry.Instruments, speller.asInstrument(channel, program))
+			case message.GetMetaMeter(&numerator, &denominator):
+				entry.Meters = addOnce(entry.Meters, fmt.Sprintf("%d/%d", numerator, denominator))
+			case message.GetMetaCopyright(&text):
+				entry.Copyright = addOnce(entry.Copyright, text)
+			case message.GetMetaText(&text), message.GetMetaMarker(&text), message.GetMetaCuepoint(&text):
+				entry.Text = addOnce(entry.Text, text)
+			}
+		}
+	}
+	if len(entry.Meters) == 0 {
+		// the standard's default
+		entry.Meters = []string{"4/4"}
+	}
+	for _, declared := range declaredKeys(data) {
+		entry.Keys = addOnce(entry.Keys, keyName(declared.key))
+	}
+	if len(entry.Keys) == 0 && len(notes) > 0 {
+		estimated := estimateKeys(pitchClassProfile(notes, tickRange{to: -1}))[0].asKey()
+		entry.Keys, entry.KeyEstimated = []string{keyName(estimated)}, true
+	}
+	return entry
+}
+
+// indexCondition is one term of a query, such as "bpm<100"
+type indexCondition struct {
+	field    string
+	operator string
+	text     string
+	number   float64
+	key      smf.Key
+}
+
+// indexTextFields maps the fields compared by text to the entry's values;
+// a term matches if any value contains the text, ignoring case
+var indexTextFields = map[string]func(e indexEntry) []string{
+	"file":       func(e indexEntry) []string { return []string{e.File} },
+	"track":      func(e indexEntry) []string { return e.TrackNames },
+	"instrument": func(e indexEntry) []string { return e.Instruments },
+	"meter":      func(e indexEntry) []string { return e.Meters },
+	"copyright":  func(e indexEntry) []string { return e.Copyright },
+	"text":       func(e indexEntry) []string { return e.Text },
+}
+
+// indexNumberFields maps the numeric fields to the range of the entry's
+// values
+var indexNumberFields = map[string]func(e indexEntry) (low, high float64){
+	"bpm":      func(e indexEntry) (float64, float64) { return e.MinBPM, e.MaxBPM },
+	"duration": func(e indexEntry) (float64, float64) { return e.DurationSeconds, e.DurationSeconds },
+	"bars":     func(e indexEntry) (float64, float64) { return float64(e.Bars), float64(e.Bars) },
+	"tracks":   func(e indexEntry) (float64, float64) { return float64(e.Tracks), float64(e.Tracks) },
+	"notes":    func(e indexEntry) (float64, float64) { return float64(e.Notes), float64(e.Notes) },
+}
+
+// splitIndexTerms splits the arguments into the terms of a query. An argument
+// may hold several terms, separated by spaces, but a term's value may have
+// spaces too (as in "instrument=Acoustic Grand Piano"): a word starts a new
+// term only if it begins with a name and an operator.
+func splitIndexTerms(args []string) []string {
+	var terms []string
+	for _, arg := range args {
+		var current []string
+		for _, word := range strings.Fields(arg) {
+			if len(current) > 0 && startsIndexTerm(word) {
+				terms = append(terms, strings.Join(current, " "))
+				current = nil
+			}
+			current = append(current, word)
+		}
+		if len(current) > 0 {
+			terms = append(terms, strings.Join(current, " "))
+		}
+	}
+	return terms
+}
+
+// startsIndexTerm reports whether the word begins with a name and an operator
+func startsIndexTerm(word string) bool {
+	at := strings.IndexAny(word, "=!<>")
+	return at > 0 && strings.IndexFunc(word[:at], func(r rune) bool { return !unicode.IsLetter(r) }) < 0
+}
+
+func parseIndexCondition(term string) (c indexCondition, err error) {
+	at := strings.IndexAny(term, "=!<>")
+	if at <= 0 {
+		return c, fmt.Errorf("it must be a field, an operator (=, !=, <, <=, >, >=), and a value")
+	}
+	c.field = strings.ToLower(term[:at])
+	rest := term[at:]
+	for _, operator := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(rest, operator) {
+			c.operator, c.text = operator, rest[len(operator):]
+			break
+		}
+	}
+	if unquoted, err := strconv.Unquote(c.text); err == nil {
+		c.text = unquoted
+	}
+	if c.operator == "" || c.text == "" {
+		return c, fmt.Errorf("it must be a field, an operator (=, !=, <, <=, >, >=), and a value")
+	}
+	ordered := c.operator != "=" && c.operator != "!="
+	switch _, isText := indexTextFields[c.field]; {
+	case c.field == "key":
+		if ordered {
+			return c, fmt.Errorf("keys can only be compared with = or !=")
+		}
+		c.key, err = parseKeyName(c.text)
+	case isText:
+		if ordered {
+			return c, fmt.Errorf("the field %q can only be compared with = or !=", c.field)
+		}
+	default:
+		if _, found := indexNumberFields[c.field]; !found {
+			return c, fmt.Errorf("the field %q is not known; the fields are %s", c.field,
+				joinSorted(append(append(fieldNames(indexTextFields), fieldNames(indexNumberFields)...), "key")))
+		}
+		if c.number, err = strconv.ParseFloat(c.text, 64); err != nil {
+			err = fmt.Errorf("%q is not a number", c.text)
+		}
+	}
+	return c, err
+}
+
+func (c indexCondition) matches(e indexEntry) bool {
+	switch values, isText := indexTextFields[c.field]; {
+	case c.field == "key":
+		found := slices.ContainsFunc(e.Keys, func(name string) bool {
+			k, err := parseKeyName(name)
+			return err == nil && k.Key == c.key.Key && k.IsMajor == c.key.IsMajor
+		})
+		return found == (c.operator == "=")
+	case isText:
+		found := slices.ContainsFunc(values(e), func(value string) bool {
+			return strings.Contains(strings.ToLower(value), strings.ToLower(c.text))
+		})
+		return found == (c.operator == "=")
+	}
+	low, high := indexNumberFields[c.field](e)
+	switch c.operator {
+	case "=":
+		return low <= c.number && c.number <= high
+	case "!=":
+		return low != c.number || high != c.number
+	case "<":
+		return low < c.number
+	case "<=":
+		return low <= c.number
+	case ">":
+		return high > c.number
+	default:
+		return high >= c.number
+	}
+}
+
+// parseKeyName parses a key such as DMaj, Bbmin, F#m, or E♭Minor (as keyName
+// writes it); a tonic alone is major. Only the tonic (as a pitch class) and
+// the mode are set.
+func parseKeyName(s string) (smf.Key, error) {
+	var k smf.Key
+	if s == "" {
+		return k, fmt.Errorf("an empty string is not a key")
+	}
+	class, found := pitchClasses[strings.ToUpper(s[:1])[0]]
+	if !found {
+		return k, fmt.Errorf("%q is not a key", s)
+	}
+	rest := s[1:]
+	for _, accidental := range []struct {
+		symbol string
+		alter  int
+	}{{"#", 1}, {"♯", 1}, {"b", -1}, {"♭", -1}} {
+		if strings.HasPrefix(rest, accidental.symbol) {
+			class += accidental.alter
+			rest = rest[len(accidental.symbol):]
+			break
+		}
+	}
+	switch strings.ToLower(rest) {
+	case "", "maj", "major":
+		k.IsMajor = true
+	case "m", "min", "minor":
+	default:
+		return k, fmt.Errorf("%q is not a key", s)
+	}
+	k.Key = uint8((class + 12) % 12)
+	return k, nil
+}
@@ -0,0 +1,230 @@
+package commands
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/afero"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// indexTestSMF builds a file in the key, with a violin or a cello playing
+// at the tempo
+func indexTestSMF(key smf.Message, program uint8, bpm float64, text string) *smf.SMF {
+	var conductor, part smf.Track
+	conductor.Add(0, smf.MetaMeter(3, 4))
+	conductor.Add(0, smf.MetaTempo(bpm))
+	if key != nil {
+		conductor.Add(0, key)
+	}
+	conductor.Add(0, smf.MetaCopyright("(c) 2024 Example"))
+	conductor.Add(0, smf.MetaText(text))
+	part.Add(0, smf.MetaTrackSequenceName("Strings"))
+	part.Add(0, midi.ProgramChange(0, program))
+	part.Add(0, midi.NoteOn(0, 62, 80))
+	part.Add(1440, midi.NoteOff(0, 62))
+	part.Add(0, midi.NoteOn(0, 66, 80))
+	part.Add(1440, midi.NoteOff(0, 66))
+	return makeTestSMF(conductor, part)
+}
+
+func Test_newIndexEntry(t *testing.T) {
+	got := newIndexEntry("a.mid", indexTestSMF(smf.DMaj(), 40, 90, "Allegro"))
+	want := indexEntry{
+		File:            "a.mid",
+		Tracks:          2,
+		TrackNames:      []string{"Strings"},
+		Instruments:     []string{"Violin"},
+		Keys:            []string{"DMajor"},
+		Meters:          []string{"3/4"},
+		MinBPM:          90,
+		MaxBPM:          90,
+		Bars:            3,
+		DurationSeconds: 4,
+		Notes:           2,
+		Copyright:       []string{"(c) 2024 Example"},
+		Text:            []string{"Allegro"},
+	}
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("newIndexEntry() = %s, want %s", gotJSON, wantJSON)
+	}
+	if estimated := newIndexEntry("b.mid", indexTestSMF(nil, 42, 120, "")); !estimated.KeyEstimated ||
+		len(estimated.Keys) != 1 {
+		t.Errorf("newIndexEntry() without a key signature = %v, want one estimated key", estimated.Keys)
+	}
+}
+
+func Test_newIndexEntry_nonFinite(t *testing.T) {
+	var conductor smf.Track
+	// zero microseconds per quarter note
+	conductor.Add(0, smf.Message([]byte{0xFF, 0x51, 3, 0, 0, 0}))
+	var melody smf.Track
+	melody.Add(0, midi.NoteOn(0, 60, 80))
+	melody.Add(100, midi.NoteOff(0, 60))
+	tests := map[string]struct {
+		timeFormat smf.TimeFormat
+		tracks     []smf.Track
+	}{
+		"infinite tempo": {timeFormat: smf.MetricTicks(480), tracks: []smf.Track{conductor, melody}},
+		"no subframes":   {timeFormat: smf.TimeCode{FramesPerSecond: 25}, tracks: []smf.Track{melody}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := makeTestSMF(tt.tracks...)
+			data.TimeFormat = tt.timeFormat
+			entry := newIndexEntry("a.mid", data)
+			if _, err := json.Marshal(entry); err != nil {
+				t.Errorf("newIndexEntry() cannot be written: %v", err)
+			}
+		})
+	}
+}
+
+func Test_index_run(t *testing.T) {
+	memory := useMemoryFileSystem(t, map[string][]byte{
+		"/library/violin.mid":      smfBytes(t, indexTestSMF(smf.DMaj(), 40, 90, "Allegro")),
+		"/library/cello/slow.mid":  smfBytes(t, indexTestSMF(smf.BMin(), 42, 60, "Largo")),
+		"/library/cello/quick.mid": smfBytes(t, indexTestSMF(smf.DMaj(), 42, 132, "Presto")),
+		"/library/piano.mid":       smfBytes(t, indexTestSMF(smf.CMaj(), 0, 100, "Moderato")),
+	})
+	o := output.NewRecorder()
+	if got := (&index{indexFile: "/library.json"}).run(o, []string{"build", "/library"}); got != exitSuccess {
+		t.Fatalf("index.run(build) = %d: %s", got, o.ErrorOutput())
+	}
+	if got, want := o.ConsoleOutput(), "/library.json: indexed 4 files\n"; got != want {
+		t.Errorf("index.run(build) console output = %q, want %q", got, want)
+	}
+	tests := map[string]struct {
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"key and instrument": {
+			args:            []string{"query", "key=DMaj instrument=Violin"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/library/violin.mid\n"},
+		},
+		"enharmonic key and tempo": {
+			args:            []string{"query", "key=Cbm", "bpm<100"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/library/cello/slow.mid\n"},
+		},
+		"text ignores case": {
+			args:            []string{"query", "text=presto"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/library/cello/quick.mid\n"},
+		},
+		"negation and ranges": {
+			args: []string{"query", "instrument!=violin bpm>=60 duration<=6 meter=3/4 track=strings"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/library/cello/quick.mid\n/library/cello/slow.mid\n/library/piano.mid\n",
+			},
+		},
+		"instrument with spaces": {
+			args:            []string{"query", "instrument=Acoustic Grand Piano"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/library/piano.mid\n"},
+		},
+		"quoted value and another term": {
+			args:            []string{"query", "instrument=\"acoustic grand\" bpm>=100"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/library/piano.mid\n"},
+		},
+		"no matches": {
+			args:            []string{"query", "key=F#Maj"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Error: "No files of the 4 indexed match.\n"},
+		},
+		"bad term": {
+			args: []string{"query", "tempo<100"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The query term \"tempo<100\" is not valid: the field \"tempo\" is not known; the fields are " +
+					"bars, bpm, copyright, duration, file, instrument, key, meter, notes, text, track, tracks.\n",
+			},
+		},
+		"bad key": {
+			args: []string{"query", "key=H"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The query term \"key=H\" is not valid: \"H\" is not a key.\n",
+			},
+		},
+		"ordered text": {
+			args: []string{"query", "instrument>Violin"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The query term \"instrument>Violin\" is not valid: the field \"instrument\" can only be " +
+					"compared with = or !=.\n",
+			},
+		},
+		"no terms": {
+			args: []string{"query"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The query must have at least one term, such as \"key=DMaj\" or \"bpm<100\".\n",
+			},
+		},
+		"no action": {
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The index action must be specified; the actions supported are \"build\" and \"query\".\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := (&index{indexFile: "/library.json"}).run(o, tt.args); got != tt.want {
+				t.Errorf("index.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("index.run() %s", issue)
+				}
+			}
+		})
+	}
+	_ = afero.WriteFile(memory, "/old.json", []byte(`{"version": 0, "files": []}`), 0o644)
+	o = output.NewRecorder()
+	if got := (&index{indexFile: "/old.json"}).run(o, []string{"query", "bpm<100"}); got != exitSystemError {
+		t.Errorf("index.run() with an old index = %d, want %d", got, exitSystemError)
+	}
+	if got, want := o.ErrorOutput(), "The index \"/old.json\" is not valid: it has version 0, not 1; rebuild it "+
+		"with \"smf-tool index build\".\n"; got != want {
+		t.Errorf("index.run() with an old index error output = %q, want %q", got, want)
+	}
+}
+
+func Test_parseKeyName(t *testing.T) {
+	tests := map[string]struct {
+		want    smf.Key
+		wantErr bool
+	}{
+		"DMaj":     {want: smf.Key{Key: 2, IsMajor: true}},
+		"D":        {want: smf.Key{Key: 2, IsMajor: true}},
+		"Bbmin":    {want: smf.Key{Key: 10}},
+		"F#m":      {want: smf.Key{Key: 6}},
+		"E♭Minor":  {want: smf.Key{Key: 3}},
+		"C♯Major":  {want: smf.Key{Key: 1, IsMajor: true}},
+		"Cb":       {want: smf.Key{Key: 11, IsMajor: true}},
+		"G dorian": {wantErr: true},
+		"":         {wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseKeyName(name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyName() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseKeyName() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+var (
+	// Krumhansl-Kessler key profiles, indexed by semitones above the tonic
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+	// number of sharps (positive) or flats (negative) in each major key, indexed
+	// by tonic
+	majorAccidentals = [12]int{0, -5, 2, -3, 4, -1, 6, 1, -4, 3, -2, 5}
+)
+
+// keyEstimator estimates the key of a file from its notes, using the
+// Krumhansl-Schmuckler algorithm: the duration-weighted pitch class profile of
+// the notes is correlated with the profile of each of the 24 major and minor
+// keys, and the best correlated keys are the likeliest
+type keyEstimator struct {
+	window  int
+	step    int
+	top     int
+	insert  bool
+	outFile string
+}
+
+// keyEstimate is a candidate key and its correlation with the notes
+type keyEstimate struct {
+	tonic       uint8
+	isMajor     bool
+	correlation float64
+}
+
+// declaredKey is a key signature and the tick at which it takes effect
+type declaredKey struct {
+	tick int64
+	key  smf.Key
+}
+
+func newKeyEstimator() command {
+	return &keyEstimator{}
+}
+
+func (ke *keyEstimator) defineFlags(flags *pflag.FlagSet) {
+	flags.IntVar(&ke.window, "window", 4, "length in bars of the windows to estimate separately (0 for none)")
+	flags.IntVar(&ke.step, "step", 0, "bars between the starts of successive windows (default: the window length)")
+	flags.IntVar(&ke.top, "top", 3, "number of candidate keys to report")
+	flags.BoolVar(&ke.insert, "insert", false, "write the estimated key into the file as its initial key signature")
+	flags.StringVarP(&ke.outFile, "output", "o", "", "file to write when inserting (default: overwrite the input file)")
+}
+
+func (ke *keyEstimator) run(o output.Bus, args []string) int {
+	if ke.window < 0 || ke.step < 0 || ke.top < 1 {
+		o.ErrorPrintln("The --window and --step values must not be negative, and the --top value must be positive.")
+		return exitUserError
+	}
+	if ke.step == 0 {
+		ke.step = ke.window
+	}
+	if ke.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return exitUserError
+	}
+	return processFiles(o, args, ke.processFile)
+}
+
+func (ke *keyEstimator) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	o.ConsolePrintf("%s:\n", path)
+	notes := collectNotes(data)
+	declared := declaredKeys(data)
+	if len(declared) == 0 {
+		o.ConsolePrintln("  declared: none")
+	} else {
+		m := newMeter(data)
+		for _, d := range declared {
+			o.ConsolePrintf("  declared: %s at %s\n", keyName(d.key), m.describe(d.tick))
+		}
+	}
+	profile := pitchClassProfile(notes, tickRange{to: -1})
+	if profile == [12]float64{} {
+		o.ConsolePrintln("  estimated: none; the file has no notes")
+		if ke.insert {
+			o.ErrorPrintf("The file %q has no notes from which to estimate a key.\n", path)
+			return false
+		}
+		return true
+	}
+	overall := estimateKeys(profile)
+	o.ConsolePrintf("  estimated: %s\n", describeEstimates(overall, ke.top))
+	if ke.window > 0 {
+		ke.reportWindows(o, data, notes, declared)
+	}
+	if !ke.insert {
+		return true
+	}
+	insertKey(data, overall[0].asKey())
+	destination := path
+	if ke.outFile != "" {
+		destination = ke.outFile
+	}
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("  inserted %s into %s\n", keyName(overall[0].asKey()), destination)
+	return true
+}
+
+func (ke *keyEstimator) reportWindows(o output.Bus, data *smf.SMF, notes []note, declared []declaredKey) {
+	m := newMeter(data)
+	end := lastTick(data)
+	for bar := 1; m.barStart(bar) < end; bar += ke.step {
+		span := tickRange{from: m.barStart(bar), to: m.barStart(bar + ke.window)}
+		profile := pitchClassProfile(notes, span)
+		if profile == [12]float64{} {
+			continue
+		}
+		estimates := estimateKeys(profile)
+		o.ConsolePrintf("  bars %d-%d: %s", bar, bar+ke.window-1, describeEstimates(estimates, ke.top))
+		if d, found := keyAt(declared, span.from); found {
+			if matches(estimates[0], d) {
+				o.ConsolePrintf("; matches declared %s", keyName(d))
+			} else {
+				o.ConsolePrintf("; declared %s", keyName(d))
+			}
+		}
+		o.ConsolePrintln("")
+	}
+}
+
+// pitchClassProfile sums the durations, clipped to the span, of the
+// non-percussion notes of each pitch class
+func pitchClassProfile(notes []note, span tickRange) [12]float64 {
+	var profile [12]float64
+	for _, n := range notes {
+		if n.channel == 9 {
+			continue
+		}
+		start := max(n.start, span.from)
+		end := n.end
+		if span.to >= 0 {
+			end = min(end, span.to)
+		}
+		if end > start {
+			profile[n.pitch%12] += float64(end - start)
+		}
+	}
+	return profile
+}
+
+// estimateKeys returns all 24 keys, ordered from most to least likely
+func estimateKeys(profile [12]float64) []keyEstimate {
+	estimates := make([]keyEstimate, 0, 24)
+	for tonic := uint8(0); tonic < 12; tonic++ {
+		var rotated [12]float64
+		for k := range rotated {
+			rotated[k] = profile[(int(tonic)+k)%12]
+		}
+		estimates = append(estimates,
+			keyEstimate{tonic: tonic, isMajor: true, correlation: correlate(rotated, majorProfile)},
+			keyEstimate{tonic: tonic, isMajor: false, correlation: correlate(rotated, minorProfile)},
+		)
+	}
+	sort.SliceStable(estimates, func(i, j int) bool { return estimates[i].correlation > estimates[j].correlation })
+	return estimates
+}
+
+// correlate returns the Pearson correlation coefficient of the two profiles;
+// it is 0 if either profile is flat
+func correlate(a, b [12]float64) float64 {
+	var meanA, meanB float64
+	for k := range a {
+		meanA += a[k] / 12
+		meanB += b[k] / 12
+	}
+	var products, squaresA, squaresB float64
+	for k := range a {
+		products += (a[k] - meanA) * (b[k] - meanB)
+		squaresA += (a[k] - meanA) * (a[k] - meanA)
+		squaresB += (b[k] - meanB) * (b[k] - meanB)
+	}
+	if squaresA == 0 || squaresB == 0 {
+		return 0
+	}
+	return products / math.Sqrt(squaresA*squaresB)
+}
+
+func describeEstimates(estimates []keyEstimate, top int) string {
+	s := ""
+	for k, e := range estimates[:min(top, len(estimates))] {
+		if k > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s (%.3f)", keyName(e.asKey()), e.correlation)
+	}
+	return s
+}
+
+// asKey converts the estimate into a key signature
+func (e keyEstimate) asKey() smf.Key {
+	relativeMajor := e.tonic
+	if !e.isMajor {
+		relativeMajor = (e.tonic + 3) % 12
+	}
+	accidentals := majorAccidentals[relativeMajor]
+	k := smf.Key{Key: e.tonic, IsMajor: e.isMajor, IsFlat: accidentals < 0}
+	if accidentals < 0 {
+		accidentals = -accidentals
+	}
+	k.Num = uint8(accidentals)
+	return k
+}
+
+func matches(e keyEstimate, k smf.Key) bool {
+	return e.tonic == k.Key && e.isMajor == k.IsMajor
+}
+
+// keyName names the key the way the reader names key signatures, e.g.,
+// "F♯Minor"
+func keyName(k smf.Key) string {
+	modifier := "Minor"
+	if k.IsMajor {
+		modifier = "Major"
+	}
+	return spellPitchClass(k, k.Key) + modifier
+}
+
+// declaredKeys returns the file's key signatures in order of occurrence
+func declaredKeys(data *smf.SMF) []declaredKey {
+	var declared []declaredKey
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var key smf.Key
+			if event.Message.GetMetaKey(&key) {
+				declared = append(declared, declaredKey{tick: ticks[k], key: key})
+			}
+		}
+	}
+	sort.SliceStable(declared, func(i, j int) bool { return declared[i].tick < declared[j].tick })
+	return declared
+}
+
+// keyAt returns the key signature in effect at the tick
+func keyAt(declared []declaredKey, tick int64) (k smf.Key, found bool) {
+	for _, d := range declared {
+		if d.tick > tick {
+			break
+		}
+		k = d.key
+		found = true
+	}
+	return
+}
+
+// keyTimeline returns the declared key signatures, or, if there are none, the
+// estimated key of the whole file
+func keyTimeline(data *smf.SMF, notes []note) []declaredKey {
+	if declared := declaredKeys(data); len(declared) > 0 {
+		if declared[0].tick > 0 {
+			declared = append([]declaredKey{{tick: 0, key: declared[0].key}}, declared...)
+		}
+		return declared
+	}
+	k := smf.Key{IsMajor: true}
+	if len(notes) > 0 {
+		k = estimateKeys(pitchClassProfile(notes, tickRange{to: -1}))[0].asKey()
+	}
+	return []declaredKey{{tick: 0, key: k}}
+}
+
+// spellingKey returns the key in which the reader spells the file's notes:
+// its first key signature, or, if it has none, the key estimated from its notes
+func spellingKey(data *smf.SMF, notes []note) smf.Key {
+	return keyTimeline(data, notes)[0].key
+}
+
+// insertKey makes the key the file's initial key signature, replacing any key
+// signatures at the start of the file
+func insertKey(data *smf.SMF, k smf.Key) {
+	message := smf.MetaKey(k.Key, k.IsMajor, k.Num, k.IsFlat)
+	replaced := false
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for j, event := range track {
+			if ticks[j] == 0 && event.Message.Is(smf.MetaKeySigMsg) {
+				track[j].Message = message
+				replaced = true
+			}
+		}
+	}
+	if !replaced && len(data.Tracks) > 0 {
+		data.Tracks[0] = append(smf.Track{{Delta: 0, Message: message}}, data.Tracks[0]...)
+	}
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// makeScaleTrack plays the pitches as consecutive quarter notes on channel 0,
+// with the first pitch held twice as long as the others
+func makeScaleTrack(pitches ...uint8) smf.Track {
+	var track smf.Track
+	for k, pitch := range pitches {
+		track.Add(0, midi.NoteOn(0, pitch, 80))
+		length := uint32(480)
+		if k == 0 {
+			length = 960
+		}
+		track.Add(length, midi.NoteOff(0, pitch))
+	}
+	return track
+}
+
+func Test_estimateKeys(t *testing.T) {
+	tests := map[string]struct {
+		pitches []uint8
+		want    string
+	}{
+		"C major":  {pitches: []uint8{60, 62, 64, 65, 67, 69, 71, 72, 67, 64, 60}, want: "CMajor"},
+		"A minor":  {pitches: []uint8{69, 71, 72, 74, 76, 77, 80, 81, 76, 72, 69}, want: "AMinor"},
+		"E minor":  {pitches: []uint8{64, 66, 67, 69, 71, 72, 75, 76, 71, 67, 64}, want: "EMinor"},
+		"B♭ major": {pitches: []uint8{70, 72, 74, 75, 77, 79, 81, 82, 77, 74, 70}, want: "B♭Major"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := makeTestSMF(makeScaleTrack(tt.pitches...))
+			estimates := estimateKeys(pitchClassProfile(collectNotes(data), tickRange{to: -1}))
+			if got := keyName(estimates[0].asKey()); got != tt.want {
+				t.Errorf("estimateKeys() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyEstimate_asKey(t *testing.T) {
+	tests := map[string]struct {
+		e    keyEstimate
+		want smf.Key
+	}{
+		"C major":  {e: keyEstimate{tonic: 0, isMajor: true}, want: smf.Key{Key: 0, IsMajor: true}},
+		"D major":  {e: keyEstimate{tonic: 2, isMajor: true}, want: smf.Key{Key: 2, IsMajor: true, Num: 2}},
+		"E♭ major": {e: keyEstimate{tonic: 3, isMajor: true}, want: smf.Key{Key: 3, IsMajor: true, Num: 3, IsFlat: true}},
+		"F♯ minor": {e: keyEstimate{tonic: 6}, want: smf.Key{Key: 6, Num: 3}},
+		"G minor":  {e: keyEstimate{tonic: 7}, want: smf.Key{Key: 7, Num: 2, IsFlat: true}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.e.asKey(); got != tt.want {
+				t.Errorf("keyEstimate.asKey() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_correlate(t *testing.T) {
+	if got := correlate(majorProfile, majorProfile); got < 0.999999 {
+		t.Errorf("correlate() of identical profiles = %g, want 1", got)
+	}
+	if got := correlate([12]float64{}, majorProfile); got != 0 {
+		t.Errorf("correlate() of a flat profile = %g, want 0", got)
+	}
+}
+
+func Test_insertKey(t *testing.T) {
+	withKey := func() *smf.SMF {
+		var track smf.Track
+		track.Add(0, smf.MetaKey(0, true, 0, false))
+		return makeTestSMF(track)
+	}
+	tests := map[string]struct {
+		data      *smf.SMF
+		wantCount int
+	}{
+		"replace": {data: withKey(), wantCount: 1},
+		"insert":  {data: makeTestSMF(smf.Track{}), wantCount: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			insertKey(tt.data, smf.Key{Key: 2, IsMajor: true, Num: 2})
+			declared := declaredKeys(tt.data)
+			if len(declared) != tt.wantCount {
+				t.Fatalf("insertKey() left %d key signatures, want %d", len(declared), tt.wantCount)
+			}
+			if got := keyName(declared[0].key); got != "DMajor" {
+				t.Errorf("insertKey() key = %s, want DMajor", got)
+			}
+		})
+	}
+}
+
+func Test_keyEstimator_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.mid")
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaKey(0, true, 0, false))
+	melody := makeScaleTrack(67, 69, 71, 72, 74, 76, 78, 79, 74, 71, 67, 67, 67, 67, 67, 67)
+	if err := makeTestSMF(conductor, melody).WriteFile(input); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	output1 := filepath.Join(dir, "out.mid")
+	ke := &keyEstimator{window: 2, step: 2, top: 1, insert: true, outFile: output1}
+	o := output.NewRecorder()
+	if !ke.processFile(o, input) {
+		t.Fatalf("keyEstimator.processFile() failed: %s", o.ErrorOutput())
+	}
+	want := input + ":\n" +
+		"  declared: CMajor at bar 1 beat 1\n" +
+		"  estimated: GMajor (0.859)\n" +
+		"  bars 1-2: GMajor (0.901); declared CMajor\n" +
+		"  bars 3-4: GMajor (0.792); declared CMajor\n" +
+		"  bars 5-6: GMajor (0.684); declared CMajor\n" +
+		"  inserted GMajor into " + output1 + "\n"
+	if got := o.ConsoleOutput(); got != want {
+		t.Errorf("keyEstimator.processFile() console = %q, want %q", got, want)
+	}
+	data, err := smf.ReadFile(output1)
+	if err != nil {
+		t.Fatalf("cannot read output file: %v", err)
+	}
+	if got := keyName(firstKey(data)); got != "GMajor" {
+		t.Errorf("keyEstimator.processFile() wrote key %s, want GMajor", got)
+	}
+}
+
+func Test_keyEstimator_processFile_noNotes(t *testing.T) {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaTempo(100))
+	useMemoryFileSystem(t, map[string][]byte{"/empty.mid": smfBytes(t, makeTestSMF(conductor))})
+	tests := map[string]struct {
+		ke   *keyEstimator
+		want bool
+		output.WantedRecording
+	}{
+		"estimate": {
+			ke:              &keyEstimator{window: 4, step: 4, top: 3},
+			want:            true,
+			WantedRecording: output.WantedRecording{Console: "/empty.mid:\n  declared: none\n  estimated: none; the file has no notes\n"},
+		},
+		"insert": {
+			ke:   &keyEstimator{top: 1, insert: true},
+			want: false,
+			WantedRecording: output.WantedRecording{
+				Console: "/empty.mid:\n  declared: none\n  estimated: none; the file has no notes\n",
+				Error:   "The file \"/empty.mid\" has no notes from which to estimate a key.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.ke.processFile(o, "/empty.mid"); got != tt.want {
+				t.Errorf("keyEstimator.processFile() = %t, want %t", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("keyEstimator.processFile() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_read_interpretSMFFile_estimatedKey(t *testing.T) {
+	data := makeTestSMF(makeScaleTrack(64, 66, 67, 69, 71, 72, 75, 76, 71, 67, 64))
+	r := &read{key: &smf.Key{IsMajor: true, IsFlat: true}}
+	r.interpretSMFFile(output.NewRecorder(), data)
+	if got := r.asNote(0, 75); got != "D♯6" {
+		t.Errorf("read.interpretSMFFile() spells 75 as %q, want \"D♯6\"", got)
+	}
+}
@@ -0,0 +1,312 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// lilyPondDrums holds the LilyPond drum mode names of the General MIDI drums
+var lilyPondDrums = map[uint8]string{
+	35: "acousticbassdrum",
+	36: "bassdrum",
+	37: "sidestick",
+	38: "acousticsnare",
+	39: "handclap",
+	40: "electricsnare",
+	41: "lowfloortom",
+	42: "closedhihat",
+	43: "highfloortom",
+	44: "pedalhihat",
+	45: "lowtom",
+	46: "openhihat",
+	47: "lowmidtom",
+	48: "himidtom",
+	49: "crashcymbala",
+	50: "hightom",
+	51: "ridecymbala",
+	52: "chinesecymbal",
+	53: "ridebell",
+	54: "tambourine",
+	55: "splashcymbal",
+	56: "cowbell",
+	57: "crashcymbalb",
+	58: "vibraslap",
+	59: "ridecymbalb",
+	60: "hibongo",
+	61: "lobongo",
+	62: "mutehiconga",
+	63: "openhiconga",
+	64: "loconga",
+	65: "hitimbale",
+	66: "lotimbale",
+	67: "hiagogo",
+	68: "loagogo",
+	69: "cabasa",
+	70: "maracas",
+	71: "shortwhistle",
+	72: "longwhistle",
+	73: "shortguiro",
+	74: "longguiro",
+	75: "claves",
+	76: "hiwoodblock",
+	77: "lowoodblock",
+	78: "mutecuica",
+	79: "opencuica",
+	80: "mutetriangle",
+	81: "opentriangle",
+}
+
+// lyric is a lyric event and its absolute tick
+type lyric struct {
+	tick int64
+	text string
+}
+
+// writeLilyPond writes the file as LilyPond source: a staff per channel of
+// each track, named for the track, a drum staff for channel 9, and the lyrics
+// under the melody
+func (e *export) writeLilyPond(w io.Writer, data *smf.SMF) error {
+	s := newScore(data, e.divisions())
+	parts := s.parts
+	if len(parts) == 0 {
+		p := scorePart{instrument: "Silence"}
+		for range s.measures {
+			p.measures = append(p.measures, []scoreVoice{{number: 1, events: []scoreEvent{{wholeMeasure: true}}}})
+		}
+		parts = []scorePart{p}
+	}
+	lyrics, lyricTrack := collectLyrics(data)
+	melody := -1
+	for k, p := range parts {
+		if !p.percussion && (melody < 0 || p.track == lyricTrack && parts[melody].track != lyricTrack) {
+			melody = k
+		}
+	}
+	var b strings.Builder
+	b.WriteString("\\version \"2.24.0\"\n\n\\score {\n  <<\n")
+	for k, p := range parts {
+		s.writeLilyPondStaff(&b, p, k == 0)
+		if k == melody && len(lyrics) > 0 {
+			fmt.Fprintf(&b, "    \\addlyrics { %s }\n", s.lilyPondLyrics(p, lyrics))
+		}
+	}
+	b.WriteString("  >>\n  \\layout { }\n  \\midi { }\n}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (s *score) writeLilyPondStaff(b *strings.Builder, p scorePart, withTempos bool) {
+	name := p.name
+	if name == "" {
+		name = p.instrument
+	}
+	if p.percussion {
+		fmt.Fprintf(b, "    \\new DrumStaff \\with { instrumentName = %s } \\drummode {\n", lilyPondString(name))
+	} else {
+		fmt.Fprintf(b, "    \\new Staff \\with { instrumentName = %s } {\n", lilyPondString(name))
+		clef := "treble"
+		if p.bassClef {
+			clef = "bass"
+		}
+		fmt.Fprintf(b, "      \\clef %s\n", clef)
+	}
+	for k, measure := range s.measures {
+		var items []string
+		if !p.percussion && (k == 0 || measure.key != s.measures[k-1].key) {
+			items = append(items, lilyPondKey(measure.key))
+		}
+		if k == 0 || measure.numerator != s.measures[k-1].numerator || measure.denominator != s.measures[k-1].denominator {
+			items = append(items, fmt.Sprintf("\\time %d/%d", measure.numerator, measure.denominator))
+		}
+		var tempos []scoreTempo
+		if withTempos {
+			tempos = measure.tempos
+		}
+		voices := p.measures[k]
+		if len(voices) == 1 {
+			items = append(items, s.lilyPondVoice(measure, voices[0], p.percussion, tempos))
+		} else {
+			var lines []string
+			for v, voice := range voices {
+				if v > 0 {
+					tempos = nil
+				}
+				lines = append(lines, "{ "+s.lilyPondVoice(measure, voice, p.percussion, tempos)+" }")
+			}
+			items = append(items, "<< "+strings.Join(lines, " \\\\ ")+" >>")
+		}
+		fmt.Fprintf(b, "      %s |\n", strings.Join(items, " "))
+	}
+	b.WriteString("    }\n")
+}
+
+// lilyPondVoice writes the voice's events, with the tempo marks placed before
+// the first event at or after their offsets
+func (s *score) lilyPondVoice(measure scoreMeasure, voice scoreVoice, percussion bool, tempos []scoreTempo) string {
+	var items []string
+	tempoMark := func(t scoreTempo) string {
+		return fmt.Sprintf("\\tempo 4 = %d", int(math.Round(t.bpm)))
+	}
+	for _, event := range voice.events {
+		for len(tempos) > 0 && tempos[0].offset <= event.offset {
+			items = append(items, tempoMark(tempos[0]))
+			tempos = tempos[1:]
+		}
+		items = append(items, s.lilyPondEvent(measure, event, percussion))
+	}
+	for _, t := range tempos {
+		items = append(items, tempoMark(t))
+	}
+	return strings.Join(items, " ")
+}
+
+func (s *score) lilyPondEvent(measure scoreMeasure, event scoreEvent, percussion bool) string {
+	if event.wholeMeasure {
+		whole := s.divisions * 4
+		divisor := gcd(measure.length, whole)
+		if measure.length == whole {
+			return "R1"
+		}
+		return fmt.Sprintf("R1*%d/%d", measure.length/divisor, whole/divisor)
+	}
+	duration := strconv.Itoa(event.value.denominator) + strings.Repeat(".", event.value.dots)
+	var names []string
+	for _, pitch := range event.pitches {
+		if !percussion {
+			names = append(names, lilyPondPitch(measure.key, pitch))
+		} else if name, found := lilyPondDrums[pitch]; found {
+			names = append(names, name)
+		}
+	}
+	var written string
+	switch len(names) {
+	case 0:
+		return "r" + duration
+	case 1:
+		written = names[0] + duration
+	default:
+		written = "<" + strings.Join(names, " ") + ">" + duration
+	}
+	if event.tieStart {
+		written += "~"
+	}
+	return written
+}
+
+// lilyPondLyrics matches each lyric to the first note starting at or after it
+// in the melody's first voice; notes without lyrics are skipped
+func (s *score) lilyPondLyrics(p scorePart, lyrics []lyric) string {
+	var onsets []int64
+	for k, measure := range s.measures {
+		for _, voice := range p.measures[k] {
+			if voice.number != 1 {
+				continue
+			}
+			for _, event := range voice.events {
+				if len(event.pitches) > 0 && !event.tieStop {
+					onsets = append(onsets, measure.start+event.offset)
+				}
+			}
+		}
+	}
+	syllables := make([]string, len(onsets))
+	for _, l := range lyrics {
+		text := strings.TrimSpace(strings.TrimLeft(l.text, "/\\"))
+		position := s.quantize(l.tick)
+		k := sort.Search(len(onsets), func(i int) bool { return onsets[i] >= position })
+		if k == len(onsets) || text == "" {
+			continue
+		}
+		syllables[k] += text
+	}
+	last := len(syllables) - 1
+	for last >= 0 && syllables[last] == "" {
+		last--
+	}
+	items := make([]string, 0, last+1)
+	for _, syllable := range syllables[:last+1] {
+		switch {
+		case syllable == "":
+			items = append(items, "\\skip 1")
+		case strings.HasSuffix(syllable, "-") && len(syllable) > 1:
+			items = append(items, lilyPondString(strings.TrimSuffix(syllable, "-")), "--")
+		default:
+			items = append(items, lilyPondString(syllable))
+		}
+	}
+	return strings.Join(items, " ")
+}
+
+// collectLyrics returns the file's lyrics in order, and the number of the
+// first track holding any
+func collectLyrics(data *smf.SMF) ([]lyric, int) {
+	var lyrics []lyric
+	lyricTrack := -1
+	for trackNumber, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var text string
+			if event.Message.GetMetaLyric(&text) {
+				lyrics = append(lyrics, lyric{tick: ticks[k], text: text})
+				if lyricTrack < 0 {
+					lyricTrack = trackNumber
+				}
+			}
+		}
+	}
+	sort.SliceStable(lyrics, func(i, j int) bool { return lyrics[i].tick < lyrics[j].tick })
+	return lyrics, lyricTrack
+}
+
+// lilyPondPitch writes the pitch in LilyPond's default (Dutch) note names and
+// absolute octaves, in which c' is middle C
+func lilyPondPitch(k smf.Key, pitch uint8) string {
+	step, alter, octave := spellPitch(k, pitch)
+	name := lilyPondPitchName(step, alter)
+	switch {
+	case octave > 3:
+		name += strings.Repeat("'", octave-3)
+	case octave < 3:
+		name += strings.Repeat(",", 3-octave)
+	}
+	return name
+}
+
+func lilyPondPitchName(step string, alter int) string {
+	name := strings.ToLower(step)
+	switch {
+	case alter > 0:
+		name += "is"
+	case alter < 0 && (name == "e" || name == "a"):
+		name += "s"
+	case alter < 0:
+		name += "es"
+	}
+	return name
+}
+
+func lilyPondKey(k smf.Key) string {
+	step, alter, _ := spellPitch(k, k.Key)
+	mode := "minor"
+	if k.IsMajor {
+		mode = "major"
+	}
+	return fmt.Sprintf("\\key %s \\%s", lilyPondPitchName(step, alter), mode)
+}
+
+func lilyPondString(s string) string {
+	return strconv.Quote(s)
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_export_writeLilyPond(t *testing.T) {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaMeter(3, 4))
+	conductor.Add(0, smf.MetaKey(5, true, 1, true))
+	conductor.Add(0, smf.MetaTempo(100))
+	var melody smf.Track
+	melody.Add(0, smf.MetaTrackSequenceName("Lead"))
+	melody.Add(0, smf.MetaLyric("Hel-"))
+	melody.Add(0, midi.NoteOn(0, 70, 80))
+	melody.Add(0, midi.NoteOn(0, 62, 80))
+	melody.Add(960, midi.NoteOff(0, 70))
+	melody.Add(0, midi.NoteOn(0, 69, 80))
+	melody.Add(960, midi.NoteOff(0, 69))
+	melody.Add(0, midi.NoteOff(0, 62))
+	melody.Add(0, smf.MetaLyric("lo"))
+	melody.Add(0, midi.NoteOn(0, 65, 80))
+	melody.Add(240, midi.NoteOff(0, 65))
+	var drums smf.Track
+	drums.Add(0, midi.NoteOn(9, 36, 100))
+	drums.Add(0, midi.NoteOn(9, 42, 100))
+	drums.Add(240, midi.NoteOff(9, 36))
+	drums.Add(0, midi.NoteOff(9, 42))
+	var b bytes.Buffer
+	if err := (&export{quantize: 16}).writeLilyPond(&b, makeTestSMF(conductor, melody, drums)); err != nil {
+		t.Fatalf("export.writeLilyPond() error = %v", err)
+	}
+	want := `\version "2.24.0"
+
+\score {
+  <<
+    \new Staff \with { instrumentName = "Lead" } {
+      \clef treble
+      \key f \major \time 3/4 << { \tempo 4 = 100 bes'2 a'4~ } \\ { d'2.~ } >> |
+      << { a'4 f'8 r4. } \\ { d'4 r2 } >> |
+    }
+    \addlyrics { "Hel" -- \skip 1 "lo" }
+    \new DrumStaff \with { instrumentName = "Drum kit" } \drummode {
+      \time 3/4 <bassdrum closedhihat>8 r2 r8 |
+      R1*3/4 |
+    }
+  >>
+  \layout { }
+  \midi { }
+}
+`
+	if got := b.String(); got != want {
+		t.Errorf("export.writeLilyPond() = %s, want %s", got, want)
+	}
+}
+
+func Test_lilyPondPitch(t *testing.T) {
+	sharps := smf.Key{Key: 7, IsMajor: true, Num: 1}
+	flats := smf.Key{Key: 3, IsMajor: true, Num: 3, IsFlat: true}
+	tests := map[string]struct {
+		k     smf.Key
+		pitch uint8
+		want  string
+	}{
+		"middle C":    {k: sharps, pitch: 60, want: "c'"},
+		"F sharp":     {k: sharps, pitch: 66, want: "fis'"},
+		"E flat":      {k: flats, pitch: 75, want: "es''"},
+		"A flat":      {k: flats, pitch: 56, want: "as"},
+		"B flat":      {k: flats, pitch: 46, want: "bes,"},
+		"low C":       {k: sharps, pitch: 24, want: "c,,"},
+		"treble clef": {k: sharps, pitch: 79, want: "g''"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := lilyPondPitch(tt.k, tt.pitch); got != tt.want {
+				t.Errorf("lilyPondPitch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lilyPondKey(t *testing.T) {
+	if got := lilyPondKey(smf.Key{Key: 10, IsMajor: false, Num: 5, IsFlat: true}); got != "\\key bes \\minor" {
+		t.Errorf("lilyPondKey() = %q, want \"\\key bes \\minor\"", got)
+	}
+}
@@ -0,0 +1,420 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// exitConflict is returned when the merge leaves conflicts, as a git merge
+	// driver must; errors return other codes
+	exitConflict = 1
+	// metaLane holds a track's meta and system exclusive events; lanes 0
+	// through 15 hold the events of each channel
+	metaLane = 16
+)
+
+// laneOrder puts a track's meta events ahead of its channels' events at the
+// same tick
+var laneOrder = []int{metaLane, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// mergeEvent is an event at its absolute tick; canon ignores how the event is
+// encoded, so that a note on with velocity 0 equals a note off
+type mergeEvent struct {
+	tick    int64
+	message smf.Message
+	lane    int
+	canon   string
+}
+
+// mergeTrack is a track's events, without its end of track event, and the
+// tick at which it ends
+type mergeTrack struct {
+	name   string
+	events []mergeEvent
+	end    int64
+}
+
+func newMergeTracks(data *smf.SMF) []mergeTrack {
+	var tracks []mergeTrack
+	for _, track := range data.Tracks {
+		var t mergeTrack
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			message := event.Message
+			t.end = ticks[k]
+			if message.Is(smf.MetaEndOfTrackMsg) {
+				continue
+			}
+			if t.name == "" {
+				_ = message.GetMetaTrackName(&t.name)
+			}
+			e := mergeEvent{tick: ticks[k], message: message, lane: metaLane, canon: hexBytes(message)}
+			var channel, key, velocity uint8
+			switch {
+			case message.GetNoteOff(&channel, &key, &velocity),
+				message.GetNoteOn(&channel, &key, &velocity) && velocity == 0:
+				e.canon = fmt.Sprintf("note off %d %d", channel, key)
+			}
+			if message.GetChannel(&channel) {
+				e.lane = int(channel)
+			}
+			t.events = append(t.events, e)
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks
+}
+
+func (t *mergeTrack) lane(lane int) []mergeEvent {
+	var events []mergeEvent
+	for _, e := range t.events {
+		if e.lane == lane {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (t *mergeTrack) sameAs(other *mergeTrack) bool {
+	return t.end == other.end && sameEvents(t.events, other.events)
+}
+
+func sameEvents(a, b []mergeEvent) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k].tick != b[k].tick || a[k].canon != b[k].canon {
+			return false
+		}
+	}
+	return true
+}
+
+// mergedTrack is a track of the merge's result: either merged cleanly, or in
+// conflict, with each side's version of it (nil for a side that deleted it)
+type mergedTrack struct {
+	result   *mergeTrack
+	conflict string
+	ours     *mergeTrack
+	theirs   *mergeTrack
+}
+
+// merge3 merges the changes two sides made to a common base file
+type merge3 struct {
+	outFile  string
+	conflict string
+}
+
+func newMerge3() command {
+	return &merge3{}
+}
+
+func (m *merge3) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&m.outFile, "output", "o", "", "file to write (default: ours, as git expects of a merge driver)")
+	flags.StringVar(&m.conflict, "conflict", "report",
+		"on conflict, report and write nothing, or write text with conflict markers for build")
+}
+
+func (m *merge3) run(o output.Bus, args []string) int {
+	valid := true
+	if m.conflict != "report" && m.conflict != "text" {
+		o.ErrorPrintf("The --conflict value %q is not valid: it must be report or text.\n", m.conflict)
+		valid = false
+	}
+	if len(args) != 3 {
+		o.ErrorPrintln("The merge3 command requires three files: base, ours, and theirs.")
+		valid = false
+	}
+	if !valid {
+		return exitUserError
+	}
+	base, okBase := readSMF(o, args[0])
+	ours, okOurs := readSMF(o, args[1])
+	theirs, okTheirs := readSMF(o, args[2])
+	if !okBase || !okOurs || !okTheirs {
+		return exitSystemError
+	}
+	for k, data := range []*smf.SMF{ours, theirs} {
+		if data.TimeFormat != base.TimeFormat {
+			o.ErrorPrintf("The files cannot be merged: %q has the time format %s, but %q has %s.\n", args[k+1],
+				describeTimeFormat(data.TimeFormat), args[0], describeTimeFormat(base.TimeFormat))
+			return exitConflict
+		}
+	}
+	destination := m.outFile
+	if destination == "" {
+		destination = args[1]
+	}
+	tracks, conflicts := mergeTracks(o, newMergeTracks(base), newMergeTracks(ours), newMergeTracks(theirs))
+	format := ours.Format()
+	if format == 0 && len(tracks) > 1 {
+		format = 1
+	}
+	if conflicts > 0 {
+		if m.conflict == "report" {
+			o.ErrorPrintf("The merge has %d conflicts; %q was left unchanged.\n", conflicts, destination)
+			return exitConflict
+		}
+		var content bytes.Buffer
+		err := writeMergeText(&content, format, ours, tracks)
+		if err == nil {
+			err = writeFile(destination, content.Bytes())
+		}
+		if err != nil {
+			o.ErrorPrintf("The file %q cannot be written: %v.\n", destination, err)
+			o.Log(output.Error, "cannot write file", map[string]any{"file": destination, "error": err})
+			return exitSystemError
+		}
+		o.ErrorPrintf("The merge has %d conflicts, marked in %q; resolve them, then build the file with "+
+			"\"smf-tool build %s -o %s\".\n", conflicts, destination, destination, destination)
+		return exitConflict
+	}
+	var result *smf.SMF
+	switch format {
+	case 0:
+		result = smf.New()
+	case 2:
+		result = smf.NewSMF2()
+	default:
+		result = smf.NewSMF1()
+	}
+	result.TimeFormat = ours.TimeFormat
+	for _, t := range tracks {
+		_ = result.Add(t.result.smfTrack())
+	}
+	if !writeSMF(o, result, destination) {
+		return exitSystemError
+	}
+	o.ConsolePrintf("merged into %s\n", destination)
+	return exitSuccess
+}
+
+func (t *mergeTrack) smfTrack() smf.Track {
+	var track smf.Track
+	var now int64
+	for _, e := range t.events {
+		track.Add(uint32(e.tick-now), e.message)
+		now = e.tick
+	}
+	track.Close(uint32(max(t.end-now, 0)))
+	return track
+}
+
+// mergeTracks pairs the tracks of the three files, by name where it is unique
+// and otherwise in order, and merges each; it reports where each change came
+// from, and returns the merged tracks and the number of conflicts
+func mergeTracks(o output.Bus, base, ours, theirs []mergeTrack) ([]mergedTrack, int) {
+	names := func(tracks []mergeTrack) []string {
+		var s []string
+		for _, t := range tracks {
+			s = append(s, t.name)
+		}
+		return s
+	}
+	partners := func(side []mergeTrack) (toSide []int, added []int) {
+		toSide = make([]int, len(base))
+		for _, pair := range pairTracks(names(base), names(side)) {
+			if pair[0] < 0 {
+				added = append(added, pair[1])
+			} else {
+				toSide[pair[0]] = pair[1]
+			}
+		}
+		return
+	}
+	toOurs, addedOurs := partners(ours)
+	toTheirs, addedTheirs := partners(theirs)
+	var merged []mergedTrack
+	conflicts := 0
+	for k := range base {
+		label := fmt.Sprintf("track %d%s", k, quotedName(base[k].name))
+		b := &base[k]
+		var oursTrack, theirsTrack *mergeTrack
+		if toOurs[k] >= 0 {
+			oursTrack = &ours[toOurs[k]]
+		}
+		if toTheirs[k] >= 0 {
+			theirsTrack = &theirs[toTheirs[k]]
+		}
+		switch {
+		case oursTrack == nil && theirsTrack == nil:
+			o.ConsolePrintf("%s: deleted in both\n", label)
+		case oursTrack == nil && theirsTrack.sameAs(b):
+			o.ConsolePrintf("%s: deleted in ours\n", label)
+		case theirsTrack == nil && oursTrack.sameAs(b):
+			o.ConsolePrintf("%s: deleted in theirs\n", label)
+		case oursTrack == nil || theirsTrack == nil:
+			conflict := label + ": deleted in ours, changed in theirs"
+			if theirsTrack == nil {
+				conflict = label + ": deleted in theirs, changed in ours"
+			}
+			o.ConsolePrintf("conflict: %s\n", conflict)
+			merged = append(merged, mergedTrack{conflict: conflict, ours: oursTrack, theirs: theirsTrack})
+			conflicts++
+		default:
+			t := mergeLanes(o, label, b, oursTrack, theirsTrack)
+			if t.conflict != "" {
+				conflicts++
+			}
+			merged = append(merged, t)
+		}
+	}
+	for _, k := range addedOurs {
+		o.ConsolePrintf("track %d%s: added in ours\n", k, quotedName(ours[k].name))
+		merged = append(merged, mergedTrack{result: &ours[k]})
+	}
+	for _, k := range addedTheirs {
+		duplicate := false
+		for _, j := range addedOurs {
+			duplicate = duplicate || theirs[k].name == ours[j].name && theirs[k].sameAs(&ours[j])
+		}
+		if duplicate {
+			continue
+		}
+		o.ConsolePrintf("track %d%s: added in theirs\n", k, quotedName(theirs[k].name))
+		merged = append(merged, mergedTrack{result: &theirs[k]})
+	}
+	return merged, conflicts
+}
+
+// mergeLanes merges a track lane by lane: a lane that only one side changed
+// takes that side's events, and a lane both changed alike takes either; a
+// lane the sides changed differently is a conflict
+func mergeLanes(o output.Bus, label string, base, ours, theirs *mergeTrack) mergedTrack {
+	result := &mergeTrack{name: ours.name}
+	oursVersion := &mergeTrack{name: ours.name}
+	theirsVersion := &mergeTrack{name: theirs.name}
+	var conflicting []string
+	for _, lane := range laneOrder {
+		laneLabel := fmt.Sprintf("channel %d", lane)
+		if lane == metaLane {
+			laneLabel = "meta events"
+		}
+		b, fromOurs, fromTheirs := base.lane(lane), ours.lane(lane), theirs.lane(lane)
+		var chosen []mergeEvent
+		switch {
+		case sameEvents(fromOurs, b):
+			chosen = fromTheirs
+			if !sameEvents(fromTheirs, b) {
+				o.ConsolePrintf("%s %s: changed in theirs\n", label, laneLabel)
+			}
+		case sameEvents(fromTheirs, b):
+			chosen = fromOurs
+			o.ConsolePrintf("%s %s: changed in ours\n", label, laneLabel)
+		case sameEvents(fromOurs, fromTheirs):
+			chosen = fromOurs
+			o.ConsolePrintf("%s %s: changed alike in both\n", label, laneLabel)
+		default:
+			o.ConsolePrintf("conflict: %s %s: changed in both\n", label, laneLabel)
+			conflicting = append(conflicting, laneLabel)
+			oursVersion.events = append(oursVersion.events, fromOurs...)
+			theirsVersion.events = append(theirsVersion.events, fromTheirs...)
+			continue
+		}
+		result.events = append(result.events, chosen...)
+		oursVersion.events = append(oursVersion.events, chosen...)
+		theirsVersion.events = append(theirsVersion.events, chosen...)
+	}
+	result.end = ours.end
+	if ours.end == base.end {
+		result.end = theirs.end
+	} else if theirs.end != base.end {
+		result.end = max(ours.end, theirs.end)
+	}
+	oursVersion.end, theirsVersion.end = result.end, result.end
+	for _, t := range []*mergeTrack{result, oursVersion, theirsVersion} {
+		sort.SliceStable(t.events, func(i, j int) bool { return t.events[i].tick < t.events[j].tick })
+		if n := len(t.events); n > 0 {
+			t.end = max(t.end, t.events[n-1].tick)
+		}
+	}
+	if len(conflicting) == 0 {
+		return mergedTrack{result: result}
+	}
+	conflict := fmt.Sprintf("%s: %s changed in both", label, conflicting[0])
+	if len(conflicting) > 1 {
+		conflict = fmt.Sprintf("%s: %s and %d more changed in both", label, conflicting[0], len(conflicting)-1)
+	}
+	return mergedTrack{conflict: conflict, ours: oursVersion, theirs: theirsVersion}
+}
+
+// writeMergeText writes the merge's result in the form dump writes, with each
+// conflict marked as git marks them: ours, then theirs, of the track's events,
+// or of the whole track when one side deleted it. Resolved, the text is ready
+// for build.
+func writeMergeText(w io.Writer, format uint16, reference *smf.SMF, tracks []mergedTrack) error {
+	var timeFormat dumpTimeFormat
+	switch tf := reference.TimeFormat.(type) {
+	case smf.MetricTicks:
+		timeFormat.TicksPerQuarter = uint16(tf)
+	case smf.TimeCode:
+		timeFormat.FramesPerSecond, timeFormat.Subframes = tf.FramesPerSecond, tf.SubFrames
+	}
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string]any{"format": format, "timeFormat": timeFormat}); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	key := firstKey(reference)
+	speller := &read{key: &key}
+	writeEvents := func(t *mergeTrack) error {
+		for _, e := range t.events {
+			event := newDumpEvent(e.tick, e.message)
+			event.annotate(speller)
+			line, err := yaml.Marshal(event)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "      - %s", line)
+		}
+		fmt.Fprintf(&b, "      - {tick: %d, type: end_of_track}\n", t.end)
+		return nil
+	}
+	b.WriteString("tracks:\n")
+	for _, t := range tracks {
+		var err error
+		switch {
+		case t.conflict == "":
+			b.WriteString("  - events:\n")
+			err = writeEvents(t.result)
+		case t.ours == nil || t.theirs == nil:
+			fmt.Fprintf(&b, "<<<<<<< ours: %s\n", t.conflict)
+			if t.ours != nil {
+				b.WriteString("  - events:\n")
+				err = writeEvents(t.ours)
+			}
+			b.WriteString("=======\n")
+			if err == nil && t.theirs != nil {
+				b.WriteString("  - events:\n")
+				err = writeEvents(t.theirs)
+			}
+			b.WriteString(">>>>>>> theirs\n")
+		default:
+			fmt.Fprintf(&b, "  - events:\n<<<<<<< ours: %s\n", t.conflict)
+			err = writeEvents(t.ours)
+			b.WriteString("=======\n")
+			if err == nil {
+				err = writeEvents(t.theirs)
+			}
+			b.WriteString(">>>>>>> theirs\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
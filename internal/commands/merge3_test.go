@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+)
+
+// sameMusic reports whether diff finds no differences between the files
+func sameMusic(t *testing.T, pathA, pathB string) bool {
+	t.Helper()
+	o := output.NewRecorder()
+	return (&diff{exitCode: true}).run(o, []string{pathA, pathB}) == exitSuccess
+}
+
+func Test_merge3_run(t *testing.T) {
+	base := diffTestOptions{resolution: 480, velocity: 80, tempo: 120}
+	louder := base
+	louder.velocity = 96
+	longer := base
+	longer.extraNote = true
+	rearranged := base
+	rearranged.tempo, rearranged.extraTrack, rearranged.bassKey = 90, true, 38
+	merged := louder
+	merged.tempo, merged.extraTrack, merged.bassKey = 90, true, 38
+	noBass := base
+	noBass.noBass = true
+	newBass := base
+	newBass.bassKey = 38
+	finer := base
+	finer.resolution = 960
+	tests := map[string]struct {
+		m          *merge3
+		ours       diffTestOptions
+		theirs     diffTestOptions
+		want       int
+		wantResult *diffTestOptions // the music written over ours
+		output.WantedRecording
+	}{
+		"separate changes": {
+			m:          &merge3{conflict: "report"},
+			ours:       louder,
+			theirs:     rearranged,
+			want:       exitSuccess,
+			wantResult: &merged,
+			WantedRecording: output.WantedRecording{
+				Console: "track 0 meta events: changed in theirs\n" +
+					"track 1 \"Piano\" channel 0: changed in ours\n" +
+					"track 2 \"Bass\" channel 1: changed in theirs\n" +
+					"track 3 \"Strings\": added in theirs\n" +
+					"merged into OURS\n",
+			},
+		},
+		"same change": {
+			m:          &merge3{conflict: "report"},
+			ours:       louder,
+			theirs:     louder,
+			want:       exitSuccess,
+			wantResult: &louder,
+			WantedRecording: output.WantedRecording{
+				Console: "track 1 \"Piano\" channel 0: changed alike in both\nmerged into OURS\n",
+			},
+		},
+		"deleted, unchanged": {
+			m:          &merge3{conflict: "report"},
+			ours:       noBass,
+			theirs:     louder,
+			want:       exitSuccess,
+			wantResult: &diffTestOptions{resolution: 480, velocity: 96, tempo: 120, noBass: true},
+			WantedRecording: output.WantedRecording{
+				Console: "track 1 \"Piano\" channel 0: changed in theirs\n" +
+					"track 2 \"Bass\": deleted in ours\n" +
+					"merged into OURS\n",
+			},
+		},
+		"conflicting changes": {
+			m:      &merge3{conflict: "report"},
+			ours:   louder,
+			theirs: longer,
+			want:   exitConflict,
+			WantedRecording: output.WantedRecording{
+				Console: "conflict: track 1 \"Piano\" channel 0: changed in both\n",
+				Error:   "The merge has 1 conflicts; \"OURS\" was left unchanged.\n",
+			},
+		},
+		"deleted, changed": {
+			m:      &merge3{conflict: "report"},
+			ours:   noBass,
+			theirs: newBass,
+			want:   exitConflict,
+			WantedRecording: output.WantedRecording{
+				Console: "conflict: track 2 \"Bass\": deleted in ours, changed in theirs\n",
+				Error:   "The merge has 1 conflicts; \"OURS\" was left unchanged.\n",
+			},
+		},
+		"bad conflict": {
+			// a bad command line is told apart from a conflict
+			m:      &merge3{conflict: "ask"},
+			ours:   louder,
+			theirs: longer,
+			want:   2,
+			WantedRecording: output.WantedRecording{
+				Error: "The --conflict value \"ask\" is not valid: it must be report or text.\n",
+			},
+		},
+		"different time formats": {
+			m:      &merge3{conflict: "report"},
+			ours:   base,
+			theirs: finer,
+			want:   exitConflict,
+			WantedRecording: output.WantedRecording{
+				Error: "The files cannot be merged: \"THEIRS\" has the time format 960 ticks per quarter, but " +
+					"\"BASE\" has 480 ticks per quarter.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			basePath := writeDiffTestFile(t, dir, "base.mid", base)
+			oursPath := writeDiffTestFile(t, dir, "ours.mid", tt.ours)
+			theirsPath := writeDiffTestFile(t, dir, "theirs.mid", tt.theirs)
+			before, _ := os.ReadFile(oursPath)
+			o := output.NewRecorder()
+			if got := tt.m.run(o, []string{basePath, oursPath, theirsPath}); got != tt.want {
+				t.Errorf("merge3.run() = %d, want %d: %s", got, tt.want, o.ErrorOutput())
+			}
+			replacer := strings.NewReplacer(basePath, "BASE", oursPath, "OURS", theirsPath, "THEIRS")
+			recorded := output.NewRecorder()
+			recorded.ConsolePrintf("%s", replacer.Replace(o.ConsoleOutput()))
+			recorded.ErrorPrintf("%s", replacer.Replace(o.ErrorOutput()))
+			if issues, ok := recorded.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("merge3.run() %s", issue)
+				}
+			}
+			if tt.wantResult == nil {
+				if after, _ := os.ReadFile(oursPath); !bytes.Equal(before, after) {
+					t.Errorf("merge3.run() changed ours")
+				}
+				return
+			}
+			expected := writeDiffTestFile(t, dir, "expected.mid", *tt.wantResult)
+			if !sameMusic(t, expected, oursPath) {
+				t.Errorf("merge3.run() did not write the expected music")
+			}
+		})
+	}
+}
+
+func Test_merge3_run_text(t *testing.T) {
+	dir := t.TempDir()
+	base := diffTestOptions{resolution: 480, velocity: 80, tempo: 120}
+	louder := base
+	louder.velocity = 96
+	longer := base
+	longer.extraNote = true
+	noBass := louder
+	noBass.noBass = true
+	newBass := longer
+	newBass.bassKey = 38
+	basePath := writeDiffTestFile(t, dir, "base.mid", base)
+	oursPath := writeDiffTestFile(t, dir, "ours.mid", noBass)
+	theirsPath := writeDiffTestFile(t, dir, "theirs.mid", newBass)
+	result := filepath.Join(dir, "result.mid")
+	o := output.NewRecorder()
+	m := &merge3{outFile: result, conflict: "text"}
+	if got := m.run(o, []string{basePath, oursPath, theirsPath}); got != exitConflict {
+		t.Fatalf("merge3.run() = %d, want %d: %s", got, exitConflict, o.ErrorOutput())
+	}
+	if got, want := o.ErrorOutput(), "The merge has 2 conflicts, marked in \""+result+"\"; resolve them, then "+
+		"build the file with \"smf-tool build "+result+" -o "+result+"\".\n"; got != want {
+		t.Errorf("merge3.run() error = %q, want %q", got, want)
+	}
+	content, err := os.ReadFile(result)
+	if err != nil {
+		t.Fatalf("cannot read the result: %v", err)
+	}
+	text := string(content)
+	for _, want := range []string{
+		"format: 1\ntimeFormat:\n  ticksPerQuarter: 480\ntracks:\n  - events:\n" +
+			"      - {tick: 0, type: time_signature, numerator: 4, denominator: 4, clocks: 8, thirtySeconds: 8}\n",
+		"  - events:\n<<<<<<< ours: track 1 \"Piano\": channel 0 changed in both\n",
+		"      - {tick: 960, type: note_on, channel: 0, key: 64, velocity: 96, note: E5}\n",
+		"=======\n",
+		"      - {tick: 960, type: note_on, channel: 0, key: 64, velocity: 80, note: E5}\n",
+		"<<<<<<< ours: track 2 \"Bass\": deleted in ours, changed in theirs\n=======\n  - events:\n",
+		"      - {tick: 0, type: note_on, channel: 1, key: 38, velocity: 100, note: D3}\n",
+		">>>>>>> theirs\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("merge3.run() wrote %s\nwithout %q", text, want)
+		}
+	}
+	// resolving each conflict in favor of ours leaves ours
+	var resolved []string
+	skipping := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< "):
+		case line == "=======\n":
+			skipping = true
+		case line == ">>>>>>> theirs\n":
+			skipping = false
+		case !skipping:
+			resolved = append(resolved, line)
+		}
+	}
+	data, err := buildSMF([]byte(strings.Join(resolved, "")))
+	if err != nil {
+		t.Fatalf("the resolved text cannot be built: %v", err)
+	}
+	rebuilt := filepath.Join(dir, "rebuilt.mid")
+	if !writeSMF(output.NewRecorder(), data, rebuilt) {
+		t.Fatalf("cannot write the rebuilt file")
+	}
+	if !sameMusic(t, oursPath, rebuilt) {
+		t.Errorf("merge3.run() resolved in favor of ours is not ours")
+	}
+}
+
+func Test_merge3_run_errors(t *testing.T) {
+	tests := map[string]struct {
+		m    *merge3
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"bad values": {
+			m:    &merge3{conflict: "ask"},
+			args: []string{"base.mid", "ours.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --conflict value \"ask\" is not valid: it must be report or text.\n" +
+					"The merge3 command requires three files: base, ours, and theirs.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.m.run(o, tt.args); got != tt.want {
+				t.Errorf("merge3.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("merge3.run() %s", issue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package commands
+
+import (
+	"slices"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// meta reads and edits text meta events, such as track names, copyright
+// notices, and markers ("meta get", "meta set", "meta remove")
+type meta struct {
+	typeName     string
+	text         string
+	track        int
+	at           string
+	encodingName string
+	outFile      string
+	// set by run
+	typ      byte
+	position position
+}
+
+func newMeta() command {
+	return &meta{}
+}
+
+func (mt *meta) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&mt.typeName, "type", "", "type of event: "+joinSorted(fieldNames(dumpTypeBytes())))
+	flags.StringVar(&mt.text, "text", "", "text to set")
+	flags.IntVar(&mt.track, "track", -1, "track to use (default: 0 for set, every track otherwise)")
+	flags.StringVar(&mt.at, "at", "", "position of the event, as bar, bar:beat, or ticks followed by 't' "+
+		"(default: the start for set, anywhere otherwise)")
+	flags.StringVar(&mt.encodingName, "encoding", autoEncoding, "encoding of the text in the files: "+
+		encodingNames())
+	flags.StringVarP(&mt.outFile, "output", "o", "", "file to write (default: overwrite the input file)")
+}
+
+func (mt *meta) run(o output.Bus, args []string) int {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "get", "set", "remove":
+	default:
+		o.ErrorPrintln("The meta action must be specified; the actions supported are \"get\", \"set\", and \"remove\".")
+		return exitUserError
+	}
+	paths := args[1:]
+	if !mt.validate(o, action, paths) {
+		return exitUserError
+	}
+	switch action {
+	case "get":
+		return processFiles(o, paths, mt.get)
+	case "set":
+		return processFiles(o, paths, mt.set)
+	default:
+		return processFiles(o, paths, mt.remove)
+	}
+}
+
+func (mt *meta) validate(o output.Bus, action string, paths []string) bool {
+	valid := true
+	types := dumpTypeBytes()
+	switch typ, found := types[mt.typeName]; {
+	case found:
+		mt.typ = typ
+	case mt.typeName == "" && action == "get":
+	case mt.typeName == "":
+		o.ErrorPrintf("The --type flag must be specified for %s; the types are %s.\n", action,
+			joinSorted(fieldNames(types)))
+		valid = false
+	default:
+		o.ErrorPrintf("The --type value %q is not valid: the types are %s.\n", mt.typeName,
+			joinSorted(fieldNames(types)))
+		valid = false
+	}
+	codec, explicit := textEncodings[mt.encodingName]
+	if err := checkEncodingName(mt.encodingName); err != nil {
+		o.ErrorPrintf("The --encoding value %q is not valid: %v.\n", mt.encodingName, err)
+		valid = false
+	}
+	switch {
+	case action != "set":
+		if mt.text != "" {
+			o.ErrorPrintln("The --text flag may only be used with set.")
+			valid = false
+		}
+	case mt.text == "":
+		o.ErrorPrintln("The --text flag must be specified for set; use remove to delete events.")
+		valid = false
+	case explicit:
+		if _, err := codec.NewEncoder().String(mt.text); err != nil {
+			o.ErrorPrintf("The --text value %q is not valid: it cannot be written in %s.\n", mt.text, mt.encodingName)
+			valid = false
+		}
+	}
+	if mt.track < -1 {
+		o.ErrorPrintf("The --track value %d is not valid: it must not be negative.\n", mt.track)
+		valid = false
+	}
+	if mt.at != "" {
+		p, err := parsePosition(mt.at)
+		if err != nil {
+			o.ErrorPrintf("The --at value %q is not valid: %v.\n", mt.at, err)
+			valid = false
+		}
+		mt.position = p
+	}
+	if action == "get" && mt.outFile != "" {
+		o.ErrorPrintln("The --output flag may only be used with set and remove.")
+		valid = false
+	}
+	if mt.outFile != "" && namesSeveralFiles(paths) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		valid = false
+	}
+	return valid
+}
+
+// matches reports whether the event is a text meta event of the type and at
+// the position chosen by the flags, returning its type and text
+func (mt *meta) matches(m *meter, tick int64, message smf.Message) (byte, []byte, bool) {
+	if !message.IsMeta() {
+		return 0, nil, false
+	}
+	typ, data := metaData(message)
+	if _, isText := dumpTextTypes[typ]; !isText || (mt.typ != 0 && typ != mt.typ) {
+		return 0, nil, false
+	}
+	if mt.at != "" && m.resolve(mt.position) != tick {
+		return 0, nil, false
+	}
+	return typ, data, true
+}
+
+// tracks returns the tracks of the file chosen by --track
+func (mt *meta) tracks(o output.Bus, path string, data *smf.SMF) ([]int, bool) {
+	if mt.track < 0 {
+		all := make([]int, len(data.Tracks))
+		for k := range all {
+			all[k] = k
+		}
+		return all, true
+	}
+	if mt.track >= len(data.Tracks) {
+		o.ErrorPrintf("The file %q has no track %d; it has %d tracks.\n", path, mt.track, len(data.Tracks))
+		return nil, false
+	}
+	return []int{mt.track}, true
+}
+
+// get prints the matching events
+func (mt *meta) get(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	tracks, ok := mt.tracks(o, path, data)
+	if !ok {
+		return false
+	}
+	m := newMeter(data)
+	_, codec := textCodec(mt.encodingName, data)
+	for _, index := range tracks {
+		track := data.Tracks[index]
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			typ, raw, found := mt.matches(m, ticks[k], event.Message)
+			if !found {
+				continue
+			}
+			o.ConsolePrintf("%s: track %d, %s, %s %q\n", path, index, m.describe(ticks[k]), dumpTextTypes[typ],
+				metaText(codec, raw))
+		}
+	}
+	return true
+}
+
+// set replaces the text of the events of the type at the position in the
+// track, or adds an event there if there are none
+func (mt *meta) set(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	index := max(mt.track, 0)
+	if _, ok = mt.tracks(o, path, data); !ok {
+		return false
+	}
+	// the text is written in the encoding of the file's other text
+	name, codec := textCodec(mt.encodingName, data)
+	encoded, err := codec.NewEncoder().Bytes([]byte(mt.text))
+	if err != nil {
+		o.ErrorPrintf("The --text value %q cannot be written in the encoding of the file %q, %s; choose one with "+
+			"--encoding.\n", mt.text, path, name)
+		return false
+	}
+	m := newMeter(data)
+	var tick int64
+	if mt.at != "" {
+		tick = m.resolve(mt.position)
+	}
+	message := smf.MetaUndefined(mt.typ, encoded)
+	track := data.Tracks[index]
+	ticks := absoluteTicks(track)
+	replaced := false
+	track = removeEvents(track, func(k int) bool {
+		if !track[k].Message.IsMeta() || ticks[k] != tick {
+			return false
+		}
+		if typ, _ := metaData(track[k].Message); typ != mt.typ {
+			return false
+		}
+		if !replaced {
+			// keep the first in its place, with the new text
+			track[k].Message = message
+			replaced = true
+			return false
+		}
+		return true
+	})
+	if !replaced {
+		track = insertEvent(track, tick, message)
+	}
+	data.Tracks[index] = track
+	destination := mt.destination(path)
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: track %d, %s, %s set\n", destination, index, m.describe(tick), mt.typeName)
+	return true
+}
+
+// remove deletes the matching events
+func (mt *meta) remove(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	tracks, ok := mt.tracks(o, path, data)
+	if !ok {
+		return false
+	}
+	m := newMeter(data)
+	removed := 0
+	for _, index := range tracks {
+		track := data.Tracks[index]
+		ticks := absoluteTicks(track)
+		data.Tracks[index] = removeEvents(track, func(k int) bool {
+			_, _, found := mt.matches(m, ticks[k], track[k].Message)
+			if found {
+				removed++
+			}
+			return found
+		})
+	}
+	if removed == 0 {
+		o.ConsolePrintf("%s: no %s events found\n", path, mt.typeName)
+		return true
+	}
+	destination := mt.destination(path)
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: %d %s events removed\n", destination, removed, mt.typeName)
+	return true
+}
+
+func (mt *meta) destination(path string) string {
+	if mt.outFile != "" {
+		return mt.outFile
+	}
+	return path
+}
+
+// insertEvent returns the track with the message added at the tick, ahead of
+// the events already there; a track ending sooner is lengthened
+func insertEvent(track smf.Track, tick int64, message smf.Message) smf.Track {
+	ticks := absoluteTicks(track)
+	at := len(track)
+	for k, event := range track {
+		if ticks[k] >= tick || event.Message.Is(smf.MetaEndOfTrackMsg) {
+			at = k
+			break
+		}
+	}
+	var previous int64
+	if at > 0 {
+		previous = ticks[at-1]
+	}
+	if at < len(track) {
+		track[at].Delta = uint32(max(ticks[at]-tick, 0))
+	}
+	return slices.Insert(track, at, smf.Event{Delta: uint32(tick - previous), Message: message})
+}
+
+// removeEvents returns the track without the events for which drop returns
+// true, keeping the others at their ticks; drop may edit the event it is
+// given
+func removeEvents(track smf.Track, drop func(k int) bool) smf.Track {
+	var kept smf.Track
+	var carried uint32
+	for k := range track {
+		if drop(k) {
+			carried += track[k].Delta
+			continue
+		}
+		event := track[k]
+		event.Delta += carried
+		carried = 0
+		kept = append(kept, event)
+	}
+	return kept
+}
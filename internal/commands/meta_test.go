@@ -0,0 +1,259 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// metaTestSMF builds a file with a named conductor track holding the
+// copyright, and a violin part with a marker at bar 2
+func metaTestSMF(copyright []byte) *smf.SMF {
+	var conductor, part smf.Track
+	conductor.Add(0, smf.MetaMeter(4, 4))
+	conductor.Add(0, smf.MetaTrackSequenceName("Song"))
+	conductor.Add(0, smf.MetaUndefined(0x02, copyright))
+	part.Add(0, smf.MetaTrackSequenceName("Violin"))
+	part.Add(0, midi.NoteOn(0, 60, 80))
+	part.Add(1920, midi.NoteOff(0, 60))
+	part.Add(0, smf.MetaMarker("Verse"))
+	part.Add(0, midi.NoteOn(0, 62, 80))
+	part.Add(1920, midi.NoteOff(0, 62))
+	return makeTestSMF(conductor, part)
+}
+
+func Test_meta_run(t *testing.T) {
+	tests := map[string]struct {
+		m    *meta
+		args []string
+		want int
+		output.WantedRecording
+		// after is what "meta get" prints for /song.mid afterwards
+		after string
+	}{
+		"get everything": {
+			m:    &meta{track: -1, encodingName: "utf-8"},
+			args: []string{"get", "/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/song.mid: track 0, bar 1 beat 1, track_name \"Song\"\n" +
+					"/song.mid: track 0, bar 1 beat 1, copyright \"(c) 2020\"\n" +
+					"/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n" +
+					"/song.mid: track 1, bar 2 beat 1, marker \"Verse\"\n",
+			},
+		},
+		"get a type in a track": {
+			m:               &meta{typeName: "track_name", track: 1, encodingName: "utf-8"},
+			args:            []string{"get", "/song.mid"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n"},
+		},
+		"get legacy text": {
+			m:               &meta{typeName: "copyright", track: -1, encodingName: "auto"},
+			args:            []string{"get", "/legacy.mid"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/legacy.mid: track 0, bar 1 beat 1, copyright \"© Café\"\n"},
+		},
+		"get Japanese text": {
+			m:               &meta{typeName: "copyright", track: -1},
+			args:            []string{"get", "/japanese.mid"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/japanese.mid: track 0, bar 1 beat 1, copyright \"著作権 さくら\"\n"},
+		},
+		"set in the file's encoding": {
+			m:    &meta{typeName: "text", text: "Crème", track: -1},
+			args: []string{"set", "/legacy.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/legacy.mid: track 0, bar 1 beat 1, text set\n",
+			},
+		},
+		"set text the file's encoding lacks": {
+			m:    &meta{typeName: "text", text: "さくら", track: -1},
+			args: []string{"set", "/legacy.mid"},
+			want: exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --text value \"さくら\" cannot be written in the encoding of the file \"/legacy.mid\", " +
+					"windows-1252; choose one with --encoding.\n",
+			},
+		},
+		"set replaces": {
+			m:    &meta{typeName: "copyright", text: "(c) 2024 Example", track: -1, encodingName: "utf-8"},
+			args: []string{"set", "/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/song.mid: track 0, bar 1 beat 1, copyright set\n",
+			},
+			after: "/song.mid: track 0, bar 1 beat 1, track_name \"Song\"\n" +
+				"/song.mid: track 0, bar 1 beat 1, copyright \"(c) 2024 Example\"\n" +
+				"/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n" +
+				"/song.mid: track 1, bar 2 beat 1, marker \"Verse\"\n",
+		},
+		"set adds": {
+			m:    &meta{typeName: "marker", text: "Chorus", track: 1, at: "2:3", encodingName: "utf-8"},
+			args: []string{"set", "/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/song.mid: track 1, bar 2 beat 3, marker set\n",
+			},
+			after: "/song.mid: track 0, bar 1 beat 1, track_name \"Song\"\n" +
+				"/song.mid: track 0, bar 1 beat 1, copyright \"(c) 2020\"\n" +
+				"/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n" +
+				"/song.mid: track 1, bar 2 beat 1, marker \"Verse\"\n" +
+				"/song.mid: track 1, bar 2 beat 3, marker \"Chorus\"\n",
+		},
+		"set in bulk": {
+			m:    &meta{typeName: "text", text: "Draft", track: -1, encodingName: "utf-8"},
+			args: []string{"set", "/song.mid", "/legacy.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/song.mid: track 0, bar 1 beat 1, text set\n/legacy.mid: track 0, bar 1 beat 1, text set\n",
+				Error:   "2 files: 2 ok, 0 failed, 0 with warnings.\n",
+			},
+			after: "/song.mid: track 0, bar 1 beat 1, text \"Draft\"\n" +
+				"/song.mid: track 0, bar 1 beat 1, track_name \"Song\"\n" +
+				"/song.mid: track 0, bar 1 beat 1, copyright \"(c) 2020\"\n" +
+				"/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n" +
+				"/song.mid: track 1, bar 2 beat 1, marker \"Verse\"\n",
+		},
+		"set to another file": {
+			m:    &meta{typeName: "copyright", text: "© Café", track: -1, encodingName: "latin-1", outFile: "/copy.mid"},
+			args: []string{"set", "/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/copy.mid: track 0, bar 1 beat 1, copyright set\n",
+			},
+			after: "/song.mid: track 0, bar 1 beat 1, track_name \"Song\"\n" +
+				"/song.mid: track 0, bar 1 beat 1, copyright \"(c) 2020\"\n" +
+				"/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n" +
+				"/song.mid: track 1, bar 2 beat 1, marker \"Verse\"\n",
+		},
+		"remove": {
+			m:    &meta{typeName: "marker", track: -1, encodingName: "utf-8"},
+			args: []string{"remove", "/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/song.mid: 1 marker events removed\n",
+			},
+			after: "/song.mid: track 0, bar 1 beat 1, track_name \"Song\"\n" +
+				"/song.mid: track 0, bar 1 beat 1, copyright \"(c) 2020\"\n" +
+				"/song.mid: track 1, bar 1 beat 1, track_name \"Violin\"\n",
+		},
+		"remove nothing": {
+			m:    &meta{typeName: "marker", track: 1, at: "3", encodingName: "utf-8"},
+			args: []string{"remove", "/song.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/song.mid: no marker events found\n",
+			},
+		},
+		"missing track": {
+			m:    &meta{typeName: "marker", text: "Coda", track: 4, encodingName: "utf-8"},
+			args: []string{"set", "/song.mid"},
+			want: exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Error: "The file \"/song.mid\" has no track 4; it has 2 tracks.\n",
+			},
+		},
+		"no action": {
+			m:    &meta{},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The meta action must be specified; the actions supported are \"get\", \"set\", and \"remove\".\n",
+			},
+		},
+		"bad set": {
+			m:    &meta{text: "日本", track: -1, at: "0", encodingName: "latin-1"},
+			args: []string{"set", "/song.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --type flag must be specified for set; the types are copyright, cue_point, device_name, " +
+					"instrument_name, lyric, marker, program_name, text, track_name.\n" +
+					"The --text value \"日本\" is not valid: it cannot be written in latin-1.\n" +
+					"The --at value \"0\" is not valid: \"0\" is not a valid bar position.\n",
+			},
+		},
+		"bad get": {
+			m:    &meta{typeName: "title", text: "x", track: -2, encodingName: "ascii", outFile: "/x.mid"},
+			args: []string{"get", "/song.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --type value \"title\" is not valid: the types are copyright, cue_point, device_name, " +
+					"instrument_name, lyric, marker, program_name, text, track_name.\n" +
+					"The --encoding value \"ascii\" is not valid: the encodings are auto, latin-1, shift-jis, utf-8, " +
+					"windows-1252.\n" +
+					"The --text flag may only be used with set.\n" +
+					"The --track value -2 is not valid: it must not be negative.\n" +
+					"The --output flag may only be used with set and remove.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			useMemoryFileSystem(t, map[string][]byte{
+				"/song.mid":   smfBytes(t, metaTestSMF([]byte("(c) 2020"))),
+				"/legacy.mid": smfBytes(t, metaTestSMF([]byte("\xa9 Caf\xe9"))),
+				// 著作権 さくら in Shift-JIS
+				"/japanese.mid": smfBytes(t, metaTestSMF([]byte("\x92\x98\x8d\xec\x8c\xa0 \x82\xb3\x82\xad\x82\xe7"))),
+			})
+			o := output.NewRecorder()
+			if got := tt.m.run(o, tt.args); got != tt.want {
+				t.Errorf("meta.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("meta.run() %s", issue)
+				}
+			}
+			if tt.after == "" {
+				return
+			}
+			o = output.NewRecorder()
+			_ = (&meta{track: -1, encodingName: "utf-8"}).run(o, []string{"get", "/song.mid"})
+			if got := o.ConsoleOutput(); got != tt.after {
+				t.Errorf("meta.run() left %q, want %q", got, tt.after)
+			}
+		})
+	}
+}
+
+func Test_insertEvent(t *testing.T) {
+	var track smf.Track
+	track.Add(0, midi.NoteOn(0, 60, 80))
+	track.Add(480, midi.NoteOff(0, 60))
+	track.Close(0)
+	tests := map[string]struct {
+		tick      int64
+		wantTicks []int64
+		wantAt    int
+	}{
+		"start":          {tick: 0, wantTicks: []int64{0, 0, 480, 480}, wantAt: 0},
+		"between":        {tick: 240, wantTicks: []int64{0, 240, 480, 480}, wantAt: 1},
+		"before the end": {tick: 480, wantTicks: []int64{0, 480, 480, 480}, wantAt: 1},
+		"after the end":  {tick: 960, wantTicks: []int64{0, 480, 960, 960}, wantAt: 2},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := insertEvent(append(smf.Track{}, track...), tt.tick, smf.MetaMarker("here"))
+			ticks := absoluteTicks(got)
+			if len(ticks) != len(tt.wantTicks) {
+				t.Fatalf("insertEvent() ticks = %v, want %v", ticks, tt.wantTicks)
+			}
+			for k := range ticks {
+				if ticks[k] != tt.wantTicks[k] {
+					t.Errorf("insertEvent() ticks = %v, want %v", ticks, tt.wantTicks)
+					break
+				}
+			}
+			var text string
+			if !got[tt.wantAt].Message.GetMetaMarker(&text) {
+				t.Errorf("insertEvent() put the marker elsewhere than %d", tt.wantAt)
+			}
+			if !got[len(got)-1].Message.Is(smf.MetaEndOfTrackMsg) {
+				t.Errorf("insertEvent() did not keep the end of track last")
+			}
+		})
+	}
+}
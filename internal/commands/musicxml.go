@@ -0,0 +1,357 @@
+package commands
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const musicXMLDoctype = `<!DOCTYPE score-partwise PUBLIC "-//Recordare//DTD MusicXML 4.0 Partwise//EN" "http://www.musicxml.org/dtds/partwise.dtd">` + "\n"
+
+var (
+	musicXMLTypes = map[int]string{
+		1: "whole", 2: "half", 4: "quarter", 8: "eighth", 16: "16th", 32: "32nd", 64: "64th",
+	}
+	// where each General MIDI drum is written on a five line percussion staff,
+	// and with what notehead
+	drumDisplay = map[uint8]struct {
+		step     string
+		octave   int
+		notehead string
+	}{
+		35: {step: "F", octave: 4},
+		36: {step: "F", octave: 4},
+		37: {step: "C", octave: 5, notehead: "x"},
+		38: {step: "C", octave: 5},
+		39: {step: "D", octave: 5, notehead: "x"},
+		40: {step: "C", octave: 5},
+		41: {step: "G", octave: 4},
+		42: {step: "G", octave: 5, notehead: "x"},
+		43: {step: "A", octave: 4},
+		44: {step: "D", octave: 4, notehead: "x"},
+		45: {step: "B", octave: 4},
+		46: {step: "G", octave: 5, notehead: "circle-x"},
+		47: {step: "D", octave: 5},
+		48: {step: "E", octave: 5},
+		49: {step: "A", octave: 5, notehead: "x"},
+		50: {step: "F", octave: 5},
+		51: {step: "F", octave: 5, notehead: "x"},
+		52: {step: "B", octave: 5, notehead: "x"},
+		53: {step: "F", octave: 5, notehead: "diamond"},
+		54: {step: "E", octave: 5, notehead: "x"},
+		55: {step: "B", octave: 5, notehead: "x"},
+		56: {step: "E", octave: 5, notehead: "triangle"},
+		57: {step: "A", octave: 5, notehead: "x"},
+		59: {step: "F", octave: 5, notehead: "x"},
+	}
+)
+
+type xmlScore struct {
+	XMLName  xml.Name       `xml:"score-partwise"`
+	Version  string         `xml:"version,attr"`
+	Parts    []xmlScorePart `xml:"part-list>score-part"`
+	Measures []xmlPart      `xml:"part"`
+}
+
+type xmlScorePart struct {
+	ID              string               `xml:"id,attr"`
+	Name            string               `xml:"part-name"`
+	Instruments     []xmlScoreInstrument `xml:"score-instrument"`
+	MIDIInstruments []xmlMIDIInstrument  `xml:"midi-instrument"`
+}
+
+type xmlScoreInstrument struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"instrument-name"`
+}
+
+type xmlMIDIInstrument struct {
+	ID        string `xml:"id,attr"`
+	Channel   int    `xml:"midi-channel"`
+	Program   int    `xml:"midi-program,omitempty"`
+	Unpitched int    `xml:"midi-unpitched,omitempty"`
+}
+
+type xmlPart struct {
+	ID       string       `xml:"id,attr"`
+	Measures []xmlMeasure `xml:"measure"`
+}
+
+// xmlMeasure holds attributes, directions, notes, and backups, in the order
+// in which they are written
+type xmlMeasure struct {
+	Number  int   `xml:"number,attr"`
+	Content []any `xml:",any"`
+}
+
+type xmlAttributes struct {
+	XMLName   xml.Name `xml:"attributes"`
+	Divisions int64    `xml:"divisions,omitempty"`
+	Key       *xmlKey  `xml:"key"`
+	Time      *xmlTime `xml:"time"`
+	Clef      *xmlClef `xml:"clef"`
+}
+
+type xmlKey struct {
+	Fifths int    `xml:"fifths"`
+	Mode   string `xml:"mode"`
+}
+
+type xmlTime struct {
+	Beats    int `xml:"beats"`
+	BeatType int `xml:"beat-type"`
+}
+
+type xmlClef struct {
+	Sign string `xml:"sign"`
+	Line int    `xml:"line,omitempty"`
+}
+
+type xmlDirection struct {
+	XMLName   xml.Name `xml:"direction"`
+	Placement string   `xml:"placement,attr"`
+	BeatUnit  string   `xml:"direction-type>metronome>beat-unit"`
+	PerMinute string   `xml:"direction-type>metronome>per-minute"`
+	Offset    int64    `xml:"offset,omitempty"`
+	Sound     xmlSound `xml:"sound"`
+}
+
+type xmlSound struct {
+	Tempo string `xml:"tempo,attr"`
+}
+
+type xmlBackup struct {
+	XMLName  xml.Name `xml:"backup"`
+	Duration int64    `xml:"duration"`
+}
+
+type xmlNote struct {
+	XMLName    xml.Name          `xml:"note"`
+	Chord      *struct{}         `xml:"chord"`
+	Pitch      *xmlPitch         `xml:"pitch"`
+	Unpitched  *xmlUnpitched     `xml:"unpitched"`
+	Rest       *xmlRest          `xml:"rest"`
+	Duration   int64             `xml:"duration"`
+	Ties       []xmlTie          `xml:"tie"`
+	Instrument *xmlInstrumentRef `xml:"instrument"`
+	Voice      int               `xml:"voice"`
+	Type       string            `xml:"type,omitempty"`
+	Dots       []struct{}        `xml:"dot"`
+	Notehead   string            `xml:"notehead,omitempty"`
+	Notations  *xmlNotations     `xml:"notations"`
+}
+
+type xmlNotations struct {
+	Tied []xmlTie `xml:"tied"`
+}
+
+type xmlPitch struct {
+	Step   string `xml:"step"`
+	Alter  int    `xml:"alter,omitempty"`
+	Octave int    `xml:"octave"`
+}
+
+type xmlUnpitched struct {
+	Step   string `xml:"display-step"`
+	Octave int    `xml:"display-octave"`
+}
+
+type xmlRest struct {
+	Measure string `xml:"measure,attr,omitempty"`
+}
+
+type xmlTie struct {
+	Type string `xml:"type,attr"`
+}
+
+type xmlInstrumentRef struct {
+	ID string `xml:"id,attr"`
+}
+
+// writeMusicXML writes the file as a MusicXML partwise score, with a part for
+// each channel of each track
+func (e *export) writeMusicXML(w io.Writer, data *smf.SMF) error {
+	s := newScore(data, e.divisions())
+	doc := xmlScore{Version: "4.0"}
+	for k, p := range s.parts {
+		id := fmt.Sprintf("P%d", k+1)
+		doc.Parts = append(doc.Parts, musicXMLPartListEntry(id, p))
+		doc.Measures = append(doc.Measures, xmlPart{ID: id, Measures: s.musicXMLMeasures(id, p, k == 0)})
+	}
+	if _, err := io.WriteString(w, xml.Header+musicXMLDoctype); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func musicXMLPartListEntry(id string, p scorePart) xmlScorePart {
+	entry := xmlScorePart{ID: id, Name: p.instrument}
+	if p.name != "" {
+		entry.Name = p.name
+	}
+	if !p.percussion {
+		entry.Instruments = []xmlScoreInstrument{{ID: id + "-I1", Name: p.instrument}}
+		entry.MIDIInstruments = []xmlMIDIInstrument{{ID: id + "-I1", Channel: int(p.channel) + 1, Program: int(p.program) + 1}}
+		return entry
+	}
+	for _, pitch := range p.drums() {
+		instrumentID := fmt.Sprintf("%s-I%d", id, pitch+1)
+		entry.Instruments = append(entry.Instruments, xmlScoreInstrument{ID: instrumentID, Name: drumName(pitch)})
+		entry.MIDIInstruments = append(entry.MIDIInstruments,
+			xmlMIDIInstrument{ID: instrumentID, Channel: int(p.channel) + 1, Unpitched: int(pitch) + 1})
+	}
+	return entry
+}
+
+func (s *score) musicXMLMeasures(id string, p scorePart, withTempos bool) []xmlMeasure {
+	var measures []xmlMeasure
+	for k, measure := range s.measures {
+		xm := xmlMeasure{Number: measure.number}
+		attributes := xmlAttributes{}
+		changed := false
+		if k == 0 {
+			attributes.Divisions = s.divisions
+			attributes.Clef = &xmlClef{Sign: "G", Line: 2}
+			switch {
+			case p.percussion:
+				attributes.Clef = &xmlClef{Sign: "percussion"}
+			case p.bassClef:
+				attributes.Clef = &xmlClef{Sign: "F", Line: 4}
+			}
+			changed = true
+		}
+		if !p.percussion && (k == 0 || measure.key != s.measures[k-1].key) {
+			fifths := int(measure.key.Num)
+			if measure.key.IsFlat {
+				fifths = -fifths
+			}
+			mode := "minor"
+			if measure.key.IsMajor {
+				mode = "major"
+			}
+			attributes.Key = &xmlKey{Fifths: fifths, Mode: mode}
+			changed = true
+		}
+		if k == 0 || measure.numerator != s.measures[k-1].numerator || measure.denominator != s.measures[k-1].denominator {
+			attributes.Time = &xmlTime{Beats: int(measure.numerator), BeatType: int(measure.denominator)}
+			changed = true
+		}
+		if changed {
+			xm.Content = append(xm.Content, attributes)
+		}
+		if withTempos {
+			for _, t := range measure.tempos {
+				bpm := strconv.FormatFloat(math.Round(t.bpm*100)/100, 'f', -1, 64)
+				xm.Content = append(xm.Content, xmlDirection{
+					Placement: "above",
+					BeatUnit:  "quarter",
+					PerMinute: bpm,
+					Offset:    t.offset,
+					Sound:     xmlSound{Tempo: bpm},
+				})
+			}
+		}
+		for v, voice := range p.measures[k] {
+			if v > 0 {
+				xm.Content = append(xm.Content, xmlBackup{Duration: measure.length})
+			}
+			for _, event := range voice.events {
+				xm.Content = append(xm.Content, musicXMLNotes(id, measure.key, p.percussion, voice.number, event)...)
+			}
+		}
+		measures = append(measures, xm)
+	}
+	return measures
+}
+
+// musicXMLNotes renders the event as a rest, a note, or the notes of a chord
+func musicXMLNotes(id string, k smf.Key, percussion bool, voice int, event scoreEvent) []any {
+	base := xmlNote{Duration: event.duration, Voice: voice}
+	if !event.wholeMeasure {
+		base.Type = musicXMLTypes[event.value.denominator]
+		base.Dots = make([]struct{}, event.value.dots)
+	}
+	if len(event.pitches) == 0 {
+		base.Rest = &xmlRest{}
+		if event.wholeMeasure {
+			base.Rest.Measure = "yes"
+		}
+		return []any{base}
+	}
+	if event.tieStop {
+		base.Ties = append(base.Ties, xmlTie{Type: "stop"})
+	}
+	if event.tieStart {
+		base.Ties = append(base.Ties, xmlTie{Type: "start"})
+	}
+	if len(base.Ties) > 0 {
+		base.Notations = &xmlNotations{Tied: base.Ties}
+	}
+	// chords are written from the top down
+	notes := make([]any, 0, len(event.pitches))
+	for j := len(event.pitches) - 1; j >= 0; j-- {
+		n := base
+		pitch := event.pitches[j]
+		if j < len(event.pitches)-1 {
+			n.Chord = &struct{}{}
+		}
+		if percussion {
+			display, found := drumDisplay[pitch]
+			if !found {
+				display.step, display.octave = "E", 5
+			}
+			n.Unpitched = &xmlUnpitched{Step: display.step, Octave: display.octave}
+			n.Notehead = display.notehead
+			n.Instrument = &xmlInstrumentRef{ID: fmt.Sprintf("%s-I%d", id, pitch+1)}
+		} else {
+			step, alter, octave := spellPitch(k, pitch)
+			n.Pitch = &xmlPitch{Step: step, Alter: alter, Octave: octave}
+		}
+		notes = append(notes, n)
+	}
+	return notes
+}
+
+// drums returns the distinct drums played in a percussion part, in ascending
+// order
+func (p scorePart) drums() []uint8 {
+	used := map[uint8]bool{}
+	for _, voices := range p.measures {
+		for _, voice := range voices {
+			for _, event := range voice.events {
+				for _, pitch := range event.pitches {
+					used[pitch] = true
+				}
+			}
+		}
+	}
+	drums := make([]uint8, 0, len(used))
+	for pitch := range used {
+		drums = append(drums, pitch)
+	}
+	sort.Slice(drums, func(i, j int) bool { return drums[i] < drums[j] })
+	return drums
+}
+
+// drumName turns the General MIDI drum's name, e.g., "CLOSED_HI_HAT", into a
+// display name, e.g., "Closed hi hat"
+func drumName(pitch uint8) string {
+	name, found := pNotes[pitch]
+	if !found {
+		return fmt.Sprintf("Percussion %d", pitch)
+	}
+	name = strings.ToLower(strings.ReplaceAll(name, "_", " "))
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_export_writeMusicXML(t *testing.T) {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaKey(5, true, 1, true))
+	conductor.Add(0, smf.MetaTempo(100))
+	var melody smf.Track
+	melody.Add(0, smf.MetaTrackSequenceName("Lead"))
+	melody.Add(0, midi.ProgramChange(0, 40))
+	melody.Add(0, midi.NoteOn(0, 70, 80))
+	melody.Add(2400, midi.NoteOff(0, 70))
+	var drums smf.Track
+	drums.Add(0, midi.NoteOn(9, 36, 100))
+	drums.Add(0, midi.NoteOn(9, 42, 100))
+	drums.Add(240, midi.NoteOff(9, 36))
+	drums.Add(0, midi.NoteOff(9, 42))
+	var b bytes.Buffer
+	if err := (&export{quantize: 16}).writeMusicXML(&b, makeTestSMF(conductor, melody, drums)); err != nil {
+		t.Fatalf("export.writeMusicXML() error = %v", err)
+	}
+	got := b.String()
+	if !strings.HasPrefix(got, xml.Header+musicXMLDoctype) {
+		t.Errorf("export.writeMusicXML() does not start with the XML declaration and doctype")
+	}
+	var doc struct {
+		Parts []struct {
+			ID   string `xml:"id,attr"`
+			Name string `xml:"part-name"`
+		} `xml:"part-list>score-part"`
+		Measures []struct {
+			ID       string `xml:"id,attr"`
+			Measures []struct {
+				Fifths []int `xml:"attributes>key>fifths"`
+				Notes  []struct {
+					Step        string           `xml:"pitch>step"`
+					Alter       int              `xml:"pitch>alter"`
+					Octave      int              `xml:"pitch>octave"`
+					DisplayStep string           `xml:"unpitched>display-step"`
+					Instrument  xmlInstrumentRef `xml:"instrument"`
+					Duration    int              `xml:"duration"`
+					Type        string           `xml:"type"`
+					Ties        []xmlTie         `xml:"tie"`
+					Rest        *struct{}        `xml:"rest"`
+				} `xml:"note"`
+			} `xml:"measure"`
+		} `xml:"part"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("export.writeMusicXML() wrote invalid XML: %v", err)
+	}
+	if len(doc.Parts) != 2 || doc.Parts[0].Name != "Lead" || doc.Parts[1].Name != "Drum kit" {
+		t.Fatalf("export.writeMusicXML() parts = %+v", doc.Parts)
+	}
+	lead := doc.Measures[0].Measures
+	if len(lead) != 2 {
+		t.Fatalf("export.writeMusicXML() lead has %d measures, want 2", len(lead))
+	}
+	if len(lead[0].Fifths) != 1 || lead[0].Fifths[0] != -1 {
+		t.Errorf("export.writeMusicXML() key fifths = %v, want [-1]", lead[0].Fifths)
+	}
+	first := lead[0].Notes[0]
+	if first.Step != "B" || first.Alter != -1 || first.Octave != 4 || first.Type != "whole" || len(first.Ties) != 1 {
+		t.Errorf("export.writeMusicXML() first note = %+v, want a whole B♭4 with a tie", first)
+	}
+	second := lead[1].Notes[0]
+	if second.Type != "quarter" || len(second.Ties) != 1 || second.Ties[0].Type != "stop" {
+		t.Errorf("export.writeMusicXML() tied note = %+v, want a quarter ending a tie", second)
+	}
+	kit := doc.Measures[1].Measures[0].Notes
+	if kit[0].DisplayStep != "G" || kit[0].Instrument.ID != "P2-I43" || kit[1].DisplayStep != "F" || kit[1].Instrument.ID != "P2-I37" {
+		t.Errorf("export.writeMusicXML() drums = %+v", kit[:2])
+	}
+	if rest := doc.Measures[1].Measures[1].Notes[0]; rest.Rest == nil || rest.Duration != 16 {
+		t.Errorf("export.writeMusicXML() drum measure 2 = %+v, want a measure rest", rest)
+	}
+	for _, want := range []string{"<per-minute>100</per-minute>", "<midi-program>41</midi-program>", "<notehead>x</notehead>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("export.writeMusicXML() output lacks %s", want)
+		}
+	}
+}
+
+func Test_drumName(t *testing.T) {
+	if got := drumName(42); got != "Closed hi hat" {
+		t.Errorf("drumName(42) = %q, want \"Closed hi hat\"", got)
+	}
+	if got := drumName(20); got != "Percussion 20" {
+		t.Errorf("drumName(20) = %q, want \"Percussion 20\"", got)
+	}
+}
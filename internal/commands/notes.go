@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// note is a note on event paired with its matching note end
+type note struct {
+	track    int
+	channel  uint8
+	pitch    uint8
+	velocity uint8
+	start    int64
+	end      int64
+}
+
+// collectNotes pairs the note on and note end events of every track, matching
+// each note end with the earliest unmatched note on of the same track, channel,
+// and pitch. Notes left sounding are ended at the end of their track. The notes
+// are returned sorted by start, then track, channel, and pitch.
+func collectNotes(data *smf.SMF) []note {
+	var notes []note
+	for trackNumber, track := range data.Tracks {
+		notes = append(notes, collectTrackNotes(trackNumber, track)...)
+	}
+	sortNotes(notes)
+	return notes
+}
+
+func collectTrackNotes(trackNumber int, track smf.Track) []note {
+	type voice struct {
+		channel uint8
+		pitch   uint8
+	}
+	var notes []note
+	sounding := map[voice][]int{}
+	ticks := absoluteTicks(track)
+	var end int64
+	for k, event := range track {
+		end = ticks[k]
+		var channel, pitch, velocity uint8
+		switch {
+		case event.Message.GetNoteStart(&channel, &pitch, &velocity):
+			v := voice{channel: channel, pitch: pitch}
+			sounding[v] = append(sounding[v], len(notes))
+			notes = append(notes, note{
+				track:    trackNumber,
+				channel:  channel,
+				pitch:    pitch,
+				velocity: velocity,
+				start:    ticks[k],
+				end:      -1,
+			})
+		case event.Message.GetNoteEnd(&channel, &pitch):
+			v := voice{channel: channel, pitch: pitch}
+			if pending := sounding[v]; len(pending) > 0 {
+				notes[pending[0]].end = ticks[k]
+				sounding[v] = pending[1:]
+			}
+		}
+	}
+	for k := range notes {
+		if notes[k].end < 0 {
+			notes[k].end = end
+		}
+	}
+	return notes
+}
+
+func sortNotes(notes []note) {
+	sort.SliceStable(notes, func(i, j int) bool {
+		a, b := notes[i], notes[j]
+		switch {
+		case a.start != b.start:
+			return a.start < b.start
+		case a.track != b.track:
+			return a.track < b.track
+		case a.channel != b.channel:
+			return a.channel < b.channel
+		default:
+			return a.pitch < b.pitch
+		}
+	})
+}
+
+// maxPolyphony returns the largest number of the notes sounding at once; a
+// note ending at the same tick another starts does not overlap it
+func maxPolyphony(notes []note) int {
+	type edge struct {
+		tick  int64
+		delta int
+	}
+	edges := make([]edge, 0, 2*len(notes))
+	for _, n := range notes {
+		edges = append(edges, edge{tick: n.start, delta: 1}, edge{tick: n.end, delta: -1})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].tick != edges[j].tick {
+			return edges[i].tick < edges[j].tick
+		}
+		return edges[i].delta < edges[j].delta
+	})
+	current, highest := 0, 0
+	for _, e := range edges {
+		current += e.delta
+		highest = max(highest, current)
+	}
+	return highest
+}
+
+// firstKey returns the first key signature in the file, or C major if there
+// is none
+func firstKey(data *smf.SMF) smf.Key {
+	var found smf.Key
+	earliest := int64(-1)
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var key smf.Key
+			if event.Message.GetMetaKey(&key) && (earliest < 0 || ticks[k] < earliest) {
+				found = key
+				earliest = ticks[k]
+			}
+		}
+	}
+	if earliest < 0 {
+		return smf.Key{IsMajor: true}
+	}
+	return found
+}
+
+// lastTick returns the tick of the last event in the file
+func lastTick(data *smf.SMF) int64 {
+	var last int64
+	for _, track := range data.Tracks {
+		if ticks := absoluteTicks(track); len(ticks) > 0 {
+			last = max(last, ticks[len(ticks)-1])
+		}
+	}
+	return last
+}
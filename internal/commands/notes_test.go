@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_collectNotes(t *testing.T) {
+	var track0 smf.Track
+	track0.Add(0, midi.NoteOn(0, 60, 80))
+	track0.Add(0, midi.NoteOn(0, 60, 90))
+	track0.Add(240, midi.NoteOff(0, 60))
+	track0.Add(240, midi.NoteOn(0, 60, 0))
+	track0.Add(0, midi.NoteOn(1, 62, 70))
+	var track1 smf.Track
+	track1.Add(120, midi.NoteOn(9, 36, 100))
+	track1.Add(120, midi.NoteOff(9, 36))
+	want := []note{
+		{track: 0, channel: 0, pitch: 60, velocity: 80, start: 0, end: 240},
+		{track: 0, channel: 0, pitch: 60, velocity: 90, start: 0, end: 480},
+		{track: 1, channel: 9, pitch: 36, velocity: 100, start: 120, end: 240},
+		{track: 0, channel: 1, pitch: 62, velocity: 70, start: 480, end: 480},
+	}
+	if got := collectNotes(makeTestSMF(track0, track1)); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectNotes() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_maxPolyphony(t *testing.T) {
+	tests := map[string]struct {
+		notes []note
+		want  int
+	}{
+		"none":     {},
+		"one":      {notes: []note{{start: 0, end: 10}}, want: 1},
+		"abutting": {notes: []note{{start: 0, end: 10}, {start: 10, end: 20}}, want: 1},
+		"chord":    {notes: []note{{start: 0, end: 10}, {start: 0, end: 10}, {start: 5, end: 20}}, want: 3},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := maxPolyphony(tt.notes); got != tt.want {
+				t.Errorf("maxPolyphony() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_firstKey(t *testing.T) {
+	var late, early smf.Track
+	late.Add(960, smf.MetaKey(0, true, 2, false))
+	early.Add(480, smf.MetaKey(0, false, 3, true))
+	tests := map[string]struct {
+		data *smf.SMF
+		want smf.Key
+	}{
+		"none":     {data: makeTestSMF(smf.Track{}), want: smf.Key{IsMajor: true}},
+		"earliest": {data: makeTestSMF(late, early), want: smf.Key{Key: 0, Num: 3, IsFlat: true}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := firstKey(tt.data); got != tt.want {
+				t.Errorf("firstKey() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
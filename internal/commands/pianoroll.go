@@ -0,0 +1,341 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const (
+	// pianoRollMargin surrounds the picture, in pixels
+	pianoRollMargin = 4
+	// pianoRollMaxPixels limits the size of a PNG image
+	pianoRollMaxPixels = 100_000_000
+)
+
+var (
+	pianoRollText       = color.RGBA{R: 0x33, G: 0x33, B: 0x33, A: 0xFF}
+	pianoRollBlackKey   = color.RGBA{R: 0xF0, G: 0xF0, B: 0xF0, A: 0xFF}
+	pianoRollOctaveLine = color.RGBA{R: 0xD0, G: 0xD0, B: 0xD0, A: 0xFF}
+	pianoRollBeatLine   = color.RGBA{R: 0xE4, G: 0xE4, B: 0xE4, A: 0xFF}
+	pianoRollBarLine    = color.RGBA{R: 0x99, G: 0x99, B: 0x99, A: 0xFF}
+	pianoRollTempoMark  = color.RGBA{R: 0xC0, G: 0x50, B: 0x20, A: 0xFF}
+)
+
+// channelColors holds a distinct color for each channel
+var channelColors = [16]color.RGBA{
+	{0x1F, 0x77, 0xB4, 0xFF}, {0xFF, 0x7F, 0x0E, 0xFF}, {0x2C, 0xA0, 0x2C, 0xFF}, {0xD6, 0x27, 0x28, 0xFF},
+	{0x94, 0x67, 0xBD, 0xFF}, {0x8C, 0x56, 0x4B, 0xFF}, {0xE3, 0x77, 0xC2, 0xFF}, {0x7F, 0x7F, 0x7F, 0xFF},
+	{0xBC, 0xBD, 0x22, 0xFF}, {0x17, 0xBE, 0xCF, 0xFF}, {0x39, 0x3B, 0x79, 0xFF}, {0x63, 0x79, 0x39, 0xFF},
+	{0x8C, 0x6D, 0x31, 0xFF}, {0x84, 0x3C, 0x39, 0xFF}, {0x7B, 0x41, 0x73, 0xFF}, {0x31, 0x82, 0xBD, 0xFF},
+}
+
+// velocityColor shades a velocity from blue (soft), through gray, to red
+// (loud)
+func velocityColor(velocity uint8) color.RGBA {
+	soft, middle, loud := [3]float64{0x3B, 0x4C, 0xC0}, [3]float64{0xCC, 0xCC, 0xCC}, [3]float64{0xB4, 0x04, 0x26}
+	f := float64(velocity) / 127
+	from, to := soft, middle
+	if f >= 0.5 {
+		from, to, f = middle, loud, f-0.5
+	}
+	f *= 2
+	mix := func(k int) uint8 { return uint8(math.Round(from[k] + (to[k]-from[k])*f)) }
+	return color.RGBA{R: mix(0), G: mix(1), B: mix(2), A: 0xFF}
+}
+
+// pianoRoll draws the notes of files over pitch and time, as SVG or PNG
+type pianoRoll struct {
+	outFile   string
+	format    string
+	colorBy   string
+	zoom      float64
+	keyHeight int
+	selection selectionFlags
+}
+
+func newPianoRoll() command {
+	return &pianoRoll{}
+}
+
+func (p *pianoRoll) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&p.outFile, "output", "o", "", "file to write (default: the input file with the format's extension)")
+	flags.StringVar(&p.format, "format", "svg", "image format: svg or png")
+	flags.StringVar(&p.colorBy, "color", "channel", "color the notes by channel or by velocity")
+	flags.Float64Var(&p.zoom, "zoom", 24, "width of a quarter note, in pixels")
+	flags.IntVar(&p.keyHeight, "key-height", 6, "height of each pitch, in pixels")
+	p.selection.define(flags)
+}
+
+func (p *pianoRoll) run(o output.Bus, args []string) int {
+	valid := true
+	if p.format != "svg" && p.format != "png" {
+		o.ErrorPrintf("The --format value %q is not valid: it must be svg or png.\n", p.format)
+		valid = false
+	}
+	if p.colorBy != "channel" && p.colorBy != "velocity" {
+		o.ErrorPrintf("The --color value %q is not valid: it must be channel or velocity.\n", p.colorBy)
+		valid = false
+	}
+	if p.zoom <= 0 || p.zoom > 1000 {
+		o.ErrorPrintf("The --zoom value %g is not valid: it must be more than 0 and at most 1000.\n", p.zoom)
+		valid = false
+	}
+	if p.keyHeight < 1 || p.keyHeight > 100 {
+		o.ErrorPrintf("The --key-height value %d is not valid: it must be from 1 to 100.\n", p.keyHeight)
+		valid = false
+	}
+	if err := p.selection.validate(); err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		valid = false
+	}
+	if p.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		valid = false
+	}
+	if !valid {
+		return exitUserError
+	}
+	return processFiles(o, args, p.processFile)
+}
+
+func (p *pianoRoll) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	selection, err := p.selection.build(newMeter(data))
+	if err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		return false
+	}
+	d, err := p.draw(data, selection)
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be drawn: %v.\n", path, err)
+		return false
+	}
+	destination := p.outFile
+	if destination == "" {
+		destination = strings.TrimSuffix(path, filepath.Ext(path)) + "." + p.format
+	}
+	if err := writeDrawingFile(destination, d, p.format); err != nil {
+		o.ErrorPrintf("The file %q cannot be written: %v.\n", destination, err)
+		o.Log(output.Error, "cannot write file", map[string]any{"file": destination, "error": err})
+		return false
+	}
+	o.ConsolePrintf("%s: drew %d by %d pixels to %s\n", path, d.width, d.height, destination)
+	return true
+}
+
+func writeDrawingFile(path string, d *drawing, format string) (err error) {
+	f, err := fileSystem.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return writeDrawing(f, d, format)
+}
+
+func writeDrawing(w io.Writer, d *drawing, format string) error {
+	if format == "png" {
+		b := bufio.NewWriter(w)
+		if err := d.writePNG(b); err != nil {
+			return err
+		}
+		return b.Flush()
+	}
+	return d.writeSVG(w)
+}
+
+// pianoRollPart is the notes of one channel of one track
+type pianoRollPart struct {
+	track   int
+	channel uint8
+	name    string
+}
+
+// draw lays out the selected notes: a column of octave labels on the left,
+// bar numbers and tempo changes above, the notes over shaded black key rows
+// and bar and beat lines, and a legend of the parts below
+func (p *pianoRoll) draw(data *smf.SMF, selection eventSelection) (*drawing, error) {
+	m := newMeter(data)
+	tempos := newTempoMap(data)
+	from, to := selection.span.from, selection.span.to
+	notes := selection.selectNotes(collectNotes(data))
+	var end int64
+	for _, n := range notes {
+		end = max(end, n.end)
+	}
+	if len(notes) == 0 {
+		return nil, errors.New("there are no notes to draw")
+	}
+	if to < 0 {
+		to = max(end, lastTick(data))
+	}
+	if to <= from {
+		return nil, errors.New("the time range is empty")
+	}
+	low, high := selection.lowPitch, selection.highPitch
+	if low == 0 && high == 127 {
+		low, high = notes[0].pitch, notes[0].pitch
+		for _, n := range notes {
+			low, high = min(low, n.pitch), max(high, n.pitch)
+		}
+	}
+	parts := pianoRollParts(data, notes)
+	keyHeight := float64(p.keyHeight)
+	left := float64(pianoRollMargin) + textWidth("C10") + 4
+	top := float64(pianoRollMargin + 2*lineHeight + 4)
+	x := func(tick int64) float64 {
+		return left + float64(tick-from)*p.zoom/float64(m.ticksPerQuarter)
+	}
+	y := func(pitch uint8) float64 {
+		return top + float64(high-pitch)*keyHeight
+	}
+	bottom := y(low) + keyHeight
+	labels := make([]string, len(parts))
+	var legendWidth float64
+	if p.colorBy == "velocity" {
+		legendWidth = textWidth("velocity 1 ") + 127 + charWidth + textWidth("127")
+	}
+	for k, part := range parts {
+		labels[k] = fmt.Sprintf("track %d channel %d", part.track, part.channel)
+		if part.name != "" {
+			labels[k] += ": " + part.name
+		}
+		legendWidth = max(legendWidth, lineHeight+textWidth(labels[k]))
+	}
+	legendRows := len(parts)
+	if p.colorBy == "velocity" {
+		legendRows++
+	}
+	d := &drawing{
+		width:  int(math.Ceil(max(x(to), pianoRollMargin+legendWidth))) + pianoRollMargin,
+		height: int(bottom) + 4 + legendRows*lineHeight + pianoRollMargin,
+	}
+	if d.width*d.height > pianoRollMaxPixels {
+		return nil, fmt.Errorf("the picture would be %d by %d pixels; use a smaller --zoom or --key-height, or a "+
+			"shorter range", d.width, d.height)
+	}
+	width := x(to) - left
+	for pitch := int(low); pitch <= int(high); pitch++ {
+		switch pitch % 12 {
+		case 1, 3, 6, 8, 10:
+			d.rect(left, y(uint8(pitch)), width, keyHeight, pianoRollBlackKey, "")
+		case 0:
+			d.rect(left, y(uint8(pitch))+keyHeight-1, width, 1, pianoRollOctaveLine, "")
+			if keyHeight*12 >= lineHeight {
+				d.text(pianoRollMargin, y(uint8(pitch))+keyHeight-lineHeight, fmt.Sprintf("C%d", pitch/12),
+					pianoRollText)
+			}
+		}
+	}
+	// bar and beat lines, with the bar numbers above
+	bar, _, _ := m.locate(from)
+	labelEnd := math.Inf(-1)
+	for start := m.barStart(bar); start < to; bar, start = bar+1, m.barStart(bar+1) {
+		c := m.changeAtBar(bar)
+		next := m.barStart(bar + 1)
+		for beat := start + m.beatTicks(c); beat < min(next, to); beat += m.beatTicks(c) {
+			if beat > from {
+				d.rect(x(beat), top, 1, bottom-top, pianoRollBeatLine, "")
+			}
+		}
+		if start < from {
+			continue
+		}
+		d.rect(x(start), top, 1, bottom-top, pianoRollBarLine, "")
+		if label := fmt.Sprintf("%d", bar); x(start) >= labelEnd {
+			d.text(x(start), pianoRollMargin, label, pianoRollText)
+			labelEnd = x(start) + textWidth(label) + charWidth
+		}
+	}
+	// the tempo at the start, and its changes
+	labelEnd = math.Inf(-1)
+	for k, c := range tempos.changes {
+		if c.tick >= to || k+1 < len(tempos.changes) && tempos.changes[k+1].tick <= from {
+			continue
+		}
+		tick := max(c.tick, from)
+		label := fmt.Sprintf("%g BPM", math.Round(c.bpm*100)/100)
+		d.rect(x(tick), pianoRollMargin+lineHeight, 1, lineHeight, pianoRollTempoMark, "")
+		if x(tick) >= labelEnd {
+			d.text(x(tick)+2, pianoRollMargin+lineHeight, label, pianoRollTempoMark)
+			labelEnd = x(tick) + 2 + textWidth(label) + charWidth
+		}
+	}
+	key := firstKey(data)
+	speller := &read{key: &key}
+	for _, n := range notes {
+		start, stop := x(max(n.start, from)), x(min(n.end, to))
+		c := channelColors[n.channel]
+		if p.colorBy == "velocity" {
+			c = velocityColor(n.velocity)
+		}
+		title := fmt.Sprintf("%s, velocity %d, track %d, channel %d, %s", speller.asNote(n.channel, n.pitch),
+			n.velocity, n.track, n.channel, m.describe(n.start))
+		d.rect(start, y(n.pitch), max(stop-start, 1), max(keyHeight-1, 1), c, title)
+	}
+	legend := bottom + 4
+	if p.colorBy == "velocity" {
+		d.text(pianoRollMargin, legend, "velocity 1", pianoRollText)
+		scale := pianoRollMargin + textWidth("velocity 1 ")
+		for v := 1; v <= 127; v++ {
+			d.rect(scale+float64(v-1), legend+2, 1, lineHeight-4, velocityColor(uint8(v)), "")
+		}
+		d.text(scale+127+charWidth, legend, "127", pianoRollText)
+		legend += lineHeight
+	}
+	for k, part := range parts {
+		textLeft := float64(pianoRollMargin)
+		if p.colorBy == "channel" {
+			d.rect(pianoRollMargin, legend+2, lineHeight-4, lineHeight-4, channelColors[part.channel], "")
+			textLeft += lineHeight
+		}
+		d.text(textLeft, legend, labels[k], pianoRollText)
+		legend += lineHeight
+	}
+	return d, nil
+}
+
+// pianoRollParts lists the tracks and channels of the notes, in order, named
+// for their tracks
+func pianoRollParts(data *smf.SMF, notes []note) []pianoRollPart {
+	found := map[pianoRollPart]bool{}
+	var parts []pianoRollPart
+	for _, n := range notes {
+		part := pianoRollPart{track: n.track, channel: n.channel}
+		if !found[part] {
+			found[part] = true
+			parts = append(parts, part)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].track != parts[j].track {
+			return parts[i].track < parts[j].track
+		}
+		return parts[i].channel < parts[j].channel
+	})
+	for k := range parts {
+		for _, event := range data.Tracks[parts[k].track] {
+			if parts[k].name == "" {
+				_ = event.Message.GetMetaTrackName(&parts[k].name)
+			}
+		}
+	}
+	return parts
+}
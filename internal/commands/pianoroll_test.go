@@ -0,0 +1,361 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// pianoRollTestSMF holds a melody in 3/4, a drum track, and a tempo change at
+// bar 3
+func pianoRollTestSMF() *smf.SMF {
+	data := smf.NewSMF1()
+	data.TimeFormat = smf.MetricTicks(480)
+	var conductor, melody, drums smf.Track
+	conductor.Add(0, smf.MetaMeter(3, 4))
+	conductor.Add(0, smf.MetaTempo(120))
+	conductor.Add(2880, smf.MetaTempo(90))
+	conductor.Close(1440)
+	melody.Add(0, smf.MetaTrackSequenceName("Melody"))
+	melody.Add(0, midi.NoteOn(0, 60, 100))
+	melody.Add(480, midi.NoteOff(0, 60))
+	melody.Add(960, midi.NoteOn(0, 64, 20))
+	melody.Add(480, midi.NoteOff(0, 64))
+	melody.Add(480, midi.NoteOn(0, 67, 127))
+	melody.Add(0, midi.NoteOff(0, 67))
+	melody.Close(0)
+	drums.Add(480, midi.NoteOn(9, 36, 90))
+	drums.Add(120, midi.NoteOff(9, 36))
+	drums.Close(0)
+	_ = data.Add(conductor)
+	_ = data.Add(melody)
+	_ = data.Add(drums)
+	return data
+}
+
+func drawingTexts(d *drawing) []string {
+	var texts []string
+	for _, item := range d.items {
+		if item.text != "" {
+			texts = append(texts, item.text)
+		}
+	}
+	return texts
+}
+
+func drawingNotes(d *drawing) []drawingItem {
+	var notes []drawingItem
+	for _, item := range d.items {
+		if item.title != "" {
+			notes = append(notes, item)
+		}
+	}
+	return notes
+}
+
+func Test_pianoRoll_draw(t *testing.T) {
+	tests := map[string]struct {
+		p          *pianoRoll
+		selection  selectionFlags
+		wantWidth  int
+		wantHeight int
+		wantNotes  []string
+		wantTexts  []string
+		check      func(t *testing.T, notes []drawingItem)
+	}{
+		"everything": {
+			p: &pianoRoll{colorBy: "channel", zoom: 24, keyHeight: 6},
+			// 3 bars of 3 quarter notes, from G5 down to C3
+			wantWidth:  4 + 24 + 4 + 216 + 4,
+			wantHeight: 4 + 24 + 4 + 32*6 + 4 + 2*12 + 4,
+			wantNotes: []string{
+				"C5, velocity 100, track 1, channel 0, bar 1 beat 1",
+				"BASS_DRUM, velocity 90, track 2, channel 9, bar 1 beat 2",
+				"E5, velocity 20, track 1, channel 0, bar 2 beat 1",
+				"G5, velocity 127, track 1, channel 0, bar 2 beat 3",
+			},
+			wantTexts: []string{"C3", "C4", "C5", "1", "2", "3", "120 BPM", "90 BPM", "track 1 channel 0: Melody",
+				"track 2 channel 9"},
+			check: func(t *testing.T, notes []drawingItem) {
+				if got, want := notes[0].color, channelColors[0]; got != want {
+					t.Errorf("pianoRoll.draw() melody color = %v, want %v", got, want)
+				}
+				if got, want := notes[1].color, channelColors[9]; got != want {
+					t.Errorf("pianoRoll.draw() drum color = %v, want %v", got, want)
+				}
+				// a quarter note is 24 pixels wide; C5 is seven rows below G5
+				if n := notes[0]; n.x != 32 || n.w != 24 || n.y != 32+42 || n.h != 5 {
+					t.Errorf("pianoRoll.draw() C5 at %g, %g, %g by %g", n.x, n.y, n.w, n.h)
+				}
+				// a note without duration is still a pixel wide
+				if n := notes[3]; n.w != 1 {
+					t.Errorf("pianoRoll.draw() G5 width = %g, want 1", n.w)
+				}
+			},
+		},
+		"by velocity, zoomed, in a range": {
+			p:         &pianoRoll{colorBy: "velocity", zoom: 48, keyHeight: 10},
+			selection: selectionFlags{tracks: "1", from: "2", to: "3", pitches: "C5..G5"},
+			// the velocity scale is wider than the three quarter notes
+			wantWidth:  4 + 88 + 127 + 8 + 24 + 4,
+			wantHeight: 4 + 24 + 4 + 8*10 + 4 + 2*12 + 4,
+			wantNotes: []string{
+				"E5, velocity 20, track 1, channel 0, bar 2 beat 1",
+				"G5, velocity 127, track 1, channel 0, bar 2 beat 3",
+			},
+			wantTexts: []string{"C5", "2", "120 BPM", "velocity 1", "127", "track 1 channel 0: Melody"},
+			check: func(t *testing.T, notes []drawingItem) {
+				if got, want := notes[0].color, velocityColor(20); got != want {
+					t.Errorf("pianoRoll.draw() color = %v, want %v", got, want)
+				}
+				if n := notes[0]; n.x != 32 || n.w != 48 || n.y != 32+30 || n.h != 9 {
+					t.Errorf("pianoRoll.draw() E5 at %g, %g, %g by %g", n.x, n.y, n.w, n.h)
+				}
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := pianoRollTestSMF()
+			selection, err := tt.selection.build(newMeter(data))
+			if err != nil {
+				t.Fatalf("selection error = %v", err)
+			}
+			d, err := tt.p.draw(data, selection)
+			if err != nil {
+				t.Fatalf("pianoRoll.draw() error = %v", err)
+			}
+			if d.width != tt.wantWidth || d.height != tt.wantHeight {
+				t.Errorf("pianoRoll.draw() size = %d by %d, want %d by %d", d.width, d.height, tt.wantWidth,
+					tt.wantHeight)
+			}
+			notes := drawingNotes(d)
+			var titles []string
+			for _, n := range notes {
+				titles = append(titles, n.title)
+			}
+			if got, want := strings.Join(titles, "\n"), strings.Join(tt.wantNotes, "\n"); got != want {
+				t.Errorf("pianoRoll.draw() notes:\n%s\nwant:\n%s", got, want)
+			}
+			if got, want := strings.Join(drawingTexts(d), "|"), strings.Join(tt.wantTexts, "|"); got != want {
+				t.Errorf("pianoRoll.draw() texts = %q, want %q", got, want)
+			}
+			if tt.check != nil && len(notes) == len(tt.wantNotes) {
+				tt.check(t, notes)
+			}
+		})
+	}
+}
+
+func Test_pianoRoll_draw_coarseResolution(t *testing.T) {
+	// one tick per quarter note is too coarse to divide into eighth note beats
+	var track smf.Track
+	track.Add(0, smf.MetaMeter(3, 8))
+	track.Add(0, midi.NoteOn(0, 60, 100))
+	track.Add(6, midi.NoteOff(0, 60))
+	data := makeTestSMF(track)
+	data.TimeFormat = smf.MetricTicks(1)
+	selection, err := (&selectionFlags{}).build(newMeter(data))
+	if err != nil {
+		t.Fatalf("selection error = %v", err)
+	}
+	d, err := (&pianoRoll{colorBy: "channel", zoom: 24, keyHeight: 6}).draw(data, selection)
+	if err != nil {
+		t.Fatalf("pianoRoll.draw() error = %v", err)
+	}
+	beats := 0
+	for _, item := range d.items {
+		if item.color == pianoRollBeatLine {
+			beats++
+		}
+	}
+	// a line at each tick but the bar lines at 0 and 3
+	if beats != 4 {
+		t.Errorf("pianoRoll.draw() drew %d beat lines, want 4", beats)
+	}
+}
+
+func Test_pianoRoll_draw_errors(t *testing.T) {
+	tests := map[string]struct {
+		p         *pianoRoll
+		selection selectionFlags
+		want      string
+	}{
+		"no notes": {
+			p:         &pianoRoll{colorBy: "channel", zoom: 24, keyHeight: 6},
+			selection: selectionFlags{channels: "5"},
+			want:      "there are no notes to draw",
+		},
+		"too big": {
+			p:         &pianoRoll{colorBy: "channel", zoom: 1000, keyHeight: 100},
+			selection: selectionFlags{to: "400"},
+			want: "the picture would be 1197036 by 3264 pixels; use a smaller --zoom or --key-height, or a " +
+				"shorter range",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := pianoRollTestSMF()
+			selection, err := tt.selection.build(newMeter(data))
+			if err != nil {
+				t.Fatalf("selection error = %v", err)
+			}
+			if _, err := tt.p.draw(data, selection); err == nil || err.Error() != tt.want {
+				t.Errorf("pianoRoll.draw() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_drawing_writeSVG(t *testing.T) {
+	d := &drawing{width: 20, height: 10}
+	d.rect(1, 2.5, 3, 4, color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xFF}, "")
+	d.rect(0.333, 0, 1, 1, color.RGBA{A: 0xFF}, "C5 & <E5>")
+	d.text(2, 3, "\"a\" < b", color.RGBA{R: 0xFF, A: 0xFF})
+	var b bytes.Buffer
+	if err := d.writeSVG(&b); err != nil {
+		t.Fatalf("drawing.writeSVG() error = %v", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="20" height="10" viewBox="0 0 20 10">
+<rect width="100%" height="100%" fill="#ffffff"/>
+<rect x="1" y="2.5" width="3" height="4" fill="#123456"/>
+<rect x="0.33" y="0" width="1" height="1" fill="#000000"><title>C5 &amp; &lt;E5&gt;</title></rect>
+<text x="2" y="13" font-family="monospace" font-size="10" fill="#ff0000">&quot;a&quot; &lt; b</text>
+</svg>
+`
+	if got := b.String(); got != want {
+		t.Errorf("drawing.writeSVG() = %s, want %s", got, want)
+	}
+}
+
+func Test_drawing_image(t *testing.T) {
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	blue := color.RGBA{B: 0xFF, A: 0xFF}
+	white := color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	d := &drawing{width: 30, height: 20}
+	d.rect(1, 1, 3, 2, red, "")
+	d.rect(10.2, 5, 0.1, 0.1, blue, "note")
+	d.text(20, 0, "1", red)
+	img := d.image()
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, white}, {1, 1, red}, {3, 2, red}, {4, 1, white}, {1, 3, white},
+		{10, 5, blue}, {11, 5, white}, {10, 6, white},
+		// the "1" glyph: .#. / ##. / .#. / .#. / ###, two pixels to a dot
+		{20, 0, white}, {22, 0, red}, {23, 1, red}, {24, 0, white}, {20, 2, red}, {20, 4, white}, {25, 9, red},
+	}
+	for _, tt := range tests {
+		if got := img.RGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("drawing.image() at %d, %d = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func Test_velocityColor(t *testing.T) {
+	tests := map[uint8]color.RGBA{
+		0:   {R: 0x3B, G: 0x4C, B: 0xC0, A: 0xFF},
+		127: {R: 0xB4, G: 0x04, B: 0x26, A: 0xFF},
+	}
+	for velocity, want := range tests {
+		if got := velocityColor(velocity); got != want {
+			t.Errorf("velocityColor(%d) = %v, want %v", velocity, got, want)
+		}
+	}
+	if got := velocityColor(64); got.R < 0xC0 || got.B < 0xC0 {
+		t.Errorf("velocityColor(64) = %v, want nearly gray", got)
+	}
+}
+
+func Test_pianoRoll_run(t *testing.T) {
+	tests := map[string]struct {
+		p    *pianoRoll
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"bad values": {
+			p:    &pianoRoll{format: "gif", colorBy: "track", zoom: 0, keyHeight: 0, outFile: "x.svg"},
+			args: []string{"a.mid", "b.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --format value \"gif\" is not valid: it must be svg or png.\n" +
+					"The --color value \"track\" is not valid: it must be channel or velocity.\n" +
+					"The --zoom value 0 is not valid: it must be more than 0 and at most 1000.\n" +
+					"The --key-height value 0 is not valid: it must be from 1 to 100.\n" +
+					"The --output flag may only be used with a single input file.\n",
+			},
+		},
+		"bad selection": {
+			p:    &pianoRoll{format: "svg", colorBy: "channel", zoom: 24, keyHeight: 6, selection: selectionFlags{from: "x"}},
+			args: []string{"a.mid", "b.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The selection is not valid: \"x\" is not a valid bar position.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.p.run(o, tt.args); got != tt.want {
+				t.Errorf("pianoRoll.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("pianoRoll.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_pianoRoll_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "song.mid")
+	if err := os.WriteFile(input, smfBytes(t, pianoRollTestSMF()), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	for _, format := range []string{"svg", "png"} {
+		t.Run(format, func(t *testing.T) {
+			o := output.NewRecorder()
+			p := &pianoRoll{format: format, colorBy: "channel", zoom: 24, keyHeight: 6}
+			if !p.processFile(o, input) {
+				t.Fatalf("pianoRoll.processFile() failed: %s", o.ErrorOutput())
+			}
+			destination := filepath.Join(dir, "song."+format)
+			if got, want := o.ConsoleOutput(), fmt.Sprintf("%s: drew 252 by 256 pixels to %s\n", input,
+				destination); got != want {
+				t.Errorf("pianoRoll.processFile() console = %q, want %q", got, want)
+			}
+			content, err := os.ReadFile(destination)
+			if err != nil {
+				t.Fatalf("cannot read the picture: %v", err)
+			}
+			if format == "svg" {
+				if !bytes.Contains(content, []byte("<title>C5, velocity 100, track 1, channel 0, bar 1 beat 1</title>")) {
+					t.Errorf("pianoRoll.processFile() wrote %s", content)
+				}
+				return
+			}
+			img, err := png.Decode(bytes.NewReader(content))
+			if err != nil {
+				t.Fatalf("png.Decode() error = %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != 252 || b.Dy() != 256 {
+				t.Errorf("pianoRoll.processFile() image is %d by %d", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+var pitchClasses = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// parseNote parses a note spelled the way asNote spells it (letter, optional
+// accidentals, octave, where C0 is MIDI note 0), or a raw MIDI note number
+func parseNote(s string) (uint8, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 || n > 127 {
+			return 0, fmt.Errorf("the note number %d is out of range", n)
+		}
+		return uint8(n), nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("an empty string is not a note")
+	}
+	class, found := pitchClasses[strings.ToUpper(s[:1])[0]]
+	if !found {
+		return 0, fmt.Errorf("%q is not a note", s)
+	}
+	rest := s[1:]
+	for {
+		switch {
+		case strings.HasPrefix(rest, "#"):
+			class++
+			rest = rest[1:]
+			continue
+		case strings.HasPrefix(rest, "♯"):
+			class++
+			rest = rest[len("♯"):]
+			continue
+		case strings.HasPrefix(rest, "b"):
+			class--
+			rest = rest[1:]
+			continue
+		case strings.HasPrefix(rest, "♭"):
+			class--
+			rest = rest[len("♭"):]
+			continue
+		}
+		break
+	}
+	octave, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, fmt.Errorf("%q does not have a valid octave", s)
+	}
+	n := octave*12 + class
+	if n < 0 || n > 127 {
+		return 0, fmt.Errorf("the note %q is out of range", s)
+	}
+	return uint8(n), nil
+}
+
+// spellPitchClass names the pitch class (0-11) using flats if the key
+// signature has flats, and sharps otherwise
+func spellPitchClass(k smf.Key, pitchClass uint8) string {
+	if k.IsFlat {
+		return minorKeys[pitchClass%12]
+	}
+	return majorKeys[pitchClass%12]
+}
+
+// spellPitch returns the letter, chromatic alteration (-1, 0, or 1), and
+// scientific octave (in which middle C, MIDI note 60, is C4) of the pitch, as
+// spelled in the key
+func spellPitch(k smf.Key, pitch uint8) (step string, alter int, octave int) {
+	name := spellPitchClass(k, pitch%12)
+	step = name[:1]
+	switch name[1:] {
+	case "♯":
+		alter = 1
+	case "♭":
+		alter = -1
+	}
+	return step, alter, int(pitch)/12 - 1
+}
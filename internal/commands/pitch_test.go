@@ -0,0 +1,35 @@
+package commands
+
+import "testing"
+
+func Test_parseNote(t *testing.T) {
+	tests := map[string]struct {
+		s       string
+		want    uint8
+		wantErr bool
+	}{
+		"middle C":     {s: "C5", want: 60},
+		"sharp":        {s: "C#5", want: 61},
+		"sharp symbol": {s: "C♯5", want: 61},
+		"flat":         {s: "Bb4", want: 58},
+		"flat symbol":  {s: "B♭4", want: 58},
+		"lower case":   {s: "g0", want: 7},
+		"number":       {s: "64", want: 64},
+		"too high":     {s: "G#10", wantErr: true},
+		"bad number":   {s: "128", wantErr: true},
+		"no octave":    {s: "C", wantErr: true},
+		"not a note":   {s: "H4", wantErr: true},
+		"empty":        {s: "", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseNote(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNote() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseNote() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// eventFacts are what a --where expression can ask about an event
+type eventFacts struct {
+	track   int
+	index   int
+	delta   uint32
+	tick    int64
+	bar     int
+	beat    int
+	message smf.Message
+	decoded dumpEvent
+}
+
+func newEventFacts(m *meter, track, index int, tick int64, event smf.Event) *eventFacts {
+	bar, beat, _ := m.locate(tick)
+	return &eventFacts{
+		track:   track,
+		index:   index,
+		delta:   event.Delta,
+		tick:    tick,
+		bar:     bar,
+		beat:    beat,
+		message: event.Message,
+		decoded: newDumpEvent(tick, event.Message),
+	}
+}
+
+// typeName names the message's type as read does
+func typeName(message smf.Message) string {
+	if message.Is(midi.SysExMsg) {
+		return "SysEx"
+	}
+	return message.Type().String()
+}
+
+// numericFields maps each numeric field to a function returning its value,
+// and whether the event has it
+var numericFields = map[string]func(f *eventFacts) (float64, bool){
+	"track": func(f *eventFacts) (float64, bool) { return float64(f.track), true },
+	"index": func(f *eventFacts) (float64, bool) { return float64(f.index), true },
+	"delta": func(f *eventFacts) (float64, bool) { return float64(f.delta), true },
+	"tick":  func(f *eventFacts) (float64, bool) { return float64(f.tick), true },
+	"bar":   func(f *eventFacts) (float64, bool) { return float64(f.bar), true },
+	"beat":  func(f *eventFacts) (float64, bool) { return float64(f.beat), true },
+	"channel": func(f *eventFacts) (float64, bool) {
+		var channel uint8
+		ok := f.message.GetChannel(&channel)
+		return float64(channel), ok
+	},
+	"key":        func(f *eventFacts) (float64, bool) { return optionalNumber(f.decoded.Key) },
+	"velocity":   func(f *eventFacts) (float64, bool) { return optionalNumber(f.decoded.Velocity) },
+	"controller": func(f *eventFacts) (float64, bool) { return optionalNumber(f.decoded.Controller) },
+	"value":      func(f *eventFacts) (float64, bool) { return optionalNumber(f.decoded.Value) },
+	"program":    func(f *eventFacts) (float64, bool) { return optionalNumber(f.decoded.Program) },
+	"pressure":   func(f *eventFacts) (float64, bool) { return optionalNumber(f.decoded.Pressure) },
+	"bend": func(f *eventFacts) (float64, bool) {
+		if f.decoded.Bend == nil {
+			return 0, false
+		}
+		return float64(*f.decoded.Bend), true
+	},
+	"bpm": func(f *eventFacts) (float64, bool) {
+		var bpm float64
+		ok := f.message.GetMetaTempo(&bpm)
+		return bpm, ok
+	},
+}
+
+func optionalNumber(v *uint8) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	return float64(*v), true
+}
+
+// textFields maps each text field to a function returning its value, and
+// whether the event has it
+var textFields = map[string]func(f *eventFacts) (string, bool){
+	"type": func(f *eventFacts) (string, bool) { return typeName(f.message), true },
+	"text": func(f *eventFacts) (string, bool) {
+		if f.decoded.Text == nil {
+			return "", false
+		}
+		return *f.decoded.Text, true
+	},
+}
+
+// flagFields are the conditions that stand alone
+var flagFields = map[string]func(f *eventFacts) bool{
+	"meta":  func(f *eventFacts) bool { return f.message.Is(smf.MetaMsg) },
+	"sysex": func(f *eventFacts) bool { return f.message.Is(midi.SysExMsg) },
+	"note": func(f *eventFacts) bool {
+		return f.message.Is(midi.NoteOnMsg) || f.message.Is(midi.NoteOffMsg)
+	},
+}
+
+// eventFilter is a compiled --where expression
+type eventFilter interface {
+	matches(f *eventFacts) bool
+}
+
+type filterAnd struct{ left, right eventFilter }
+
+func (a filterAnd) matches(f *eventFacts) bool { return a.left.matches(f) && a.right.matches(f) }
+
+type filterOr struct{ left, right eventFilter }
+
+func (o filterOr) matches(f *eventFacts) bool { return o.left.matches(f) || o.right.matches(f) }
+
+type filterNot struct{ inner eventFilter }
+
+func (n filterNot) matches(f *eventFacts) bool { return !n.inner.matches(f) }
+
+type filterFlag struct{ test func(f *eventFacts) bool }
+
+func (c filterFlag) matches(f *eventFacts) bool { return c.test(f) }
+
+// filterNumber compares a numeric field; an event without the field never
+// matches
+type filterNumber struct {
+	field     func(f *eventFacts) (float64, bool)
+	operator  string
+	low, high float64 // high is only used by "in"
+}
+
+func (c filterNumber) matches(f *eventFacts) bool {
+	v, ok := c.field(f)
+	if !ok {
+		return false
+	}
+	switch c.operator {
+	case "==":
+		return v == c.low
+	case "!=":
+		return v != c.low
+	case "<":
+		return v < c.low
+	case "<=":
+		return v <= c.low
+	case ">":
+		return v > c.low
+	case ">=":
+		return v >= c.low
+	default:
+		return v >= c.low && v <= c.high
+	}
+}
+
+// filterText compares a text field; type names are compared without regard
+// to case
+type filterText struct {
+	field    func(f *eventFacts) (string, bool)
+	equal    bool
+	value    string
+	foldCase bool
+}
+
+func (c filterText) matches(f *eventFacts) bool {
+	v, ok := c.field(f)
+	if !ok {
+		return false
+	}
+	same := v == c.value || c.foldCase && strings.EqualFold(v, c.value)
+	return same == c.equal
+}
+
+// filterToken is a word, a quoted string, or an operator
+type filterToken struct {
+	text     string
+	quoted   bool
+	operator bool
+}
+
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "..", "(", ")", "!", "<", ">"}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("the string at %q is not terminated", s)
+			}
+			text, _ := strconv.Unquote(quoted)
+			tokens = append(tokens, filterToken{text: text, quoted: true})
+			s = s[len(quoted):]
+			continue
+		}
+		operator := ""
+		for _, candidate := range filterOperators {
+			if strings.HasPrefix(s, candidate) {
+				operator = candidate
+				break
+			}
+		}
+		if operator != "" {
+			tokens = append(tokens, filterToken{text: operator, operator: true})
+			s = s[len(operator):]
+			continue
+		}
+		end := 0
+		for end < len(s) && !strings.ContainsRune(" \t()!=<>&|\"", rune(s[end])) && !strings.HasPrefix(s[end:], "..") {
+			end++
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("%q is not expected", s[:1])
+		}
+		tokens = append(tokens, filterToken{text: s[:end]})
+		s = s[end:]
+	}
+	return tokens, nil
+}
+
+// filterParser compiles tokens by recursive descent:
+//
+//	expression = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expression ")" | condition
+//	condition  = flag | field operator value | field "in" value ".." value
+type filterParser struct {
+	tokens []filterToken
+	next   int
+}
+
+// parseEventFilter compiles a --where expression
+func parseEventFilter(s string) (eventFilter, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("the expression is empty")
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.next < len(p.tokens) {
+		return nil, fmt.Errorf("%q is not expected", p.tokens[p.next].text)
+	}
+	return filter, nil
+}
+
+func (p *filterParser) peek(operator string) bool {
+	return p.next < len(p.tokens) && p.tokens[p.next].operator && p.tokens[p.next].text == operator
+}
+
+func (p *filterParser) take() (filterToken, error) {
+	if p.next >= len(p.tokens) {
+		return filterToken{}, errors.New("the expression ends too soon")
+	}
+	p.next++
+	return p.tokens[p.next-1], nil
+}
+
+func (p *filterParser) expression() (eventFilter, error) {
+	left, err := p.and()
+	for err == nil && p.peek("||") {
+		p.next++
+		var right eventFilter
+		if right, err = p.and(); err == nil {
+			left = filterOr{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (eventFilter, error) {
+	left, err := p.unary()
+	for err == nil && p.peek("&&") {
+		p.next++
+		var right eventFilter
+		if right, err = p.unary(); err == nil {
+			left = filterAnd{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) unary() (eventFilter, error) {
+	switch {
+	case p.peek("!"):
+		p.next++
+		inner, err := p.unary()
+		return filterNot{inner: inner}, err
+	case p.peek("("):
+		p.next++
+		inner, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, errors.New("a \")\" is missing")
+		}
+		p.next++
+		return inner, nil
+	}
+	return p.condition()
+}
+
+func (p *filterParser) condition() (eventFilter, error) {
+	name, err := p.take()
+	if err != nil {
+		return nil, err
+	}
+	if name.quoted || name.operator {
+		return nil, fmt.Errorf("%q is not expected", name.text)
+	}
+	field := strings.ToLower(name.text)
+	if field == "note" && p.next < len(p.tokens) && p.isComparison() {
+		field = "key"
+	}
+	if test, found := flagFields[field]; found {
+		return filterFlag{test: test}, nil
+	}
+	operator, err := p.take()
+	if err != nil || !p.isOperator(operator) {
+		return nil, fmt.Errorf("%q is not a condition: name one of %s, or compare one of %s to a value", name.text,
+			joinSorted(fieldNames(flagFields)), comparableFields())
+	}
+	value, err := p.take()
+	if err != nil {
+		return nil, err
+	}
+	if get, found := textFields[field]; found {
+		if operator.text != "==" && operator.text != "!=" {
+			return nil, fmt.Errorf("the field %q can only be compared with == or !=", name.text)
+		}
+		return filterText{field: get, equal: operator.text == "==", value: value.text, foldCase: field == "type"}, nil
+	}
+	get, found := numericFields[field]
+	if !found {
+		return nil, fmt.Errorf("the field %q is not known; the fields are %s", name.text, comparableFields())
+	}
+	c := filterNumber{field: get, operator: operator.text}
+	if c.low, err = fieldNumber(field, value.text); err != nil {
+		return nil, err
+	}
+	if operator.text == "in" {
+		if !p.peek("..") {
+			return nil, fmt.Errorf("the range for %q needs \"..\" between its ends", name.text)
+		}
+		p.next++
+		high, err := p.take()
+		if err != nil {
+			return nil, err
+		}
+		if c.high, err = fieldNumber(field, high.text); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *filterParser) isOperator(t filterToken) bool {
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return t.operator
+	case "in":
+		return !t.quoted
+	}
+	return false
+}
+
+func (p *filterParser) isComparison() bool {
+	return p.isOperator(p.tokens[p.next])
+}
+
+// fieldNumber parses a value for the field: keys may be note names
+func fieldNumber(field, s string) (float64, error) {
+	if field == "key" {
+		key, err := parseNote(s)
+		return float64(key), err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return v, nil
+}
+
+// comparableFields lists the fields that take a value
+func comparableFields() string {
+	return joinSorted(append(fieldNames(numericFields), fieldNames(textFields)...))
+}
+
+func fieldNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
+
+func joinSorted(names []string) string {
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
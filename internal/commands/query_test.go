@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_parseEventFilter(t *testing.T) {
+	data := diffTestSMF(diffTestOptions{resolution: 480, velocity: 80, tempo: 120})
+	m := newMeter(data)
+	noteOn := newEventFacts(m, 3, 7, 1920*9, smf.Event{Delta: 240, Message: smf.Message(midi.NoteOn(3, 64, 110))})
+	tempo := newEventFacts(m, 0, 1, 0, smf.Event{Message: smf.MetaTempo(96)})
+	sustain := newEventFacts(m, 1, 2, 960, smf.Event{Message: smf.Message(midi.ControlChange(0, 64, 127))})
+	marker := newEventFacts(m, 2, 0, 0, smf.Event{Message: smf.MetaMarker("Verse 1")})
+	tests := map[string]struct {
+		expression string
+		matches    map[*eventFacts]bool
+	}{
+		"conjunction": {
+			expression: "type==NoteOn && channel==3 && velocity>100",
+			matches:    map[*eventFacts]bool{noteOn: true, tempo: false, sustain: false},
+		},
+		"type names ignore case": {
+			expression: "type == noteon",
+			matches:    map[*eventFacts]bool{noteOn: true, sustain: false},
+		},
+		"meta": {
+			expression: "meta",
+			matches:    map[*eventFacts]bool{noteOn: false, tempo: true, marker: true},
+		},
+		"bar range": {
+			expression: "bar in 10..20",
+			matches:    map[*eventFacts]bool{noteOn: true, tempo: false},
+		},
+		"controller": {
+			expression: "controller==64",
+			matches:    map[*eventFacts]bool{sustain: true, noteOn: false},
+		},
+		"note names": {
+			expression: "note >= E5 && note < F5",
+			matches:    map[*eventFacts]bool{noteOn: true, sustain: false},
+		},
+		"bare note": {
+			expression: "note",
+			matches:    map[*eventFacts]bool{noteOn: true, sustain: false},
+		},
+		"negation and parentheses": {
+			expression: "!(meta || channel == 0)",
+			matches:    map[*eventFacts]bool{noteOn: true, tempo: false, sustain: false},
+		},
+		"or binds less tightly than and": {
+			expression: "bpm < 100 || type==ControlChange && value==0",
+			matches:    map[*eventFacts]bool{tempo: true, sustain: false},
+		},
+		"quoted text": {
+			expression: `text == "Verse 1"`,
+			matches:    map[*eventFacts]bool{marker: true, tempo: false},
+		},
+		"missing fields never match": {
+			expression: "velocity != 0",
+			matches:    map[*eventFacts]bool{noteOn: true, tempo: false, marker: false},
+		},
+		"position": {
+			expression: "track==3 && index==7 && delta==240 && tick==17280 && beat==1",
+			matches:    map[*eventFacts]bool{noteOn: true},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter, err := parseEventFilter(tt.expression)
+			if err != nil {
+				t.Fatalf("parseEventFilter() error = %v", err)
+			}
+			for facts, want := range tt.matches {
+				if got := filter.matches(facts); got != want {
+					t.Errorf("parseEventFilter(%q).matches(%s) = %t, want %t", tt.expression,
+						typeName(facts.message), got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseEventFilter_errors(t *testing.T) {
+	tests := map[string]struct {
+		expression string
+		wantErr    string
+	}{
+		"empty": {expression: " ", wantErr: "the expression is empty"},
+		"unknown field": {
+			expression: "colour == 3",
+			wantErr: "the field \"colour\" is not known; the fields are bar, beat, bend, bpm, channel, " +
+				"controller, delta, index, key, pressure, program, text, tick, track, type, value, velocity",
+		},
+		"not a condition": {
+			expression: "channel",
+			wantErr: "\"channel\" is not a condition: name one of meta, note, sysex, or compare one of bar, " +
+				"beat, bend, bpm, channel, controller, delta, index, key, pressure, program, text, tick, track, " +
+				"type, value, velocity to a value",
+		},
+		"bad number":      {expression: "velocity > loud", wantErr: "\"loud\" is not a number"},
+		"bad note":        {expression: "key == H2", wantErr: "\"H2\" is not a note"},
+		"ordered text":    {expression: "type < NoteOn", wantErr: "the field \"type\" can only be compared with == or !="},
+		"incomplete":      {expression: "channel ==", wantErr: "the expression ends too soon"},
+		"unbalanced":      {expression: "(meta || note", wantErr: "a \")\" is missing"},
+		"trailing tokens": {expression: "meta note", wantErr: "\"note\" is not expected"},
+		"open range":      {expression: "bar in 10", wantErr: "the range for \"bar\" needs \"..\" between its ends"},
+		"single equals":   {expression: "channel = 3", wantErr: "\"=\" is not expected"},
+		"unterminated":    {expression: `text == "Verse`, wantErr: "the string at \"\\\"Verse\" is not terminated"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseEventFilter(tt.expression)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseEventFilter(%q) error = %v, want %q", tt.expression, err, tt.wantErr)
+			}
+		})
+	}
+}
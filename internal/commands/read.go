@@ -4,8 +4,10 @@ import (
 	"fmt"
 
 	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/smf"
+	"golang.org/x/text/encoding"
 )
 
 var (
@@ -267,8 +269,114 @@ var (
 	}
 )
 
+// messageInterpreter handles the messages of a track, one method per message
+// type; read describes them, and the CSV exporter writes them as rows
+type messageInterpreter interface {
+	beginEvent(o output.Bus, index int, event smf.Event)
+	interpretAfterTouchMsg(o output.Bus, message smf.Message)
+	interpretControlChangeMsg(o output.Bus, message smf.Message)
+	interpretMetaChannelMsg(o output.Bus, message smf.Message)
+	interpretMetaCopyrightMsg(o output.Bus, message smf.Message)
+	interpretMetaCuepointMsg(o output.Bus, message smf.Message)
+	interpretMetaDeviceMsg(o output.Bus, message smf.Message)
+	interpretMetaInstrumentMsg(o output.Bus, message smf.Message)
+	interpretMetaKeySigMsg(o output.Bus, message smf.Message)
+	interpretMetaLyricMsg(o output.Bus, message smf.Message)
+	interpretMetaMarkerMsg(o output.Bus, message smf.Message)
+	interpretMetaPortMsg(o output.Bus, message smf.Message)
+	interpretMetaProgramNameMsg(o output.Bus, message smf.Message)
+	interpretMetaSMPTEOffsetMsg(o output.Bus, message smf.Message)
+	interpretMetaSeqDataMsg(o output.Bus, message smf.Message)
+	interpretMetaSeqNumberMsg(o output.Bus, message smf.Message)
+	interpretMetaTempoMsg(o output.Bus, message smf.Message)
+	interpretMetaTextMsg(o output.Bus, message smf.Message)
+	interpretMetaTimeSigMsg(o output.Bus, message smf.Message)
+	interpretMetaTrackNameMsg(o output.Bus, message smf.Message)
+	interpretNoteOffMsg(o output.Bus, message smf.Message)
+	interpretNoteOnMsg(o output.Bus, message smf.Message)
+	interpretPitchBendMsg(o output.Bus, message smf.Message)
+	interpretPolyAfterTouchMsg(o output.Bus, message smf.Message)
+	interpretProgramChangeMsg(o output.Bus, message smf.Message)
+	interpretSysExMsg(o output.Bus, message smf.Message)
+	interpretUnrecognizedMsg(o output.Bus, message smf.Message)
+}
+
+// read prints the events of files; other commands use it to spell notes and
+// name instruments
 type read struct {
-	key *smf.Key
+	key          *smf.Key
+	where        string
+	encodingName string
+	selection    selectionFlags
+	// set while printing a file: a nil meter prints every event
+	filter   eventFilter
+	selected eventSelection
+	meter    *meter
+	track    int
+	codec    encoding.Encoding
+}
+
+func newRead() command {
+	return &read{}
+}
+
+func (r *read) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&r.where, "where", "",
+		"print only the events matching the expression, e.g. \"type==NoteOn && velocity>100\"")
+	flags.StringVar(&r.encodingName, "encoding", autoEncoding, "encoding of the text in the files: "+encodingNames())
+	r.selection.define(flags)
+}
+
+func (r *read) run(o output.Bus, args []string) int {
+	if err := checkEncodingName(r.encodingName); err != nil {
+		o.ErrorPrintf("The --encoding value %q is not valid: %v.\n", r.encodingName, err)
+		return exitUserError
+	}
+	if r.where != "" {
+		filter, err := parseEventFilter(r.where)
+		if err != nil {
+			o.ErrorPrintf("The --where value %q is not valid: %v.\n", r.where, err)
+			return exitUserError
+		}
+		r.filter = filter
+	}
+	if err := r.selection.validate(); err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		return exitUserError
+	}
+	return processFiles(o, args, r.processFile)
+}
+
+func (r *read) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	m := newMeter(data)
+	selection, err := r.selection.build(m)
+	if err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		return false
+	}
+	key := firstKey(data)
+	// files may be printed at once, so each gets its own printer
+	printer := *r
+	printer.key, printer.selected, printer.meter = &key, selection, m
+	name, codec := textCodec(r.encodingName, data)
+	printer.codec = codec
+	if name == "utf-8" {
+		o.ConsolePrintf("%s:\n", path)
+	} else {
+		o.ConsolePrintf("%s (text in %s):\n", path, name)
+	}
+	printer.interpretSMFFile(o, data)
+	return true
+}
+
+// text decodes a text meta event in the file's encoding
+func (r *read) text(message smf.Message) string {
+	_, data := metaData(message)
+	return metaText(r.codec, data)
 }
 
 func (r *read) asNote(channel, raw uint8) string {
@@ -279,54 +387,23 @@ func (r *read) asNote(channel, raw uint8) string {
 			return fmt.Sprintf("unknown percussion %d", raw)
 		}
 	} else {
-		noteMap := minorKeys
-		if r.key.IsMajor {
-			noteMap = majorKeys
-		}
-		return fmt.Sprintf("%s%d", noteMap[raw%12], raw/12)
+		return fmt.Sprintf("%s%d", spellPitchClass(*r.key, raw%12), raw/12)
 	}
 }
 
 func (r *read) asVolume(velocity uint8) string {
-	switch velocity {
-	case 16:
-		return "pianississimo (𝆏𝆏𝆏)"
-	case 33:
-		return "pianissimo (𝆏𝆏)"
-	case 49:
-		return "piano (𝆏)"
-	case 64:
-		return "mezzo-piano (𝆐𝆏)"
-	case 80:
-		return "mezzo-forte (𝆐𝆑)"
-	case 96:
-		return "forte (𝆑)"
-	case 112:
-		return "fortissimo (𝆑𝆑)"
-	case 127:
-		return "fortississimo (𝆑𝆑𝆑)"
-	default:
+	for k, d := range dynamics {
 		switch {
-		case velocity < 16:
-			return fmt.Sprintf("below pianississimo (𝆏𝆏𝆏) (%d)", velocity)
-		case velocity < 33:
-			return fmt.Sprintf("between pianississimo (𝆏𝆏𝆏) and pianissimo (𝆏𝆏) (%d)", velocity)
-		case velocity < 49:
-			return fmt.Sprintf("between pianissimo (𝆏𝆏) and piano (𝆏) (%d)", velocity)
-		case velocity < 64:
-			return fmt.Sprintf("between piano (𝆏) and mezzo-piano (𝆐𝆏) (%d)", velocity)
-		case velocity < 80:
-			return fmt.Sprintf("between mezzo-piano (𝆐𝆏) and mezzo-forte (𝆐𝆑) (%d)", velocity)
-		case velocity < 96:
-			return fmt.Sprintf("between mezzo-forte (𝆐𝆑) and forte (𝆑) (%d)", velocity)
-		case velocity < 112:
-			return fmt.Sprintf("between forte (𝆑) and fortissimo (𝆑𝆑) (%d)", velocity)
-		case velocity < 127:
-			return fmt.Sprintf("between fortissimo (𝆑𝆑) and fortississimo (𝆑𝆑𝆑) (%d)", velocity)
-		default:
-			return fmt.Sprintf("above fortississimo (𝆑𝆑𝆑) (%d)", velocity)
+		case velocity == d.velocity:
+			return d.String()
+		case velocity < d.velocity:
+			if k == 0 {
+				return fmt.Sprintf("below %s (%d)", d, velocity)
+			}
+			return fmt.Sprintf("between %s and %s (%d)", dynamics[k-1], d, velocity)
 		}
 	}
+	return fmt.Sprintf("above %s (%d)", dynamics[len(dynamics)-1], velocity)
 }
 
 func (r *read) interpretAfterTouchMsg(o output.Bus, message smf.Message) {
@@ -348,30 +425,29 @@ func (r *read) interpretMetaChannelMsg(o output.Bus, message smf.Message) {
 }
 
 func (r *read) interpretMetaCopyrightMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaCopyright(&text)
-	o.ConsolePrintf("MetaCopyright text %q\n", text)
+	o.ConsolePrintf("MetaCopyright text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaCuepointMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaCuepoint(&text)
-	o.ConsolePrintf("MetaCuepoint text %q\n", text)
+	o.ConsolePrintf("MetaCuepoint text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaDeviceMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaDevice(&text)
-	o.ConsolePrintf("MetaDevice text %q\n", text)
+	o.ConsolePrintf("MetaDevice text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaInstrumentMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaInstrument(&text)
-	o.ConsolePrintf("MetaInstrument text %q\n", text)
+	o.ConsolePrintf("MetaInstrument text %q\n", r.text(message))
 }
 
 func (r *read) interpretSMFFile(o output.Bus, data *smf.SMF) {
+	if len(declaredKeys(data)) == 0 {
+		// with no key signature to go by, spell notes per the estimated key
+		if notes := collectNotes(data); len(notes) > 0 {
+			estimated := spellingKey(data, notes)
+			r.key = &estimated
+		}
+	}
 	r.interpretSMFTimeFormat(o, data.TimeFormat)
 	r.interpretSMFTracks(o, data.Tracks)
 }
@@ -386,33 +462,24 @@ func (r *read) interpretSMFTimeFormat(o output.Bus, tf smf.TimeFormat) {
 
 func (r *read) interpretMetaKeySigMsg(o output.Bus, message smf.Message) {
 	_ = message.GetMetaKey(r.key)
-	delta := "flats"
-	if r.key.Num == 1 {
+	// the key signature of C major or A minor has no accidentals, and
+	// keeps its traditional description
+	delta := "sharp"
+	if r.key.IsFlat || (r.key.Num == 0 && !r.key.IsMajor) {
 		delta = "flat"
 	}
-	noteMap := minorKeys
-	modifier := "Minor"
-	if r.key.IsMajor {
-		delta = "sharps"
-		if r.key.Num == 1 {
-			delta = "sharp"
-		}
-		noteMap = majorKeys
-		modifier = "Major"
+	if r.key.Num != 1 {
+		delta += "s"
 	}
-	o.ConsolePrintf("MetaKeySig %s%s (%d %s)\n", noteMap[r.key.Key], modifier, r.key.Num, delta)
+	o.ConsolePrintf("MetaKeySig %s (%d %s)\n", keyName(*r.key), r.key.Num, delta)
 }
 
 func (r *read) interpretMetaLyricMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaLyric(&text)
-	o.ConsolePrintf("MetaLyric text %q\n", text)
+	o.ConsolePrintf("MetaLyric text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaMarkerMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaMarker(&text)
-	o.ConsolePrintf("MetaMarker text %q\n", text)
+	o.ConsolePrintf("MetaMarker text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaPortMsg(o output.Bus, message smf.Message) {
@@ -422,9 +489,7 @@ func (r *read) interpretMetaPortMsg(o output.Bus, message smf.Message) {
 }
 
 func (r *read) interpretMetaProgramNameMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaProgramName(&text)
-	o.ConsolePrintf("MetaProgramName text %q\n", text)
+	o.ConsolePrintf("MetaProgramName text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaSMPTEOffsetMsg(o output.Bus, message smf.Message) {
@@ -452,9 +517,7 @@ func (r *read) interpretMetaTempoMsg(o output.Bus, message smf.Message) {
 }
 
 func (r *read) interpretMetaTextMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaText(&text)
-	o.ConsolePrintf("MetaText text %q\n", text)
+	o.ConsolePrintf("MetaText text %q\n", r.text(message))
 }
 
 func (r *read) interpretMetaTimeSigMsg(o output.Bus, message smf.Message) {
@@ -470,9 +533,7 @@ func (r *read) interpretMetaTimeSigMsg(o output.Bus, message smf.Message) {
 }
 
 func (r *read) interpretMetaTrackNameMsg(o output.Bus, message smf.Message) {
-	var text string
-	_ = message.GetMetaTrackName(&text)
-	o.ConsolePrintf("MetaTrackName text %q\n", text)
+	o.ConsolePrintf("MetaTrackName text %q\n", r.text(message))
 }
 
 func (r *read) interpretNoteOffMsg(o output.Bus, message smf.Message) {
@@ -520,69 +581,124 @@ func (r *read) interpretProgramChangeMsg(o output.Bus, message smf.Message) {
 }
 
 func (r *read) interpretSMFTrack(o output.Bus, track smf.Track) {
+	if r.meter == nil {
+		interpretTrack(o, r, track)
+		return
+	}
+	var tick int64
 	for k, event := range track {
-		o.ConsolePrintf("%d: delta %d ", k, event.Delta)
-		message := event.Message
-		switch message.Type() {
-		case midi.AfterTouchMsg:
-			r.interpretAfterTouchMsg(o, message)
-		case midi.ControlChangeMsg:
-			r.interpretControlChangeMsg(o, message)
-		case smf.MetaChannelMsg:
-			r.interpretMetaChannelMsg(o, message)
-		case smf.MetaCopyrightMsg:
-			r.interpretMetaCopyrightMsg(o, message)
-		case smf.MetaCuepointMsg:
-			r.interpretMetaCuepointMsg(o, message)
-		case smf.MetaDeviceMsg:
-			r.interpretMetaDeviceMsg(o, message)
-		case smf.MetaInstrumentMsg:
-			r.interpretMetaInstrumentMsg(o, message)
-		case smf.MetaKeySigMsg:
-			r.interpretMetaKeySigMsg(o, message)
-		case smf.MetaLyricMsg:
-			r.interpretMetaLyricMsg(o, message)
-		case smf.MetaMarkerMsg:
-			r.interpretMetaMarkerMsg(o, message)
-		case smf.MetaPortMsg:
-			r.interpretMetaPortMsg(o, message)
-		case smf.MetaProgramNameMsg:
-			r.interpretMetaProgramNameMsg(o, message)
-		case smf.MetaSMPTEOffsetMsg:
-			r.interpretMetaSMPTEOffsetMsg(o, message)
-		case smf.MetaSeqDataMsg:
-			r.interpretMetaSeqDataMsg(o, message)
-		case smf.MetaSeqNumberMsg:
-			r.interpretMetaSeqNumberMsg(o, message)
-		case smf.MetaTempoMsg:
-			r.interpretMetaTempoMsg(o, message)
-		case smf.MetaTextMsg:
-			r.interpretMetaTextMsg(o, message)
-		case smf.MetaTimeSigMsg:
-			r.interpretMetaTimeSigMsg(o, message)
-		case smf.MetaTrackNameMsg:
-			r.interpretMetaTrackNameMsg(o, message)
-		case midi.NoteOffMsg:
-			r.interpretNoteOffMsg(o, message)
-		case midi.NoteOnMsg:
-			r.interpretNoteOnMsg(o, message)
-		case midi.PitchBendMsg:
-			r.interpretPitchBendMsg(o, message)
-		case midi.PolyAfterTouchMsg:
-			r.interpretPolyAfterTouchMsg(o, message)
-		case midi.ProgramChangeMsg:
-			r.interpretProgramChangeMsg(o, message)
-		case midi.SysExMsg:
-			r.interpretSysExMsg(o, message)
-		default:
-			o.ConsolePrintf("Unrecognized message: %q %v\n", message.Type(), message.Bytes())
+		tick += int64(event.Delta)
+		if r.selects(k, tick, event) {
+			interpretEvent(o, r, k, event)
 		}
 	}
 }
 
+// selects reports whether the event is within the selection and matches the
+// --where expression
+func (r *read) selects(index int, tick int64, event smf.Event) bool {
+	message := event.Message
+	var channel, key, value uint8
+	if message.GetChannel(&channel) && !r.selected.includesChannel(channel) {
+		return false
+	}
+	if (message.GetNoteOn(&channel, &key, &value) || message.GetNoteOff(&channel, &key, &value) ||
+		message.GetPolyAfterTouch(&channel, &key, &value)) && !r.selected.includesPitch(key) {
+		return false
+	}
+	if tick < r.selected.span.from || r.selected.span.to >= 0 && tick >= r.selected.span.to {
+		return false
+	}
+	if r.filter == nil {
+		return true
+	}
+	facts := newEventFacts(r.meter, r.track, index, tick, event)
+	if message.IsMeta() {
+		if typ, _ := metaData(message); dumpTextTypes[typ] != "" {
+			// compare text as it is printed, even if it is not UTF-8
+			text := r.text(message)
+			facts.decoded.Text = &text
+		}
+	}
+	return r.filter.matches(facts)
+}
+
+// interpretTrack decodes each event of the track and hands its message to the
+// interpreter's method for the message's type
+func interpretTrack(o output.Bus, i messageInterpreter, track smf.Track) {
+	for k, event := range track {
+		interpretEvent(o, i, k, event)
+	}
+}
+
+// interpretEvent hands the event's message to the interpreter's method for
+// the message's type
+func interpretEvent(o output.Bus, i messageInterpreter, index int, event smf.Event) {
+	i.beginEvent(o, index, event)
+	message := event.Message
+	switch message.Type() {
+	case midi.AfterTouchMsg:
+		i.interpretAfterTouchMsg(o, message)
+	case midi.ControlChangeMsg:
+		i.interpretControlChangeMsg(o, message)
+	case smf.MetaChannelMsg:
+		i.interpretMetaChannelMsg(o, message)
+	case smf.MetaCopyrightMsg:
+		i.interpretMetaCopyrightMsg(o, message)
+	case smf.MetaCuepointMsg:
+		i.interpretMetaCuepointMsg(o, message)
+	case smf.MetaDeviceMsg:
+		i.interpretMetaDeviceMsg(o, message)
+	case smf.MetaInstrumentMsg:
+		i.interpretMetaInstrumentMsg(o, message)
+	case smf.MetaKeySigMsg:
+		i.interpretMetaKeySigMsg(o, message)
+	case smf.MetaLyricMsg:
+		i.interpretMetaLyricMsg(o, message)
+	case smf.MetaMarkerMsg:
+		i.interpretMetaMarkerMsg(o, message)
+	case smf.MetaPortMsg:
+		i.interpretMetaPortMsg(o, message)
+	case smf.MetaProgramNameMsg:
+		i.interpretMetaProgramNameMsg(o, message)
+	case smf.MetaSMPTEOffsetMsg:
+		i.interpretMetaSMPTEOffsetMsg(o, message)
+	case smf.MetaSeqDataMsg:
+		i.interpretMetaSeqDataMsg(o, message)
+	case smf.MetaSeqNumberMsg:
+		i.interpretMetaSeqNumberMsg(o, message)
+	case smf.MetaTempoMsg:
+		i.interpretMetaTempoMsg(o, message)
+	case smf.MetaTextMsg:
+		i.interpretMetaTextMsg(o, message)
+	case smf.MetaTimeSigMsg:
+		i.interpretMetaTimeSigMsg(o, message)
+	case smf.MetaTrackNameMsg:
+		i.interpretMetaTrackNameMsg(o, message)
+	case midi.NoteOffMsg:
+		i.interpretNoteOffMsg(o, message)
+	case midi.NoteOnMsg:
+		i.interpretNoteOnMsg(o, message)
+	case midi.PitchBendMsg:
+		i.interpretPitchBendMsg(o, message)
+	case midi.PolyAfterTouchMsg:
+		i.interpretPolyAfterTouchMsg(o, message)
+	case midi.ProgramChangeMsg:
+		i.interpretProgramChangeMsg(o, message)
+	case midi.SysExMsg:
+		i.interpretSysExMsg(o, message)
+	default:
+		i.interpretUnrecognizedMsg(o, message)
+	}
+}
+
 func (r *read) interpretSMFTracks(o output.Bus, tracks []smf.Track) {
 	o.ConsolePrintf("%d tracks\n", len(tracks))
 	for k, track := range tracks {
+		if r.meter != nil && !r.selected.includesTrack(k) {
+			continue
+		}
+		r.track = k
 		if track.IsEmpty() {
 			o.ConsolePrintf("Track %d is empty\n", k)
 		} else {
@@ -597,3 +713,11 @@ func (r *read) interpretSysExMsg(o output.Bus, message smf.Message) {
 	_ = message.GetSysEx(&bt)
 	o.ConsolePrintf("SysEx bytes %v\n", bt)
 }
+
+func (r *read) beginEvent(o output.Bus, index int, event smf.Event) {
+	o.ConsolePrintf("%d: delta %d ", index, event.Delta)
+}
+
+func (r *read) interpretUnrecognizedMsg(o output.Bus, message smf.Message) {
+	o.ConsolePrintf("Unrecognized message: %q %v\n", message.Type(), message.Bytes())
+}
@@ -679,6 +679,18 @@ func Test_read_interpretMetaKeySigMsg(t *testing.T) {
 			wantKey:         &smf.Key{Key: 6, Num: 6, IsMajor: true, IsFlat: false},
 			WantedRecording: output.WantedRecording{Console: "MetaKeySig F♯Major (6 sharps)\n"},
 		},
+		"B-flat major": {
+			r:               &read{key: &smf.Key{}},
+			args:            args{message: makeMetaKeySigMsg(-2, true)},
+			wantKey:         &smf.Key{Key: 10, Num: 2, IsMajor: true, IsFlat: true},
+			WantedRecording: output.WantedRecording{Console: "MetaKeySig B♭Major (2 flats)\n"},
+		},
+		"F-sharp minor": {
+			r:               &read{key: &smf.Key{}},
+			args:            args{message: makeMetaKeySigMsg(3, false)},
+			wantKey:         &smf.Key{Key: 6, Num: 3, IsMajor: false, IsFlat: false},
+			WantedRecording: output.WantedRecording{Console: "MetaKeySig F♯Minor (3 sharps)\n"},
+		},
 		"C-sharp major": {
 			r:               &read{key: &smf.Key{}},
 			args:            args{message: makeMetaKeySigMsg(7, true)},
@@ -1651,3 +1663,124 @@ func Test_read_asInstrument(t *testing.T) {
 		})
 	}
 }
+
+func Test_read_run(t *testing.T) {
+	path := writeDiffTestFile(t, t.TempDir(), "a.mid",
+		diffTestOptions{resolution: 480, velocity: 80, tempo: 120, extraNote: true})
+	header := path + ":\nQuarter note: 480 ticks\n3 tracks\n"
+	tests := map[string]struct {
+		r    *read
+		want int
+		output.WantedRecording
+	}{
+		"where": {
+			r:    &read{where: "type==NoteOn && channel==0 && velocity>80"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{Console: header +
+				"Track 0:\n" +
+				"Track 1:\n" +
+				"7: delta 1920 NoteOn channel 0 note \"G5\" volume between mezzo-forte (𝆐𝆑) and forte (𝆑) (90)\n" +
+				"Track 2:\n"},
+		},
+		"bars": {
+			r:    &read{where: "bar in 2..2 && !meta"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{Console: header +
+				"Track 0:\n" +
+				"Track 1:\n" +
+				"7: delta 1920 NoteOn channel 0 note \"G5\" volume between mezzo-forte (𝆐𝆑) and forte (𝆑) (90)\n" +
+				"Track 2:\n" +
+				"2: delta 1920 NoteOff channel 1 note \"C3\" volume below pianississimo (𝆏𝆏𝆏) (0)\n"},
+		},
+		"tracks and channels keep meta events": {
+			r:    &read{selection: selectionFlags{tracks: "1..2", channels: "1"}},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{Console: header +
+				"Track 1:\n" +
+				"0: delta 0 MetaTrackName text \"Piano\"\n" +
+				"9: delta 0 Unrecognized message: \"MetaEndOfTrack\" [255 47 0]\n" +
+				"Track 2:\n" +
+				"0: delta 0 MetaTrackName text \"Bass\"\n" +
+				"1: delta 0 NoteOn channel 1 note \"C3\" volume between forte (𝆑) and fortissimo (𝆑𝆑) (100)\n" +
+				"2: delta 1920 NoteOff channel 1 note \"C3\" volume below pianississimo (𝆏𝆏𝆏) (0)\n" +
+				"3: delta 0 Unrecognized message: \"MetaEndOfTrack\" [255 47 0]\n"},
+		},
+		"bad expression": {
+			r:    &read{where: "velocity >"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --where value \"velocity >\" is not valid: the expression ends too soon.\n",
+			},
+		},
+		"bad selection": {
+			r:    &read{selection: selectionFlags{channels: "16"}},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The selection is not valid: \"16\" is not a valid value or range between 0 and 15.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.r.run(o, []string{path}); got != tt.want {
+				t.Errorf("read.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("read.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_read_run_encoding(t *testing.T) {
+	useMemoryFileSystem(t, map[string][]byte{"/legacy.mid": smfBytes(t, metaTestSMF([]byte("\xa9 Caf\xe9")))})
+	header := "Quarter note: 480 ticks\n2 tracks\nTrack 0:\n"
+	tests := map[string]struct {
+		r    *read
+		want int
+		output.WantedRecording
+	}{
+		"detected": {
+			r:    &read{where: "text==\"© Café\""},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/legacy.mid (text in windows-1252):\n" + header +
+					"2: delta 0 MetaCopyright text \"© Café\"\n" +
+					"Track 1:\n",
+			},
+		},
+		"chosen": {
+			r:    &read{where: "type==MetaCopyright", encodingName: "shift-jis"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/legacy.mid (text in shift-jis):\n" + header +
+					"2: delta 0 MetaCopyright text \"ｩ Caf�\"\n" +
+					"Track 1:\n",
+			},
+		},
+		"unknown": {
+			r:    &read{encodingName: "ebcdic"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --encoding value \"ebcdic\" is not valid: the encodings are auto, latin-1, shift-jis, " +
+					"utf-8, windows-1252.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.r.run(o, []string{"/legacy.mid"}); got != tt.want {
+				t.Errorf("read.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("read.run() %s", issue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const (
+	// renderTail is the longest time voices may ring on after the last event
+	renderTail = 5.0
+	// renderGain leaves headroom for several voices at once
+	renderGain = 0.25
+)
+
+// render plays files through a software instrument bank into WAV files
+type render struct {
+	outFile       string
+	sampleRate    int
+	soundFontFile string
+	soundFont     *soundFont
+}
+
+func newRender() command {
+	return &render{}
+}
+
+func (r *render) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&r.outFile, "output", "o", "", "file to write (default: the input file with the .wav extension)")
+	flags.IntVar(&r.sampleRate, "rate", 44100, "sample rate in Hz, from 8000 to 192000")
+	flags.StringVar(&r.soundFontFile, "soundfont", "", "SoundFont (.sf2) file to play the notes with, in place of the "+
+		"built-in synthesizer")
+}
+
+func (r *render) run(o output.Bus, args []string) int {
+	if r.sampleRate < 8000 || r.sampleRate > 192000 {
+		o.ErrorPrintf("The --rate value %d is not valid: it must be from 8000 to 192000.\n", r.sampleRate)
+		return exitUserError
+	}
+	if r.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		return exitUserError
+	}
+	if r.soundFontFile != "" {
+		content, err := readFile(r.soundFontFile)
+		if err != nil {
+			o.ErrorPrintf("The file %q cannot be read: %v.\n", r.soundFontFile, err)
+			o.Log(output.Error, "cannot read file", map[string]any{"file": r.soundFontFile, "error": err})
+			return exitUserError
+		}
+		if r.soundFont, err = parseSoundFont(content); err != nil {
+			o.ErrorPrintf("The SoundFont %q is not valid: %v.\n", r.soundFontFile, err)
+			o.Log(output.Error, "invalid SoundFont", map[string]any{"file": r.soundFontFile, "error": err})
+			return exitUserError
+		}
+	}
+	return processFiles(o, args, r.processFile)
+}
+
+func (r *render) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	destination := r.outFile
+	if destination == "" {
+		destination = strings.TrimSuffix(path, filepath.Ext(path)) + ".wav"
+	}
+	var bank instrumentBank = newSynth(float64(r.sampleRate))
+	if r.soundFont != nil {
+		bank = newSoundFontBank(r.soundFont, float64(r.sampleRate))
+	}
+	left, right := renderAudio(data, r.sampleRate, bank)
+	if err := writeWAVFile(destination, r.sampleRate, left, right); err != nil {
+		o.ErrorPrintf("The file %q cannot be written: %v.\n", destination, err)
+		o.Log(output.Error, "cannot write file", map[string]any{"file": destination, "error": err})
+		return false
+	}
+	o.ConsolePrintf("%s: rendered %.1f seconds to %s\n", path, float64(len(left))/float64(r.sampleRate), destination)
+	return true
+}
+
+// sound is the audio of one note, whatever makes it
+type sound interface {
+	// sample returns the next stereo sample; bend is the pitch bend as a
+	// frequency ratio
+	sample(bend float64) (left, right float64)
+	// release starts the sound's release, as when its key is let go
+	release()
+	// finished reports whether the sound has died away
+	finished() bool
+}
+
+// instrumentBank makes the sounds of notes played on a channel
+type instrumentBank interface {
+	newSound(ch *channelState, key, velocity uint8) sound
+}
+
+// channelState holds the controller settings of a channel
+type channelState struct {
+	number      uint8
+	bank        uint16 // MSB*128+LSB of the bank select controllers at the last program change
+	program     uint8
+	volume      uint8
+	expression  uint8
+	pan         uint8
+	bend        float64 // in semitones
+	sustain     bool
+	controllers [128]uint8 // the last value of each controller
+}
+
+func (ch *channelState) reset() {
+	ch.volume, ch.pan = 100, 64
+	ch.controllers[7], ch.controllers[10] = 100, 64
+	ch.resetControllers()
+}
+
+// resetControllers resets all controllers, as recommended practice RP-015 has
+// it, but the bank, volume, pan, and effects depths
+func (ch *channelState) resetControllers() {
+	for k := range ch.controllers {
+		if k != 0 && k != 7 && k != 10 && k != 32 && (k < 91 || k > 95) {
+			ch.controllers[k] = 0
+		}
+	}
+	ch.expression, ch.controllers[11] = 127, 127
+	ch.bend = 0
+	ch.sustain = false
+}
+
+// renderVoice is a sounding note
+type renderVoice struct {
+	channel   *channelState
+	key       uint8
+	sound     sound
+	held      bool // the key is down
+	sustained bool // the key is up, but the sustain pedal holds the note
+}
+
+// renderEvent is a channel message at a sample position
+type renderEvent struct {
+	sample  int64
+	message smf.Message
+}
+
+// renderer mixes the voices of the sixteen channels
+type renderer struct {
+	bank     instrumentBank
+	channels [16]channelState
+	voices   []*renderVoice
+}
+
+// renderAudio plays the file's channel messages through the instrument bank,
+// returning the left and right channels
+func renderAudio(data *smf.SMF, sampleRate int, bank instrumentBank) (left, right []float64) {
+	tempos := newTempoMap(data)
+	var events []renderEvent
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			if len(event.Message) > 0 && event.Message[0] >= 0x80 && event.Message[0] < 0xF0 {
+				sample := int64(math.Round(tempos.seconds(ticks[k]) * float64(sampleRate)))
+				events = append(events, renderEvent{sample: sample, message: event.Message})
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].sample < events[j].sample })
+	end := int64(math.Round(tempos.seconds(lastTick(data)) * float64(sampleRate)))
+	r := &renderer{bank: bank}
+	for k := range r.channels {
+		r.channels[k].number = uint8(k)
+		r.channels[k].reset()
+	}
+	var now int64
+	mix := func(until int64) {
+		for ; now < until; now++ {
+			l, rr := r.mixSample()
+			left = append(left, l)
+			right = append(right, rr)
+		}
+	}
+	for _, e := range events {
+		mix(e.sample)
+		r.handle(e.message)
+	}
+	mix(end)
+	for _, v := range r.voices {
+		v.held, v.sustained = false, false
+		v.sound.release()
+	}
+	tail := now + int64(renderTail*float64(sampleRate))
+	for now < tail && len(r.voices) > 0 {
+		mix(now + 1)
+	}
+	return left, right
+}
+
+func (r *renderer) handle(message smf.Message) {
+	var channel, key, velocity, controller, value, program uint8
+	var relative int16
+	switch {
+	case message.GetNoteOn(&channel, &key, &velocity) && velocity > 0:
+		ch := &r.channels[channel]
+		if s := r.bank.newSound(ch, key, velocity); s != nil {
+			r.voices = append(r.voices, &renderVoice{channel: ch, key: key, sound: s, held: true})
+		}
+	case message.GetNoteOn(&channel, &key, nil), message.GetNoteOff(&channel, &key, nil):
+		for _, v := range r.voices {
+			if v.channel.number == channel && v.key == key && v.held {
+				v.held = false
+				if v.channel.sustain {
+					v.sustained = true
+				} else {
+					v.sound.release()
+				}
+			}
+		}
+	case message.GetProgramChange(&channel, &program):
+		ch := &r.channels[channel]
+		ch.program = program
+		ch.bank = uint16(ch.controllers[0])<<7 | uint16(ch.controllers[32])
+	case message.GetPitchBend(&channel, &relative, nil):
+		r.channels[channel].bend = float64(relative) / 8192 * 2
+	case message.GetControlChange(&channel, &controller, &value):
+		r.control(&r.channels[channel], controller, value)
+	}
+}
+
+func (r *renderer) control(ch *channelState, controller, value uint8) {
+	ch.controllers[controller&0x7F] = value
+	switch controller {
+	case 7:
+		ch.volume = value
+	case 10:
+		ch.pan = value
+	case 11:
+		ch.expression = value
+	case 64:
+		ch.sustain = value >= 64
+		if !ch.sustain {
+			r.releaseVoices(ch, func(v *renderVoice) bool { return v.sustained })
+		}
+	case 120, 123: // all sound off, all notes off
+		r.releaseVoices(ch, func(v *renderVoice) bool { return true })
+	case 121: // reset all controllers
+		r.releaseVoices(ch, func(v *renderVoice) bool { return v.sustained })
+		ch.resetControllers()
+	}
+}
+
+func (r *renderer) releaseVoices(ch *channelState, selected func(*renderVoice) bool) {
+	for _, v := range r.voices {
+		if v.channel == ch && selected(v) {
+			v.held, v.sustained = false, false
+			v.sound.release()
+		}
+	}
+}
+
+// mixSample mixes the next sample of every voice, with the channel's pan as
+// an equal power balance, and drops the voices that have finished
+func (r *renderer) mixSample() (left, right float64) {
+	live := r.voices[:0]
+	for _, v := range r.voices {
+		ch := v.channel
+		l, rr := v.sound.sample(math.Exp2(ch.bend / 12))
+		gain := renderGain * float64(ch.volume) / 127 * float64(ch.expression) / 127
+		angle := float64(ch.pan) / 127 * math.Pi / 2
+		left += l * gain * math.Sqrt2 * math.Cos(angle)
+		right += rr * gain * math.Sqrt2 * math.Sin(angle)
+		if !v.sound.finished() {
+			live = append(live, v)
+		}
+	}
+	r.voices = live
+	return left, right
+}
+
+// writeWAVFile writes the audio as 16 bit stereo PCM, scaled down if it
+// would clip
+func writeWAVFile(path string, sampleRate int, left, right []float64) (err error) {
+	f, err := fileSystem.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if err = writeWAV(w, sampleRate, left, right); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func writeWAV(w io.Writer, sampleRate int, left, right []float64) error {
+	peak := 1.0
+	for k := range left {
+		peak = max(peak, math.Abs(left[k]), math.Abs(right[k]))
+	}
+	dataSize := uint32(len(left) * 4)
+	header := []any{
+		[]byte("RIFF"), 36 + dataSize, []byte("WAVE"),
+		[]byte("fmt "), uint32(16), uint16(1), uint16(2), uint32(sampleRate), uint32(sampleRate * 4), uint16(4),
+		uint16(16),
+		[]byte("data"), dataSize,
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	frame := make([]byte, 4)
+	for k := range left {
+		binary.LittleEndian.PutUint16(frame, uint16(int16(math.Round(left[k]/peak*32767))))
+		binary.LittleEndian.PutUint16(frame[2:], uint16(int16(math.Round(right[k]/peak*32767))))
+		if _, err := w.Write(frame); err != nil {
+			return fmt.Errorf("cannot write sample %d: %w", k, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const renderTestRate = 8000
+
+// renderTestSMF holds one track of events, at 120 beats per minute with 960
+// ticks per quarter note (half a second)
+func renderTestSMF(events ...smf.Event) *smf.SMF {
+	data := smf.New()
+	data.TimeFormat = smf.MetricTicks(960)
+	var track smf.Track
+	for _, e := range events {
+		track.Add(e.Delta, e.Message)
+	}
+	track.Close(0)
+	_ = data.Add(track)
+	return data
+}
+
+func renderEv(delta uint32, message midi.Message) smf.Event {
+	return smf.Event{Delta: delta, Message: smf.Message(message)}
+}
+
+func peakOf(samples []float64) float64 {
+	var peak float64
+	for _, s := range samples {
+		peak = max(peak, math.Abs(s))
+	}
+	return peak
+}
+
+// frequencyOf counts the rising zero crossings over the samples
+func frequencyOf(samples []float64) float64 {
+	var crossings int
+	for k := 1; k < len(samples); k++ {
+		if samples[k-1] < 0 && samples[k] >= 0 {
+			crossings++
+		}
+	}
+	return float64(crossings) * renderTestRate / float64(len(samples))
+}
+
+func Test_renderAudio(t *testing.T) {
+	organ := renderEv(0, midi.ProgramChange(0, 16))
+	tests := map[string]struct {
+		data  *smf.SMF
+		check func(t *testing.T, left, right []float64)
+	}{
+		"note sounds and rings out": {
+			data: renderTestSMF(organ, renderEv(0, midi.NoteOn(0, 69, 100)), renderEv(960, midi.NoteOff(0, 69))),
+			check: func(t *testing.T, left, right []float64) {
+				// half a second, then the organ's 50 ms release
+				if got, want := len(left), renderTestRate/2+renderTestRate/20; got < want || got > want+2 {
+					t.Errorf("renderAudio() rendered %d samples, want about %d", got, want)
+				}
+				if peakOf(left[:renderTestRate/2]) == 0 || peakOf(right[:renderTestRate/2]) == 0 {
+					t.Errorf("renderAudio() rendered silence")
+				}
+				if got := frequencyOf(left[400:4000]); math.Abs(got-440) > 5 {
+					t.Errorf("renderAudio() frequency = %.1f Hz, want 440 Hz", got)
+				}
+			},
+		},
+		"pitch bend": {
+			data: renderTestSMF(organ, renderEv(0, midi.Pitchbend(0, 8191)), renderEv(0, midi.NoteOn(0, 69, 100)),
+				renderEv(960, midi.NoteOff(0, 69))),
+			check: func(t *testing.T, left, _ []float64) {
+				if got := frequencyOf(left[400:4000]); math.Abs(got-494) > 5 {
+					t.Errorf("renderAudio() frequency = %.1f Hz, want 494 Hz", got)
+				}
+			},
+		},
+		"hard left": {
+			data: renderTestSMF(organ, renderEv(0, midi.ControlChange(0, 10, 0)), renderEv(0, midi.NoteOn(0, 69, 100)),
+				renderEv(960, midi.NoteOff(0, 69))),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) == 0 {
+					t.Errorf("renderAudio() left channel is silent")
+				}
+				if got := peakOf(right); got > 1e-9 {
+					t.Errorf("renderAudio() right channel peak = %g, want 0", got)
+				}
+			},
+		},
+		"no volume": {
+			data: renderTestSMF(organ, renderEv(0, midi.ControlChange(0, 7, 0)), renderEv(0, midi.NoteOn(0, 69, 100)),
+				renderEv(960, midi.NoteOff(0, 69))),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) != 0 || peakOf(right) != 0 {
+					t.Errorf("renderAudio() rendered sound at volume 0")
+				}
+			},
+		},
+		"reset all controllers keeps the volume": {
+			data: renderTestSMF(organ, renderEv(0, midi.ControlChange(0, 7, 0)), renderEv(0, midi.ControlChange(0, 121, 0)),
+				renderEv(0, midi.NoteOn(0, 69, 100)), renderEv(960, midi.NoteOff(0, 69))),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) != 0 || peakOf(right) != 0 {
+					t.Errorf("renderAudio() reset the volume")
+				}
+			},
+		},
+		"reset all controllers keeps the pan": {
+			data: renderTestSMF(organ, renderEv(0, midi.ControlChange(0, 10, 0)), renderEv(0, midi.ControlChange(0, 11, 0)),
+				renderEv(0, midi.ControlChange(0, 121, 0)), renderEv(0, midi.NoteOn(0, 69, 100)),
+				renderEv(960, midi.NoteOff(0, 69))),
+			check: func(t *testing.T, left, right []float64) {
+				// the expression is reset to full
+				if peakOf(left) == 0 {
+					t.Errorf("renderAudio() did not reset the expression")
+				}
+				if got := peakOf(right); got > 1e-9 {
+					t.Errorf("renderAudio() reset the pan: right channel peak = %g, want 0", got)
+				}
+			},
+		},
+		"sustain pedal": {
+			data: renderTestSMF(organ, renderEv(0, midi.ControlChange(0, 64, 127)), renderEv(0, midi.NoteOn(0, 69, 100)),
+				renderEv(480, midi.NoteOff(0, 69)), renderEv(960, midi.ControlChange(0, 64, 0))),
+			check: func(t *testing.T, left, _ []float64) {
+				// the note is let go at 0.25 seconds, but sounds until the pedal
+				// is lifted at 0.75 seconds
+				if peakOf(left[5000:5800]) == 0 {
+					t.Errorf("renderAudio() did not sustain the note")
+				}
+				if got, want := len(left), renderTestRate*3/4+renderTestRate/20; got < want || got > want+2 {
+					t.Errorf("renderAudio() rendered %d samples, want about %d", got, want)
+				}
+			},
+		},
+		"drums": {
+			data: renderTestSMF(renderEv(0, midi.NoteOn(9, 36, 100)), renderEv(0, midi.NoteOn(9, 42, 100)),
+				renderEv(0, midi.NoteOn(9, 45, 100)), renderEv(0, midi.NoteOn(9, 62, 100)),
+				renderEv(10, midi.NoteOff(9, 36))),
+			check: func(t *testing.T, left, _ []float64) {
+				if peakOf(left) == 0 {
+					t.Errorf("renderAudio() drums are silent")
+				}
+				// the drums ignore the note off, and the tom rings longest
+				if got := float64(len(left)) / renderTestRate; got < 0.49 || got > 0.51 {
+					t.Errorf("renderAudio() rendered %.3f seconds, want 0.5", got)
+				}
+			},
+		},
+		"all notes off": {
+			data: renderTestSMF(renderEv(0, midi.ProgramChange(0, 88)), renderEv(0, midi.NoteOn(0, 60, 100)),
+				renderEv(0, midi.NoteOn(0, 64, 100)), renderEv(960, midi.ControlChange(0, 123, 0)),
+				renderEv(2880, midi.Pitchbend(0, 0))),
+			check: func(t *testing.T, left, _ []float64) {
+				// the pad's release is 0.8 seconds, ending well before the last event
+				if peakOf(left[len(left)-1000:]) != 0 {
+					t.Errorf("renderAudio() did not release the notes")
+				}
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			left, right := renderAudio(tt.data, renderTestRate, newSynth(renderTestRate))
+			if len(left) != len(right) {
+				t.Fatalf("renderAudio() rendered %d left and %d right samples", len(left), len(right))
+			}
+			tt.check(t, left, right)
+		})
+	}
+}
+
+func Test_writeWAV(t *testing.T) {
+	tests := map[string]struct {
+		left, right []float64
+		want        []int16
+	}{
+		"quiet": {left: []float64{0, 0.5}, right: []float64{-0.5, 1}, want: []int16{0, -16384, 16384, 32767}},
+		"normalized": {
+			left:  []float64{2, -1},
+			right: []float64{0, -4},
+			want:  []int16{16384, 0, -8192, -32767},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeWAV(&b, 22050, tt.left, tt.right); err != nil {
+				t.Fatalf("writeWAV() error = %v", err)
+			}
+			got := b.Bytes()
+			if len(got) != 44+4*len(tt.left) {
+				t.Fatalf("writeWAV() wrote %d bytes, want %d", len(got), 44+4*len(tt.left))
+			}
+			le := binary.LittleEndian
+			if string(got[0:4]) != "RIFF" || string(got[8:16]) != "WAVEfmt " || string(got[36:40]) != "data" {
+				t.Errorf("writeWAV() header = % x", got[:44])
+			}
+			if le.Uint32(got[4:]) != uint32(len(got)-8) || le.Uint32(got[40:]) != uint32(len(got)-44) {
+				t.Errorf("writeWAV() sizes = %d, %d", le.Uint32(got[4:]), le.Uint32(got[40:]))
+			}
+			if le.Uint16(got[22:]) != 2 || le.Uint32(got[24:]) != 22050 || le.Uint32(got[28:]) != 88200 ||
+				le.Uint16(got[32:]) != 4 || le.Uint16(got[34:]) != 16 {
+				t.Errorf("writeWAV() format = % x", got[20:36])
+			}
+			for k, want := range tt.want {
+				if v := int16(le.Uint16(got[44+2*k:])); v != want {
+					t.Errorf("writeWAV() sample %d = %d, want %d", k, v, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_render_run(t *testing.T) {
+	tests := map[string]struct {
+		r    *render
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"bad rate": {
+			r:    &render{sampleRate: 4000},
+			args: []string{"a.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --rate value 4000 is not valid: it must be from 8000 to 192000.\n",
+			},
+		},
+		"output with several files": {
+			r:    &render{sampleRate: 44100, outFile: "x.wav"},
+			args: []string{"a.mid", "b.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --output flag may only be used with a single input file.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.r.run(o, tt.args); got != tt.want {
+				t.Errorf("render.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("render.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_render_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "song.mid")
+	data := renderTestSMF(renderEv(0, midi.NoteOn(0, 60, 100)), renderEv(1920, midi.NoteOff(0, 60)))
+	if err := os.WriteFile(input, smfBytes(t, data), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	o := output.NewRecorder()
+	if !(&render{sampleRate: renderTestRate}).processFile(o, input) {
+		t.Fatalf("render.processFile() failed: %s", o.ErrorOutput())
+	}
+	wav := filepath.Join(dir, "song.wav")
+	if got, want := o.ConsoleOutput(), input+": rendered 1.3 seconds to "+wav+"\n"; got != want {
+		t.Errorf("render.processFile() console = %q, want %q", got, want)
+	}
+	content, err := os.ReadFile(wav)
+	if err != nil {
+		t.Fatalf("cannot read rendered file: %v", err)
+	}
+	if string(content[:4]) != "RIFF" {
+		t.Errorf("render.processFile() wrote % x", content[:12])
+	}
+}
@@ -0,0 +1,320 @@
+package commands
+
+import (
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// noteValue is a written note length: a whole note divided by the
+// denominator, lengthened by the dots
+type noteValue struct {
+	denominator int
+	dots        int
+}
+
+// scoreEvent is a note, chord, or rest within one voice of one measure. Its
+// offset (from the start of the measure) and duration are in divisions of a
+// quarter note; every event has a single written value, so sounds that do not
+// have one are written as tied events.
+type scoreEvent struct {
+	offset       int64
+	duration     int64
+	value        noteValue
+	pitches      []uint8 // ascending; empty for a rest
+	velocity     uint8
+	wholeMeasure bool // a rest filling the measure
+	tieStart     bool
+	tieStop      bool
+}
+
+// scoreVoice is the sequence of events of one voice in one measure, filling
+// the measure
+type scoreVoice struct {
+	number int
+	events []scoreEvent
+}
+
+// scoreTempo is a tempo change at an offset (in divisions) within a measure
+type scoreTempo struct {
+	offset int64
+	bpm    float64
+}
+
+// scoreMeasure is a bar, positioned in divisions of a quarter note
+type scoreMeasure struct {
+	number      int
+	tick        int64
+	start       int64
+	length      int64
+	numerator   uint8
+	denominator uint8
+	key         smf.Key
+	tempos      []scoreTempo
+}
+
+// scorePart is the music of one channel within one track
+type scorePart struct {
+	track      int
+	channel    uint8
+	name       string // the track name, if any
+	instrument string
+	program    uint8
+	percussion bool
+	bassClef   bool
+	measures   [][]scoreVoice // indexed like score.measures
+}
+
+// score is a quantized, measure by measure view of a file, for the notation
+// exporters
+type score struct {
+	ticksPerQuarter int64
+	divisions       int64
+	measures        []scoreMeasure
+	parts           []scorePart
+}
+
+// newScore quantizes the file's notes to the nearest division of a quarter
+// note (which must be a power of two) and lays them out in measures, with ties
+// across barlines and a voice for each overlapping line of notes
+func newScore(data *smf.SMF, divisions int64) *score {
+	m := newMeter(data)
+	tempos := newTempoMap(data)
+	notes := collectNotes(data)
+	keys := keyTimeline(data, notes)
+	s := &score{ticksPerQuarter: m.ticksPerQuarter, divisions: divisions}
+	end := lastTick(data)
+	for _, n := range notes {
+		end = max(end, n.end)
+	}
+	for bar := 1; bar == 1 || m.barStart(bar) < end; bar++ {
+		tick := m.barStart(bar)
+		next := m.barStart(bar + 1)
+		c := m.changeAtBar(bar)
+		measure := scoreMeasure{
+			number:      bar,
+			tick:        tick,
+			start:       s.quantize(tick),
+			length:      s.quantize(next) - s.quantize(tick),
+			numerator:   c.numerator,
+			denominator: c.denominator,
+		}
+		measure.key, _ = keyAt(keys, tick)
+		if bar == 1 {
+			measure.tempos = append(measure.tempos, scoreTempo{bpm: tempos.bpmAt(0)})
+		}
+		for _, t := range tempos.changes {
+			if t.tick > 0 && t.tick >= tick && t.tick < next {
+				measure.tempos = append(measure.tempos, scoreTempo{offset: s.quantize(t.tick) - measure.start, bpm: t.bpm})
+			}
+		}
+		s.measures = append(s.measures, measure)
+	}
+	type partKey struct {
+		track   int
+		channel uint8
+	}
+	grouped := map[partKey][]note{}
+	var order []partKey
+	for _, n := range notes {
+		k := partKey{track: n.track, channel: n.channel}
+		if _, found := grouped[k]; !found {
+			order = append(order, k)
+		}
+		grouped[k] = append(grouped[k], n)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].track != order[j].track {
+			return order[i].track < order[j].track
+		}
+		return order[i].channel < order[j].channel
+	})
+	for _, k := range order {
+		s.parts = append(s.parts, s.newPart(data.Tracks[k.track], k.track, k.channel, grouped[k]))
+	}
+	return s
+}
+
+// newPart names the part and lays out its notes
+func (s *score) newPart(track smf.Track, trackNumber int, channel uint8, notes []note) scorePart {
+	p := scorePart{track: trackNumber, channel: channel, percussion: channel == 9}
+	programFound := false
+	for _, event := range track {
+		var ch, program uint8
+		var name string
+		switch {
+		case event.Message.GetMetaTrackName(&name):
+			if p.name == "" {
+				p.name = name
+			}
+		case event.Message.GetProgramChange(&ch, &program):
+			if ch == channel && !programFound {
+				p.program = program
+				programFound = true
+			}
+		}
+	}
+	if p.percussion {
+		p.instrument = "Drum kit"
+	} else {
+		p.instrument = instruments[p.program&0x7F]
+		var sum int
+		for _, n := range notes {
+			sum += int(n.pitch)
+		}
+		p.bassClef = sum/len(notes) < 60
+	}
+	// notes with the same quantized start and end form a chord
+	type cluster struct {
+		start, end int64
+		pitches    []uint8
+		velocity   uint8
+		voice      int
+	}
+	var clusters []*cluster
+	byTime := map[[2]int64]*cluster{}
+	for _, n := range notes {
+		start := s.quantize(n.start)
+		end := max(s.quantize(n.end), start+1)
+		if c, found := byTime[[2]int64{start, end}]; found {
+			c.pitches = append(c.pitches, n.pitch)
+			continue
+		}
+		c := &cluster{start: start, end: end, pitches: []uint8{n.pitch}, velocity: n.velocity}
+		byTime[[2]int64{start, end}] = c
+		clusters = append(clusters, c)
+	}
+	for _, c := range clusters {
+		sort.Slice(c.pitches, func(i, j int) bool { return c.pitches[i] < c.pitches[j] })
+	}
+	// earlier chords, and higher chords at the same time, take the lower
+	// numbered voices
+	sort.SliceStable(clusters, func(i, j int) bool {
+		a, b := clusters[i], clusters[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.pitches[len(a.pitches)-1] > b.pitches[len(b.pitches)-1]
+	})
+	var voiceEnds []int64
+	for _, c := range clusters {
+		c.voice = len(voiceEnds)
+		for v, end := range voiceEnds {
+			if end <= c.start {
+				c.voice = v
+				break
+			}
+		}
+		if c.voice == len(voiceEnds) {
+			voiceEnds = append(voiceEnds, 0)
+		}
+		voiceEnds[c.voice] = c.end
+	}
+	for _, measure := range s.measures {
+		measureEnd := measure.start + measure.length
+		var voices []scoreVoice
+		for v := range voiceEnds {
+			voice := scoreVoice{number: v + 1}
+			position := measure.start
+			for _, c := range clusters {
+				if c.voice != v || c.end <= measure.start || c.start >= measureEnd {
+					continue
+				}
+				from, to := max(c.start, measure.start), min(c.end, measureEnd)
+				if from > position {
+					voice.events = s.appendEvents(voice.events, scoreEvent{offset: position - measure.start}, from-position)
+				}
+				voice.events = s.appendEvents(voice.events, scoreEvent{
+					offset:   from - measure.start,
+					pitches:  c.pitches,
+					velocity: c.velocity,
+					tieStop:  c.start < measure.start,
+					tieStart: c.end > measureEnd,
+				}, to-from)
+				position = to
+			}
+			switch {
+			case len(voice.events) == 0 && v == 0:
+				voice.events = []scoreEvent{{duration: measure.length, wholeMeasure: true}}
+			case len(voice.events) == 0:
+				continue
+			case position < measureEnd:
+				voice.events = s.appendEvents(voice.events, scoreEvent{offset: position - measure.start}, measureEnd-position)
+			}
+			voices = append(voices, voice)
+		}
+		if len(voices) == 0 {
+			voices = []scoreVoice{{number: 1, events: []scoreEvent{{duration: measure.length, wholeMeasure: true}}}}
+		}
+		p.measures = append(p.measures, voices)
+	}
+	return p
+}
+
+// quantize converts the tick to the nearest division
+func (s *score) quantize(tick int64) int64 {
+	return (tick*s.divisions*2 + s.ticksPerQuarter) / (2 * s.ticksPerQuarter)
+}
+
+// appendEvents appends the event, lasting the duration, as a sequence of
+// written values; notes are tied from one value to the next
+func (s *score) appendEvents(events []scoreEvent, e scoreEvent, duration int64) []scoreEvent {
+	values := s.noteValues(duration)
+	offset := e.offset
+	for k, v := range values {
+		piece := e
+		piece.offset = offset
+		piece.value = v
+		piece.duration = s.valueDuration(v)
+		if len(e.pitches) > 0 {
+			piece.tieStop = e.tieStop || k > 0
+			piece.tieStart = e.tieStart || k < len(values)-1
+		}
+		offset += piece.duration
+		events = append(events, piece)
+	}
+	return events
+}
+
+// noteValues splits the duration into written values, longest first
+func (s *score) noteValues(duration int64) []noteValue {
+	var candidates []noteValue
+	for denominator := 1; denominator <= 64; denominator *= 2 {
+		candidates = append(candidates, noteValue{denominator: denominator, dots: 1}, noteValue{denominator: denominator})
+	}
+	var values []noteValue
+	for duration > 0 {
+		found := false
+		for _, v := range candidates {
+			if d := s.valueDuration(v); d > 0 && d <= duration {
+				values = append(values, v)
+				duration -= d
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return values
+}
+
+// valueDuration returns the length of the value in divisions, or 0 if it
+// cannot be expressed in whole divisions
+func (s *score) valueDuration(v noteValue) int64 {
+	whole := s.divisions * 4
+	if whole%int64(v.denominator) != 0 {
+		return 0
+	}
+	base := whole / int64(v.denominator)
+	total := base
+	for dot := 1; dot <= v.dots; dot++ {
+		if base%2 != 0 {
+			return 0
+		}
+		base /= 2
+		total += base
+	}
+	return total
+}
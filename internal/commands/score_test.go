@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_score_noteValues(t *testing.T) {
+	s := &score{divisions: 4}
+	tests := map[string]struct {
+		duration int64
+		want     []noteValue
+	}{
+		"quarter":        {duration: 4, want: []noteValue{{denominator: 4}}},
+		"dotted half":    {duration: 12, want: []noteValue{{denominator: 2, dots: 1}}},
+		"half and 16th":  {duration: 9, want: []noteValue{{denominator: 2}, {denominator: 16}}},
+		"two whole tied": {duration: 32, want: []noteValue{{denominator: 1, dots: 1}, {denominator: 2}}},
+		"nothing":        {duration: 0, want: nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := s.noteValues(tt.duration); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("score.noteValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newScore(t *testing.T) {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaMeter(3, 4))
+	conductor.Add(1440, smf.MetaTempo(90))
+	var melody smf.Track
+	// a dotted half note tied over the barline into a quarter note, and a
+	// second voice starting under it
+	melody.Add(960, midi.NoteOn(0, 72, 80))
+	melody.Add(480, midi.NoteOn(0, 60, 80))
+	melody.Add(480, midi.NoteOff(0, 60))
+	melody.Add(480, midi.NoteOff(0, 72))
+	s := newScore(makeTestSMF(conductor, melody), 4)
+	if len(s.measures) != 2 {
+		t.Fatalf("newScore() has %d measures, want 2", len(s.measures))
+	}
+	if got := s.measures[1].tempos; len(got) != 1 || got[0].offset != 0 || round3(got[0].bpm) != 90 {
+		t.Errorf("newScore() measure 2 tempos = %v, want 90 bpm at offset 0", got)
+	}
+	if len(s.parts) != 1 {
+		t.Fatalf("newScore() has %d parts, want 1", len(s.parts))
+	}
+	p := s.parts[0]
+	if p.instrument != "Acoustic grand piano" || p.bassClef {
+		t.Errorf("newScore() part = %q, bass clef %t", p.instrument, p.bassClef)
+	}
+	want := [][]scoreVoice{
+		{
+			{number: 1, events: []scoreEvent{
+				{offset: 0, duration: 8, value: noteValue{denominator: 2}},
+				{offset: 8, duration: 4, value: noteValue{denominator: 4}, pitches: []uint8{72}, velocity: 80, tieStart: true},
+			}},
+		},
+		{
+			{number: 1, events: []scoreEvent{
+				{offset: 0, duration: 8, value: noteValue{denominator: 2}, pitches: []uint8{72}, velocity: 80, tieStop: true},
+				{offset: 8, duration: 4, value: noteValue{denominator: 4}},
+			}},
+			{number: 2, events: []scoreEvent{
+				{offset: 0, duration: 4, value: noteValue{denominator: 4}, pitches: []uint8{60}, velocity: 80},
+				{offset: 4, duration: 8, value: noteValue{denominator: 2}},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(p.measures, want) {
+		t.Errorf("newScore() measures = %+v, want %+v", p.measures, want)
+	}
+}
@@ -0,0 +1,252 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+)
+
+// melodyLine is a monophonic line of notes: the highest of each group of notes
+// starting together
+type melodyLine struct {
+	pitches []uint8
+	starts  []int64
+}
+
+// newMelodyLine reduces notes, sorted by start, to the line heard on top
+func newMelodyLine(notes []note) melodyLine {
+	var line melodyLine
+	for _, n := range notes {
+		if last := len(line.starts) - 1; last >= 0 && line.starts[last] == n.start {
+			line.pitches[last] = max(line.pitches[last], n.pitch)
+			continue
+		}
+		line.pitches = append(line.pitches, n.pitch)
+		line.starts = append(line.starts, n.start)
+	}
+	return line
+}
+
+// melodyMatch is where a line plays the melody
+type melodyMatch struct {
+	tick      int64
+	transpose int
+}
+
+// find returns where the line plays the melody's intervals; with a positive
+// tolerance, the time between notes must also be in the melody's proportions,
+// each within that fraction
+func (l melodyLine) find(melody melodyLine, tolerance float64) []melodyMatch {
+	var matches []melodyMatch
+	size := len(melody.pitches)
+	for at := 0; at+size <= len(l.pitches); at++ {
+		if l.matchesAt(at, melody, tolerance) {
+			matches = append(matches, melodyMatch{
+				tick:      l.starts[at],
+				transpose: int(l.pitches[at]) - int(melody.pitches[0]),
+			})
+		}
+	}
+	return matches
+}
+
+func (l melodyLine) matchesAt(at int, melody melodyLine, tolerance float64) bool {
+	for k := 1; k < len(melody.pitches); k++ {
+		wanted := int(melody.pitches[k]) - int(melody.pitches[k-1])
+		if int(l.pitches[at+k])-int(l.pitches[at+k-1]) != wanted {
+			return false
+		}
+	}
+	if tolerance <= 0 || len(melody.starts) < 3 {
+		return true
+	}
+	// compare each gap between notes, relative to the first gap
+	firstGap := float64(l.starts[at+1] - l.starts[at])
+	melodyFirstGap := float64(melody.starts[1] - melody.starts[0])
+	for k := 2; k < len(melody.starts); k++ {
+		gap := float64(l.starts[at+k]-l.starts[at+k-1]) / firstGap
+		wanted := float64(melody.starts[k]-melody.starts[k-1]) / melodyFirstGap
+		if gap < wanted*(1-tolerance) || gap > wanted*(1+tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// melodyWholeTicks is the length of a whole note in the lines parsed from
+// notes with durations
+const melodyWholeTicks = 3840
+
+// parseMelody parses notes separated by spaces or commas, either all spelled
+// as for --pitches, e.g., "E5 D5 C5 D5 E5 E5 E5", or all in the token syntax
+// of the README, with durations, rests, and chords (whose top note is the
+// melody), e.g., "E5q. D5e C5q D5q E5q E5q E5h"; it reports whether the notes
+// gave the melody a rhythm
+func parseMelody(s string) (line melodyLine, rhythm bool, err error) {
+	var now big.Rat
+	for k, name := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if hasDuration := strings.LastIndexAny(name, "whqestx") > 0; k == 0 {
+			rhythm = hasDuration
+		} else if hasDuration != rhythm {
+			return line, rhythm, fmt.Errorf("either every note must have a duration, or none")
+		}
+		if !rhythm {
+			pitch, err := parseNote(name)
+			if err != nil {
+				return line, rhythm, err
+			}
+			line.pitches = append(line.pitches, pitch)
+			line.starts = append(line.starts, int64(len(line.starts)))
+			continue
+		}
+		pitches, length, err := parseTokenNote(name)
+		if err != nil {
+			return line, rhythm, err
+		}
+		if len(pitches) > 0 {
+			ticks, _ := new(big.Rat).Mul(&now, big.NewRat(melodyWholeTicks, 1)).Float64()
+			line.pitches = append(line.pitches, slices.Max(pitches))
+			line.starts = append(line.starts, int64(math.Round(ticks)))
+		}
+		now.Add(&now, length)
+	}
+	return line, rhythm, nil
+}
+
+// search finds a melody in files, in any key
+type search struct {
+	melody     string
+	melodyFile string
+	tolerance  float64
+	query      melodyLine
+	rhythm     bool
+}
+
+func newSearch() command {
+	return &search{}
+}
+
+func (s *search) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&s.melody, "melody", "", "notes of the melody to find, e.g. \"E5 D5 C5 D5 E5\", or with durations, "+
+		"e.g. \"E5q. D5e C5q D5q E5q\"")
+	flags.StringVar(&s.melodyFile, "melody-file", "", "file holding the melody to find (the highest note at each onset)")
+	flags.Float64Var(&s.tolerance, "rhythm-tolerance", 0,
+		"if more than 0, match the melody's rhythm, each gap between notes within this fraction")
+}
+
+func (s *search) run(o output.Bus, args []string) int {
+	valid := true
+	switch {
+	case (s.melody == "") == (s.melodyFile == ""):
+		o.ErrorPrintln("Either --melody or --melody-file must be specified, but not both.")
+		valid = false
+	case s.melody != "":
+		query, rhythm, err := parseMelody(s.melody)
+		if err != nil {
+			o.ErrorPrintf("The --melody value %q is not valid: %v.\n", s.melody, err)
+			valid = false
+		}
+		s.query, s.rhythm = query, rhythm
+	default:
+		data, ok := readSMF(o, s.melodyFile)
+		if !ok {
+			return exitSystemError
+		}
+		s.query, s.rhythm = newMelodyLine(pitchedNotes(collectNotes(data))), true
+	}
+	if s.tolerance < 0 || s.tolerance >= 1 {
+		o.ErrorPrintf("The --rhythm-tolerance value %g is not valid: it must be at least 0 and less than 1.\n",
+			s.tolerance)
+		valid = false
+	}
+	if s.tolerance > 0 && !s.rhythm {
+		o.ErrorPrintln("The --rhythm-tolerance flag needs a rhythm: a --melody-file, or --melody notes with durations.")
+		valid = false
+	}
+	if valid && len(s.query.pitches) < 2 {
+		o.ErrorPrintln("The melody must have at least two notes.")
+		valid = false
+	}
+	if !valid {
+		return exitUserError
+	}
+	counts, exitCode := mapFiles(o, args, midiExtensions, s.searchFile)
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 && exitCode == exitSuccess {
+		o.ErrorPrintln("The melody was not found.")
+	}
+	return exitCode
+}
+
+// searchFile prints each place where a track and channel of the file plays
+// the melody, and returns how many there are
+func (s *search) searchFile(o output.Bus, path string) (int, bool) {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return 0, false
+	}
+	m := newMeter(data)
+	found := 0
+	for index, track := range data.Tracks {
+		var name string
+		for _, event := range track {
+			if event.Message.GetMetaTrackName(&name) {
+				break
+			}
+		}
+		byChannel := map[uint8][]note{}
+		for _, n := range pitchedNotes(collectTrackNotes(index, track)) {
+			byChannel[n.channel] = append(byChannel[n.channel], n)
+		}
+		channels := make([]int, 0, len(byChannel))
+		for channel := range byChannel {
+			channels = append(channels, int(channel))
+		}
+		sort.Ints(channels)
+		for _, channel := range channels {
+			notes := byChannel[uint8(channel)]
+			sort.SliceStable(notes, func(i, j int) bool { return notes[i].start < notes[j].start })
+			for _, match := range newMelodyLine(notes).find(s.query, s.tolerance) {
+				o.ConsolePrintf("%s: track %d%s channel %d, %s, %s\n", path, index, quotedName(name), channel,
+					m.describe(match.tick), describeTransposition(match.transpose))
+				found++
+			}
+		}
+	}
+	return found, true
+}
+
+// pitchedNotes drops the notes of the percussion channel
+func pitchedNotes(notes []note) []note {
+	var pitched []note
+	for _, n := range notes {
+		if n.channel != 9 {
+			pitched = append(pitched, n)
+		}
+	}
+	return pitched
+}
+
+func describeTransposition(semitones int) string {
+	switch {
+	case semitones == 0:
+		return "as given"
+	case semitones == 1:
+		return "1 semitone higher"
+	case semitones == -1:
+		return "1 semitone lower"
+	case semitones > 0:
+		return fmt.Sprintf("%d semitones higher", semitones)
+	default:
+		return fmt.Sprintf("%d semitones lower", -semitones)
+	}
+}
@@ -0,0 +1,205 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// searchTestSMF builds a file with a track playing each line of pitches, one
+// note per gap; a line on channel 9 is percussion
+func searchTestSMF(lines map[uint8][]uint8, gaps []uint32) *smf.SMF {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaMeter(4, 4))
+	tracks := []smf.Track{conductor}
+	for _, channel := range []uint8{0, 1, 9} {
+		pitches, found := lines[channel]
+		if !found {
+			continue
+		}
+		var track smf.Track
+		track.Add(0, smf.MetaTrackSequenceName(map[uint8]string{0: "Flute", 1: "Cello", 9: "Drums"}[channel]))
+		for k, pitch := range pitches {
+			gap := gaps[k%len(gaps)]
+			track.Add(0, midi.NoteOn(channel, pitch, 80))
+			track.Add(gap, midi.NoteOff(channel, pitch))
+		}
+		tracks = append(tracks, track)
+	}
+	return makeTestSMF(tracks...)
+}
+
+func Test_search_run(t *testing.T) {
+	// E D C D E E E, then again a fifth lower; the drums play the same pitches,
+	// which are not melody
+	tune := []uint8{64, 62, 60, 62, 64, 64, 64, 57, 55, 53, 55, 57, 57, 57}
+	motif := searchTestSMF(map[uint8][]uint8{0: {76, 74, 72, 74}}, []uint32{240, 240, 480})
+	useMemoryFileSystem(t, map[string][]byte{
+		"/cues/a.mid": smfBytes(t, searchTestSMF(map[uint8][]uint8{0: tune, 9: {76, 74, 72, 74}},
+			[]uint32{480})),
+		"/cues/b.mid": smfBytes(t, searchTestSMF(map[uint8][]uint8{1: {48, 50, 52, 50, 48, 46, 44, 46}},
+			[]uint32{240, 240, 480, 480})),
+		"/motif.mid": smfBytes(t, motif),
+	})
+	tests := map[string]struct {
+		s    *search
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"note names": {
+			s:    &search{melody: "E5 D5 C5 D5"},
+			args: []string{"/cues"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/cues/a.mid: track 1 \"Flute\" channel 0, bar 1 beat 1, as given\n" +
+					"/cues/a.mid: track 1 \"Flute\" channel 0, bar 2 beat 4, 7 semitones lower\n" +
+					"/cues/b.mid: track 1 \"Cello\" channel 1, bar 1 beat 4, 16 semitones lower\n",
+				Error: "2 files: 2 ok, 0 failed, 0 with warnings.\n",
+			},
+		},
+		"melody file, any rhythm": {
+			s:    &search{melodyFile: "/motif.mid"},
+			args: []string{"/cues/b.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/cues/b.mid: track 1 \"Cello\" channel 1, bar 1 beat 4, 28 semitones lower\n",
+			},
+		},
+		"melody file, its rhythm": {
+			s:    &search{melodyFile: "/motif.mid", tolerance: 0.2},
+			args: []string{"/cues/a.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Error: "The melody was not found.\n",
+			},
+		},
+		"melody file, its rhythm found": {
+			s:    &search{melodyFile: "/motif.mid", tolerance: 0.2},
+			args: []string{"/cues/b.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/cues/b.mid: track 1 \"Cello\" channel 1, bar 1 beat 4, 28 semitones lower\n",
+			},
+		},
+		"note tokens, their rhythm": {
+			s:    &search{melody: "E6e D6e C6q D6q", tolerance: 0.2},
+			args: []string{"/cues/a.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Error: "The melody was not found.\n",
+			},
+		},
+		"note tokens, their rhythm found": {
+			s:    &search{melody: "E6e D6e C6q D6q", tolerance: 0.2},
+			args: []string{"/cues/b.mid"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/cues/b.mid: track 1 \"Cello\" channel 1, bar 1 beat 4, 28 semitones lower\n",
+			},
+		},
+		"no melody": {
+			s:    &search{},
+			args: []string{"/cues"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "Either --melody or --melody-file must be specified, but not both.\n",
+			},
+		},
+		"bad values": {
+			s:    &search{melody: "E5 X", tolerance: 1},
+			args: []string{"/cues"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --melody value \"E5 X\" is not valid: \"X\" is not a note.\n" +
+					"The --rhythm-tolerance value 1 is not valid: it must be at least 0 and less than 1.\n" +
+					"The --rhythm-tolerance flag needs a rhythm: a --melody-file, or --melody notes with durations.\n",
+			},
+		},
+		"too short": {
+			s:    &search{melody: "E5"},
+			args: []string{"/cues"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The melody must have at least two notes.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.s.run(o, tt.args); got != tt.want {
+				t.Errorf("search.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("search.run() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseMelody(t *testing.T) {
+	tests := map[string]struct {
+		s          string
+		want       melodyLine
+		wantRhythm bool
+		wantErr    string
+	}{
+		"note names": {
+			s:    "E5, D5 C5",
+			want: melodyLine{pitches: []uint8{64, 62, 60}, starts: []int64{0, 1, 2}},
+		},
+		"note tokens": {
+			s:          "E5q. D5e C5e3 D5e3 Re3 C5h",
+			want:       melodyLine{pitches: []uint8{64, 62, 60, 62, 60}, starts: []int64{0, 1440, 1920, 2240, 2880}},
+			wantRhythm: true,
+		},
+		"chord": {
+			s:          "C5+E5+G5h F5q",
+			want:       melodyLine{pitches: []uint8{67, 65}, starts: []int64{0, 1920}},
+			wantRhythm: true,
+		},
+		"mixed":      {s: "E5q D5", wantErr: "either every note must have a duration, or none"},
+		"bad token":  {s: "E5q H5q", wantErr: "\"H5\" is not a pitch"},
+		"bad pitch":  {s: "E5 X", wantErr: "\"X\" is not a note"},
+		"bad rhythm": {s: "E5q D5y", wantErr: "either every note must have a duration, or none"},
+		"bad length": {s: "E5q D5q3.", wantErr: "\"q3.\" is not a duration (w, h, q, e, s, t, or x, with any dots, and 3 for a triplet)"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, rhythm, err := parseMelody(tt.s)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseMelody() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMelody() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || rhythm != tt.wantRhythm {
+				t.Errorf("parseMelody() = %v, %t, want %v, %t", got, rhythm, tt.want, tt.wantRhythm)
+			}
+		})
+	}
+}
+
+func Test_newMelodyLine(t *testing.T) {
+	notes := []note{
+		{pitch: 60, start: 0}, {pitch: 64, start: 0}, {pitch: 67, start: 0},
+		{pitch: 62, start: 480},
+		{pitch: 55, start: 960}, {pitch: 59, start: 960},
+	}
+	line := newMelodyLine(notes)
+	if got, want := line.pitches, []uint8{67, 62, 59}; string(got) != string(want) {
+		t.Errorf("newMelodyLine() pitches = %v, want %v", got, want)
+	}
+	if got := line.starts; len(got) != 3 || got[0] != 0 || got[1] != 480 || got[2] != 960 {
+		t.Errorf("newMelodyLine() starts = %v, want [0 480 960]", got)
+	}
+}
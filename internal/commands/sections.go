@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// section is a named part of a file, from a marker or cue point up to the
+// next one, or the end
+type section struct {
+	name string
+	from int64
+	to   int64
+}
+
+// findSections returns the sections the file's markers and cue points mark
+// out, in order; of several at the same tick, the last names the section
+func findSections(data *smf.SMF) []section {
+	var starts []section
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var name string
+			if event.Message.GetMetaMarker(&name) || event.Message.GetMetaCuepoint(&name) {
+				starts = append(starts, section{name: strings.TrimSpace(name), from: ticks[k]})
+			}
+		}
+	}
+	sort.SliceStable(starts, func(i, j int) bool { return starts[i].from < starts[j].from })
+	end := lastTick(data)
+	var sections []section
+	for k, s := range starts {
+		s.to = end
+		if k+1 < len(starts) {
+			s.to = starts[k+1].from
+		}
+		if s.to > s.from {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// findSection returns the first section with the name, which may differ in
+// case if no section has it exactly
+func findSection(sections []section, name string) (section, bool) {
+	if k := slices.IndexFunc(sections, func(s section) bool { return s.name == name }); k >= 0 {
+		return sections[k], true
+	}
+	if k := slices.IndexFunc(sections, func(s section) bool { return strings.EqualFold(s.name, name) }); k >= 0 {
+		return sections[k], true
+	}
+	return section{}, false
+}
+
+// sectionNames lists the names of the sections, quoted, for errors
+func sectionNames(sections []section) string {
+	names := make([]string, 0, len(sections))
+	for _, s := range sections {
+		names = append(names, strconv.Quote(s.name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// sections lists the sections of files marked out by markers and cue points
+type sections struct{}
+
+func newSections() command {
+	return &sections{}
+}
+
+func (s *sections) defineFlags(_ *pflag.FlagSet) {}
+
+func (s *sections) run(o output.Bus, args []string) int {
+	return processFiles(o, args, s.processFile)
+}
+
+func (s *sections) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	found := findSections(data)
+	if len(found) == 0 {
+		o.ErrorPrintf("The file %q has no markers or cue points.\n", path)
+		return true
+	}
+	m := newMeter(data)
+	tempos := newTempoMap(data)
+	for _, section := range found {
+		o.ConsolePrintf("%s: %q from %s to %s, %g seconds\n", path, section.name, m.describe(section.from),
+			m.describe(section.to), round3(tempos.seconds(section.to)-tempos.seconds(section.from)))
+	}
+	return true
+}
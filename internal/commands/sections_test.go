@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// sectionsTestSMF builds a file with an intro, a chorus at bar 3, and an
+// outro at bar 5, the tempo slowing to 60 at bar 2; the violin sets its bank,
+// program, volume, and pitch bend range at the start, and plays notes across
+// the chorus's boundaries
+func sectionsTestSMF() *smf.SMF {
+	var conductor, part smf.Track
+	conductor.Add(0, smf.MetaMeter(4, 4))
+	conductor.Add(0, smf.MetaTempo(120))
+	conductor.Add(0, smf.MetaMarker("Intro"))
+	conductor.Add(1920, smf.MetaTempo(60))
+	conductor.Add(1920, smf.MetaMarker("Chorus"))
+	conductor.Add(3840, smf.MetaCuepoint("Outro"))
+	part.Add(0, smf.MetaTrackSequenceName("Violin"))
+	part.Add(0, midi.ControlChange(0, 0, 1))
+	part.Add(0, midi.ProgramChange(0, 40))
+	part.Add(0, midi.ControlChange(0, 7, 100))
+	part.Add(0, midi.ControlChange(0, 101, 0))
+	part.Add(0, midi.ControlChange(0, 100, 0))
+	part.Add(0, midi.ControlChange(0, 6, 12))
+	part.Add(0, midi.ControlChange(0, 101, 127))
+	part.Add(0, midi.ControlChange(0, 100, 127))
+	part.Add(3000, midi.NoteOn(0, 60, 80))
+	part.Add(1320, midi.NoteOff(0, 60))
+	part.Add(0, midi.NoteOn(0, 62, 80))
+	part.Add(680, midi.NoteOff(0, 62))
+	part.Add(2000, midi.NoteOn(0, 64, 80))
+	part.Add(1000, midi.NoteOff(0, 64))
+	part.Add(0, midi.NoteOn(0, 65, 80))
+	part.Add(1600, midi.NoteOff(0, 65))
+	return makeTestSMF(conductor, part)
+}
+
+func Test_findSections(t *testing.T) {
+	got := findSections(sectionsTestSMF())
+	want := []section{{name: "Intro", from: 0, to: 3840}, {name: "Chorus", from: 3840, to: 7680},
+		{name: "Outro", from: 7680, to: 9600}}
+	if len(got) != len(want) {
+		t.Fatalf("findSections() = %v, want %v", got, want)
+	}
+	for k := range got {
+		if got[k] != want[k] {
+			t.Errorf("findSections()[%d] = %v, want %v", k, got[k], want[k])
+		}
+	}
+	if s, found := findSection(got, "chorus"); !found || s.name != "Chorus" {
+		t.Errorf("findSection() = %v, %t, want the chorus", s, found)
+	}
+}
+
+func Test_sections_run(t *testing.T) {
+	useMemoryFileSystem(t, map[string][]byte{
+		"/song.mid":  smfBytes(t, sectionsTestSMF()),
+		"/plain.mid": smfBytes(t, makeTestSMF(smf.Track{})),
+	})
+	o := output.NewRecorder()
+	if got := (&sections{}).run(o, []string{"/song.mid", "/plain.mid"}); got != exitSuccess {
+		t.Errorf("sections.run() = %d, want %d", got, exitSuccess)
+	}
+	if issues, ok := o.Verify(output.WantedRecording{
+		Console: "/song.mid: \"Intro\" from bar 1 beat 1 to bar 3 beat 1, 6 seconds\n" +
+			"/song.mid: \"Chorus\" from bar 3 beat 1 to bar 5 beat 1, 8 seconds\n" +
+			"/song.mid: \"Outro\" from bar 5 beat 1 to bar 6 beat 1, 4 seconds\n",
+		Error: "The file \"/plain.mid\" has no markers or cue points.\n" +
+			"2 files: 1 ok, 0 failed, 1 with warnings.\n",
+	}); !ok {
+		for _, issue := range issues {
+			t.Errorf("sections.run() %s", issue)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// eventSelection restricts an operation to a subset of a file's events; nil
+// track and channel sets select everything
+type eventSelection struct {
+	tracks    map[int]bool
+	channels  map[int]bool
+	lowPitch  uint8
+	highPitch uint8
+	span      tickRange
+}
+
+// selectionFlags holds the raw values of the flags used to build an
+// eventSelection
+type selectionFlags struct {
+	tracks   string
+	channels string
+	pitches  string
+	from     string
+	to       string
+}
+
+func (sf *selectionFlags) define(flags *pflag.FlagSet) {
+	flags.StringVar(&sf.tracks, "tracks", "", "tracks to include, e.g. 1,3..5 (default all)")
+	flags.StringVar(&sf.channels, "channels", "", "channels to include, e.g. 0,9 (default all)")
+	flags.StringVar(&sf.pitches, "pitches", "", "pitch range to include, e.g. C4..G5 or 60..79 (default all)")
+	flags.StringVar(&sf.from, "from", "", "start position (bar, bar:beat, or ticks followed by t)")
+	flags.StringVar(&sf.to, "to", "", "end position, exclusive (bar, bar:beat, or ticks followed by t)")
+}
+
+// validate checks the syntax of the flags, which does not depend on the file
+func (sf *selectionFlags) validate() error {
+	if _, err := sf.parse(); err != nil {
+		return err
+	}
+	for _, p := range []string{sf.from, sf.to} {
+		if p != "" {
+			if _, err := parsePosition(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sf *selectionFlags) build(m *meter) (s eventSelection, err error) {
+	if s, err = sf.parse(); err != nil {
+		return
+	}
+	s.span, err = parseTickRange(m, sf.from, sf.to)
+	return
+}
+
+// parse builds the selection of tracks, channels, and pitches
+func (sf *selectionFlags) parse() (s eventSelection, err error) {
+	if s.tracks, err = parseNumberSet(sf.tracks, 0, 65535); err != nil {
+		return
+	}
+	if s.channels, err = parseNumberSet(sf.channels, 0, 15); err != nil {
+		return
+	}
+	s.highPitch = 127
+	if sf.pitches != "" {
+		low, high, found := strings.Cut(sf.pitches, "..")
+		if !found {
+			high = low
+		}
+		if s.lowPitch, err = parseNote(low); err != nil {
+			return
+		}
+		if s.highPitch, err = parseNote(high); err != nil {
+			return
+		}
+		if s.highPitch < s.lowPitch {
+			err = fmt.Errorf("the pitch range %q is empty", sf.pitches)
+		}
+	}
+	return
+}
+
+func (s eventSelection) includesTrack(track int) bool {
+	return s.tracks == nil || s.tracks[track]
+}
+
+func (s eventSelection) includesChannel(channel uint8) bool {
+	return s.channels == nil || s.channels[int(channel)]
+}
+
+func (s eventSelection) includesPitch(pitch uint8) bool {
+	return pitch >= s.lowPitch && pitch <= s.highPitch
+}
+
+// selectNotes returns the notes of the selected tracks, channels, and pitches
+// that sound within the selected span: those that start in it, and those that
+// start before it and end after its start
+func (s eventSelection) selectNotes(notes []note) []note {
+	var selected []note
+	for _, n := range notes {
+		if s.includesTrack(n.track) && s.includesChannel(n.channel) && s.includesPitch(n.pitch) &&
+			(n.start >= s.span.from || n.end > s.span.from) && (s.span.to < 0 || n.start < s.span.to) {
+			selected = append(selected, n)
+		}
+	}
+	return selected
+}
+
+// parseNumberSet parses a comma-separated list of numbers and ranges (e.g.,
+// "1,3..5"); an empty string yields a nil set
+func parseNumberSet(s string, low, high int) (map[int]bool, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	set := map[int]bool{}
+	for _, item := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(item), "..")
+		if !isRange {
+			last = first
+		}
+		from, err1 := strconv.Atoi(first)
+		to, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil || from > to || from < low || to > high {
+			return nil, fmt.Errorf("%q is not a valid value or range between %d and %d", item, low, high)
+		}
+		for n := from; n <= to; n++ {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func Test_parseNumberSet(t *testing.T) {
+	tests := map[string]struct {
+		s       string
+		want    map[int]bool
+		wantErr bool
+	}{
+		"empty":        {s: ""},
+		"single":       {s: "3", want: map[int]bool{3: true}},
+		"list":         {s: "1, 4", want: map[int]bool{1: true, 4: true}},
+		"range":        {s: "2..4,9", want: map[int]bool{2: true, 3: true, 4: true, 9: true}},
+		"out of range": {s: "16", wantErr: true},
+		"backwards":    {s: "4..2", wantErr: true},
+		"garbage":      {s: "x", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseNumberSet(tt.s, 0, 15)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNumberSet() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNumberSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_selectionFlags_build(t *testing.T) {
+	m := newMeter(makeTestSMF(smf.Track{}))
+	tests := map[string]struct {
+		sf      selectionFlags
+		wantErr bool
+	}{
+		"defaults":         {},
+		"single pitch":     {sf: selectionFlags{pitches: "C5"}},
+		"bad tracks":       {sf: selectionFlags{tracks: "-1"}, wantErr: true},
+		"bad channels":     {sf: selectionFlags{channels: "99"}, wantErr: true},
+		"bad low pitch":    {sf: selectionFlags{pitches: "X..C5"}, wantErr: true},
+		"bad high pitch":   {sf: selectionFlags{pitches: "C5..X"}, wantErr: true},
+		"empty pitch span": {sf: selectionFlags{pitches: "C5..B4"}, wantErr: true},
+		"bad range":        {sf: selectionFlags{from: "x"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := tt.sf.build(m); (err != nil) != tt.wantErr {
+				t.Errorf("selectionFlags.build() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_selectionFlags_validate(t *testing.T) {
+	tests := map[string]struct {
+		sf      selectionFlags
+		wantErr bool
+	}{
+		"defaults":         {},
+		"positions":        {sf: selectionFlags{from: "2", to: "3:2"}},
+		"bad channels":     {sf: selectionFlags{channels: "16"}, wantErr: true},
+		"empty pitch span": {sf: selectionFlags{pitches: "C5..B4"}, wantErr: true},
+		"bad from":         {sf: selectionFlags{from: "x"}, wantErr: true},
+		"bad to":           {sf: selectionFlags{to: "0"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.sf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("selectionFlags.validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_eventSelection_selectNotes(t *testing.T) {
+	notes := []note{
+		{pitch: 60, start: 0, end: 480},
+		{pitch: 62, start: 240, end: 720},
+		{pitch: 64, start: 480, end: 480},
+		{pitch: 65, start: 480, end: 960},
+		{pitch: 67, start: 960, end: 1440},
+	}
+	s := eventSelection{highPitch: 127, span: tickRange{from: 480, to: 960}}
+	var got []uint8
+	for _, n := range s.selectNotes(notes) {
+		got = append(got, n.pitch)
+	}
+	// the note ending where the span starts, and the one starting where it
+	// ends, are outside it
+	if want := []uint8{62, 64, 65}; !reflect.DeepEqual(got, want) {
+		t.Errorf("eventSelection.selectNotes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,705 @@
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// the SoundFont 2 generators that the player uses
+const (
+	sfStartOffset           = 0
+	sfEndOffset             = 1
+	sfStartLoopOffset       = 2
+	sfEndLoopOffset         = 3
+	sfStartCoarseOffset     = 4
+	sfEndCoarseOffset       = 12
+	sfPan                   = 17
+	sfDelayVolumeEnvelope   = 33
+	sfAttackVolumeEnvelope  = 34
+	sfHoldVolumeEnvelope    = 35
+	sfDecayVolumeEnvelope   = 36
+	sfSustainVolumeEnvelope = 37
+	sfReleaseVolumeEnvelope = 38
+	sfInstrumentID          = 41
+	sfKeyRange              = 43
+	sfVelocityRange         = 44
+	sfStartLoopCoarseOffset = 45
+	sfKeyNumber             = 46
+	sfVelocity              = 47
+	sfInitialAttenuation    = 48
+	sfEndLoopCoarseOffset   = 50
+	sfCoarseTune            = 51
+	sfFineTune              = 52
+	sfSampleID              = 53
+	sfSampleModes           = 54
+	sfScaleTuning           = 56
+	sfExclusiveClass        = 57
+	sfOverridingRootKey     = 58
+	sfGenerators            = 61
+)
+
+const (
+	sfSilence                = 1000.0 // centibels of attenuation at which a voice is silent
+	sfLoopContinuously       = 1
+	sfLoopUntilRelease       = 3
+	sfROMSample              = 0x8000
+	sfVelocityToAttenuation  = 0x0502 // velocity, decreasing, unipolar, concave
+	sfDefaultVelocityEffect  = 960
+	sfAbsoluteValueTransform = 2
+)
+
+// soundFont holds the presets of a SoundFont 2 file, with their instruments
+// and samples
+type soundFont struct {
+	presets []*sfPreset
+	data    []int16 // the sample data points of every sample
+}
+
+// sfPreset is what a bank and program select
+type sfPreset struct {
+	name    string
+	bank    uint16
+	program uint16
+	zones   []*sfZone
+}
+
+// sfInstrument is a set of samples, each zone covering its own keys and
+// velocities
+type sfInstrument struct {
+	name  string
+	zones []*sfZone
+}
+
+// sfZone is a preset zone (naming an instrument) or an instrument zone (naming
+// a sample), with the global zone's generators and modulators folded in
+type sfZone struct {
+	generators [sfGenerators]int16
+	set        [sfGenerators]bool
+	modulators []sfModulator
+	instrument *sfInstrument
+	sample     *sfSample
+}
+
+// sfSample is a sample header; the positions index the sample data points
+type sfSample struct {
+	name       string
+	start      uint32
+	end        uint32
+	loopStart  uint32
+	loopEnd    uint32
+	rate       uint32
+	rootKey    uint8
+	correction int8 // in cents
+	sampleType uint16
+}
+
+// sfModulator routes a controller, scaled by the amount (and by a second
+// controller), to a generator
+type sfModulator struct {
+	source       uint16
+	destination  uint16
+	amount       int16
+	amountSource uint16
+	transform    uint16
+}
+
+// parseSoundFont reads the presets, instruments, and samples of a SoundFont
+// 2 file
+func parseSoundFont(content []byte) (*soundFont, error) {
+	if len(content) < 12 || string(content[0:4]) != "RIFF" || string(content[8:12]) != "sfbk" {
+		return nil, errors.New("it is not a RIFF sfbk file")
+	}
+	size := binary.LittleEndian.Uint32(content[4:8])
+	if uint64(size)+8 > uint64(len(content)) || size < 4 {
+		return nil, errors.New("the RIFF chunk is truncated")
+	}
+	lists, err := riffChunks(content[12 : 8+size])
+	if err != nil {
+		return nil, err
+	}
+	sdta, err := riffChunks(lists["sdta"])
+	if err != nil {
+		return nil, err
+	}
+	smpl, found := sdta["smpl"]
+	if !found {
+		return nil, errors.New("the smpl chunk is missing")
+	}
+	font := &soundFont{data: make([]int16, len(smpl)/2)}
+	for k := range font.data {
+		font.data[k] = int16(binary.LittleEndian.Uint16(smpl[2*k:]))
+	}
+	pdta, err := riffChunks(lists["pdta"])
+	if err != nil {
+		return nil, err
+	}
+	records := map[string][][]byte{}
+	for _, chunk := range []struct {
+		id   string
+		size int
+	}{
+		{"phdr", 38}, {"pbag", 4}, {"pmod", 10}, {"pgen", 4},
+		{"inst", 22}, {"ibag", 4}, {"imod", 10}, {"igen", 4}, {"shdr", 46},
+	} {
+		body, found := pdta[chunk.id]
+		if !found {
+			return nil, fmt.Errorf("the %s chunk is missing", chunk.id)
+		}
+		if len(body) == 0 || len(body)%chunk.size != 0 {
+			return nil, fmt.Errorf("the %s chunk's size %d is not a multiple of %d", chunk.id, len(body),
+				chunk.size)
+		}
+		for k := 0; k < len(body); k += chunk.size {
+			records[chunk.id] = append(records[chunk.id], body[k:k+chunk.size])
+		}
+	}
+	u16 := func(b []byte, at int) uint16 { return binary.LittleEndian.Uint16(b[at:]) }
+	u32 := func(b []byte, at int) uint32 { return binary.LittleEndian.Uint32(b[at:]) }
+	// the last record of each list is a terminal record
+	var samples []*sfSample
+	for _, r := range records["shdr"][:len(records["shdr"])-1] {
+		s := &sfSample{
+			name:       sfName(r),
+			start:      u32(r, 20),
+			end:        u32(r, 24),
+			loopStart:  u32(r, 28),
+			loopEnd:    u32(r, 32),
+			rate:       u32(r, 36),
+			rootKey:    r[40],
+			correction: int8(r[41]),
+			sampleType: u16(r, 44),
+		}
+		if s.sampleType&sfROMSample == 0 && (s.start > s.end || int(s.end) > len(font.data)) {
+			return nil, fmt.Errorf("the sample %q runs past the end of the sample data", s.name)
+		}
+		samples = append(samples, s)
+	}
+	var instruments []*sfInstrument
+	instrumentRecords := records["inst"]
+	for k, r := range instrumentRecords[:len(instrumentRecords)-1] {
+		instrument := &sfInstrument{name: sfName(r)}
+		zones, err := sfZones(records["ibag"], records["igen"], records["imod"], int(u16(r, 20)),
+			int(u16(instrumentRecords[k+1], 20)), sfSampleID)
+		if err != nil {
+			return nil, fmt.Errorf("instrument %q: %w", instrument.name, err)
+		}
+		for _, z := range zones {
+			id := int(uint16(z.generators[sfSampleID]))
+			if id >= len(samples) {
+				return nil, fmt.Errorf("instrument %q: the sample %d does not exist", instrument.name, id)
+			}
+			if samples[id].sampleType&sfROMSample == 0 {
+				z.sample = samples[id]
+				instrument.zones = append(instrument.zones, z)
+			}
+		}
+		instruments = append(instruments, instrument)
+	}
+	presetRecords := records["phdr"]
+	for k, r := range presetRecords[:len(presetRecords)-1] {
+		preset := &sfPreset{name: sfName(r), program: u16(r, 20), bank: u16(r, 22)}
+		zones, err := sfZones(records["pbag"], records["pgen"], records["pmod"], int(u16(r, 24)),
+			int(u16(presetRecords[k+1], 24)), sfInstrumentID)
+		if err != nil {
+			return nil, fmt.Errorf("preset %q: %w", preset.name, err)
+		}
+		for _, z := range zones {
+			id := int(uint16(z.generators[sfInstrumentID]))
+			if id >= len(instruments) {
+				return nil, fmt.Errorf("preset %q: the instrument %d does not exist", preset.name, id)
+			}
+			z.instrument = instruments[id]
+			preset.zones = append(preset.zones, z)
+		}
+		font.presets = append(font.presets, preset)
+	}
+	return font, nil
+}
+
+// riffChunks splits RIFF data into its chunks, keyed by ID, or by list type
+// for LIST chunks
+func riffChunks(data []byte) (map[string][]byte, error) {
+	chunks := map[string][]byte{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("a chunk header is truncated")
+		}
+		id := string(data[:4])
+		size := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return nil, fmt.Errorf("the %s chunk is truncated", id)
+		}
+		body := data[8 : 8+size]
+		if id == "LIST" {
+			if len(body) < 4 {
+				return nil, errors.New("a LIST chunk has no type")
+			}
+			id, body = string(body[:4]), body[4:]
+		}
+		chunks[id] = body
+		data = data[8+size:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:]
+		}
+	}
+	return chunks, nil
+}
+
+// sfName reads a zero padded name
+func sfName(record []byte) string {
+	name, _, _ := strings.Cut(string(record[:20]), "\x00")
+	return strings.TrimSpace(name)
+}
+
+// sfZones reads the zones of the bags from first up to (but not including)
+// last; a zone ends with its terminal generator (the instrument or sample it
+// names), and a first zone without one is the global zone, folded into the
+// others
+func sfZones(bags, generators, modulators [][]byte, first, last int, terminal uint16) ([]*sfZone, error) {
+	if first > last || last >= len(bags) {
+		return nil, fmt.Errorf("the zones %d to %d do not exist", first, last)
+	}
+	var global *sfZone
+	var zones []*sfZone
+	for k := first; k < last; k++ {
+		genFirst, genLast := int(binary.LittleEndian.Uint16(bags[k])), int(binary.LittleEndian.Uint16(bags[k+1]))
+		modFirst, modLast := int(binary.LittleEndian.Uint16(bags[k][2:])), int(binary.LittleEndian.Uint16(bags[k+1][2:]))
+		if genFirst > genLast || genLast > len(generators) || modFirst > modLast || modLast > len(modulators) {
+			return nil, fmt.Errorf("zone %d's generators or modulators do not exist", k-first)
+		}
+		z := &sfZone{}
+		terminated := false
+		for _, g := range generators[genFirst:genLast] {
+			operator := binary.LittleEndian.Uint16(g)
+			if operator >= sfGenerators {
+				continue
+			}
+			z.generators[operator] = int16(binary.LittleEndian.Uint16(g[2:]))
+			z.set[operator] = true
+			if operator == terminal {
+				// generators after the terminal generator are ignored
+				terminated = true
+				break
+			}
+		}
+		for _, m := range modulators[modFirst:modLast] {
+			z.modulators = append(z.modulators, sfModulator{
+				source:       binary.LittleEndian.Uint16(m),
+				destination:  binary.LittleEndian.Uint16(m[2:]),
+				amount:       int16(binary.LittleEndian.Uint16(m[4:])),
+				amountSource: binary.LittleEndian.Uint16(m[6:]),
+				transform:    binary.LittleEndian.Uint16(m[8:]),
+			})
+		}
+		switch {
+		case terminated:
+			zones = append(zones, z)
+		case k == first:
+			global = z
+		}
+	}
+	if global != nil {
+		for _, z := range zones {
+			for g := range z.generators {
+				if !z.set[g] && global.set[g] {
+					z.generators[g], z.set[g] = global.generators[g], true
+				}
+			}
+			z.modulators = mergeModulators(global.modulators, z.modulators)
+		}
+	}
+	return zones, nil
+}
+
+// mergeModulators returns the base modulators, each replaced by an identical
+// one (the same sources and destination) from the overrides, followed by the
+// other overrides
+func mergeModulators(base, overrides []sfModulator) []sfModulator {
+	merged := append([]sfModulator{}, base...)
+	for _, m := range overrides {
+		replaced := false
+		for k, b := range merged {
+			if b.source == m.source && b.destination == m.destination && b.amountSource == m.amountSource {
+				merged[k] = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, m)
+		}
+	}
+	return merged
+}
+
+// contains reports whether the zone's key and velocity ranges include the note
+func (z *sfZone) contains(key, velocity uint8) bool {
+	inRange := func(generator int, v uint8) bool {
+		if !z.set[generator] {
+			return true
+		}
+		r := uint16(z.generators[generator])
+		return v >= uint8(r) && v <= uint8(r>>8)
+	}
+	return inRange(sfKeyRange, key) && inRange(sfVelocityRange, velocity)
+}
+
+// preset finds the preset for the channel's bank and program; channel 9
+// plays the percussion bank, 128. A preset's bank above 128 is matched by the
+// bank select MSB and LSB together (MSB*128+LSB), and one below 128 by the MSB
+// alone; failing both, the first bank (or, for the percussion bank, the first
+// kit) stands in
+func (f *soundFont) preset(ch *channelState) *sfPreset {
+	program := uint16(ch.program)
+	candidates := [][2]uint16{{ch.bank >> 7, program}, {0, program}}
+	if ch.bank > 128 {
+		candidates = append([][2]uint16{{ch.bank, program}}, candidates...)
+	}
+	if ch.number == 9 {
+		candidates = [][2]uint16{{128, program}, {128, 0}}
+	}
+	for _, c := range candidates {
+		for _, p := range f.presets {
+			if p.bank == c[0] && p.program == c[1] {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// soundFontBank plays the notes with a SoundFont's samples
+type soundFontBank struct {
+	font       *soundFont
+	sampleRate float64
+}
+
+func newSoundFontBank(font *soundFont, sampleRate float64) *soundFontBank {
+	return &soundFontBank{font: font, sampleRate: sampleRate}
+}
+
+// sfDefaultModulators are the standard modulators that apply at the start of a
+// note; the renderer applies the channel's volume, expression, pan, and pitch
+// bend itself
+var sfDefaultModulators = []sfModulator{
+	{source: sfVelocityToAttenuation, destination: sfInitialAttenuation, amount: sfDefaultVelocityEffect},
+}
+
+func (b *soundFontBank) newSound(ch *channelState, key, velocity uint8) sound {
+	preset := b.font.preset(ch)
+	if preset == nil {
+		return nil
+	}
+	var voices []*sfVoice
+	for _, pz := range preset.zones {
+		if !pz.contains(key, velocity) {
+			continue
+		}
+		for _, iz := range pz.instrument.zones {
+			if iz.contains(key, velocity) {
+				if v := b.newVoice(ch, key, velocity, pz, iz); v != nil {
+					voices = append(voices, v)
+				}
+			}
+		}
+	}
+	if len(voices) == 0 {
+		return nil
+	}
+	return &sfSound{voices: voices}
+}
+
+// sfAbsoluteGenerators are the generators that only instrument zones may set;
+// preset zones add to the others
+var sfAbsoluteGenerators = map[int]bool{
+	sfStartOffset: true, sfEndOffset: true, sfStartLoopOffset: true, sfEndLoopOffset: true,
+	sfStartCoarseOffset: true, sfEndCoarseOffset: true, sfStartLoopCoarseOffset: true, sfEndLoopCoarseOffset: true,
+	sfInstrumentID: true, sfKeyRange: true, sfVelocityRange: true, sfKeyNumber: true, sfVelocity: true,
+	sfSampleID: true, sfSampleModes: true, sfExclusiveClass: true, sfOverridingRootKey: true,
+}
+
+// newVoice starts the instrument zone's sample, with the preset zone's
+// generators added to its own and the modulators applied
+func (b *soundFontBank) newVoice(ch *channelState, key, velocity uint8, pz, iz *sfZone) *sfVoice {
+	var g [sfGenerators]float64
+	for _, generator := range []int{sfDelayVolumeEnvelope, sfAttackVolumeEnvelope, sfHoldVolumeEnvelope,
+		sfDecayVolumeEnvelope, sfReleaseVolumeEnvelope} {
+		g[generator] = -12000
+	}
+	g[sfScaleTuning], g[sfOverridingRootKey], g[sfKeyNumber], g[sfVelocity] = 100, -1, -1, -1
+	for k := range g {
+		if iz.set[k] {
+			g[k] = float64(iz.generators[k])
+		}
+		if pz.set[k] && !sfAbsoluteGenerators[k] {
+			g[k] += float64(pz.generators[k])
+		}
+	}
+	if g[sfKeyNumber] >= 0 {
+		key = uint8(min(g[sfKeyNumber], 127))
+	}
+	if g[sfVelocity] >= 0 {
+		velocity = uint8(min(g[sfVelocity], 127))
+	}
+	for _, m := range append(mergeModulators(sfDefaultModulators, iz.modulators), pz.modulators...) {
+		if int(m.destination) < sfGenerators && !sfAbsoluteGenerators[int(m.destination)] {
+			g[m.destination] += m.value(ch, key, velocity)
+		}
+	}
+	s := iz.sample
+	if s.rate == 0 {
+		return nil
+	}
+	offset := func(base uint32, fine, coarse int) float64 {
+		return float64(base) + g[fine] + 32768*g[coarse]
+	}
+	limit := float64(len(b.font.data))
+	start := min(max(offset(s.start, sfStartOffset, sfStartCoarseOffset), 0), limit)
+	end := min(max(offset(s.end, sfEndOffset, sfEndCoarseOffset), start), limit)
+	loopStart := offset(s.loopStart, sfStartLoopOffset, sfStartLoopCoarseOffset)
+	loopEnd := offset(s.loopEnd, sfEndLoopOffset, sfEndLoopCoarseOffset)
+	mode := int(g[sfSampleModes])
+	if loopStart < start || loopEnd <= loopStart || loopEnd > end {
+		mode = 0
+	}
+	root := float64(s.rootKey)
+	if s.rootKey > 127 {
+		root = 60
+	}
+	if g[sfOverridingRootKey] >= 0 {
+		root = g[sfOverridingRootKey]
+	}
+	cents := (float64(key)-root)*g[sfScaleTuning] + g[sfCoarseTune]*100 + g[sfFineTune] + float64(s.correction)
+	angle := (min(max(g[sfPan], -500), 500) + 500) / 1000 * math.Pi / 2
+	gain := math.Pow(10, -min(max(g[sfInitialAttenuation], 0), 1440)/200)
+	seconds := func(generator int) float64 {
+		return math.Exp2(min(max(g[generator], -12000), 8000)/1200) * b.sampleRate
+	}
+	return &sfVoice{
+		data:      b.font.data,
+		position:  start,
+		increment: float64(s.rate) / b.sampleRate * math.Exp2(cents/1200),
+		end:       end,
+		loopStart: loopStart,
+		loopEnd:   loopEnd,
+		mode:      mode,
+		left:      gain * math.Sqrt2 * math.Cos(angle),
+		right:     gain * math.Sqrt2 * math.Sin(angle),
+		envelope: &sfEnvelope{
+			delay:   seconds(sfDelayVolumeEnvelope),
+			attack:  seconds(sfAttackVolumeEnvelope),
+			hold:    seconds(sfHoldVolumeEnvelope),
+			decay:   seconds(sfDecayVolumeEnvelope),
+			release: seconds(sfReleaseVolumeEnvelope),
+			sustain: min(max(g[sfSustainVolumeEnvelope], 0), 1440),
+		},
+	}
+}
+
+// value returns the modulator's contribution to its destination, from the
+// controller values at the start of the note
+func (m sfModulator) value(ch *channelState, key, velocity uint8) float64 {
+	v := float64(m.amount) * sfSourceValue(m.source, ch, key, velocity) *
+		sfSourceValue(m.amountSource, ch, key, velocity)
+	if m.transform == sfAbsoluteValueTransform {
+		v = math.Abs(v)
+	}
+	return v
+}
+
+// sfSourceValue maps a modulator source to its value, from 0 to 1 (or -1 to
+// 1 for a bipolar source); sources that cannot be known at the start of a
+// note, and linked modulators, contribute nothing
+func sfSourceValue(source uint16, ch *channelState, key, velocity uint8) float64 {
+	index := source & 0x7F
+	var x float64
+	if source&0x80 != 0 {
+		switch {
+		case index == 0, index == 6, index >= 32 && index <= 63, index >= 98 && index <= 101, index >= 120:
+			return 0
+		}
+		x = float64(ch.controllers[index]) / 128
+	} else {
+		switch index {
+		case 0:
+			return 1
+		case 2:
+			x = float64(velocity) / 128
+		case 3:
+			x = float64(key) / 128
+		default:
+			return 0
+		}
+	}
+	if source&0x100 != 0 {
+		x = 1 - x
+	}
+	curve := func(x float64) float64 {
+		switch source >> 10 {
+		case 0:
+			return x
+		case 1:
+			return sfConcave(x)
+		case 2:
+			return 1 - sfConcave(1-x)
+		case 3:
+			if x >= 0.5 {
+				return 1
+			}
+			return 0
+		}
+		return 0
+	}
+	if source&0x200 == 0 {
+		return curve(x)
+	}
+	if source>>10 == 3 {
+		return 2*curve(x) - 1
+	}
+	if x >= 0.5 {
+		return curve(2*x - 1)
+	}
+	return -curve(1 - 2*x)
+}
+
+// sfConcave is the concave curve, following the attenuation of a sound
+// falling off as the square of its amplitude
+func sfConcave(x float64) float64 {
+	if x >= 1 {
+		return 1
+	}
+	return min(1, -20.0/96*math.Log10((1-x)*(1-x)))
+}
+
+// sfSound is the voices a note plays: one per matching zone, so stereo
+// samples and layered instruments sound together
+type sfSound struct {
+	voices []*sfVoice
+}
+
+func (s *sfSound) sample(bend float64) (left, right float64) {
+	for _, v := range s.voices {
+		x := v.sample(bend)
+		left += x * v.left
+		right += x * v.right
+	}
+	return left, right
+}
+
+func (s *sfSound) release() {
+	for _, v := range s.voices {
+		v.released = true
+		v.envelope.startRelease()
+	}
+}
+
+func (s *sfSound) finished() bool {
+	for _, v := range s.voices {
+		if !v.done && !v.envelope.finished() {
+			return false
+		}
+	}
+	return true
+}
+
+// sfVoice plays a sample, interpolating between its data points
+type sfVoice struct {
+	data               []int16
+	position           float64
+	increment          float64 // data points per sample, before bending
+	end                float64
+	loopStart, loopEnd float64
+	mode               int
+	left, right        float64 // gains
+	released           bool
+	done               bool
+	envelope           *sfEnvelope
+}
+
+func (v *sfVoice) looping() bool {
+	return v.mode == sfLoopContinuously || v.mode == sfLoopUntilRelease && !v.released
+}
+
+func (v *sfVoice) sample(bend float64) float64 {
+	if v.done || v.position >= v.end {
+		v.done = true
+		return 0
+	}
+	k := int(v.position)
+	next := k + 1
+	if v.looping() && float64(next) >= v.loopEnd {
+		next = int(v.loopStart)
+	}
+	a := float64(v.data[k])
+	b := 0.0
+	if float64(next) < v.end {
+		b = float64(v.data[next])
+	}
+	x := (a + (b-a)*(v.position-float64(k))) / 32768 * v.envelope.next()
+	v.position += v.increment * bend
+	if v.looping() {
+		for v.position >= v.loopEnd {
+			v.position -= v.loopEnd - v.loopStart
+		}
+	} else if v.position >= v.end {
+		v.done = true
+	}
+	return x
+}
+
+// sfEnvelope is the volume envelope: after the delay, the level rises
+// linearly during the attack, holds, and falls in decibels during the decay
+// to the sustain level, and, on release, to silence; the times are in samples
+// and the levels in centibels of attenuation
+type sfEnvelope struct {
+	delay, attack, hold, decay, release float64
+	sustain                             float64
+	elapsed                             float64
+	attenuation                         float64
+	releasing                           bool
+	releaseFrom                         float64
+	released                            float64
+}
+
+// next returns the envelope's gain for the next sample
+func (e *sfEnvelope) next() float64 {
+	if e.releasing {
+		e.attenuation = e.releaseFrom + sfSilence*e.released/e.release
+		e.released++
+	} else {
+		t := e.elapsed
+		e.elapsed++
+		switch {
+		case t < e.delay:
+			e.attenuation = sfSilence
+		case t < e.delay+e.attack:
+			gain := (t - e.delay) / e.attack
+			e.attenuation = min(sfSilence, -200*math.Log10(gain))
+			return gain
+		case t < e.delay+e.attack+e.hold:
+			e.attenuation = 0
+		default:
+			e.attenuation = min(e.sustain, sfSilence*(t-e.delay-e.attack-e.hold)/e.decay)
+		}
+	}
+	if e.attenuation >= sfSilence {
+		return 0
+	}
+	return math.Pow(10, -e.attenuation/200)
+}
+
+func (e *sfEnvelope) startRelease() {
+	if !e.releasing {
+		e.releasing = true
+		e.releaseFrom = e.attenuation
+	}
+}
+
+func (e *sfEnvelope) finished() bool {
+	if e.releasing {
+		return e.attenuation >= sfSilence
+	}
+	return e.elapsed > e.delay+e.attack && e.attenuation >= sfSilence
+}
@@ -0,0 +1,490 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+type sfTestZone struct {
+	generators [][2]uint16 // operator, amount
+	modulators []sfModulator
+}
+
+type sfTestPreset struct {
+	name          string
+	bank, program uint16
+	zones         []sfTestZone
+}
+
+type sfTestInstrument struct {
+	name  string
+	zones []sfTestZone
+}
+
+type sfTestSample struct {
+	name               string
+	data               []int16
+	loopStart, loopEnd uint32 // relative to the sample's start
+	rate               uint32
+	rootKey            uint8
+}
+
+func sfRange(low, high uint8) uint16 {
+	return uint16(low) | uint16(high)<<8
+}
+
+func riffChunk(id string, body []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(body)))
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func riffList(listType string, chunks ...[]byte) []byte {
+	return riffChunk("LIST", append([]byte(listType), bytes.Join(chunks, nil)...))
+}
+
+func sfTestName(name string) []byte {
+	b := make([]byte, 20)
+	copy(b, name)
+	return b
+}
+
+// assembleSoundFont writes a SoundFont 2 file, with each sample followed by
+// the 46 zero data points the format calls for
+func assembleSoundFont(presets []sfTestPreset, instruments []sfTestInstrument, samples []sfTestSample) []byte {
+	le := binary.LittleEndian
+	var smpl, shdr []byte
+	for _, s := range samples {
+		start := uint32(len(smpl) / 2)
+		for _, v := range s.data {
+			smpl = le.AppendUint16(smpl, uint16(v))
+		}
+		smpl = append(smpl, make([]byte, 92)...)
+		shdr = append(shdr, sfTestName(s.name)...)
+		for _, v := range []uint32{start, start + uint32(len(s.data)), start + s.loopStart, start + s.loopEnd, s.rate} {
+			shdr = le.AppendUint32(shdr, v)
+		}
+		shdr = append(shdr, s.rootKey, 0, 0, 0, 1, 0)
+	}
+	shdr = append(shdr, make([]byte, 46)...)
+	zones := func(zoneLists [][]sfTestZone) (headers []uint16, bags, mods, gens []byte) {
+		for _, list := range zoneLists {
+			headers = append(headers, uint16(len(bags)/4))
+			for _, z := range list {
+				bags = le.AppendUint16(bags, uint16(len(gens)/4))
+				bags = le.AppendUint16(bags, uint16(len(mods)/10))
+				for _, g := range z.generators {
+					gens = le.AppendUint16(le.AppendUint16(gens, g[0]), g[1])
+				}
+				for _, m := range z.modulators {
+					for _, v := range []uint16{m.source, m.destination, uint16(m.amount), m.amountSource, m.transform} {
+						mods = le.AppendUint16(mods, v)
+					}
+				}
+			}
+		}
+		headers = append(headers, uint16(len(bags)/4))
+		bags = le.AppendUint16(le.AppendUint16(bags, uint16(len(gens)/4)), uint16(len(mods)/10))
+		return headers, bags, append(mods, make([]byte, 10)...), append(gens, make([]byte, 4)...)
+	}
+	var presetZones, instrumentZones [][]sfTestZone
+	for _, p := range presets {
+		presetZones = append(presetZones, p.zones)
+	}
+	for _, i := range instruments {
+		instrumentZones = append(instrumentZones, i.zones)
+	}
+	presetBags, pbag, pmod, pgen := zones(presetZones)
+	instrumentBags, ibag, imod, igen := zones(instrumentZones)
+	var phdr, inst []byte
+	for k, bag := range presetBags {
+		name, bank, program := "EOP", uint16(0), uint16(0)
+		if k < len(presets) {
+			name, bank, program = presets[k].name, presets[k].bank, presets[k].program
+		}
+		phdr = append(phdr, sfTestName(name)...)
+		phdr = le.AppendUint16(le.AppendUint16(le.AppendUint16(phdr, program), bank), bag)
+		phdr = append(phdr, make([]byte, 12)...)
+	}
+	for k, bag := range instrumentBags {
+		name := "EOI"
+		if k < len(instruments) {
+			name = instruments[k].name
+		}
+		inst = le.AppendUint16(append(inst, sfTestName(name)...), bag)
+	}
+	body := append([]byte("sfbk"), riffList("INFO", riffChunk("ifil", []byte{2, 0, 1, 0}),
+		riffChunk("INAM", []byte("Test\x00")))...)
+	body = append(body, riffList("sdta", riffChunk("smpl", smpl))...)
+	body = append(body, riffList("pdta", riffChunk("phdr", phdr), riffChunk("pbag", pbag), riffChunk("pmod", pmod),
+		riffChunk("pgen", pgen), riffChunk("inst", inst), riffChunk("ibag", ibag), riffChunk("imod", imod),
+		riffChunk("igen", igen), riffChunk("shdr", shdr))...)
+	return riffChunk("RIFF", body)
+}
+
+// sfSquareWave holds two cycles of a square wave with a period of 20 data
+// points, looping over the second: 400 Hz at 8000 Hz
+func sfSquareWave() []int16 {
+	data := make([]int16, 40)
+	for k := range data {
+		data[k] = 16000
+		if k%20 >= 10 {
+			data[k] = -16000
+		}
+	}
+	return data
+}
+
+// sfTestFont holds presets that sound the square wave in different ways:
+// each preset's instrument (by index) is named in its zone
+func sfTestFont(t *testing.T) *soundFont {
+	t.Helper()
+	looped := [][2]uint16{{sfSampleModes, sfLoopContinuously}, {sfReleaseVolumeEnvelope, uint16(0xF000)}}
+	instruments := []sfTestInstrument{
+		{name: "Looped", zones: []sfTestZone{{generators: append(looped, [2]uint16{sfSampleID, 0})}}},
+		{name: "Once", zones: []sfTestZone{{generators: [][2]uint16{{sfSampleID, 0}}}}},
+		{name: "Split", zones: []sfTestZone{
+			{generators: looped}, // the global zone
+			{generators: [][2]uint16{{sfKeyRange, sfRange(0, 63)}, {sfPan, uint16(0xFE0C)}, {sfSampleID, 0}}},
+			{generators: [][2]uint16{{sfKeyRange, sfRange(64, 127)}, {sfVelocityRange, sfRange(0, 63)},
+				{sfPan, 500}, {sfSampleID, 0}}},
+		}},
+		{name: "Mod wheel", zones: []sfTestZone{{
+			generators: append(looped, [2]uint16{sfSampleID, 0}),
+			modulators: []sfModulator{{source: 0x0081, destination: sfInitialAttenuation, amount: 960}},
+		}}},
+	}
+	presetFor := func(name string, bank, program, instrument uint16, generators ...[2]uint16) sfTestPreset {
+		return sfTestPreset{name: name, bank: bank, program: program, zones: []sfTestZone{
+			{generators: append(generators, [2]uint16{sfInstrumentID, instrument})},
+		}}
+	}
+	presets := []sfTestPreset{
+		presetFor("Looped", 0, 0, 0),
+		presetFor("Once", 0, 1, 1),
+		presetFor("Split", 0, 2, 2),
+		presetFor("Mod wheel", 0, 3, 3),
+		presetFor("Left", 1, 0, 0, [2]uint16{sfPan, uint16(0xFE0C)}),
+		presetFor("Right", 2<<7|5, 0, 0, [2]uint16{sfPan, 500}),
+		presetFor("Octave up", 128, 0, 0, [2]uint16{sfCoarseTune, 12}),
+	}
+	samples := []sfTestSample{{name: "Square", data: sfSquareWave(), loopStart: 20, loopEnd: 40, rate: 8000, rootKey: 69}}
+	font, err := parseSoundFont(assembleSoundFont(presets, instruments, samples))
+	if err != nil {
+		t.Fatalf("parseSoundFont() error = %v", err)
+	}
+	return font
+}
+
+func Test_parseSoundFont(t *testing.T) {
+	font := sfTestFont(t)
+	if got := len(font.presets); got != 7 {
+		t.Fatalf("parseSoundFont() read %d presets, want 7", got)
+	}
+	split := font.presets[2]
+	if split.name != "Split" || split.bank != 0 || split.program != 2 {
+		t.Errorf("parseSoundFont() preset 2 = %q %d:%d", split.name, split.bank, split.program)
+	}
+	zones := split.zones[0].instrument.zones
+	if len(zones) != 2 {
+		t.Fatalf("parseSoundFont() read %d Split zones, want 2", len(zones))
+	}
+	for k, z := range zones {
+		if !z.set[sfSampleModes] || z.generators[sfSampleModes] != sfLoopContinuously {
+			t.Errorf("parseSoundFont() zone %d did not inherit the global zone's sample mode", k)
+		}
+		if z.sample == nil || z.sample.name != "Square" || z.sample.end-z.sample.start != 40 {
+			t.Errorf("parseSoundFont() zone %d sample = %+v", k, z.sample)
+		}
+	}
+	if zones[0].contains(64, 10) || !zones[0].contains(63, 127) || !zones[1].contains(64, 63) ||
+		zones[1].contains(64, 64) {
+		t.Errorf("parseSoundFont() key and velocity ranges are wrong")
+	}
+	if got := font.presets[3].zones[0].instrument.zones[0].modulators; len(got) != 1 || got[0].source != 0x0081 {
+		t.Errorf("parseSoundFont() modulators = %+v", got)
+	}
+}
+
+func Test_parseSoundFont_errors(t *testing.T) {
+	valid := assembleSoundFont(
+		[]sfTestPreset{{name: "P", zones: []sfTestZone{{generators: [][2]uint16{{sfInstrumentID, 0}}}}}},
+		[]sfTestInstrument{{name: "I", zones: []sfTestZone{{generators: [][2]uint16{{sfSampleID, 0}}}}}},
+		[]sfTestSample{{name: "S", data: []int16{1, 2, 3}, rate: 8000, rootKey: 60}},
+	)
+	replace := func(old, new string) []byte {
+		return bytes.Replace(valid, []byte(old), []byte(new), 1)
+	}
+	badInstrument := assembleSoundFont(
+		[]sfTestPreset{{name: "P", zones: []sfTestZone{{generators: [][2]uint16{{sfInstrumentID, 4}}}}}},
+		[]sfTestInstrument{{name: "I", zones: []sfTestZone{{generators: [][2]uint16{{sfSampleID, 0}}}}}},
+		[]sfTestSample{{name: "S", data: []int16{1, 2, 3}, rate: 8000, rootKey: 60}},
+	)
+	tests := map[string]struct {
+		content []byte
+		want    string
+	}{
+		"not RIFF":           {content: []byte("MThd\x00\x00\x00\x06\x00\x00\x00\x01"), want: "it is not a RIFF sfbk file"},
+		"truncated":          {content: valid[:len(valid)-10], want: "the RIFF chunk is truncated"},
+		"missing samples":    {content: replace("smpl", "smpX"), want: "the smpl chunk is missing"},
+		"missing presets":    {content: replace("phdr", "phdX"), want: "the phdr chunk is missing"},
+		"missing instrument": {content: badInstrument, want: "preset \"P\": the instrument 4 does not exist"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseSoundFont(tt.content); err == nil || err.Error() != tt.want {
+				t.Errorf("parseSoundFont() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_soundFontBank(t *testing.T) {
+	font := sfTestFont(t)
+	note := func(channel, key, velocity uint8, prefix ...smf.Event) *smf.SMF {
+		events := append(prefix, renderEv(0, midi.NoteOn(channel, key, velocity)),
+			renderEv(960, midi.NoteOff(channel, key)))
+		return renderTestSMF(events...)
+	}
+	program := func(channel, program uint8) smf.Event {
+		return renderEv(0, midi.ProgramChange(channel, program))
+	}
+	control := func(controller, value uint8) smf.Event {
+		return renderEv(0, midi.ControlChange(0, controller, value))
+	}
+	tests := map[string]struct {
+		data  *smf.SMF
+		check func(t *testing.T, left, right []float64)
+	}{
+		"looped sample at the root key": {
+			data: note(0, 69, 127),
+			check: func(t *testing.T, left, right []float64) {
+				if got := frequencyOf(left[400:4000]); math.Abs(got-400) > 5 {
+					t.Errorf("soundFontBank frequency = %.1f Hz, want 400 Hz", got)
+				}
+				if peakOf(left[3900:4000]) == 0 || peakOf(right[3900:4000]) == 0 {
+					t.Errorf("soundFontBank did not loop the sample")
+				}
+			},
+		},
+		"an octave up": {
+			data: note(0, 81, 127),
+			check: func(t *testing.T, left, _ []float64) {
+				if got := frequencyOf(left[400:4000]); math.Abs(got-800) > 5 {
+					t.Errorf("soundFontBank frequency = %.1f Hz, want 800 Hz", got)
+				}
+			},
+		},
+		"unlooped sample ends": {
+			data: note(0, 69, 127, program(0, 1)),
+			check: func(t *testing.T, left, _ []float64) {
+				if peakOf(left[:40]) == 0 {
+					t.Errorf("soundFontBank did not play the sample")
+				}
+				if peakOf(left[41:]) != 0 {
+					t.Errorf("soundFontBank played past the end of the sample")
+				}
+			},
+		},
+		"low key zone": {
+			data: note(0, 60, 127, program(0, 2)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) == 0 || peakOf(right) > 1e-9 {
+					t.Errorf("soundFontBank did not play the low key zone, panned left")
+				}
+			},
+		},
+		"high key zone": {
+			data: note(0, 70, 40, program(0, 2)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(right) == 0 || peakOf(left) > 1e-9 {
+					t.Errorf("soundFontBank did not play the high key zone, panned right")
+				}
+			},
+		},
+		"outside the velocity range": {
+			data: note(0, 70, 100, program(0, 2)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) != 0 || peakOf(right) != 0 {
+					t.Errorf("soundFontBank played a note outside every zone")
+				}
+			},
+		},
+		"bank select MSB": {
+			data: note(0, 69, 127, control(0, 1), program(0, 0)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) == 0 || peakOf(right) > 1e-9 {
+					t.Errorf("soundFontBank did not select bank 1, panned left")
+				}
+			},
+		},
+		"bank select MSB and LSB": {
+			data: note(0, 69, 127, control(0, 2), control(32, 5), program(0, 0)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(right) == 0 || peakOf(left) > 1e-9 {
+					t.Errorf("soundFontBank did not select bank 2:5, panned right")
+				}
+			},
+		},
+		"bank select LSB alone": {
+			data: note(0, 69, 127, control(32, 1), program(0, 0)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) == 0 || peakOf(right) == 0 {
+					t.Errorf("soundFontBank selected bank 1 by its LSB, not bank 0")
+				}
+			},
+		},
+		"bank select waits for the program change": {
+			data: note(0, 69, 127, control(0, 1)),
+			check: func(t *testing.T, left, right []float64) {
+				if peakOf(left) == 0 || peakOf(right) == 0 {
+					t.Errorf("soundFontBank changed banks without a program change")
+				}
+			},
+		},
+		"missing preset": {
+			data: note(0, 69, 127, program(0, 40)),
+			check: func(t *testing.T, left, _ []float64) {
+				if peakOf(left) != 0 {
+					t.Errorf("soundFontBank played a missing preset")
+				}
+			},
+		},
+		"percussion bank": {
+			data: note(9, 69, 127),
+			check: func(t *testing.T, left, _ []float64) {
+				if got := frequencyOf(left[400:4000]); math.Abs(got-800) > 5 {
+					t.Errorf("soundFontBank frequency = %.1f Hz, want the kit's 800 Hz", got)
+				}
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			left, right := renderAudio(tt.data, renderTestRate, newSoundFontBank(font, renderTestRate))
+			tt.check(t, left, right)
+		})
+	}
+}
+
+func Test_soundFontBank_modulators(t *testing.T) {
+	font := sfTestFont(t)
+	peak := func(velocity, modulation uint8) float64 {
+		data := renderTestSMF(renderEv(0, midi.ProgramChange(0, 3)), renderEv(0, midi.ControlChange(0, 1, modulation)),
+			renderEv(0, midi.NoteOn(0, 69, velocity)), renderEv(960, midi.NoteOff(0, 69)))
+		left, _ := renderAudio(data, renderTestRate, newSoundFontBank(font, renderTestRate))
+		return peakOf(left)
+	}
+	loud := peak(127, 0)
+	// the default velocity modulator attenuates by 960 cB times the concave
+	// curve of the velocity's distance from the top
+	want := math.Pow(10, -960*(sfConcave(0.5)-sfConcave(1.0/128))/200)
+	if got := peak(64, 0) / loud; math.Abs(got-want) > 0.001 {
+		t.Errorf("soundFontBank velocity 64 gain = %.3f, want %.3f", got, want)
+	}
+	// the mod wheel, at full, attenuates by another 960 * 127/128 cB
+	if got, want := peak(127, 127)/loud, math.Pow(10, -960*127.0/128/200); math.Abs(got-want) > 0.001 {
+		t.Errorf("soundFontBank mod wheel gain = %.5f, want %.5f", got, want)
+	}
+}
+
+func Test_sfSourceValue(t *testing.T) {
+	ch := &channelState{}
+	ch.controllers[1] = 64
+	tests := map[string]struct {
+		source uint16
+		want   float64
+	}{
+		"no controller":              {source: 0x0000, want: 1},
+		"velocity":                   {source: 0x0002, want: 0.75},
+		"key, decreasing":            {source: 0x0103, want: 0.5},
+		"mod wheel, bipolar":         {source: 0x0281, want: 0},
+		"velocity, convex":           {source: 0x0802, want: 1 - sfConcave(0.25)},
+		"velocity, switch":           {source: 0x0C02, want: 1},
+		"velocity, bipolar switch":   {source: 0x0E02, want: 1},
+		"velocity, bipolar concave":  {source: 0x0602, want: sfConcave(0.5)},
+		"unsupported pitch wheel":    {source: 0x000E, want: 0},
+		"invalid bank select source": {source: 0x0080, want: 0},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := sfSourceValue(tt.source, ch, 64, 96); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("sfSourceValue() = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_render_soundFont(t *testing.T) {
+	dir := t.TempDir()
+	fontFile := filepath.Join(dir, "test.sf2")
+	content := assembleSoundFont(
+		[]sfTestPreset{{name: "P", zones: []sfTestZone{{generators: [][2]uint16{{sfInstrumentID, 0}}}}}},
+		[]sfTestInstrument{{name: "I", zones: []sfTestZone{{generators: [][2]uint16{{sfSampleID, 0}}}}}},
+		[]sfTestSample{{name: "S", data: sfSquareWave(), rate: 8000, rootKey: 69}},
+	)
+	if err := os.WriteFile(fontFile, content, 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	badFile := filepath.Join(dir, "bad.sf2")
+	if err := os.WriteFile(badFile, []byte("not a soundfont"), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	input := filepath.Join(dir, "song.mid")
+	data := renderTestSMF(renderEv(0, midi.NoteOn(0, 69, 100)), renderEv(960, midi.NoteOff(0, 69)))
+	if err := os.WriteFile(input, smfBytes(t, data), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.sf2")
+	tests := map[string]struct {
+		soundFontFile string
+		want          int
+		output.WantedRecording
+	}{
+		"renders": {
+			soundFontFile: fontFile,
+			want:          exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: input + ": rendered 0.5 seconds to " + filepath.Join(dir, "song.wav") + "\n",
+			},
+		},
+		"not valid": {
+			soundFontFile: badFile,
+			want:          exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The SoundFont \"" + badFile + "\" is not valid: it is not a RIFF sfbk file.\n",
+				Log: "level='error' error='it is not a RIFF sfbk file' file='" + badFile +
+					"' msg='invalid SoundFont'\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			r := &render{sampleRate: renderTestRate, soundFontFile: tt.soundFontFile}
+			if got := r.run(o, []string{input}); got != tt.want {
+				t.Errorf("render.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("render.run() %s", issue)
+				}
+			}
+		})
+	}
+	o := output.NewRecorder()
+	if got := (&render{sampleRate: renderTestRate, soundFontFile: missing}).run(o, []string{input}); got != exitUserError {
+		t.Errorf("render.run() with a missing SoundFont = %d, want %d", got, exitUserError)
+	}
+}
@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// registeredParameterNames names the registered parameters of General MIDI
+var registeredParameterNames = map[uint16]string{
+	0: "pitch bend range",
+	1: "fine tuning",
+	2: "coarse tuning",
+	5: "modulation depth range",
+}
+
+// state reports what the events of files have set up at a position: the
+// tempo, time and key signatures, and each channel's program, controllers,
+// parameters, pitch bend, and sounding notes
+type state struct {
+	at string
+	// set by run
+	position timePosition
+}
+
+func newState() command {
+	return &state{}
+}
+
+func (s *state) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&s.at, "at", "", "position to report on: a bar (12), bar and beat (12:3), ticks (1920t), "+
+		"or seconds (12.5s)")
+}
+
+func (s *state) run(o output.Bus, args []string) int {
+	if s.at == "" {
+		o.ErrorPrintln("The --at flag must be specified.")
+		return exitUserError
+	}
+	p, err := parseTimePosition(s.at)
+	if err != nil {
+		o.ErrorPrintf("The --at value %q is not valid: %v.\n", s.at, err)
+		return exitUserError
+	}
+	s.position = p
+	return processFiles(o, args, s.processFile)
+}
+
+// chaseFile returns the state the events of all the file's tracks up to and
+// including the tick set up, applied in the order of their ticks
+func chaseFile(data *smf.SMF, tick int64) *chaser {
+	var messages []timedMessage
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k := 0; k < len(track) && ticks[k] <= tick; k++ {
+			messages = append(messages, timedMessage{tick: ticks[k], message: track[k].Message})
+		}
+	}
+	slices.SortStableFunc(messages, func(a, b timedMessage) int { return int(a.tick - b.tick) })
+	c := newChaser()
+	for _, m := range messages {
+		c.apply(m.message)
+	}
+	return c
+}
+
+func (s *state) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	m, tempos := newMeter(data), newTempoMap(data)
+	at := s.position.resolve(m, tempos)
+	key, keyFound := keyAt(declaredKeys(data), at)
+	signature := m.changeAtTick(at)
+	keyDescription := "no key signature"
+	if keyFound {
+		keyDescription = "key " + keyName(key)
+	}
+	o.ConsolePrintf("%s at %s:\n", path, m.describe(at))
+	o.ConsolePrintf("  tempo %g bpm, time signature %d/%d, %s\n", round3(tempos.bpmAt(at)), signature.numerator,
+		signature.denominator, keyDescription)
+	c := chaseFile(data, at)
+	speller := &read{key: &key}
+	for channel, ch := range c.channels {
+		if ch != nil {
+			describeChannel(o, speller, uint8(channel), ch)
+		}
+	}
+	return true
+}
+
+// describeChannel reports the channel's state; "-" marks a value never set
+func describeChannel(o output.Bus, speller *read, channel uint8, ch *chasedChannel) {
+	value := func(v int) string {
+		if v < 0 {
+			return "-"
+		}
+		return fmt.Sprint(v)
+	}
+	program := "-"
+	if ch.program >= 0 {
+		program = fmt.Sprintf("%d %q", ch.program, speller.asInstrument(channel, uint8(ch.program)))
+	}
+	sustain := "-"
+	switch {
+	case ch.controllers[64] >= 64:
+		sustain = "on"
+	case ch.controllers[64] >= 0:
+		sustain = "off"
+	}
+	pitchBend := "-"
+	if ch.hasPitchBend() {
+		pitchBend = fmt.Sprint(ch.pitchBend)
+	}
+	o.ConsolePrintf("  channel %d: program %s, bank %s/%s, volume %s, pan %s, expression %s, sustain %s, "+
+		"pitch bend %s, pressure %s\n", channel, program, value(ch.controllers[0]), value(ch.controllers[32]),
+		value(ch.controllers[7]), value(ch.controllers[10]), value(ch.controllers[11]), sustain, pitchBend,
+		value(ch.pressure))
+	var controllers []string
+	for controller, v := range ch.controllers {
+		if v >= 0 {
+			controllers = append(controllers, fmt.Sprintf("%d=%d", controller, v))
+		}
+	}
+	if len(controllers) > 0 {
+		o.ConsolePrintf("    controllers: %s\n", strings.Join(controllers, ", "))
+	}
+	for _, p := range ch.parameterNumbers() {
+		entry := ch.parameters[p]
+		switch name, named := registeredParameterNames[p.number]; {
+		case p.registered && named:
+			o.ConsolePrintf("    RPN %d (%s): %s/%s\n", p.number, name, value(entry[0]), value(entry[1]))
+		case p.registered:
+			o.ConsolePrintf("    RPN %d: %s/%s\n", p.number, value(entry[0]), value(entry[1]))
+		default:
+			o.ConsolePrintf("    NRPN %d: %s/%s\n", p.number, value(entry[0]), value(entry[1]))
+		}
+	}
+	if len(ch.noteOrder) > 0 {
+		notes := make([]string, 0, len(ch.noteOrder))
+		for _, key := range ch.noteOrder {
+			notes = append(notes, fmt.Sprintf("%s velocity %d", speller.asNote(channel, key), ch.notes[key]))
+		}
+		o.ConsolePrintf("    sounding: %s\n", strings.Join(notes, ", "))
+	}
+}
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func stateTestSMF() *smf.SMF {
+	var conductor, strings, drums smf.Track
+	conductor.Add(0, smf.MetaMeter(3, 4))
+	conductor.Add(0, smf.DMaj())
+	conductor.Add(1440, smf.MetaTempo(90))
+	strings.Add(0, midi.ProgramChange(1, 48))
+	strings.Add(0, midi.ControlChange(1, 10, 32))
+	strings.Add(0, midi.ControlChange(1, 64, 127))
+	strings.Add(0, midi.NoteOn(1, 62, 70))
+	strings.Add(0, midi.NoteOn(1, 66, 72))
+	strings.Add(960, midi.Pitchbend(1, -4096))
+	strings.Add(480, midi.ControlChange(1, 64, 0))
+	strings.Add(0, midi.NoteOff(1, 62))
+	strings.Add(480, midi.NoteOff(1, 66))
+	drums.Add(1440, midi.ControlChange(9, 99, 1))
+	drums.Add(0, midi.ControlChange(9, 98, 8))
+	drums.Add(0, midi.ControlChange(9, 6, 64))
+	drums.Add(0, midi.NoteOn(9, 42, 100))
+	drums.Add(240, midi.NoteOff(9, 42))
+	return makeTestSMF(conductor, strings, drums)
+}
+
+func Test_state_run(t *testing.T) {
+	tests := map[string]struct {
+		s    *state
+		want int
+		output.WantedRecording
+	}{
+		"start": {
+			s:    &state{at: "1"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid at bar 1 beat 1:\n" +
+					"  tempo 120 bpm, time signature 3/4, key DMajor\n" +
+					"  channel 1: program 48 \"String ensemble 1\", bank -/-, volume -, pan 32, expression -, " +
+					"sustain on, pitch bend -, pressure -\n" +
+					"    controllers: 10=32, 64=127\n" +
+					"    sounding: D5 velocity 70, F♯5 velocity 72\n",
+			},
+		},
+		"second bar": {
+			s:    &state{at: "2"},
+			want: exitSuccess,
+			WantedRecording: output.WantedRecording{
+				Console: "/songs/song.mid at bar 2 beat 1:\n" +
+					"  tempo 90 bpm, time signature 3/4, key DMajor\n" +
+					"  channel 1: program 48 \"String ensemble 1\", bank -/-, volume -, pan 32, expression -, " +
+					"sustain off, pitch bend -4096, pressure -\n" +
+					"    controllers: 10=32, 64=0\n" +
+					"    sounding: F♯5 velocity 72\n" +
+					"  channel 9: program -, bank -/-, volume -, pan -, expression -, sustain -, pitch bend -, " +
+					"pressure -\n" +
+					"    controllers: 98=8, 99=1\n" +
+					"    NRPN 136: 64/-\n" +
+					"    sounding: CLOSED_HI_HAT velocity 100\n",
+			},
+		},
+		"no position": {
+			s:    &state{},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --at flag must be specified.\n",
+			},
+		},
+		"bad position": {
+			s:    &state{at: "0:1"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --at value \"0:1\" is not valid: \"0:1\" is not a valid bar position.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			useMemoryFileSystem(t, map[string][]byte{"/songs/song.mid": smfBytes(t, stateTestSMF())})
+			o := output.NewRecorder()
+			if got := tt.s.run(o, []string{"/songs/song.mid"}); got != tt.want {
+				t.Errorf("state.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("state.run() %s", issue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// stats reports musical statistics for each track and channel of a file
+type stats struct {
+	format string
+}
+
+type fileStatistics struct {
+	File            string            `json:"file"`
+	Tracks          int               `json:"tracks"`
+	Bars            int               `json:"bars"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Groups          []groupStatistics `json:"groups"`
+}
+
+// groupStatistics describes the events of one channel within one track
+type groupStatistics struct {
+	Track           int           `json:"track"`
+	Channel         int           `json:"channel"`
+	Notes           int           `json:"notes"`
+	Lowest          string        `json:"lowest,omitempty"`
+	Highest         string        `json:"highest,omitempty"`
+	Velocities      []int         `json:"velocities"`
+	MaxPolyphony    int           `json:"maxPolyphony"`
+	NotesPerBar     []int         `json:"notesPerBar"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	Instruments     []string      `json:"instruments"`
+	Controllers     map[uint8]int `json:"controllers"`
+}
+
+func newStats() command {
+	return &stats{}
+}
+
+func (s *stats) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&s.format, "format", "text", "output format: text, json, or csv")
+}
+
+func (s *stats) run(o output.Bus, args []string) int {
+	switch s.format {
+	case "text", "json", "csv":
+	default:
+		o.ErrorPrintf("The --format value %q is not valid: it must be text, json, or csv.\n", s.format)
+		return exitUserError
+	}
+	results, exitCode := mapFiles(o, args, midiExtensions, func(o output.Bus, path string) (*fileStatistics, bool) {
+		data, ok := readSMF(o, path)
+		if !ok {
+			return nil, false
+		}
+		return s.collect(path, data), true
+	})
+	if len(results) > 0 {
+		s.report(o, results)
+	}
+	return exitCode
+}
+
+func (s *stats) collect(path string, data *smf.SMF) *fileStatistics {
+	m := newMeter(data)
+	tempos := newTempoMap(data)
+	last := lastTick(data)
+	bars, _, _ := m.locate(last)
+	result := &fileStatistics{
+		File:            path,
+		Tracks:          len(data.Tracks),
+		Bars:            bars,
+		DurationSeconds: round3(tempos.seconds(last)),
+	}
+	all := collectNotes(data)
+	// notes are spelled as the reader spells them
+	key := spellingKey(data, all)
+	speller := &read{key: &key}
+	type groupKey struct {
+		track   int
+		channel uint8
+	}
+	groups := map[groupKey]*groupStatistics{}
+	groupFor := func(track int, channel uint8) *groupStatistics {
+		k := groupKey{track: track, channel: channel}
+		g, found := groups[k]
+		if !found {
+			g = &groupStatistics{
+				Track:       track,
+				Channel:     int(channel),
+				Velocities:  make([]int, len(velocityBands())),
+				NotesPerBar: make([]int, bars),
+				Instruments: []string{},
+				Controllers: map[uint8]int{},
+			}
+			groups[k] = g
+		}
+		return g
+	}
+	for trackNumber, track := range data.Tracks {
+		for _, event := range track {
+			var channel, controller, program uint8
+			switch {
+			case event.Message.GetProgramChange(&channel, &program):
+				g := groupFor(trackNumber, channel)
+				name := speller.asInstrument(channel, program)
+				if !slices.Contains(g.Instruments, name) {
+					g.Instruments = append(g.Instruments, name)
+				}
+			case event.Message.GetControlChange(&channel, &controller, nil):
+				groupFor(trackNumber, channel).Controllers[controller]++
+			}
+		}
+	}
+	notesByGroup := map[groupKey][]note{}
+	for _, n := range all {
+		k := groupKey{track: n.track, channel: n.channel}
+		notesByGroup[k] = append(notesByGroup[k], n)
+	}
+	for k, notes := range notesByGroup {
+		g := groupFor(k.track, k.channel)
+		g.Notes = len(notes)
+		lowest, highest := notes[0].pitch, notes[0].pitch
+		var end int64
+		for _, n := range notes {
+			lowest = min(lowest, n.pitch)
+			highest = max(highest, n.pitch)
+			end = max(end, n.end)
+			g.Velocities[velocityBand(n.velocity)]++
+			bar, _, _ := m.locate(n.start)
+			g.NotesPerBar[bar-1]++
+		}
+		g.Lowest = speller.asNote(k.channel, lowest)
+		g.Highest = speller.asNote(k.channel, highest)
+		g.MaxPolyphony = maxPolyphony(notes)
+		g.DurationSeconds = round3(tempos.seconds(end) - tempos.seconds(notes[0].start))
+	}
+	for _, g := range groups {
+		result.Groups = append(result.Groups, *g)
+	}
+	sort.Slice(result.Groups, func(i, j int) bool {
+		if result.Groups[i].Track != result.Groups[j].Track {
+			return result.Groups[i].Track < result.Groups[j].Track
+		}
+		return result.Groups[i].Channel < result.Groups[j].Channel
+	})
+	return result
+}
+
+func (s *stats) report(o output.Bus, results []*fileStatistics) {
+	switch s.format {
+	case "json":
+		content, _ := json.MarshalIndent(results, "", "  ")
+		o.ConsolePrintln(string(content))
+	case "csv":
+		s.reportCSV(o, results)
+	default:
+		for _, result := range results {
+			s.reportText(o, result)
+		}
+	}
+}
+
+func (s *stats) reportText(o output.Bus, result *fileStatistics) {
+	o.ConsolePrintf("%s: %d tracks, %d bars, %.3f seconds\n", result.File, result.Tracks, result.Bars, result.DurationSeconds)
+	bands := velocityBands()
+	for _, g := range result.Groups {
+		o.ConsolePrintf("  Track %d channel %d: %d notes", g.Track, g.Channel, g.Notes)
+		if g.Notes > 0 {
+			average, peak := g.density()
+			o.ConsolePrintf(", range %s to %s, polyphony %d, %.2f notes per bar (peak %d), %.3f seconds",
+				g.Lowest, g.Highest, g.MaxPolyphony, average, peak, g.DurationSeconds)
+		}
+		o.ConsolePrintln("")
+		if len(g.Instruments) > 0 {
+			o.ConsolePrintf("    instruments: %s\n", strings.Join(g.Instruments, ", "))
+		}
+		if len(g.Controllers) > 0 {
+			o.ConsolePrintf("    controllers: %s\n", g.controllerSummary(", ", " x"))
+		}
+		if g.Notes > 0 {
+			var parts []string
+			for k, count := range g.Velocities {
+				if count > 0 {
+					parts = append(parts, fmt.Sprintf("%s %d", bands[k], count))
+				}
+			}
+			o.ConsolePrintf("    velocities: %s\n", strings.Join(parts, ", "))
+		}
+	}
+}
+
+func (s *stats) reportCSV(o output.Bus, results []*fileStatistics) {
+	w := csv.NewWriter(o.ConsoleWriter())
+	header := []string{
+		"file", "track", "channel", "notes", "lowest", "highest", "max_polyphony",
+		"notes_per_bar", "peak_notes_per_bar", "duration_seconds", "instruments", "controllers",
+	}
+	for _, band := range velocityBands() {
+		header = append(header, "velocity_"+band)
+	}
+	_ = w.Write(header)
+	for _, result := range results {
+		for _, g := range result.Groups {
+			average, peak := g.density()
+			row := []string{
+				result.File,
+				strconv.Itoa(g.Track),
+				strconv.Itoa(g.Channel),
+				strconv.Itoa(g.Notes),
+				g.Lowest,
+				g.Highest,
+				strconv.Itoa(g.MaxPolyphony),
+				strconv.FormatFloat(average, 'f', 2, 64),
+				strconv.Itoa(peak),
+				strconv.FormatFloat(g.DurationSeconds, 'f', 3, 64),
+				strings.Join(g.Instruments, ";"),
+				g.controllerSummary(";", ":"),
+			}
+			for _, count := range g.Velocities {
+				row = append(row, strconv.Itoa(count))
+			}
+			_ = w.Write(row)
+		}
+	}
+	w.Flush()
+}
+
+// density returns the average number of notes per bar (over the bars from the
+// group's first note to its last), and the highest number of notes in any bar
+func (g groupStatistics) density() (average float64, peak int) {
+	first, last := -1, -1
+	for k, count := range g.NotesPerBar {
+		if count > 0 {
+			if first < 0 {
+				first = k
+			}
+			last = k
+			peak = max(peak, count)
+		}
+	}
+	if first < 0 {
+		return 0, 0
+	}
+	return float64(g.Notes) / float64(last-first+1), peak
+}
+
+func (g groupStatistics) controllerSummary(separator, joiner string) string {
+	controllers := make([]int, 0, len(g.Controllers))
+	for c := range g.Controllers {
+		controllers = append(controllers, int(c))
+	}
+	sort.Ints(controllers)
+	parts := make([]string, 0, len(controllers))
+	for _, c := range controllers {
+		parts = append(parts, fmt.Sprintf("%d%s%d", c, joiner, g.Controllers[uint8(c)]))
+	}
+	return strings.Join(parts, separator)
+}
+
+func round3(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func makeStatsTestSMF() *smf.SMF {
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaKey(0, true, 2, false))
+	conductor.Add(0, smf.MetaTempo(60))
+	var piano smf.Track
+	piano.Add(0, midi.ProgramChange(0, 0))
+	piano.Add(0, midi.ControlChange(0, 7, 100))
+	piano.Add(0, midi.NoteOn(0, 60, 64))
+	piano.Add(0, midi.NoteOn(0, 66, 70))
+	piano.Add(480, midi.NoteOff(0, 60))
+	piano.Add(0, midi.NoteOff(0, 66))
+	piano.Add(1440, midi.NoteOn(0, 72, 127))
+	piano.Add(480, midi.NoteOff(0, 72))
+	piano.Add(0, midi.ControlChange(0, 64, 0))
+	piano.Add(0, midi.ControlChange(0, 7, 90))
+	return makeTestSMF(conductor, piano)
+}
+
+func Test_stats_report(t *testing.T) {
+	tests := map[string]struct {
+		s *stats
+		output.WantedRecording
+	}{
+		"text": {
+			s: &stats{format: "text"},
+			WantedRecording: output.WantedRecording{Console: "" +
+				"x.mid: 2 tracks, 2 bars, 5.000 seconds\n" +
+				"  Track 1 channel 0: 3 notes, range C5 to C6, polyphony 2, 1.50 notes per bar (peak 2), 5.000 seconds\n" +
+				"    instruments: Acoustic grand piano\n" +
+				"    controllers: 7 x2, 64 x1\n" +
+				"    velocities: mp 1, mp-mf 1, fff 1\n"},
+		},
+		"csv": {
+			s: &stats{format: "csv"},
+			WantedRecording: output.WantedRecording{Console: "" +
+				"file,track,channel,notes,lowest,highest,max_polyphony,notes_per_bar,peak_notes_per_bar," +
+				"duration_seconds,instruments,controllers," +
+				"velocity_<ppp,velocity_ppp,velocity_ppp-pp,velocity_pp,velocity_pp-p,velocity_p,velocity_p-mp," +
+				"velocity_mp,velocity_mp-mf,velocity_mf,velocity_mf-f,velocity_f,velocity_f-ff,velocity_ff," +
+				"velocity_ff-fff,velocity_fff\n" +
+				"x.mid,1,0,3,C5,C6,2,1.50,2,5.000,Acoustic grand piano,7:2;64:1,0,0,0,0,0,0,0,1,1,0,0,0,0,0,0,1\n"},
+		},
+		"json": {
+			s: &stats{format: "json"},
+			WantedRecording: output.WantedRecording{Console: "" +
+				"[\n" +
+				"  {\n" +
+				"    \"file\": \"x.mid\",\n" +
+				"    \"tracks\": 2,\n" +
+				"    \"bars\": 2,\n" +
+				"    \"durationSeconds\": 5,\n" +
+				"    \"groups\": [\n" +
+				"      {\n" +
+				"        \"track\": 1,\n" +
+				"        \"channel\": 0,\n" +
+				"        \"notes\": 3,\n" +
+				"        \"lowest\": \"C5\",\n" +
+				"        \"highest\": \"C6\",\n" +
+				"        \"velocities\": [\n" +
+				"          0,\n          0,\n          0,\n          0,\n          0,\n          0,\n          0,\n" +
+				"          1,\n          1,\n          0,\n          0,\n          0,\n          0,\n          0,\n" +
+				"          0,\n          1\n" +
+				"        ],\n" +
+				"        \"maxPolyphony\": 2,\n" +
+				"        \"notesPerBar\": [\n" +
+				"          2,\n" +
+				"          1\n" +
+				"        ],\n" +
+				"        \"durationSeconds\": 5,\n" +
+				"        \"instruments\": [\n" +
+				"          \"Acoustic grand piano\"\n" +
+				"        ],\n" +
+				"        \"controllers\": {\n" +
+				"          \"64\": 1,\n" +
+				"          \"7\": 2\n" +
+				"        }\n" +
+				"      }\n" +
+				"    ]\n" +
+				"  }\n" +
+				"]\n"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			tt.s.report(o, []*fileStatistics{tt.s.collect("x.mid", makeStatsTestSMF())})
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("stats.report() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_stats_collect_estimatedKey(t *testing.T) {
+	// with no key signature, an E-flat major scale is spelled with flats, as
+	// the reader spells it
+	data := makeTestSMF(makeScaleTrack(63, 65, 67, 68, 70, 72, 74, 75, 70, 67, 63))
+	groups := (&stats{}).collect("x.mid", data).Groups
+	if len(groups) != 1 {
+		t.Fatalf("stats.collect() found %d groups, want 1", len(groups))
+	}
+	if got := groups[0].Lowest + " to " + groups[0].Highest; got != "E♭5 to E♭6" {
+		t.Errorf("stats.collect() range = %q, want \"E♭5 to E♭6\"", got)
+	}
+}
+
+func Test_stats_run(t *testing.T) {
+	tests := map[string]struct {
+		s            *stats
+		args         []string
+		wantExitCode int
+		output.WantedRecording
+	}{
+		"bad format": {
+			s:            &stats{format: "xml"},
+			args:         []string{"x.mid"},
+			wantExitCode: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --format value \"xml\" is not valid: it must be text, json, or csv.\n",
+			},
+		},
+		"no files": {
+			s:               &stats{format: "text"},
+			wantExitCode:    exitUserError,
+			WantedRecording: output.WantedRecording{Error: "No files were specified.\n"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.s.run(o, tt.args); got != tt.wantExitCode {
+				t.Errorf("stats.run() = %d, want %d", got, tt.wantExitCode)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("stats.run() %s", issue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package commands
+
+import (
+	"math"
+)
+
+// waveform is the shape of an oscillator's cycle
+type waveform int
+
+const (
+	sineWave waveform = iota
+	triangleWave
+	squareWave
+	sawtoothWave
+)
+
+// envelope is an ADSR envelope: the attack, decay, and release times are in
+// seconds, and the sustain is a level from 0 to 1; with no sustain, the note
+// dies away at the end of its decay
+type envelope struct {
+	attack  float64
+	decay   float64
+	sustain float64
+	release float64
+}
+
+// synthPatch is the oscillator and envelope for a family of instruments
+type synthPatch struct {
+	family   string
+	waveform waveform
+	envelope envelope
+}
+
+// synthPatches holds a patch for each family of eight General MIDI programs,
+// in the order of the instruments table
+var synthPatches = [16]synthPatch{
+	{family: "Piano", waveform: triangleWave, envelope: envelope{attack: 0.005, decay: 2.5, release: 0.3}},
+	{family: "Chromatic percussion", waveform: sineWave, envelope: envelope{attack: 0.002, decay: 1.0, release: 0.3}},
+	{family: "Organ", waveform: squareWave, envelope: envelope{attack: 0.01, sustain: 1, release: 0.05}},
+	{family: "Guitar", waveform: triangleWave, envelope: envelope{attack: 0.003, decay: 1.5, release: 0.2}},
+	{family: "Bass", waveform: triangleWave, envelope: envelope{attack: 0.005, decay: 0.8, sustain: 0.4, release: 0.1}},
+	{family: "Strings", waveform: sawtoothWave, envelope: envelope{attack: 0.08, decay: 0.2, sustain: 0.8, release: 0.3}},
+	{family: "Ensemble", waveform: sawtoothWave, envelope: envelope{attack: 0.1, decay: 0.3, sustain: 0.8, release: 0.4}},
+	{family: "Brass", waveform: sawtoothWave, envelope: envelope{attack: 0.03, decay: 0.1, sustain: 0.8, release: 0.15}},
+	{family: "Reed", waveform: squareWave, envelope: envelope{attack: 0.02, decay: 0.1, sustain: 0.8, release: 0.1}},
+	{family: "Pipe", waveform: sineWave, envelope: envelope{attack: 0.03, decay: 0.1, sustain: 0.9, release: 0.1}},
+	{family: "Synth lead", waveform: squareWave, envelope: envelope{attack: 0.005, decay: 0.1, sustain: 0.8, release: 0.1}},
+	{family: "Synth pad", waveform: sawtoothWave, envelope: envelope{attack: 0.3, decay: 0.5, sustain: 0.7, release: 0.8}},
+	{family: "Synth effects", waveform: triangleWave, envelope: envelope{attack: 0.1, decay: 0.5, sustain: 0.6, release: 0.8}},
+	{family: "Ethnic", waveform: triangleWave, envelope: envelope{attack: 0.003, decay: 0.8, sustain: 0.2, release: 0.2}},
+	{family: "Percussive", waveform: sineWave, envelope: envelope{attack: 0.001, decay: 0.4, release: 0.1}},
+	{family: "Sound effects", waveform: squareWave, envelope: envelope{attack: 0.05, decay: 0.3, sustain: 0.5, release: 0.3}},
+}
+
+// synth is the built-in instrument bank: an oscillator per note, shaped by
+// the patch for the channel's program, and a kit of tuned drums on channel 9
+type synth struct {
+	sampleRate float64
+}
+
+func newSynth(sampleRate float64) *synth {
+	return &synth{sampleRate: sampleRate}
+}
+
+func (s *synth) newSound(ch *channelState, key, velocity uint8) sound {
+	amplitude := float64(velocity) / 127
+	if ch.number == 9 {
+		return newDrumSound(s.sampleRate, key, amplitude)
+	}
+	patch := synthPatches[ch.program/8]
+	return &oscillatorSound{
+		waveform:  patch.waveform,
+		envelope:  newEnvelopeState(patch.envelope, s.sampleRate),
+		increment: keyFrequency(key) / s.sampleRate,
+		amplitude: amplitude,
+	}
+}
+
+// keyFrequency returns the equal tempered frequency of the key, with A above
+// middle C (key 69) at 440 Hz
+func keyFrequency(key uint8) float64 {
+	return 440 * math.Exp2((float64(key)-69)/12)
+}
+
+// oscillator returns the waveform's value at the phase, in cycles
+func oscillator(w waveform, phase float64) float64 {
+	phase -= math.Floor(phase)
+	switch w {
+	case triangleWave:
+		return 1 - 4*math.Abs(phase-0.5)
+	case squareWave:
+		if phase < 0.5 {
+			return 0.6
+		}
+		return -0.6
+	case sawtoothWave:
+		return (2*phase - 1) * 0.6
+	default:
+		return math.Sin(2 * math.Pi * phase)
+	}
+}
+
+// envelopeState steps an envelope one sample at a time
+type envelopeState struct {
+	envelope     envelope
+	sampleRate   float64
+	elapsed      float64 // samples since the note began
+	releasing    bool
+	releaseLevel float64
+	released     float64 // samples since the release began
+	level        float64
+}
+
+func newEnvelopeState(e envelope, sampleRate float64) *envelopeState {
+	return &envelopeState{envelope: e, sampleRate: sampleRate}
+}
+
+// next returns the envelope's level for the next sample
+func (s *envelopeState) next() float64 {
+	e := s.envelope
+	t := s.elapsed / s.sampleRate
+	s.elapsed++
+	if s.releasing {
+		r := s.released / s.sampleRate
+		s.released++
+		if r >= e.release {
+			s.level = 0
+		} else {
+			s.level = s.releaseLevel * (1 - r/e.release)
+		}
+		return s.level
+	}
+	switch {
+	case t < e.attack:
+		s.level = t / e.attack
+	case e.sustain == 0:
+		// the level decays exponentially, to about -60 dB at the end of the decay
+		s.level = math.Exp(-6.9 * (t - e.attack) / e.decay)
+		if t-e.attack >= e.decay {
+			s.level = 0
+		}
+	case t < e.attack+e.decay:
+		s.level = 1 - (1-e.sustain)*(t-e.attack)/e.decay
+	default:
+		s.level = e.sustain
+	}
+	return s.level
+}
+
+func (s *envelopeState) release() {
+	if !s.releasing {
+		s.releasing = true
+		s.releaseLevel = s.level
+	}
+}
+
+func (s *envelopeState) finished() bool {
+	if s.releasing {
+		return s.released/s.sampleRate >= s.envelope.release
+	}
+	return s.envelope.sustain == 0 && s.elapsed/s.sampleRate >= s.envelope.attack+s.envelope.decay
+}
+
+// oscillatorSound is a note played by the synth
+type oscillatorSound struct {
+	waveform  waveform
+	envelope  *envelopeState
+	phase     float64
+	increment float64 // cycles per sample, before bending
+	amplitude float64
+}
+
+func (o *oscillatorSound) sample(bend float64) (left, right float64) {
+	v := oscillator(o.waveform, o.phase) * o.envelope.next() * o.amplitude
+	o.phase += o.increment * bend
+	return v, v
+}
+
+func (o *oscillatorSound) release() {
+	o.envelope.release()
+}
+
+func (o *oscillatorSound) finished() bool {
+	return o.envelope.finished()
+}
+
+// drumVoice describes how a drum is synthesized, without noise or samples: a
+// tone sweeping from one frequency down to another, a metallic cluster of
+// square waves, or both, each decaying over its own time
+type drumVoice struct {
+	toneFrom, toneTo float64 // Hz; no tone if 0
+	sweep            float64 // seconds for the tone to fall
+	toneDecay        float64
+	metal            float64 // scales the cluster's frequencies; no cluster if 0
+	metalDecay       float64
+}
+
+// metalRatios are the frequencies of a classic analog cymbal's six square
+// wave oscillators, in Hz
+var metalRatios = []float64{205.3, 304.4, 369.6, 522.7, 540.0, 800.0}
+
+var (
+	kickDrum   = drumVoice{toneFrom: 130, toneTo: 45, sweep: 0.08, toneDecay: 0.4}
+	snareDrum  = drumVoice{toneFrom: 240, toneTo: 180, sweep: 0.03, toneDecay: 0.12, metal: 3, metalDecay: 0.2}
+	clap       = drumVoice{metal: 2.5, metalDecay: 0.15}
+	closedHat  = drumVoice{metal: 8, metalDecay: 0.05}
+	openHat    = drumVoice{metal: 8, metalDecay: 0.4}
+	cymbal     = drumVoice{metal: 6, metalDecay: 1.5}
+	ride       = drumVoice{metal: 5, metalDecay: 0.9}
+	highPitch  = drumVoice{toneFrom: 800, toneTo: 800, toneDecay: 0.15}
+	woodenTone = drumVoice{toneFrom: 1200, toneTo: 1000, sweep: 0.01, toneDecay: 0.06}
+)
+
+// drumKit maps the General MIDI drums to their voices; toms are tuned by key
+var drumKit = map[uint8]drumVoice{
+	35: kickDrum, 36: kickDrum,
+	37: woodenTone, 38: snareDrum, 39: clap, 40: snareDrum,
+	42: closedHat, 44: closedHat, 46: openHat,
+	49: cymbal, 51: ride, 52: cymbal, 53: ride, 55: cymbal, 57: cymbal, 59: ride,
+	54: {metal: 10, metalDecay: 0.25},
+	56: {toneFrom: 540, toneTo: 540, toneDecay: 0.3, metal: 1.5, metalDecay: 0.3},
+	75: woodenTone, 76: woodenTone, 77: {toneFrom: 900, toneTo: 800, sweep: 0.01, toneDecay: 0.06},
+	80: {metal: 12, metalDecay: 0.2}, 81: {metal: 12, metalDecay: 1.0},
+}
+
+// drumSound is a drum stroke; it ignores note off messages and pitch bend
+type drumSound struct {
+	voice      drumVoice
+	sampleRate float64
+	elapsed    float64
+	tonePhase  float64
+	metalPhase []float64
+	amplitude  float64
+}
+
+func newDrumSound(sampleRate float64, key uint8, amplitude float64) *drumSound {
+	v, found := drumKit[key]
+	if !found {
+		switch {
+		case key == 41 || key == 43 || key == 45 || key == 47 || key == 48 || key == 50:
+			// toms rise with the key, from the low floor tom up
+			f := 70 * math.Exp2(float64(key-41)/9)
+			v = drumVoice{toneFrom: f * 1.6, toneTo: f, sweep: 0.1, toneDecay: 0.5}
+		default:
+			v = highPitch
+			v.toneFrom = keyFrequency(key)
+			v.toneTo = v.toneFrom
+		}
+	}
+	return &drumSound{voice: v, sampleRate: sampleRate, metalPhase: make([]float64, len(metalRatios)), amplitude: amplitude}
+}
+
+func (d *drumSound) sample(_ float64) (left, right float64) {
+	v := d.voice
+	t := d.elapsed / d.sampleRate
+	d.elapsed++
+	var s float64
+	if v.toneFrom > 0 {
+		f := v.toneTo
+		if t < v.sweep {
+			f = v.toneFrom + (v.toneTo-v.toneFrom)*t/v.sweep
+		}
+		s += math.Sin(2*math.Pi*d.tonePhase) * math.Exp(-6.9*t/v.toneDecay)
+		d.tonePhase += f / d.sampleRate
+	}
+	if v.metal > 0 {
+		var cluster float64
+		for k, ratio := range metalRatios {
+			cluster += oscillator(squareWave, d.metalPhase[k])
+			d.metalPhase[k] += ratio * v.metal / d.sampleRate
+		}
+		s += cluster / float64(len(metalRatios)) * math.Exp(-6.9*t/v.metalDecay)
+	}
+	return s * d.amplitude, s * d.amplitude
+}
+
+func (d *drumSound) release() {}
+
+func (d *drumSound) finished() bool {
+	t := d.elapsed / d.sampleRate
+	return t >= d.voice.toneDecay && t >= d.voice.metalDecay
+}
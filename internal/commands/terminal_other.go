@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package commands
+
+// consoleWidth is not known on this platform
+func consoleWidth() int {
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package commands
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// consoleWidth returns the width of the terminal standard output is written
+// to, or 0 if it is not a terminal
+func consoleWidth() int {
+	size, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0
+	}
+	return int(size.Col)
+}
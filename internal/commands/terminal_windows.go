@@ -0,0 +1,19 @@
+//go:build windows
+
+package commands
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// consoleWidth returns the width of the console window standard output is
+// written to, or 0 if it is not a console
+func consoleWidth() int {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 0
+	}
+	return int(info.Window.Right-info.Window.Left) + 1
+}
@@ -0,0 +1,323 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// meterChange records a time signature taking effect at a given tick; bar is
+// the (1-based) number of the bar that starts there
+type meterChange struct {
+	tick        int64
+	bar         int
+	numerator   uint8
+	denominator uint8
+}
+
+// meter maps absolute ticks to bars and beats, per the time signatures found
+// in a file. A time signature change that does not fall on a bar line starts a
+// new bar.
+type meter struct {
+	ticksPerQuarter int64
+	changes         []meterChange
+}
+
+// position is a parsed location in a file: either an absolute tick, or a bar
+// and beat (both 1-based)
+type position struct {
+	tick   int64
+	isTick bool
+	bar    int
+	beat   int
+}
+
+// tickRange is a half-open range of absolute ticks; an unbounded end is
+// represented by a negative value
+type tickRange struct {
+	from int64
+	to   int64
+}
+
+// quarterTicks returns the number of ticks in a quarter note, and at least 1;
+// files using SMPTE time are treated as if they were played at 120 beats per
+// minute
+func quarterTicks(tf smf.TimeFormat) int64 {
+	switch t := tf.(type) {
+	case smf.MetricTicks:
+		return max(int64(t.Resolution()), 1)
+	case smf.TimeCode:
+		return max(int64(t.FramesPerSecond)*int64(t.SubFrames)/2, 1)
+	default:
+		return 960
+	}
+}
+
+// absoluteTicks returns the absolute tick of each event in the track
+func absoluteTicks(track smf.Track) []int64 {
+	ticks := make([]int64, len(track))
+	var now int64
+	for k, event := range track {
+		now += int64(event.Delta)
+		ticks[k] = now
+	}
+	return ticks
+}
+
+// timedMessage is a message and the absolute tick at which it occurs
+type timedMessage struct {
+	tick    int64
+	message smf.Message
+}
+
+// buildTrack returns the messages as a closed track, in order of their ticks;
+// messages at the same tick keep their relative order
+func buildTrack(messages []timedMessage) smf.Track {
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].tick < messages[j].tick })
+	var track smf.Track
+	var now int64
+	for _, m := range messages {
+		track.Add(uint32(m.tick-now), m.message)
+		now = m.tick
+	}
+	track.Close(0)
+	return track
+}
+
+func newMeter(data *smf.SMF) *meter {
+	type signature struct {
+		tick        int64
+		numerator   uint8
+		denominator uint8
+	}
+	var signatures []signature
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var numerator, denominator uint8
+			if event.Message.GetMetaMeter(&numerator, &denominator) && numerator > 0 && denominator > 0 {
+				signatures = append(signatures, signature{tick: ticks[k], numerator: numerator, denominator: denominator})
+			}
+		}
+	}
+	sort.SliceStable(signatures, func(i, j int) bool { return signatures[i].tick < signatures[j].tick })
+	m := &meter{ticksPerQuarter: quarterTicks(data.TimeFormat)}
+	m.changes = []meterChange{{tick: 0, bar: 1, numerator: 4, denominator: 4}}
+	for _, s := range signatures {
+		last := m.changes[len(m.changes)-1]
+		if s.tick == last.tick {
+			m.changes[len(m.changes)-1].numerator = s.numerator
+			m.changes[len(m.changes)-1].denominator = s.denominator
+			continue
+		}
+		length := m.barTicks(last)
+		bars := (s.tick - last.tick) / length
+		if (s.tick-last.tick)%length != 0 {
+			bars++
+		}
+		m.changes = append(m.changes, meterChange{
+			tick:        s.tick,
+			bar:         last.bar + int(bars),
+			numerator:   s.numerator,
+			denominator: s.denominator,
+		})
+	}
+	return m
+}
+
+// beatTicks returns the number of ticks in a beat, and at least 1, even when
+// the file's resolution is too coarse to divide a quarter note into beats
+func (m *meter) beatTicks(c meterChange) int64 {
+	return max(m.ticksPerQuarter*4/int64(c.denominator), 1)
+}
+
+func (m *meter) barTicks(c meterChange) int64 {
+	return m.beatTicks(c) * int64(c.numerator)
+}
+
+func (m *meter) changeAtTick(tick int64) meterChange {
+	k := sort.Search(len(m.changes), func(i int) bool { return m.changes[i].tick > tick })
+	return m.changes[max(k-1, 0)]
+}
+
+func (m *meter) changeAtBar(bar int) meterChange {
+	k := sort.Search(len(m.changes), func(i int) bool { return m.changes[i].bar > bar })
+	return m.changes[max(k-1, 0)]
+}
+
+// locate returns the bar and beat (both 1-based) containing the tick, and the
+// tick's offset from the start of that beat
+func (m *meter) locate(tick int64) (bar, beat int, offset int64) {
+	c := m.changeAtTick(tick)
+	elapsed := tick - c.tick
+	barLength := m.barTicks(c)
+	bar = c.bar + int(elapsed/barLength)
+	inBar := elapsed % barLength
+	beatLength := m.beatTicks(c)
+	beat = 1 + int(inBar/beatLength)
+	offset = inBar % beatLength
+	return
+}
+
+// barStart returns the absolute tick at which the (1-based) bar begins
+func (m *meter) barStart(bar int) int64 {
+	c := m.changeAtBar(bar)
+	return c.tick + int64(bar-c.bar)*m.barTicks(c)
+}
+
+// resolve returns the absolute tick of the position
+func (m *meter) resolve(p position) int64 {
+	if p.isTick {
+		return p.tick
+	}
+	start := m.barStart(p.bar)
+	return start + int64(p.beat-1)*m.beatTicks(m.changeAtBar(p.bar))
+}
+
+// describe renders the tick as "bar N beat B", appending the offset in ticks
+// when the tick does not fall on a beat
+func (m *meter) describe(tick int64) string {
+	bar, beat, offset := m.locate(tick)
+	if offset == 0 {
+		return fmt.Sprintf("bar %d beat %d", bar, beat)
+	}
+	return fmt.Sprintf("bar %d beat %d+%d", bar, beat, offset)
+}
+
+// parsePosition parses a position written as "bar", "bar:beat", or "ticks"
+// followed by a 't' (e.g., "12", "12:3", "1920t")
+func parsePosition(s string) (p position, err error) {
+	s = strings.TrimSpace(s)
+	if ticks, found := strings.CutSuffix(s, "t"); found {
+		p.isTick = true
+		if p.tick, err = strconv.ParseInt(ticks, 10, 64); err != nil || p.tick < 0 {
+			err = fmt.Errorf("%q is not a valid tick position", s)
+		}
+		return
+	}
+	barText, beatText, hasBeat := strings.Cut(s, ":")
+	p.beat = 1
+	if p.bar, err = strconv.Atoi(barText); err != nil || p.bar < 1 {
+		err = fmt.Errorf("%q is not a valid bar position", s)
+		return
+	}
+	if hasBeat {
+		if p.beat, err = strconv.Atoi(beatText); err != nil || p.beat < 1 {
+			err = fmt.Errorf("%q is not a valid beat position", s)
+		}
+	}
+	return
+}
+
+// parseTickRange resolves optional from and to positions into a tick range
+func parseTickRange(m *meter, from, to string) (r tickRange, err error) {
+	r.to = -1
+	if from != "" {
+		var p position
+		if p, err = parsePosition(from); err != nil {
+			return
+		}
+		r.from = m.resolve(p)
+	}
+	if to != "" {
+		var p position
+		if p, err = parsePosition(to); err != nil {
+			return
+		}
+		r.to = m.resolve(p)
+		if r.to < r.from {
+			err = fmt.Errorf("the range %q to %q ends before it begins", from, to)
+		}
+	}
+	return
+}
+
+func (r tickRange) contains(tick int64) bool {
+	return tick >= r.from && (r.to < 0 || tick < r.to)
+}
+
+// tempoChange records a tempo taking effect at a given tick, and the elapsed
+// time in seconds at that tick
+type tempoChange struct {
+	tick    int64
+	bpm     float64
+	seconds float64
+}
+
+// tempoMap converts absolute ticks to elapsed time, per the tempo changes found
+// in a file; the tempo is 120 beats per minute until the first change. Files
+// using SMPTE time ignore tempo changes.
+type tempoMap struct {
+	ticksPerQuarter int64
+	ticksPerSecond  float64
+	changes         []tempoChange
+}
+
+func newTempoMap(data *smf.SMF) *tempoMap {
+	t := &tempoMap{
+		ticksPerQuarter: quarterTicks(data.TimeFormat),
+		changes:         []tempoChange{{tick: 0, bpm: 120}},
+	}
+	if tc, ok := data.TimeFormat.(smf.TimeCode); ok {
+		t.ticksPerSecond = float64(tc.FramesPerSecond) * float64(tc.SubFrames)
+		return t
+	}
+	var found []tempoChange
+	for _, track := range data.Tracks {
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var bpm float64
+			if event.Message.GetMetaTempo(&bpm) && bpm > 0 {
+				found = append(found, tempoChange{tick: ticks[k], bpm: bpm})
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].tick < found[j].tick })
+	for _, change := range found {
+		last := t.changes[len(t.changes)-1]
+		if change.tick == last.tick {
+			t.changes[len(t.changes)-1].bpm = change.bpm
+			continue
+		}
+		change.seconds = last.seconds + t.span(last.bpm, change.tick-last.tick)
+		t.changes = append(t.changes, change)
+	}
+	return t
+}
+
+func (t *tempoMap) span(bpm float64, ticks int64) float64 {
+	return float64(ticks) / float64(t.ticksPerQuarter) * 60 / bpm
+}
+
+func (t *tempoMap) changeAt(tick int64) tempoChange {
+	k := sort.Search(len(t.changes), func(i int) bool { return t.changes[i].tick > tick })
+	return t.changes[max(k-1, 0)]
+}
+
+// bpmAt returns the tempo in effect at the tick
+func (t *tempoMap) bpmAt(tick int64) float64 {
+	return t.changeAt(tick).bpm
+}
+
+// seconds returns the elapsed time at the tick
+func (t *tempoMap) seconds(tick int64) float64 {
+	if t.ticksPerSecond > 0 {
+		return float64(tick) / t.ticksPerSecond
+	}
+	c := t.changeAt(tick)
+	return c.seconds + t.span(c.bpm, tick-c.tick)
+}
+
+// tickAt returns the (rounded) tick at the elapsed time
+func (t *tempoMap) tickAt(seconds float64) int64 {
+	if t.ticksPerSecond > 0 {
+		return int64(math.Round(seconds * t.ticksPerSecond))
+	}
+	k := sort.Search(len(t.changes), func(i int) bool { return t.changes[i].seconds > seconds })
+	c := t.changes[max(k-1, 0)]
+	return c.tick + int64(math.Round((seconds-c.seconds)*c.bpm/60*float64(t.ticksPerQuarter)))
+}
@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"math"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func makeMeterTestSMF() *smf.SMF {
+	// two bars of 4/4, then 3/4 from tick 3840, then 6/8 at tick 5280
+	var track smf.Track
+	track.Add(0, smf.MetaMeter(4, 4))
+	track.Add(3840, smf.MetaMeter(3, 4))
+	track.Add(1440, smf.MetaMeter(6, 8))
+	return makeTestSMF(track)
+}
+
+func Test_meter_locate(t *testing.T) {
+	m := newMeter(makeMeterTestSMF())
+	tests := map[string]struct {
+		tick       int64
+		wantBar    int
+		wantBeat   int
+		wantOffset int64
+	}{
+		"start":            {tick: 0, wantBar: 1, wantBeat: 1},
+		"second beat":      {tick: 480, wantBar: 1, wantBeat: 2},
+		"off beat":         {tick: 500, wantBar: 1, wantBeat: 2, wantOffset: 20},
+		"second bar":       {tick: 1920, wantBar: 2, wantBeat: 1},
+		"first 3/4 bar":    {tick: 3840, wantBar: 3, wantBeat: 1},
+		"second 3/4 bar":   {tick: 5280, wantBar: 4, wantBeat: 1},
+		"6/8 eighth notes": {tick: 5280 + 240*5, wantBar: 4, wantBeat: 6},
+		"next 6/8 bar":     {tick: 5280 + 1440, wantBar: 5, wantBeat: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			bar, beat, offset := m.locate(tt.tick)
+			if bar != tt.wantBar || beat != tt.wantBeat || offset != tt.wantOffset {
+				t.Errorf("meter.locate() = %d, %d, %d, want %d, %d, %d",
+					bar, beat, offset, tt.wantBar, tt.wantBeat, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func Test_meter_resolve(t *testing.T) {
+	m := newMeter(makeMeterTestSMF())
+	tests := map[string]struct {
+		p    position
+		want int64
+	}{
+		"tick":           {p: position{tick: 123, isTick: true}, want: 123},
+		"bar 1":          {p: position{bar: 1, beat: 1}, want: 0},
+		"bar 2 beat 3":   {p: position{bar: 2, beat: 3}, want: 2880},
+		"bar 3":          {p: position{bar: 3, beat: 1}, want: 3840},
+		"bar 4 beat 2":   {p: position{bar: 4, beat: 2}, want: 5520},
+		"bar 6":          {p: position{bar: 6, beat: 1}, want: 5280 + 2*1440},
+		"bar 5, 8th two": {p: position{bar: 5, beat: 2}, want: 5280 + 1440 + 240},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := m.resolve(tt.p); got != tt.want {
+				t.Errorf("meter.resolve() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_meter_describe(t *testing.T) {
+	m := newMeter(makeTestSMF(smf.Track{}))
+	tests := map[string]struct {
+		tick int64
+		want string
+	}{
+		"on beat":  {tick: 1920 + 960, want: "bar 2 beat 3"},
+		"off beat": {tick: 1920 + 1000, want: "bar 2 beat 3+40"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := m.describe(tt.tick); got != tt.want {
+				t.Errorf("meter.describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_meter_coarseResolution(t *testing.T) {
+	var track smf.Track
+	track.Add(0, smf.MetaMeter(3, 8))
+	tests := map[string]struct {
+		timeFormat smf.TimeFormat
+		tick       int64
+		want       string
+	}{
+		"one tick per quarter": {timeFormat: smf.MetricTicks(1), tick: 7, want: "bar 3 beat 2"},
+		"no subframes":         {timeFormat: smf.TimeCode{FramesPerSecond: 25}, tick: 4, want: "bar 2 beat 2"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := makeTestSMF(track)
+			data.TimeFormat = tt.timeFormat
+			if got := newMeter(data).describe(tt.tick); got != tt.want {
+				t.Errorf("meter.describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parsePosition(t *testing.T) {
+	tests := map[string]struct {
+		s       string
+		want    position
+		wantErr bool
+	}{
+		"bar":         {s: "12", want: position{bar: 12, beat: 1}},
+		"bar:beat":    {s: "12:3", want: position{bar: 12, beat: 3}},
+		"ticks":       {s: "1920t", want: position{tick: 1920, isTick: true}},
+		"bad ticks":   {s: "xt", wantErr: true},
+		"zero bar":    {s: "0", wantErr: true},
+		"bad beat":    {s: "3:0", wantErr: true},
+		"not numeric": {s: "chorus", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parsePosition(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePosition() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parsePosition() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseTickRange(t *testing.T) {
+	m := newMeter(makeTestSMF(smf.Track{}))
+	tests := map[string]struct {
+		from    string
+		to      string
+		want    tickRange
+		wantErr bool
+	}{
+		"unbounded": {want: tickRange{from: 0, to: -1}},
+		"bars":      {from: "2", to: "3", want: tickRange{from: 1920, to: 3840}},
+		"backwards": {from: "3", to: "2", wantErr: true},
+		"bad from":  {from: "x", wantErr: true},
+		"bad to":    {to: "x", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseTickRange(m, tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTickRange() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseTickRange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tempoMap(t *testing.T) {
+	var track smf.Track
+	track.Add(0, smf.MetaTempo(60))
+	track.Add(960, smf.MetaTempo(120))
+	tempos := newTempoMap(makeTestSMF(track))
+	tests := map[string]struct {
+		tick        int64
+		wantSeconds float64
+		wantBPM     float64
+	}{
+		"start":             {tick: 0, wantSeconds: 0, wantBPM: 60},
+		"one beat at 60":    {tick: 480, wantSeconds: 1, wantBPM: 60},
+		"tempo change":      {tick: 960, wantSeconds: 2, wantBPM: 120},
+		"two beats later":   {tick: 1920, wantSeconds: 3, wantBPM: 120},
+		"half beat at 120 ": {tick: 1200, wantSeconds: 2.25, wantBPM: 120},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tempos.seconds(tt.tick); got != tt.wantSeconds {
+				t.Errorf("tempoMap.seconds() = %g, want %g", got, tt.wantSeconds)
+			}
+			if got := tempos.bpmAt(tt.tick); got != tt.wantBPM {
+				t.Errorf("tempoMap.bpmAt() = %g, want %g", got, tt.wantBPM)
+			}
+			if got := tempos.tickAt(tt.wantSeconds); got != tt.tick {
+				t.Errorf("tempoMap.tickAt() = %d, want %d", got, tt.tick)
+			}
+		})
+	}
+}
+
+func Test_tempoMap_timeCode(t *testing.T) {
+	data := makeTestSMF(smf.Track{})
+	data.TimeFormat = smf.SMPTE25(40)
+	tempos := newTempoMap(data)
+	if got := tempos.seconds(2000); got != 2 {
+		t.Errorf("tempoMap.seconds() = %g, want 2", got)
+	}
+	if got := tempos.tickAt(1.5); got != 1500 {
+		t.Errorf("tempoMap.tickAt() = %d, want 1500", got)
+	}
+	data.TimeFormat = smf.TimeCode{FramesPerSecond: 25}
+	if got := newTempoMap(data).seconds(2000); math.IsInf(got, 0) || math.IsNaN(got) {
+		t.Errorf("tempoMap.seconds() = %g with no subframes, want a finite value", got)
+	}
+}
@@ -0,0 +1,412 @@
+package commands
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+)
+
+// tokenExtensions are the extensions of the token-language files found in
+// directories
+var tokenExtensions = []string{".tok"}
+
+// tokenDurations are the note durations of the token language, longest
+// first, with their lengths in whole notes
+var tokenDurations = []struct {
+	letter byte
+	name   string
+	length *big.Rat
+}{
+	{'w', "whole", big.NewRat(1, 1)},
+	{'h', "half", big.NewRat(1, 2)},
+	{'q', "quarter", big.NewRat(1, 4)},
+	{'e', "eighth", big.NewRat(1, 8)},
+	{'s', "sixteenth", big.NewRat(1, 16)},
+	{'t', "thirty-second", big.NewRat(1, 32)},
+	{'x', "sixty-fourth", big.NewRat(1, 64)},
+}
+
+// tokenTempoNames are the tempo names the T token takes
+var tokenTempoNames = map[string]bool{
+	"GRAVE": true, "LARGO": true, "LARGHETTO": true, "LENTO": true, "ADAGIO": true, "ADAGIETTO": true,
+	"ANDANTE": true, "ANDANTINO": true, "ADANTINO": true, "MODERATO": true, "ALLEGRETTO": true, "ALLEGRO": true,
+	"VIVACE": true, "PRESTO": true, "PRESTISSIMO": true,
+}
+
+type scoreTokenKind int
+
+const (
+	tokenOther     scoreTokenKind = iota // a token that does not affect the measures
+	tokenBar                             // |
+	tokenMeasure                         // Mn
+	tokenVoice                           // Vn
+	tokenSignature                       // TSn
+	tokenPickup                          // PICKUP
+	tokenNote                            // a note, chord, or rest
+)
+
+// scoreToken is one token of a token-language score
+type scoreToken struct {
+	line      int
+	kind      scoreTokenKind
+	number    int      // the measure number of an M token
+	voice     string   // the voice of a V token
+	signature [2]int   // the numerator and denominator of a TS token
+	length    *big.Rat // the length of a note, in whole notes
+}
+
+// tokenizeScore splits the content into tokens, separated by spaces; a |
+// need not be, and a [special instruction] may contain spaces
+func tokenizeScore(content string) ([]scoreToken, error) {
+	var tokens []scoreToken
+	for index, line := range strings.Split(content, "\n") {
+		number := index + 1
+		for i := 0; i < len(line); {
+			switch c := line[i]; {
+			case c == ' ' || c == '\t' || c == '\r':
+				i++
+			case c == '|':
+				tokens = append(tokens, scoreToken{line: number, kind: tokenBar})
+				i++
+			case c == '[':
+				end := strings.IndexByte(line[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: a special instruction is not closed", number)
+				}
+				tokens = append(tokens, scoreToken{line: number})
+				i += end + 1
+			default:
+				end := strings.IndexAny(line[i:], " \t\r|[")
+				if end < 0 {
+					end = len(line) - i
+				}
+				t, err := parseScoreToken(line[i : i+end])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: the token %q is not valid: %v", number, line[i:i+end], err)
+				}
+				t.line = number
+				tokens = append(tokens, t)
+				i += end
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func parseScoreToken(text string) (t scoreToken, err error) {
+	switch {
+	case text == "PICKUP":
+		t.kind = tokenPickup
+	case strings.HasPrefix(text, "TS"):
+		t.kind = tokenSignature
+		t.signature, err = parseTokenSignature(text[2:])
+	case strings.HasPrefix(text, "T"):
+		err = checkTokenTempo(text[1:])
+	case strings.HasPrefix(text, "M"):
+		t.kind = tokenMeasure
+		if t.number, err = strconv.Atoi(text[1:]); err != nil || t.number < 0 {
+			err = fmt.Errorf("the measure number must be a number, at least 0")
+		}
+	case strings.HasPrefix(text, "V"):
+		t.kind = tokenVoice
+		t.voice = text[1:]
+		if n, err2 := strconv.Atoi(t.voice); t.voice != "percussion" && (err2 != nil || n < 0 || n > 15) {
+			err = fmt.Errorf("the voice must be from 0 to 15, or percussion")
+		}
+	case strings.HasPrefix(text, "I"):
+		if text == "I" {
+			err = fmt.Errorf("the instrument is missing")
+		}
+	case strings.HasPrefix(text, "K"):
+		err = checkTokenKey(text[1:])
+	case strings.HasPrefix(text, "L"):
+		if layer, err2 := strconv.ParseFloat(text[1:], 64); err2 != nil || layer < 0 {
+			err = fmt.Errorf("the layer must be a number, at least 0")
+		}
+	case strings.HasPrefix(text, "P"):
+		if page, err2 := strconv.Atoi(text[1:]); text != "P+" && (err2 != nil || page < 1) {
+			err = fmt.Errorf("the page must be a number, at least 1, or +")
+		}
+	case strings.IndexByte("ABCDEFGR", text[0]) >= 0:
+		t.kind = tokenNote
+		_, t.length, err = parseTokenNote(text)
+	default:
+		err = fmt.Errorf("it is not a known token")
+	}
+	return
+}
+
+// parseTokenSignature parses a time signature in fractional form (3/4), or
+// as C (common time) or CUT (cut time), or their symbols
+func parseTokenSignature(s string) ([2]int, error) {
+	switch s {
+	case "C", "𝄴":
+		return [2]int{4, 4}, nil
+	case "CUT", "¢", "𝄵":
+		return [2]int{2, 2}, nil
+	}
+	numeratorText, denominatorText, found := strings.Cut(s, "/")
+	numerator, err1 := strconv.Atoi(numeratorText)
+	denominator, err2 := strconv.Atoi(denominatorText)
+	if !found || err1 != nil || err2 != nil || numerator < 1 || denominator < 1 || denominator > 64 ||
+		denominator&(denominator-1) != 0 {
+		return [2]int{}, fmt.Errorf("the time signature must be a fraction such as 3/4, C, or CUT")
+	}
+	return [2]int{numerator, denominator}, nil
+}
+
+// checkTokenTempo checks the tempo: a number of quarter notes per minute, a
+// tempo name, or a duration, =, and a number of those per minute (q.=60)
+func checkTokenTempo(s string) error {
+	if duration, perMinute, found := strings.Cut(s, "="); found {
+		if _, err := parseTokenDuration(duration); err != nil {
+			return err
+		}
+		s = perMinute
+	} else if tokenTempoNames[strings.ToUpper(s)] {
+		return nil
+	}
+	if bpm, err := strconv.ParseFloat(s, 64); err != nil || bpm <= 0 {
+		return fmt.Errorf("the tempo must be a number more than 0, or a tempo name")
+	}
+	return nil
+}
+
+// checkTokenKey checks the key: a number of flats or sharps (3b, 2#), or the
+// name of a scale (EbMaj, CMin)
+func checkTokenKey(s string) error {
+	for _, accidental := range []string{"b", "#", "♭", "♯"} {
+		if count, found := strings.CutSuffix(s, accidental); found {
+			if n, err := strconv.Atoi(count); err == nil && n >= 0 && n <= 7 {
+				return nil
+			}
+		}
+	}
+	_, err := parseKeyName(s)
+	return err
+}
+
+// parseTokenNote parses a note, chord, or rest, and returns its pitches and
+// length: the pitch (spelled as for --pitches), pitches joined by + (C5+E5+G5),
+// or R, followed by a duration
+func parseTokenNote(s string) ([]uint8, *big.Rat, error) {
+	at := strings.LastIndexAny(s, "whqestx")
+	if at < 1 {
+		return nil, nil, fmt.Errorf("the note has no duration (w, h, q, e, s, t, or x)")
+	}
+	text, duration := s[:at], s[at:]
+	var pitches []uint8
+	if text != "R" {
+		for _, name := range strings.Split(text, "+") {
+			pitch, err := parseNote(name)
+			if err != nil || unicode.IsDigit(rune(name[0])) {
+				return nil, nil, fmt.Errorf("%q is not a pitch", name)
+			}
+			pitches = append(pitches, pitch)
+		}
+	}
+	length, err := parseTokenDuration(duration)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pitches, length, nil
+}
+
+// parseTokenDuration parses a duration letter, followed by any dots, each
+// adding half the length added before, and by 3 for a triplet
+func parseTokenDuration(s string) (*big.Rat, error) {
+	text, triplet := strings.CutSuffix(s, "3")
+	dots := len(text) - len(strings.TrimRight(text, "."))
+	text = text[:len(text)-dots]
+	var length *big.Rat
+	for _, d := range tokenDurations {
+		if text == string(d.letter) {
+			length = new(big.Rat).Set(d.length)
+		}
+	}
+	if length == nil {
+		return nil, fmt.Errorf("%q is not a duration (w, h, q, e, s, t, or x, with any dots, and 3 for a triplet)", s)
+	}
+	added := new(big.Rat).Set(length)
+	for range dots {
+		added.Mul(added, big.NewRat(1, 2))
+		length.Add(length, added)
+	}
+	if triplet {
+		length.Mul(length, big.NewRat(2, 3))
+	}
+	return length, nil
+}
+
+// voiceMeasure is one voice's measure of a score
+type voiceMeasure struct {
+	voice  string
+	number int
+	line   int // where the measure starts
+	length *big.Rat
+	pickup bool
+}
+
+// signatureChange records a time signature taking effect at a measure, in
+// every voice
+type signatureChange struct {
+	measure   int
+	signature [2]int
+}
+
+// collectMeasures gathers each voice's measures, numbered by the M tokens or
+// counted from 1 (or from 0, after a pickup measure), and the time signature
+// changes; measures without notes are skipped
+func collectMeasures(tokens []scoreToken) ([]voiceMeasure, []signatureChange) {
+	type voiceState struct {
+		current  voiceMeasure
+		notes    bool
+		measures int
+	}
+	var measures []voiceMeasure
+	var changes []signatureChange
+	states := map[string]*voiceState{}
+	var order []string
+	state := func(voice string) *voiceState {
+		if s, found := states[voice]; found {
+			return s
+		}
+		s := &voiceState{current: voiceMeasure{voice: voice, number: 1, length: new(big.Rat)}}
+		states[voice] = s
+		order = append(order, voice)
+		return s
+	}
+	closeMeasure := func(s *voiceState) {
+		if s.notes {
+			measures = append(measures, s.current)
+			s.current = voiceMeasure{voice: s.current.voice, number: s.current.number + 1, length: new(big.Rat)}
+			s.notes = false
+			s.measures++
+		}
+	}
+	s := state("0")
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenVoice:
+			s = state(t.voice)
+		case tokenBar:
+			closeMeasure(s)
+		case tokenMeasure:
+			s.current.number = t.number
+		case tokenSignature:
+			changes = append(changes, signatureChange{measure: s.current.number, signature: t.signature})
+		case tokenPickup:
+			s.current.pickup = true
+			if s.measures == 0 && s.current.number == 1 {
+				s.current.number = 0
+			}
+		case tokenNote:
+			if !s.notes {
+				s.current.line = t.line
+			}
+			s.notes = true
+			s.current.length.Add(s.current.length, t.length)
+		}
+	}
+	for _, voice := range order {
+		closeMeasure(states[voice])
+	}
+	return measures, changes
+}
+
+// signatureAt returns the time signature in effect at the measure, the last
+// one given for the latest measure not after it; it is 4/4 until the first
+func signatureAt(changes []signatureChange, measure int) [2]int {
+	signature, from := [2]int{4, 4}, -1
+	for _, c := range changes {
+		if c.measure <= measure && c.measure >= from {
+			signature, from = c.signature, c.measure
+		}
+	}
+	return signature
+}
+
+// describeNoteValues renders the length as a number of the longest note
+// value, or triplet note value, that measures it exactly
+func describeNoteValues(length *big.Rat) string {
+	for _, triplet := range []bool{false, true} {
+		for _, d := range tokenDurations {
+			value, name := d.length, d.name
+			if triplet {
+				value, name = new(big.Rat).Mul(value, big.NewRat(2, 3)), "triplet "+name
+			}
+			if count := new(big.Rat).Quo(length, value); count.IsInt() {
+				if count.Num().Int64() == 1 {
+					return "1 " + name + " note"
+				}
+				return count.Num().String() + " " + name + " notes"
+			}
+		}
+	}
+	return length.RatString() + " of a whole note"
+}
+
+// checkMeasures reports each measure whose notes do not add up to a bar of
+// its time signature; a pickup measure may be short
+func checkMeasures(measures []voiceMeasure, changes []signatureChange) []string {
+	var problems []string
+	for _, m := range measures {
+		signature := signatureAt(changes, m.number)
+		bar := big.NewRat(int64(signature[0]), int64(signature[1]))
+		difference := new(big.Rat).Sub(m.length, bar)
+		switch sign := difference.Sign(); {
+		case sign > 0:
+			problems = append(problems, fmt.Sprintf("voice %s, measure %d (line %d): %s too long for %d/%d", m.voice,
+				m.number, m.line, describeNoteValues(difference), signature[0], signature[1]))
+		case sign < 0 && !m.pickup:
+			problems = append(problems, fmt.Sprintf("voice %s, measure %d (line %d): %s too short for %d/%d", m.voice,
+				m.number, m.line, describeNoteValues(difference.Neg(difference)), signature[0], signature[1]))
+		}
+	}
+	return problems
+}
+
+// tokens checks the measures of token-language scores against their time
+// signatures
+type tokens struct{}
+
+func newTokens() command {
+	return &tokens{}
+}
+
+func (tk *tokens) defineFlags(_ *pflag.FlagSet) {}
+
+func (tk *tokens) run(o output.Bus, args []string) int {
+	return processFilesOfType(o, args, tokenExtensions, tk.processFile)
+}
+
+func (tk *tokens) processFile(o output.Bus, path string) bool {
+	content, err := readFile(path)
+	if err != nil {
+		o.ErrorPrintf("The file %q cannot be read: %v.\n", path, err)
+		o.Log(output.Error, "cannot read file", map[string]any{"file": path, "error": err})
+		return false
+	}
+	scoreTokens, err := tokenizeScore(string(content))
+	if err != nil {
+		o.ErrorPrintf("The file %q is not valid: %v.\n", path, err)
+		return false
+	}
+	measures, changes := collectMeasures(scoreTokens)
+	problems := checkMeasures(measures, changes)
+	for _, problem := range problems {
+		o.ConsolePrintf("%s: %s\n", path, problem)
+	}
+	if len(problems) > 0 {
+		o.ErrorPrintf("The file %q has %d of %d measures that do not fit their time signatures.\n", path,
+			len(problems), len(measures))
+		return false
+	}
+	o.ConsolePrintf("%s: all %d measures fit their time signatures\n", path, len(measures))
+	return true
+}
@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/majohn-r/output"
+)
+
+func Test_parseTokenNote(t *testing.T) {
+	tests := map[string]struct {
+		s           string
+		wantPitches []uint8
+		want        *big.Rat
+		wantErr     bool
+	}{
+		"quarter":          {s: "C5q", wantPitches: []uint8{60}, want: big.NewRat(1, 4)},
+		"octave 3":         {s: "C3e", wantPitches: []uint8{36}, want: big.NewRat(1, 8)},
+		"flat, dotted":     {s: "Bb4h.", wantPitches: []uint8{58}, want: big.NewRat(3, 4)},
+		"double dotted":    {s: "F#5q..", wantPitches: []uint8{66}, want: big.NewRat(7, 16)},
+		"triplet":          {s: "E4e3", wantPitches: []uint8{52}, want: big.NewRat(1, 12)},
+		"chord":            {s: "C5+E5+G5w", wantPitches: []uint8{60, 64, 67}, want: big.NewRat(1, 1)},
+		"rest":             {s: "Rs", want: big.NewRat(1, 16)},
+		"no duration":      {s: "C5", wantErr: true},
+		"no pitch":         {s: "q", wantErr: true},
+		"bad pitch":        {s: "H5q", wantErr: true},
+		"number as pitch":  {s: "C5+60q", wantErr: true},
+		"bad duration":     {s: "C5q3.", wantErr: true},
+		"empty chord note": {s: "C5++E5q", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pitches, got, err := parseTokenNote(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTokenNote() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(pitches, tt.wantPitches) {
+				t.Errorf("parseTokenNote() pitches = %v, want %v", pitches, tt.wantPitches)
+			}
+			if got.Cmp(tt.want) != 0 {
+				t.Errorf("parseTokenNote() = %s, want %s", got.RatString(), tt.want.RatString())
+			}
+		})
+	}
+}
+
+func Test_tokenizeScore(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    []scoreTokenKind
+		wantErr string
+	}{
+		"all kinds": {
+			content: "P1 IVIOLIN K2# TS3/4 Tq.=60 TADAGIO T72 L1 V0 M0 PICKUP\n" +
+				"D5q|[a tempo] F#5h E5q|",
+			want: []scoreTokenKind{tokenOther, tokenOther, tokenOther, tokenSignature, tokenOther, tokenOther,
+				tokenOther, tokenOther, tokenVoice, tokenMeasure, tokenPickup, tokenNote, tokenBar, tokenOther,
+				tokenNote, tokenNote, tokenBar},
+		},
+		"keys and signatures": {
+			content: "KEbMaj KF#Min K0b TSC TSCUT TS6/8 P+ Vpercussion",
+			want: []scoreTokenKind{tokenOther, tokenOther, tokenOther, tokenSignature, tokenSignature, tokenSignature,
+				tokenOther, tokenVoice},
+		},
+		"open instruction": {content: "C5q\n[ff C5q", wantErr: "line 2: a special instruction is not closed"},
+		"bad signature": {
+			content: "TS3/5",
+			wantErr: "line 1: the token \"TS3/5\" is not valid: the time signature must be a fraction such as 3/4, " +
+				"C, or CUT",
+		},
+		"bad voice": {
+			content: "V16",
+			wantErr: "line 1: the token \"V16\" is not valid: the voice must be from 0 to 15, or percussion",
+		},
+		"unknown": {content: "C5q\nZ9", wantErr: "line 2: the token \"Z9\" is not valid: it is not a known token"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tokenizeScore(tt.content)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("tokenizeScore() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tokenizeScore() error = %v", err)
+			}
+			var kinds []scoreTokenKind
+			for _, token := range got {
+				kinds = append(kinds, token.kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.want) {
+				t.Errorf("tokenizeScore() kinds = %v, want %v", kinds, tt.want)
+			}
+		})
+	}
+}
+
+func Test_describeNoteValues(t *testing.T) {
+	for length, want := range map[*big.Rat]string{
+		big.NewRat(1, 4):  "1 quarter note",
+		big.NewRat(3, 8):  "3 eighth notes",
+		big.NewRat(1, 12): "1 triplet eighth note",
+		big.NewRat(1, 5):  "1/5 of a whole note",
+	} {
+		if got := describeNoteValues(length); got != want {
+			t.Errorf("describeNoteValues(%s) = %q, want %q", length.RatString(), got, want)
+		}
+	}
+}
+
+func Test_checkMeasures(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    []string
+	}{
+		"complete": {
+			content: "TS3/4 D5q E5q F#5q | G5h. |",
+		},
+		"default 4/4, last bar unclosed": {
+			content: "C5w | C5h Rh | C5e C5e C5h",
+			want:    []string{"voice 0, measure 3 (line 1): 1 quarter note too short for 4/4"},
+		},
+		"surplus and deficit": {
+			content: "TS3/4 D5q E5q F#5q G5e |\nG5h |",
+			want: []string{
+				"voice 0, measure 1 (line 1): 1 eighth note too long for 3/4",
+				"voice 0, measure 2 (line 2): 1 quarter note too short for 3/4",
+			},
+		},
+		"pickup may be short, not long": {
+			content: "TS3/4 PICKUP D5q | D5h. | M7 PICKUP D5h. D5q |",
+			want:    []string{"voice 0, measure 7 (line 1): 1 quarter note too long for 3/4"},
+		},
+		"numbered and signature changes": {
+			content: "TS2/4 M10 C5q C5q | TS6/8 C5q. C5q. | C5q C5q |",
+			want:    []string{"voice 0, measure 12 (line 1): 1 quarter note too short for 6/8"},
+		},
+		"signature changes apply to every voice": {
+			content: "V0 TS3/4 C5h. | TS2/4 C5h |\nV1 C5q C5q C5q | C5q C5q C5q |",
+			want:    []string{"voice 1, measure 2 (line 2): 1 quarter note too long for 2/4"},
+		},
+		"triplets": {
+			content: "TS2/4 C5e3 C5e3 C5e3 C5q | C5e3 C5e3 C5q |",
+			want:    []string{"voice 0, measure 2 (line 1): 1 triplet eighth note too short for 2/4"},
+		},
+		"empty measures are skipped": {
+			content: "| TSC | C5w || C5w |",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tokens, err := tokenizeScore(tt.content)
+			if err != nil {
+				t.Fatalf("tokenizeScore() error = %v", err)
+			}
+			if got := checkMeasures(collectMeasures(tokens)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkMeasures() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tokens_run(t *testing.T) {
+	useMemoryFileSystem(t, map[string][]byte{
+		"/scores/good.tok":  []byte("TS3/4 D5q E5q F#5q | G5h. |\n"),
+		"/scores/short.tok": []byte("TS3/4 D5q E5q F#5q | G5h |\n"),
+		"/bad.tok":          []byte("TS3/4 D5q Y |\n"),
+	})
+	tests := map[string]struct {
+		args []string
+		want int
+		output.WantedRecording
+	}{
+		"good": {
+			args:            []string{"/scores/good.tok"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/scores/good.tok: all 2 measures fit their time signatures\n"},
+		},
+		"short": {
+			args: []string{"/scores/short.tok"},
+			want: exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Console: "/scores/short.tok: voice 0, measure 2 (line 1): 1 quarter note too short for 3/4\n",
+				Error:   "The file \"/scores/short.tok\" has 1 of 2 measures that do not fit their time signatures.\n",
+			},
+		},
+		"bad token": {
+			args: []string{"/bad.tok"},
+			want: exitSystemError,
+			WantedRecording: output.WantedRecording{
+				Error: "The file \"/bad.tok\" is not valid: line 1: the token \"Y\" is not valid: it is not a known token.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := (&tokens{}).run(o, tt.args); got != tt.want {
+				t.Errorf("tokens.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("tokens.run() %s", issue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// transcode rewrites the text meta events of files, such as track names and
+// lyrics, in UTF-8
+type transcode struct {
+	encodingName string
+	outFile      string
+}
+
+func newTranscode() command {
+	return &transcode{}
+}
+
+func (tc *transcode) defineFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&tc.encodingName, "encoding", autoEncoding, "encoding of the text in the files: "+encodingNames())
+	flags.StringVarP(&tc.outFile, "output", "o", "", "file to write (default: overwrite the input file)")
+}
+
+func (tc *transcode) run(o output.Bus, args []string) int {
+	valid := true
+	if err := checkEncodingName(tc.encodingName); err != nil {
+		o.ErrorPrintf("The --encoding value %q is not valid: %v.\n", tc.encodingName, err)
+		valid = false
+	}
+	if tc.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		valid = false
+	}
+	if !valid {
+		return exitUserError
+	}
+	return processFiles(o, args, tc.processFile)
+}
+
+func (tc *transcode) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	name, codec := textCodec(tc.encodingName, data)
+	if name == "utf-8" && tc.outFile == "" {
+		o.ConsolePrintf("%s: the text is already in UTF-8\n", path)
+		return true
+	}
+	changed := 0
+	for _, track := range data.Tracks {
+		for k, event := range track {
+			if !event.Message.IsMeta() {
+				continue
+			}
+			typ, text := metaData(event.Message)
+			if dumpTextTypes[typ] == "" {
+				continue
+			}
+			if decoded := metaText(codec, text); decoded != string(text) {
+				track[k].Message = smf.MetaUndefined(typ, []byte(decoded))
+				changed++
+			}
+		}
+	}
+	destination := path
+	if tc.outFile != "" {
+		destination = tc.outFile
+	}
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: %d text events transcoded from %s\n", destination, changed, name)
+	return true
+}
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/majohn-r/output"
+)
+
+func Test_transcode_run(t *testing.T) {
+	tests := map[string]struct {
+		tc   *transcode
+		args []string
+		want int
+		output.WantedRecording
+		// after is what "meta get" prints for the file written
+		after string
+	}{
+		"detected": {
+			tc:              &transcode{},
+			args:            []string{"/legacy.mid"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/legacy.mid: 1 text events transcoded from windows-1252\n"},
+			after:           "/legacy.mid: track 0, bar 1 beat 1, copyright \"© Café\"\n",
+		},
+		"chosen, to another file": {
+			tc:              &transcode{encodingName: "shift-jis", outFile: "/copy.mid"},
+			args:            []string{"/japanese.mid"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/copy.mid: 1 text events transcoded from shift-jis\n"},
+			after:           "/copy.mid: track 0, bar 1 beat 1, copyright \"さくら\"\n",
+		},
+		"already UTF-8": {
+			tc:              &transcode{encodingName: "auto"},
+			args:            []string{"/song.mid"},
+			want:            exitSuccess,
+			WantedRecording: output.WantedRecording{Console: "/song.mid: the text is already in UTF-8\n"},
+		},
+		"bad flags": {
+			tc:   &transcode{encodingName: "utf-16", outFile: "/copy.mid"},
+			args: []string{"/song.mid", "/legacy.mid"},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --encoding value \"utf-16\" is not valid: the encodings are auto, latin-1, shift-jis, " +
+					"utf-8, windows-1252.\n" +
+					"The --output flag may only be used with a single input file.\n",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			useMemoryFileSystem(t, map[string][]byte{
+				"/song.mid":     smfBytes(t, metaTestSMF([]byte("(c) 2020"))),
+				"/legacy.mid":   smfBytes(t, metaTestSMF([]byte("\xa9 Caf\xe9"))),
+				"/japanese.mid": smfBytes(t, metaTestSMF([]byte("\x82\xb3\x82\xad\x82\xe7"))),
+			})
+			o := output.NewRecorder()
+			if got := tt.tc.run(o, tt.args); got != tt.want {
+				t.Errorf("transcode.run() = %d, want %d", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("transcode.run() %s", issue)
+				}
+			}
+			if tt.after == "" {
+				return
+			}
+			written := tt.args[0]
+			if tt.tc.outFile != "" {
+				written = tt.tc.outFile
+			}
+			// read back as UTF-8, not detected
+			o = output.NewRecorder()
+			_ = (&meta{typeName: "copyright", track: -1, encodingName: "utf-8"}).run(o, []string{"get", written})
+			if got := o.ConsoleOutput(); got != tt.after {
+				t.Errorf("transcode.run() left %q, want %q", got, tt.after)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/majohn-r/output"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// velocity edits the velocities of note on events. The edits are applied in
+// this order: scale, offset, compress, randomize, snap, limit.
+type velocity struct {
+	scale     float64
+	offset    int
+	ratio     float64
+	threshold int
+	randomize int
+	seed      int64
+	snap      bool
+	minimum   int
+	maximum   int
+	outFile   string
+	selection selectionFlags
+	random    *rand.Rand
+}
+
+func newVelocity() command {
+	return &velocity{}
+}
+
+func (v *velocity) defineFlags(flags *pflag.FlagSet) {
+	flags.Float64Var(&v.scale, "scale", 1, "multiply velocities by this factor")
+	flags.IntVar(&v.offset, "offset", 0, "add this value to velocities")
+	flags.Float64Var(&v.ratio, "ratio", 1, "compression ratio applied to velocities above the threshold")
+	flags.IntVar(&v.threshold, "threshold", 80, "velocity above which compression is applied")
+	flags.IntVar(&v.randomize, "randomize", 0, "randomly vary velocities by up to plus or minus this value")
+	flags.Int64Var(&v.seed, "seed", 1, "seed for the randomizer")
+	flags.BoolVar(&v.snap, "snap", false, "snap velocities to the nearest dynamic marking (ppp=16 ... fff=127)")
+	flags.IntVar(&v.minimum, "min", 1, "lowest permitted velocity")
+	flags.IntVar(&v.maximum, "max", 127, "highest permitted velocity")
+	flags.StringVarP(&v.outFile, "output", "o", "", "file to write (default: overwrite the input file)")
+	v.selection.define(flags)
+}
+
+func (v *velocity) validate(o output.Bus, args []string) bool {
+	valid := true
+	if v.scale < 0 {
+		o.ErrorPrintf("The --scale value %g is not valid: it must not be negative.\n", v.scale)
+		valid = false
+	}
+	if v.ratio < 1 {
+		o.ErrorPrintf("The --ratio value %g is not valid: it must be at least 1.\n", v.ratio)
+		valid = false
+	}
+	if v.threshold < 1 || v.threshold > 127 {
+		o.ErrorPrintf("The --threshold value %d is not valid: it must be between 1 and 127.\n", v.threshold)
+		valid = false
+	}
+	if v.randomize < 0 {
+		o.ErrorPrintf("The --randomize value %d is not valid: it must not be negative.\n", v.randomize)
+		valid = false
+	}
+	if v.minimum < 1 || v.maximum > 127 || v.minimum > v.maximum {
+		o.ErrorPrintf("The --min and --max values %d and %d are not valid: they must satisfy 1 <= min <= max <= 127.\n",
+			v.minimum, v.maximum)
+		valid = false
+	}
+	if err := v.selection.validate(); err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		valid = false
+	}
+	if v.outFile != "" && namesSeveralFiles(args) {
+		o.ErrorPrintln("The --output flag may only be used with a single input file.")
+		valid = false
+	}
+	return valid
+}
+
+func (v *velocity) run(o output.Bus, args []string) int {
+	if !v.validate(o, args) {
+		return exitUserError
+	}
+	return processFiles(o, args, v.processFile)
+}
+
+func (v *velocity) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	selection, err := v.selection.build(newMeter(data))
+	if err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		return false
+	}
+	// files may be edited at once, so each gets its own randomizer, seeded
+	// alike
+	editor := *v
+	editor.random = rand.New(rand.NewSource(v.seed))
+	changed := editor.apply(data, selection)
+	destination := path
+	if v.outFile != "" {
+		destination = v.outFile
+	}
+	if !writeSMF(o, data, destination) {
+		return false
+	}
+	o.ConsolePrintf("%s: %d note velocities changed\n", destination, changed)
+	return true
+}
+
+// apply edits the velocities of the selected note on events in place, and
+// returns the number of events whose velocity changed
+func (v *velocity) apply(data *smf.SMF, selection eventSelection) (changed int) {
+	for trackNumber, track := range data.Tracks {
+		if !selection.includesTrack(trackNumber) {
+			continue
+		}
+		ticks := absoluteTicks(track)
+		for k, event := range track {
+			var channel, key, oldVelocity uint8
+			if !event.Message.GetNoteStart(&channel, &key, &oldVelocity) {
+				continue
+			}
+			if !selection.includesChannel(channel) || !selection.includesPitch(key) ||
+				!selection.span.contains(ticks[k]) {
+				continue
+			}
+			if newVelocity := v.transform(oldVelocity); newVelocity != oldVelocity {
+				track[k].Message = smf.Message(midi.NoteOn(channel, key, newVelocity))
+				changed++
+			}
+		}
+	}
+	return
+}
+
+func (v *velocity) transform(original uint8) uint8 {
+	value := float64(original)*v.scale + float64(v.offset)
+	if threshold := float64(v.threshold); value > threshold {
+		value = threshold + (value-threshold)/v.ratio
+	}
+	if v.randomize > 0 {
+		value += float64(v.random.Intn(2*v.randomize+1) - v.randomize)
+	}
+	result := int(math.Round(value))
+	if v.snap {
+		result = int(nearestDynamic(uint8(max(min(result, 127), 0))).velocity)
+	}
+	return uint8(max(min(result, v.maximum), v.minimum))
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// makeTestSMF creates a format 1 file with 480 ticks per quarter note from the
+// provided tracks, closing each track
+func makeTestSMF(tracks ...smf.Track) *smf.SMF {
+	data := smf.NewSMF1()
+	data.TimeFormat = smf.MetricTicks(480)
+	for _, track := range tracks {
+		track.Close(0)
+		_ = data.Add(track)
+	}
+	return data
+}
+
+// noteVelocities returns the velocities of all note on events with a non-zero
+// velocity in the track
+func noteVelocities(track smf.Track) []uint8 {
+	var velocities []uint8
+	for _, event := range track {
+		var v uint8
+		if event.Message.GetNoteStart(nil, nil, &v) {
+			velocities = append(velocities, v)
+		}
+	}
+	return velocities
+}
+
+func Test_velocity_transform(t *testing.T) {
+	tests := map[string]struct {
+		v        *velocity
+		original uint8
+		want     uint8
+	}{
+		"identity":          {v: &velocity{scale: 1, ratio: 1, threshold: 80, minimum: 1, maximum: 127}, original: 64, want: 64},
+		"scale":             {v: &velocity{scale: 1.5, ratio: 1, threshold: 127, minimum: 1, maximum: 127}, original: 64, want: 96},
+		"offset":            {v: &velocity{scale: 1, offset: -10, ratio: 1, threshold: 127, minimum: 1, maximum: 127}, original: 64, want: 54},
+		"compress":          {v: &velocity{scale: 1, ratio: 2, threshold: 80, minimum: 1, maximum: 127}, original: 120, want: 100},
+		"below threshold":   {v: &velocity{scale: 1, ratio: 2, threshold: 80, minimum: 1, maximum: 127}, original: 70, want: 70},
+		"snap":              {v: &velocity{scale: 1, ratio: 1, threshold: 127, snap: true, minimum: 1, maximum: 127}, original: 70, want: 64},
+		"snap tie":          {v: &velocity{scale: 1, ratio: 1, threshold: 127, snap: true, minimum: 1, maximum: 127}, original: 72, want: 80},
+		"limit high":        {v: &velocity{scale: 2, ratio: 1, threshold: 127, minimum: 1, maximum: 100}, original: 64, want: 100},
+		"limit low":         {v: &velocity{scale: 1, offset: -100, ratio: 1, threshold: 127, minimum: 20, maximum: 127}, original: 64, want: 20},
+		"never zero":        {v: &velocity{scale: 0, ratio: 1, threshold: 127, minimum: 1, maximum: 127}, original: 64, want: 1},
+		"snap before limit": {v: &velocity{scale: 1, ratio: 1, threshold: 127, snap: true, minimum: 1, maximum: 90}, original: 100, want: 90},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.v.transform(tt.original); got != tt.want {
+				t.Errorf("velocity.transform() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_velocity_apply(t *testing.T) {
+	makeTrack := func(channel uint8) smf.Track {
+		var track smf.Track
+		track.Add(0, midi.NoteOn(channel, 60, 50))
+		track.Add(480, midi.NoteOff(channel, 60))
+		track.Add(0, midi.NoteOn(channel, 72, 50))
+		track.Add(480, midi.NoteOn(channel, 72, 0))
+		track.Add(960, midi.NoteOn(channel, 60, 50))
+		track.Add(480, midi.NoteOff(channel, 60))
+		return track
+	}
+	doubler := &velocity{scale: 2, ratio: 1, threshold: 127, minimum: 1, maximum: 127}
+	tests := map[string]struct {
+		flags       selectionFlags
+		wantChanged int
+		want        [][]uint8
+	}{
+		"everything": {
+			wantChanged: 6,
+			want:        [][]uint8{{100, 100, 100}, {100, 100, 100}},
+		},
+		"one track": {
+			flags:       selectionFlags{tracks: "1"},
+			wantChanged: 3,
+			want:        [][]uint8{{50, 50, 50}, {100, 100, 100}},
+		},
+		"one channel": {
+			flags:       selectionFlags{channels: "3"},
+			wantChanged: 3,
+			want:        [][]uint8{{100, 100, 100}, {50, 50, 50}},
+		},
+		"pitch range": {
+			flags:       selectionFlags{pitches: "C6..C7"},
+			wantChanged: 2,
+			want:        [][]uint8{{50, 100, 50}, {50, 100, 50}},
+		},
+		"time range": {
+			flags:       selectionFlags{from: "1", to: "1:3"},
+			wantChanged: 4,
+			want:        [][]uint8{{100, 100, 50}, {100, 100, 50}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := makeTestSMF(makeTrack(3), makeTrack(4))
+			selection, err := tt.flags.build(newMeter(data))
+			if err != nil {
+				t.Fatalf("selectionFlags.build() error = %v", err)
+			}
+			if got := doubler.apply(data, selection); got != tt.wantChanged {
+				t.Errorf("velocity.apply() = %d, want %d", got, tt.wantChanged)
+			}
+			for k, track := range data.Tracks {
+				got := noteVelocities(track)
+				if len(got) != len(tt.want[k]) {
+					t.Fatalf("velocity.apply() track %d velocities = %v, want %v", k, got, tt.want[k])
+				}
+				for j := range got {
+					if got[j] != tt.want[k][j] {
+						t.Errorf("velocity.apply() track %d velocities = %v, want %v", k, got, tt.want[k])
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_velocity_validate(t *testing.T) {
+	tests := map[string]struct {
+		v    *velocity
+		args []string
+		want bool
+		output.WantedRecording
+	}{
+		"good": {
+			v:    &velocity{scale: 1, ratio: 1, threshold: 80, minimum: 1, maximum: 127},
+			args: []string{"a.mid"},
+			want: true,
+		},
+		"bad everything": {
+			v: &velocity{scale: -1, ratio: 0.5, threshold: 0, randomize: -1, minimum: 0, maximum: 127, outFile: "x.mid",
+				selection: selectionFlags{channels: "16"}},
+			args: []string{"a.mid", "b.mid"},
+			want: false,
+			WantedRecording: output.WantedRecording{Error: "" +
+				"The --scale value -1 is not valid: it must not be negative.\n" +
+				"The --ratio value 0.5 is not valid: it must be at least 1.\n" +
+				"The --threshold value 0 is not valid: it must be between 1 and 127.\n" +
+				"The --randomize value -1 is not valid: it must not be negative.\n" +
+				"The --min and --max values 0 and 127 are not valid: they must satisfy 1 <= min <= max <= 127.\n" +
+				"The selection is not valid: \"16\" is not a valid value or range between 0 and 15.\n" +
+				"The --output flag may only be used with a single input file.\n"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			if got := tt.v.validate(o, tt.args); got != tt.want {
+				t.Errorf("velocity.validate() = %t, want %t", got, tt.want)
+			}
+			if issues, ok := o.Verify(tt.WantedRecording); !ok {
+				for _, issue := range issues {
+					t.Errorf("velocity.validate() %s", issue)
+				}
+			}
+		})
+	}
+}
+
+func Test_velocity_processFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.mid")
+	var track smf.Track
+	track.Add(0, midi.NoteOn(0, 60, 70))
+	track.Add(480, midi.NoteOff(0, 60))
+	if err := makeTestSMF(track).WriteFile(input); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	outFile := filepath.Join(dir, "out.mid")
+	v := &velocity{scale: 1, ratio: 1, threshold: 80, snap: true, minimum: 1, maximum: 127, outFile: outFile}
+	o := output.NewRecorder()
+	if !v.processFile(o, input) {
+		t.Fatalf("velocity.processFile() failed: %s", o.ErrorOutput())
+	}
+	if got, want := o.ConsoleOutput(), outFile+": 1 note velocities changed\n"; got != want {
+		t.Errorf("velocity.processFile() console = %q, want %q", got, want)
+	}
+	data, err := smf.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("cannot read output file: %v", err)
+	}
+	if got := noteVelocities(data.Tracks[0]); len(got) != 1 || got[0] != 64 {
+		t.Errorf("velocity.processFile() wrote velocities %v, want [64]", got)
+	}
+}
@@ -0,0 +1,399 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/majohn-r/output"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/pflag"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const (
+	// viewDefaultWidth is used when the terminal's width cannot be found
+	viewDefaultWidth = 80
+	// viewMinWidth leaves room for the labels and a few columns of music
+	viewMinWidth = 40
+	// viewNameWidth limits the track names in the overview
+	viewNameWidth = 16
+	ansiReset     = "\x1b[0m"
+)
+
+// stdoutIsTerminal and terminalWidth describe the console; tests replace them
+var (
+	stdoutIsTerminal = func() bool {
+		fd := os.Stdout.Fd()
+		return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+	}
+	terminalWidth = consoleWidth
+)
+
+// viewGlyphs are the characters a view is drawn with
+type viewGlyphs struct {
+	levels  []rune // activity, from none to the most
+	barLine rune
+	// the roll is drawn with two time slices per character in Unicode, and one
+	// in ASCII, where a note's start is marked apart from its continuation
+	both, first, second rune
+}
+
+var (
+	unicodeGlyphs = viewGlyphs{
+		levels: []rune(" ▁▂▃▄▅▆▇█"), barLine: '│', both: '█', first: '▌', second: '▐',
+	}
+	asciiGlyphs = viewGlyphs{
+		levels: []rune(" .:-=+*#%@"), barLine: '|', both: '=', first: '[',
+	}
+)
+
+// channelANSIColors holds a foreground color for each channel
+var channelANSIColors = [16]int{34, 33, 32, 31, 35, 36, 94, 93, 92, 96, 95, 91, 37, 90, 97, 39}
+
+// view prints files as text: a row of bars per track, showing how busy each
+// measure is, and optionally a piano roll
+type view struct {
+	width     int
+	roll      bool
+	ascii     bool
+	color     string
+	selection selectionFlags
+	// set by run
+	columns  int
+	useColor bool
+	glyphs   viewGlyphs
+}
+
+func newView() command {
+	return &view{}
+}
+
+func (v *view) defineFlags(flags *pflag.FlagSet) {
+	flags.IntVar(&v.width, "width", 0, "width in characters (default: the terminal's width)")
+	flags.BoolVar(&v.roll, "roll", false, "also show a piano roll, one row per pitch")
+	flags.BoolVar(&v.ascii, "ascii", false, "draw with ASCII characters instead of Unicode blocks")
+	flags.StringVar(&v.color, "color", "auto", "use color: auto (when writing to a terminal), always, or never")
+	v.selection.define(flags)
+}
+
+func (v *view) run(o output.Bus, args []string) int {
+	valid := true
+	switch v.color {
+	case "always":
+		v.useColor = true
+	case "never":
+		v.useColor = false
+	case "auto":
+		v.useColor = stdoutIsTerminal() && os.Getenv("NO_COLOR") == ""
+	default:
+		o.ErrorPrintf("The --color value %q is not valid: it must be auto, always, or never.\n", v.color)
+		valid = false
+	}
+	v.columns = v.width
+	if v.columns == 0 {
+		v.columns = terminalWidth()
+		if v.columns == 0 {
+			v.columns, _ = strconv.Atoi(os.Getenv("COLUMNS"))
+		}
+		if v.columns <= 0 {
+			v.columns = viewDefaultWidth
+		}
+		v.columns = max(v.columns, viewMinWidth)
+	} else if v.columns < viewMinWidth || v.columns > 1000 {
+		o.ErrorPrintf("The --width value %d is not valid: it must be from %d to 1000.\n", v.width, viewMinWidth)
+		valid = false
+	}
+	if err := v.selection.validate(); err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		valid = false
+	}
+	if !valid {
+		return exitUserError
+	}
+	v.glyphs = unicodeGlyphs
+	if v.ascii {
+		v.glyphs = asciiGlyphs
+	}
+	return processFiles(o, args, v.processFile)
+}
+
+func (v *view) processFile(o output.Bus, path string) bool {
+	data, ok := readSMF(o, path)
+	if !ok {
+		return false
+	}
+	m := newMeter(data)
+	selection, err := v.selection.build(m)
+	if err != nil {
+		o.ErrorPrintf("The selection is not valid: %v.\n", err)
+		return false
+	}
+	notes := selection.selectNotes(collectNotes(data))
+	from, to := selection.span.from, selection.span.to
+	if to < 0 {
+		to = lastTick(data)
+		for _, n := range notes {
+			to = max(to, n.end)
+		}
+	}
+	if to <= from {
+		o.ErrorPrintf("The file %q has nothing to show in the selected range.\n", path)
+		return false
+	}
+	firstBar, _, _ := m.locate(from)
+	lastBar, _, _ := m.locate(to - 1)
+	o.ConsolePrintf("%s: %d tracks, bars %d to %d, %d notes\n", path, len(data.Tracks), firstBar, lastBar,
+		len(notes))
+	for _, line := range v.overview(data, m, selection, notes, from, to) {
+		o.ConsolePrintln(line)
+	}
+	if v.roll && len(notes) > 0 {
+		o.ConsolePrintln("")
+		for _, line := range v.pianoRoll(data, m, selection, notes, from, to) {
+			o.ConsolePrintln(line)
+		}
+	}
+	return true
+}
+
+// overview returns a ruler of bar numbers and a row per selected track, with
+// a character per measure (or per group of measures, when there are more
+// than fit) that rises with the number of notes starting in it
+func (v *view) overview(data *smf.SMF, m *meter, selection eventSelection, notes []note, from, to int64) []string {
+	var tracks []int
+	names := map[int]string{}
+	nameWidth := 0
+	for track, events := range data.Tracks {
+		if !selection.includesTrack(track) {
+			continue
+		}
+		tracks = append(tracks, track)
+		for _, event := range events {
+			var name string
+			if event.Message.GetMetaTrackName(&name) {
+				names[track] = truncateRunes(name, viewNameWidth)
+				break
+			}
+		}
+		nameWidth = max(nameWidth, len([]rune(names[track])))
+	}
+	label := func(track int) string {
+		return fmt.Sprintf("%2d %-*s ", track, nameWidth, names[track])
+	}
+	labelWidth := len([]rune(label(0)))
+	firstBar, _, _ := m.locate(from)
+	lastBar, _, _ := m.locate(to - 1)
+	bars := lastBar - firstBar + 1
+	available := max(v.columns-labelWidth, 1)
+	barsPerColumn := (bars + available - 1) / available
+	columns := (bars + barsPerColumn - 1) / barsPerColumn
+	counts := map[int][]int{}
+	for _, track := range tracks {
+		counts[track] = make([]int, columns)
+	}
+	most := 0
+	for _, n := range notes {
+		if n.start < from {
+			continue
+		}
+		bar, _, _ := m.locate(n.start)
+		column := (bar - firstBar) / barsPerColumn
+		counts[n.track][column]++
+		most = max(most, counts[n.track][column])
+	}
+	// number evenly spaced columns, leaving room for the widest number
+	step := len(strconv.Itoa(lastBar)) + 1
+	lines := []string{v.ruler(labelWidth, columns, func(column int) (int, bool) {
+		return firstBar + column*barsPerColumn, column%step == 0
+	})}
+	top := len(v.glyphs.levels) - 1
+	for _, track := range tracks {
+		var b strings.Builder
+		b.WriteString(label(track))
+		current := ""
+		for _, count := range counts[track] {
+			level := 0
+			if count > 0 {
+				level = (count*top + most - 1) / most
+			}
+			colorCode := ""
+			if level > 0 && v.useColor {
+				colorCode = activityColor(level, top)
+			}
+			current = v.switchColor(&b, current, colorCode)
+			b.WriteRune(v.glyphs.levels[level])
+		}
+		v.switchColor(&b, current, "")
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	if barsPerColumn > 1 {
+		lines = append(lines, fmt.Sprintf("(%d bars per column)", barsPerColumn))
+	}
+	return lines
+}
+
+// pianoRoll returns a ruler of bar numbers and a row per pitch, from the
+// highest down, with a sixteenth note per time slice when the range fits, and
+// longer slices when it does not
+func (v *view) pianoRoll(data *smf.SMF, m *meter, selection eventSelection, notes []note, from, to int64) []string {
+	low, high := selection.lowPitch, selection.highPitch
+	if low == 0 && high == 127 {
+		low, high = notes[0].pitch, notes[0].pitch
+		for _, n := range notes {
+			low, high = min(low, n.pitch), max(high, n.pitch)
+		}
+	}
+	key := firstKey(data)
+	speller := &read{key: &key}
+	labelWidth := 0
+	labels := map[uint8]string{}
+	for pitch := int(low); pitch <= int(high); pitch++ {
+		labels[uint8(pitch)] = speller.asNote(0, uint8(pitch))
+		labelWidth = max(labelWidth, len([]rune(labels[uint8(pitch)])))
+	}
+	labelWidth++
+	columns := max(v.columns-labelWidth, 1)
+	slicesPerColumn := int64(2)
+	if v.ascii {
+		slicesPerColumn = 1
+	}
+	sliceTicks := max(m.ticksPerQuarter/4, 1)
+	if needed := (to - from + sliceTicks - 1) / sliceTicks; needed > int64(columns)*slicesPerColumn {
+		sliceTicks = (to - from + int64(columns)*slicesPerColumn - 1) / (int64(columns) * slicesPerColumn)
+	}
+	slices := int((to - from + sliceTicks - 1) / sliceTicks)
+	columns = int((int64(slices) + slicesPerColumn - 1) / slicesPerColumn)
+	columnTicks := sliceTicks * slicesPerColumn
+	// a cell is 0 when empty, 1 where a note starts, and 2 where one continues
+	type cell struct {
+		state   int
+		channel uint8
+	}
+	grid := map[uint8][]cell{}
+	for pitch := int(low); pitch <= int(high); pitch++ {
+		grid[uint8(pitch)] = make([]cell, columns*int(slicesPerColumn))
+	}
+	for _, n := range notes {
+		first := int((max(n.start, from) - from) / sliceTicks)
+		last := int((min(n.end, to)-from+sliceTicks-1)/sliceTicks) - 1
+		row := grid[n.pitch]
+		for s := first; s <= max(last, first) && s < len(row); s++ {
+			state := 2
+			if s == first && n.start >= from {
+				state = 1
+			}
+			if row[s].state == 0 || state < row[s].state {
+				row[s] = cell{state: state, channel: n.channel}
+			}
+		}
+	}
+	// the columns in which bars begin
+	barColumns := map[int]int{}
+	bar, _, _ := m.locate(from)
+	for start := m.barStart(bar); start < to; bar, start = bar+1, m.barStart(bar+1) {
+		if start >= from {
+			barColumns[int((start-from)/columnTicks)] = bar
+		}
+	}
+	lines := []string{v.ruler(labelWidth, columns, func(column int) (int, bool) {
+		bar, found := barColumns[column]
+		return bar, found
+	})}
+	for pitch := int(high); pitch >= int(low); pitch-- {
+		row := grid[uint8(pitch)]
+		var b strings.Builder
+		fmt.Fprintf(&b, "%-*s", labelWidth, labels[uint8(pitch)])
+		current := ""
+		for column := 0; column < columns; column++ {
+			glyph, colorCode := ' ', ""
+			if _, found := barColumns[column]; found {
+				glyph = v.glyphs.barLine
+			}
+			var c cell
+			if v.ascii {
+				c = row[column]
+				switch c.state {
+				case 1:
+					glyph = v.glyphs.first
+				case 2:
+					glyph = v.glyphs.both
+				}
+			} else {
+				a, z := row[2*column], row[2*column+1]
+				c = a
+				switch {
+				case a.state != 0 && z.state != 0:
+					glyph = v.glyphs.both
+				case a.state != 0:
+					glyph = v.glyphs.first
+				case z.state != 0:
+					glyph, c = v.glyphs.second, z
+				}
+			}
+			if c.state != 0 && v.useColor {
+				colorCode = fmt.Sprintf("\x1b[%dm", channelANSIColors[c.channel])
+			}
+			current = v.switchColor(&b, current, colorCode)
+			b.WriteRune(glyph)
+		}
+		v.switchColor(&b, current, "")
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	return lines
+}
+
+// ruler returns a line of bar numbers, each over the column where its bar
+// begins, skipping those that would run into the previous number or past the
+// width
+func (v *view) ruler(indent, columns int, barAt func(column int) (int, bool)) string {
+	line := []rune(strings.Repeat(" ", max(indent+columns, v.columns)))
+	free := 0
+	for column := 0; column < columns; column++ {
+		bar, found := barAt(column)
+		if !found || column < free {
+			continue
+		}
+		label := strconv.Itoa(bar)
+		if indent+column+len(label) > len(line) {
+			break
+		}
+		copy(line[indent+column:], []rune(label))
+		free = column + len(label) + 1
+	}
+	return strings.TrimRight(string(line), " ")
+}
+
+// switchColor writes the escape sequence that changes the current color to
+// the wanted one, and returns the wanted color
+func (v *view) switchColor(b *strings.Builder, current, wanted string) string {
+	if current == wanted {
+		return current
+	}
+	if current != "" {
+		b.WriteString(ansiReset)
+	}
+	b.WriteString(wanted)
+	return wanted
+}
+
+// activityColor returns green for light activity, yellow for moderate, and
+// red for the busiest
+func activityColor(level, top int) string {
+	switch {
+	case level*3 <= top:
+		return "\x1b[32m"
+	case level*3 <= top*2:
+		return "\x1b[33m"
+	default:
+		return "\x1b[31m"
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,286 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majohn-r/output"
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func writeViewTestFile(t *testing.T, data *smf.SMF) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "song.mid")
+	if err := os.WriteFile(path, smfBytes(t, data), 0o644); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	return path
+}
+
+func Test_view_processFile(t *testing.T) {
+	input := writeViewTestFile(t, pianoRollTestSMF())
+	tests := map[string]struct {
+		v         *view
+		selection selectionFlags
+		wantNotes int
+		want      []string
+	}{
+		"overview": {
+			v:         &view{columns: 40, glyphs: unicodeGlyphs},
+			wantNotes: 4,
+			want: []string{
+				"          1 3",
+				" 0",
+				" 1 Melody ▄█",
+				" 2        ▄",
+			},
+		},
+		"unicode roll": {
+			v:         &view{columns: 40, roll: true, glyphs: unicodeGlyphs},
+			selection: selectionFlags{tracks: "1"},
+			wantNotes: 3,
+			want: []string{
+				"          1 3",
+				" 1 Melody ▄█",
+				"",
+				"    1     2     3",
+				"G5  │     │   ▌ │",
+				"F♯5 │     │     │",
+				"F5  │     │     │",
+				"E5  │     ██    │",
+				"D♯5 │     │     │",
+				"D5  │     │     │",
+				"C♯5 │     │     │",
+				"C5  ██    │     │",
+			},
+		},
+		"ascii roll": {
+			v:         &view{columns: 40, roll: true, ascii: true, glyphs: asciiGlyphs},
+			selection: selectionFlags{pitches: "C5..E5"},
+			wantNotes: 2,
+			want: []string{
+				"          1 3",
+				" 0",
+				" 1 Melody @@",
+				" 2",
+				"",
+				"    1           2           3",
+				"E5  |           [===        |",
+				"D♯5 |           |           |",
+				"D5  |           |           |",
+				"C♯5 |           |           |",
+				"C5  [===        |           |",
+			},
+		},
+		"color": {
+			v:         &view{columns: 40, roll: true, useColor: true, glyphs: unicodeGlyphs},
+			selection: selectionFlags{tracks: "2"},
+			wantNotes: 1,
+			want: []string{
+				"    1 3",
+				" 2  \x1b[31m█\x1b[0m",
+				"",
+				"   1     2     3",
+				"C3 │ \x1b[96m▌\x1b[0m   │     │",
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.v.selection = tt.selection
+			o := output.NewRecorder()
+			if !tt.v.processFile(o, input) {
+				t.Fatalf("view.processFile() failed: %s", o.ErrorOutput())
+			}
+			want := fmt.Sprintf("%s: 3 tracks, bars 1 to 3, %d notes\n", input, tt.wantNotes) +
+				strings.Join(tt.want, "\n") + "\n"
+			if got := o.ConsoleOutput(); got != want {
+				t.Errorf("view.processFile() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func Test_view_processFile_compressed(t *testing.T) {
+	// a note in every bar of 4/4 for 100 bars, and two in the last
+	data := smf.NewSMF1()
+	data.TimeFormat = smf.MetricTicks(96)
+	var track smf.Track
+	track.Add(0, smf.MetaTrackSequenceName("A rather long track name"))
+	for bar := 0; bar < 100; bar++ {
+		track.Add(0, midi.NoteOn(0, 60, 100))
+		track.Add(384, midi.NoteOff(0, 60))
+	}
+	track.Add(0, midi.NoteOn(0, 62, 100))
+	track.Add(96, midi.NoteOff(0, 62))
+	track.Close(0)
+	_ = data.Add(track)
+	input := writeViewTestFile(t, data)
+	o := output.NewRecorder()
+	v := &view{columns: 40, glyphs: asciiGlyphs}
+	if !v.processFile(o, input) {
+		t.Fatalf("view.processFile() failed: %s", o.ErrorOutput())
+	}
+	// 101 bars over the 20 columns left by the label take 6 bars per column
+	want := input + ": 1 tracks, bars 1 to 101, 101 notes\n" +
+		"                    1   25  49  73  97\n" +
+		" 0 A rather long t… @@@@@@@@@@@@@@@@%\n" +
+		"(6 bars per column)\n"
+	if got := o.ConsoleOutput(); got != want {
+		t.Errorf("view.processFile() = %q, want %q", got, want)
+	}
+}
+
+func Test_view_processFile_errors(t *testing.T) {
+	input := writeViewTestFile(t, pianoRollTestSMF())
+	tests := map[string]struct {
+		selection selectionFlags
+		wantError string
+	}{
+		"backwards range": {
+			selection: selectionFlags{from: "5", to: "3"},
+			wantError: "The selection is not valid: the range \"5\" to \"3\" ends before it begins.\n",
+		},
+		"empty range": {
+			selection: selectionFlags{from: "5", to: "5"},
+			wantError: "has nothing to show in the selected range.\n",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := output.NewRecorder()
+			v := &view{columns: 40, selection: tt.selection, glyphs: unicodeGlyphs}
+			if v.processFile(o, input) {
+				t.Fatalf("view.processFile() succeeded")
+			}
+			if got := o.ErrorOutput(); !strings.Contains(got, tt.wantError) {
+				t.Errorf("view.processFile() error = %q, want %q", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func Test_view_run(t *testing.T) {
+	input := writeViewTestFile(t, pianoRollTestSMF())
+	savedTerminal, savedWidth := stdoutIsTerminal, terminalWidth
+	defer func() {
+		stdoutIsTerminal, terminalWidth = savedTerminal, savedWidth
+	}()
+	tests := map[string]struct {
+		v            *view
+		terminal     bool
+		consoleWidth int
+		columnsEnv   string
+		noColor      string
+		args         []string
+		want         int
+		wantColumns  int
+		wantColor    bool
+		output.WantedRecording
+	}{
+		"bad values": {
+			v:    &view{color: "sometimes", width: 10, selection: selectionFlags{channels: "16"}},
+			args: []string{input},
+			want: exitUserError,
+			WantedRecording: output.WantedRecording{
+				Error: "The --color value \"sometimes\" is not valid: it must be auto, always, or never.\n" +
+					"The --width value 10 is not valid: it must be from 40 to 1000.\n" +
+					"The selection is not valid: \"16\" is not a valid value or range between 0 and 15.\n",
+			},
+		},
+		"terminal": {
+			v:            &view{color: "auto"},
+			terminal:     true,
+			consoleWidth: 132,
+			args:         []string{input},
+			wantColumns:  132,
+			wantColor:    true,
+		},
+		"NO_COLOR": {
+			v:            &view{color: "auto"},
+			terminal:     true,
+			consoleWidth: 132,
+			noColor:      "1",
+			args:         []string{input},
+			wantColumns:  132,
+		},
+		"narrow terminal": {
+			v:            &view{color: "never"},
+			terminal:     true,
+			consoleWidth: 20,
+			args:         []string{input},
+			wantColumns:  40,
+		},
+		"piped, with COLUMNS": {
+			v:           &view{color: "auto"},
+			columnsEnv:  "100",
+			args:        []string{input},
+			wantColumns: 100,
+		},
+		"piped": {
+			v:           &view{color: "always"},
+			args:        []string{input},
+			wantColumns: 80,
+			wantColor:   true,
+		},
+		"explicit width": {
+			v:            &view{color: "auto", width: 60},
+			consoleWidth: 132,
+			args:         []string{input},
+			wantColumns:  60,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			stdoutIsTerminal = func() bool { return tt.terminal }
+			terminalWidth = func() int { return tt.consoleWidth }
+			t.Setenv("COLUMNS", tt.columnsEnv)
+			t.Setenv("NO_COLOR", tt.noColor)
+			o := output.NewRecorder()
+			if got := tt.v.run(o, tt.args); got != tt.want {
+				t.Errorf("view.run() = %d, want %d", got, tt.want)
+			}
+			if tt.want != exitSuccess {
+				if issues, ok := o.Verify(tt.WantedRecording); !ok {
+					for _, issue := range issues {
+						t.Errorf("view.run() %s", issue)
+					}
+				}
+				return
+			}
+			if tt.v.columns != tt.wantColumns || tt.v.useColor != tt.wantColor {
+				t.Errorf("view.run() used %d columns and color %t, want %d and %t", tt.v.columns, tt.v.useColor,
+					tt.wantColumns, tt.wantColor)
+			}
+			if strings.Contains(o.ConsoleOutput(), "\x1b[") != tt.wantColor {
+				t.Errorf("view.run() color = %t, want %t", !tt.wantColor, tt.wantColor)
+			}
+		})
+	}
+}
+
+func Test_truncateRunes(t *testing.T) {
+	tests := map[string]struct {
+		s    string
+		n    int
+		want string
+	}{
+		"short":  {s: "Bass", n: 4, want: "Bass"},
+		"long":   {s: "Basses", n: 4, want: "Bas…"},
+		"runes":  {s: "Ärger über", n: 6, want: "Ärger…"},
+		"empty":  {s: "", n: 3, want: ""},
+		"exact":  {s: "Über", n: 4, want: "Über"},
+		"one on": {s: "Übers", n: 4, want: "Übe…"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := truncateRunes(tt.s, tt.n); got != tt.want {
+				t.Errorf("truncateRunes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}